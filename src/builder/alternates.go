@@ -0,0 +1,37 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+// alternateSource is implemented by sources with alternate URIs
+type alternateSource interface {
+	AddAlternates(uris ...string)
+}
+
+// AddSourceAlternates will add the alternate URIs, keyed by source URI, to
+// the matching sources of this package. Alternates are tried in the order
+// they were added, after the source URI itself.
+func (p *Package) AddSourceAlternates(alternates map[string][]string) {
+	for _, src := range p.Sources {
+		uris, ok := alternates[src.GetIdentifier()]
+		if !ok {
+			continue
+		}
+		if alt, ok := src.(alternateSource); ok {
+			alt.AddAlternates(uris...)
+		}
+	}
+}
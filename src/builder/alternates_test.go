@@ -0,0 +1,50 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"testing"
+)
+
+func TestAddSourceAlternates(t *testing.T) {
+	nano, err := source.NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	vim, err := source.NewSimple("https://example.com/vim-8.0.tar.bz2", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	pkg := &Package{Sources: []source.Source{nano, vim}}
+
+	// Recipe alternates come first, then those of the profile
+	pkg.AddSourceAlternates(map[string][]string{
+		nano.URI: {"https://mirror.example.com/nano-2.7.5.tar.xz"},
+	})
+	pkg.AddSourceAlternates(map[string][]string{
+		nano.URI:             {"https://mirror.internal/nano-2.7.5.tar.xz"},
+		"https://unused.tar": {"https://mirror.internal/unused.tar"},
+	})
+	alts := nano.GetAlternates()
+	if len(alts) != 2 || alts[0] != "https://mirror.example.com/nano-2.7.5.tar.xz" || alts[1] != "https://mirror.internal/nano-2.7.5.tar.xz" {
+		t.Fatalf("Wrong alternates: %v", alts)
+	}
+	if len(vim.GetAlternates()) != 0 {
+		t.Fatalf("Unrelated source was given alternates: %v", vim.GetAlternates())
+	}
+}
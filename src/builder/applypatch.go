@@ -0,0 +1,375 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// devNull names the missing side of a git patch creating or deleting a file
+const devNull = "/dev/null"
+
+var (
+	// ErrMalformedPatch is returned for patches that cannot be parsed
+	ErrMalformedPatch = errors.New("Malformed unified diff")
+
+	// ErrBinaryPatch is returned for patches with binary changes
+	ErrBinaryPatch = errors.New("Binary patches are not supported")
+)
+
+// A diffHunk is a single change within a file, with the lines of the hunk
+// kept with their prefix and newline.
+type diffHunk struct {
+	oldStart int
+	oldLines int
+	newStart int
+	newLines int
+	lines    []string
+}
+
+// A fileDiff holds the hunks changing a single file
+type fileDiff struct {
+	oldName string
+	newName string
+	hunks   []*diffHunk
+}
+
+// parseRange will parse a hunk range of the form start[,count]
+func parseRange(s string) (int, int, error) {
+	fields := strings.SplitN(s, ",", 2)
+	start, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, ErrMalformedPatch
+	}
+	count := 1
+	if len(fields) == 2 {
+		if count, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, ErrMalformedPatch
+		}
+	}
+	return start, count, nil
+}
+
+// parseFileName will return the name of a ---/+++ header line, without any
+// timestamp following it.
+func parseFileName(line string) string {
+	name := strings.TrimRight(line[4:], "\r\n")
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSpace(name)
+}
+
+// parseUnifiedDiff will parse each file changed by the unified diff. Any
+// text outside of the file headers and hunks, such as a commit message, is
+// ignored as it is by patch(1).
+func parseUnifiedDiff(r io.Reader) ([]*fileDiff, error) {
+	br := bufio.NewReader(r)
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var diffs []*fileDiff
+	var diff *fileDiff
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, ErrBinaryPatch
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			diff = &fileDiff{oldName: parseFileName(line), newName: parseFileName(lines[i+1])}
+			diffs = append(diffs, diff)
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if diff == nil {
+				return nil, ErrMalformedPatch
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 4 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+				return nil, ErrMalformedPatch
+			}
+			h := &diffHunk{}
+			var err error
+			if h.oldStart, h.oldLines, err = parseRange(fields[1][1:]); err != nil {
+				return nil, err
+			}
+			if h.newStart, h.newLines, err = parseRange(fields[2][1:]); err != nil {
+				return nil, err
+			}
+			// Read the body until both sides are complete
+			oldLeft, newLeft := h.oldLines, h.newLines
+			for oldLeft > 0 || newLeft > 0 {
+				i++
+				if i >= len(lines) {
+					return nil, ErrMalformedPatch
+				}
+				body := lines[i]
+				// Some editors strip the space from empty context lines
+				if body == "\n" || body == "\r\n" {
+					body = " " + body
+				}
+				switch body[0] {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				case '\\':
+					h.markNoNewline()
+					continue
+				default:
+					return nil, ErrMalformedPatch
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, ErrMalformedPatch
+				}
+				h.lines = append(h.lines, body)
+			}
+			// The final line of the file may lack a newline
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+				h.markNoNewline()
+				i++
+			}
+			diff.hunks = append(diff.hunks, h)
+		}
+	}
+	return diffs, nil
+}
+
+// markNoNewline will strip the newline from the last line of the hunk
+func (h *diffHunk) markNoNewline() {
+	if n := len(h.lines); n > 0 {
+		h.lines[n-1] = strings.TrimSuffix(h.lines[n-1], "\n")
+	}
+}
+
+// split will return the lines the hunk expects to find, and the lines they
+// are replaced with.
+func (h *diffHunk) split() ([]string, []string) {
+	var before, after []string
+	for _, line := range h.lines {
+		switch line[0] {
+		case ' ':
+			before = append(before, line[1:])
+			after = append(after, line[1:])
+		case '-':
+			before = append(before, line[1:])
+		case '+':
+			after = append(after, line[1:])
+		}
+	}
+	return before, after
+}
+
+// creates will determine if the diff creates the file, as diff -N does
+// with an empty original and git does with /dev/null.
+func (d *fileDiff) creates() bool {
+	if d.oldName == devNull {
+		return true
+	}
+	return len(d.hunks) == 1 && d.hunks[0].oldStart == 0 && d.hunks[0].oldLines == 0
+}
+
+// deletes will determine if the diff deletes the file
+func (d *fileDiff) deletes() bool {
+	if d.newName == devNull {
+		return true
+	}
+	return len(d.hunks) == 1 && d.hunks[0].newStart == 0 && d.hunks[0].newLines == 0
+}
+
+// matchesAt will determine if the lines appear within contents at index i
+func matchesAt(contents, lines []string, i int) bool {
+	if i < 0 || i+len(lines) > len(contents) {
+		return false
+	}
+	for j, line := range lines {
+		if contents[i+j] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// apply will apply each hunk of the diff to the lines of the file. Hunks
+// are found at their stated position where possible, or else at the
+// nearest offset after the previous hunk, as patch(1) does without fuzz.
+func (d *fileDiff) apply(contents []string) ([]string, error) {
+	var out []string
+	pos, offset := 0, 0
+	for n, h := range d.hunks {
+		before, after := h.split()
+		want := h.oldStart - 1 + offset
+		if h.oldLines == 0 {
+			// Lines are inserted after the stated line
+			want++
+		}
+		at := -1
+		for delta := 0; at < 0; delta++ {
+			lo, hi := want-delta, want+delta
+			if lo < pos && hi+len(before) > len(contents) {
+				break
+			}
+			if lo >= pos && matchesAt(contents, before, lo) {
+				at = lo
+			} else if hi >= pos && matchesAt(contents, before, hi) {
+				at = hi
+			}
+		}
+		if at < 0 {
+			return nil, fmt.Errorf("Hunk #%d of %s does not apply at line %d", n+1, d.newName, h.oldStart)
+		}
+		out = append(out, contents[pos:at]...)
+		out = append(out, after...)
+		pos = at + len(before)
+		offset += at - want
+	}
+	return append(out, contents[pos:]...), nil
+}
+
+// stripPath will remove the leading components from the name in the
+// patch, rejecting any name that would escape the tree.
+func stripPath(name string, strip int) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) <= strip {
+		return "", fmt.Errorf("Cannot strip %d components from %s", strip, name)
+	}
+	clean := filepath.Clean(strings.Join(parts[strip:], "/"))
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Refusing to patch unsafe path: %s", name)
+	}
+	return clean, nil
+}
+
+// splitLines will split the file into lines, keeping their newlines
+func splitLines(data string) []string {
+	lines := strings.SplitAfter(data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// A patchedFile is the new contents of a file, held until the whole patch
+// is known to apply.
+type patchedFile struct {
+	lines   []string
+	mode    os.FileMode
+	deleted bool
+}
+
+// applyPatch will apply the unified diff to the tree in dir, stripping the
+// given number of leading path components from each file name, like the
+// -p option of patch(1). Nothing is written unless every hunk applies, so a
+// failed patch never leaves the tree half patched.
+func applyPatch(dir string, r io.Reader, strip int) error {
+	diffs, err := parseUnifiedDiff(r)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		return ErrMalformedPatch
+	}
+
+	files := make(map[string]*patchedFile)
+	var order []string
+	for _, d := range diffs {
+		name := d.newName
+		if name == devNull {
+			name = d.oldName
+		}
+		rel, err := stripPath(name, strip)
+		if err != nil {
+			return err
+		}
+		file, ok := files[rel]
+		if !ok {
+			file = &patchedFile{mode: 00644}
+			path := filepath.Join(dir, rel)
+			if st, err := os.Stat(path); err == nil {
+				if d.creates() {
+					return fmt.Errorf("Cannot create %s, it already exists", rel)
+				}
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				file.lines = splitLines(string(data))
+				file.mode = st.Mode().Perm()
+			} else if !os.IsNotExist(err) || !d.creates() {
+				return err
+			}
+			files[rel] = file
+			order = append(order, rel)
+		}
+		if file.lines, err = d.apply(file.lines); err != nil {
+			return err
+		}
+		file.deleted = d.deletes()
+		if file.deleted && len(file.lines) != 0 {
+			return fmt.Errorf("Cannot delete %s, it has unexpected contents", rel)
+		}
+	}
+
+	for _, rel := range order {
+		file := files[rel]
+		path := filepath.Join(dir, rel)
+		if file.deleted {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(strings.Join(file.lines, "")), file.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPatchFile will apply the patch file to the tree in dir with -p1
+func applyPatchFile(dir, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return applyPatch(dir, f, 1)
+}
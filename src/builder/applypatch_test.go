@@ -0,0 +1,136 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPatchTree is patched by diff -Nru, with the first hunk of main.c
+// stated two lines earlier than it applies.
+const testPatchTree = `Fix the second line, and replace OLD with NEW
+
+diff -Nru a/NEW b/NEW
+--- a/NEW	1970-01-01 00:00:00.000000000 +0000
++++ b/NEW	2017-01-01 00:00:00.000000000 +0000
+@@ -0,0 +1 @@
++new
+diff -Nru a/OLD b/OLD
+--- a/OLD	2017-01-01 00:00:00.000000000 +0000
++++ b/OLD	1970-01-01 00:00:00.000000000 +0000
+@@ -1 +0,0 @@
+-old
+diff -Nru a/src/main.c b/src/main.c
+--- a/src/main.c	2017-01-01 00:00:00.000000000 +0000
++++ b/src/main.c	2017-01-01 00:00:00.000000000 +0000
+@@ -1,5 +1,5 @@
+ line1
+-line2
++line2 changed
+ line3
+ line4
+ line5
+@@ -10,3 +10,4 @@
+ line10
+ line11
+ line12
++line13
+\ No newline at end of file
+`
+
+// writeTestTree will write the files within dir
+func writeTestTree(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-applypatch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	lines := "line1\nline2\nline3\nline4\nline5\nline6\nline7\nline8\nline9\nline10\nline11\nline12\n"
+	writeTestTree(t, tmp, map[string]string{
+		"OLD":        "old\n",
+		"src/main.c": "/* header */\n\n" + lines,
+	})
+	if err := applyPatch(tmp, strings.NewReader(testPatchTree), 1); err != nil {
+		t.Fatalf("Failed to apply patch: %v", err)
+	}
+
+	expected := "/* header */\n\n" + strings.Replace(lines, "line2\n", "line2 changed\n", 1) + "line13"
+	if contents, _ := ioutil.ReadFile(filepath.Join(tmp, "src/main.c")); string(contents) != expected {
+		t.Fatalf("Wrong patched contents: %q", contents)
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(tmp, "NEW")); err != nil || string(contents) != "new\n" {
+		t.Fatalf("New file was not created: %v", err)
+	}
+	if PathExists(filepath.Join(tmp, "OLD")) {
+		t.Fatalf("Deleted file still exists")
+	}
+
+	// Applying again must fail without touching the tree
+	if err := applyPatch(tmp, strings.NewReader(testPatchTree), 1); err == nil {
+		t.Fatalf("Patch should not apply twice")
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(tmp, "src/main.c")); string(contents) != expected {
+		t.Fatalf("Failed patch modified the tree: %q", contents)
+	}
+}
+
+func TestApplyPatchAtomic(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-applypatch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	writeTestTree(t, tmp, map[string]string{"README": "nano\n", "AUTHORS": "someone\n"})
+	patch := testPatchFix + "--- a/AUTHORS\n+++ b/AUTHORS\n@@ -1 +1 @@\n-nobody\n+anybody\n"
+	err = applyPatch(tmp, strings.NewReader(patch), 1)
+	if err == nil || !strings.Contains(err.Error(), "AUTHORS") {
+		t.Fatalf("Expected the AUTHORS hunk to fail, got: %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(tmp, "README")); string(contents) != "nano\n" {
+		t.Fatalf("Partially applied patch modified the tree: %q", contents)
+	}
+
+	for _, name := range []string{"a/../../escape", "a/src/../../escape", "README"} {
+		unsafe := "--- " + name + "\n+++ " + name + "\n@@ -1 +1 @@\n-nano\n+vim\n"
+		if err := applyPatch(tmp, strings.NewReader(unsafe), 1); err == nil {
+			t.Fatalf("Should not patch %s", name)
+		}
+	}
+	for _, malformed := range []string{"", "not a patch\n", "--- a/README\n+++ b/README\n@@ -1 +1 @@\n-nano\n"} {
+		if err := applyPatch(tmp, strings.NewReader(malformed), 1); err != ErrMalformedPatch {
+			t.Fatalf("Expected a malformed patch, got: %v", err)
+		}
+	}
+}
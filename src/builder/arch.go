@@ -0,0 +1,144 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// TargetArchEnv is exported into the build environment, so that the build
+// tool knows which architecture it is building for.
+const TargetArchEnv = "SOLBUILD_TARGET_ARCH"
+
+// SourceArchKey may be set within a package.yml source entry to restrict
+// its sources to the given architectures, separated by commas:
+//
+//	source:
+//	    - https://example.com/blob-x86_64.tar.xz : $sha256sum
+//	      arch : x86_64
+const SourceArchKey = "arch"
+
+var (
+	// SupportedArches is the set of architectures that may be targeted
+	SupportedArches = []string{
+		"x86_64",
+		"i686",
+		"aarch64",
+	}
+
+	// TargetArch is the architecture that builds are performed for
+	TargetArch = HostArch()
+)
+
+// HostArch will return the architecture of the host in the naming used by
+// the images and packages, i.e. x86_64 rather than amd64.
+func HostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// IsSupportedArch will determine if the architecture may be targeted
+func IsSupportedArch(arch string) bool {
+	for _, a := range SupportedArches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTargetArch will validate and set the architecture to build for. An
+// empty architecture selects the host architecture.
+func SetTargetArch(arch string) error {
+	arch = strings.TrimSpace(arch)
+	if arch == "" {
+		arch = HostArch()
+	}
+	if !IsSupportedArch(arch) {
+		return fmt.Errorf("Unsupported target architecture: %s", arch)
+	}
+	TargetArch = arch
+	return nil
+}
+
+// GetArchImage will return the backing image to use for the architecture,
+// replacing the architecture suffix of the image, i.e. main-x86_64 becomes
+// main-aarch64. Images without a known suffix are returned unchanged.
+func GetArchImage(image, arch string) string {
+	for _, a := range SupportedArches {
+		if strings.HasSuffix(image, "-"+a) {
+			return strings.TrimSuffix(image, a) + arch
+		}
+	}
+	return image
+}
+
+// splitArches will return each architecture in the comma separated list
+func splitArches(arches string) []string {
+	var ret []string
+	for _, arch := range strings.Split(arches, ",") {
+		if arch = strings.TrimSpace(arch); arch != "" {
+			ret = append(ret, arch)
+		}
+	}
+	return ret
+}
+
+// SelectSources will drop the sources scoped to other architectures, so
+// that only those needed for the given architecture are fetched.
+func (p *Package) SelectSources(arch string) {
+	if len(p.SourceArches) == 0 {
+		return
+	}
+	var sources []source.Source
+	for _, src := range p.Sources {
+		arches, ok := p.SourceArches[src.GetIdentifier()]
+		if !ok {
+			sources = append(sources, src)
+			continue
+		}
+		for _, a := range arches {
+			if a == arch {
+				sources = append(sources, src)
+				break
+			}
+		}
+	}
+	p.Sources = sources
+}
+
+// GetBuildEnvironment will return the environment for the build itself
+func (p *Package) GetBuildEnvironment() []string {
+	var env []string
+	if p.Type == PackageTypeXML {
+		env = SaneEnvironment("root", "/root")
+	} else {
+		env = SaneEnvironment(BuildUser, BuildUserHome)
+	}
+	return append(env, fmt.Sprintf("%s=%s", TargetArchEnv, TargetArch))
+}
@@ -0,0 +1,121 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"testing"
+)
+
+const (
+	archTestPackage = `
+name: nano
+version: 2.7.5
+release: 61
+source:
+    - https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz : a64d24e6bc4fc448376d038f9a755af77f8e748c9051b6e45bf85e783a7e67e4
+    - https://example.com/blob-x86_64.tar.xz : 0000000000000000000000000000000000000000000000000000000000000000
+      arch : x86_64
+    - https://example.com/blob-aarch64.tar.xz : 1111111111111111111111111111111111111111111111111111111111111111
+      arch : aarch64, i686
+`
+)
+
+func TestSetTargetArch(t *testing.T) {
+	defer func() { TargetArch = HostArch() }()
+
+	if err := SetTargetArch("aarch64"); err != nil || TargetArch != "aarch64" {
+		t.Fatalf("Failed to set target architecture: %v", err)
+	}
+	if err := SetTargetArch("sparc64"); err == nil {
+		t.Fatalf("Unsupported architecture should be rejected")
+	}
+	if TargetArch != "aarch64" {
+		t.Fatalf("Rejected architecture should not be set")
+	}
+	if err := SetTargetArch(""); err != nil || TargetArch != HostArch() {
+		t.Fatalf("Empty architecture should select the host: %v", err)
+	}
+}
+
+func TestGetArchImage(t *testing.T) {
+	tests := map[string]string{
+		"main-x86_64":     "main-aarch64",
+		"unstable-x86_64": "unstable-aarch64",
+		"custom":          "custom",
+	}
+	for image, want := range tests {
+		if got := GetArchImage(image, "aarch64"); got != want {
+			t.Fatalf("Expected %s for %s, got %s", want, image, got)
+		}
+	}
+	if got := GetArchImage("main-x86_64", "x86_64"); got != "main-x86_64" {
+		t.Fatalf("Image for the same architecture should be unchanged: %s", got)
+	}
+}
+
+func TestSourceArches(t *testing.T) {
+	tests := map[string][]string{
+		"x86_64": {
+			"https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz",
+			"https://example.com/blob-x86_64.tar.xz",
+		},
+		"aarch64": {
+			"https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz",
+			"https://example.com/blob-aarch64.tar.xz",
+		},
+	}
+	for arch, want := range tests {
+		pkg, err := NewYmlPackageFromBytes([]byte(archTestPackage))
+		if err != nil {
+			t.Fatalf("Failed to load package: %v", err)
+		}
+		if len(pkg.Sources) != 3 {
+			t.Fatalf("Expected 3 sources, got %d", len(pkg.Sources))
+		}
+		pkg.SelectSources(arch)
+		if len(pkg.Sources) != len(want) {
+			t.Fatalf("Expected %d sources for %s, got %d", len(want), arch, len(pkg.Sources))
+		}
+		for i, src := range pkg.Sources {
+			if src.GetIdentifier() != want[i] {
+				t.Fatalf("Expected %s for %s, got %s", want[i], arch, src.GetIdentifier())
+			}
+		}
+	}
+}
+
+func TestBuildEnvironmentArch(t *testing.T) {
+	defer func() { TargetArch = HostArch() }()
+	if err := SetTargetArch("i686"); err != nil {
+		t.Fatalf("Failed to set target architecture: %v", err)
+	}
+
+	want := fmt.Sprintf("%s=i686", TargetArchEnv)
+	for _, pkgType := range []PackageType{PackageTypeYpkg, PackageTypeXML} {
+		p := &Package{Name: "nano", Type: pkgType}
+		found := false
+		for _, env := range p.GetBuildEnvironment() {
+			if env == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Target architecture was not exported for %s builds", pkgType)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ArtifactSHA256Suffix is the suffix of the sha256sum sidecar files
+	ArtifactSHA256Suffix = ".sha256sum"
+
+	// ArtifactSHA512Suffix is the suffix of the sha512sum sidecar files
+	ArtifactSHA512Suffix = ".sha512sum"
+)
+
+// ArtifactSHA512 controls whether sha512sum sidecars are also written
+// for build artifacts.
+var ArtifactSHA512 = false
+
+// An ArtifactDigest records the checksums of a produced build artifact
+type ArtifactDigest struct {
+	Path   string // Path to the artifact
+	SHA256 string // Hex encoded sha256sum
+	SHA512 string // Hex encoded sha512sum, if enabled
+}
+
+// computeArtifactDigest will hash the artifact in a single pass
+func computeArtifactDigest(path string, withSHA512 bool) (*ArtifactDigest, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	h256 := sha256.New()
+	h512 := sha512.New()
+	var w io.Writer = h256
+	if withSHA512 {
+		w = io.MultiWriter(h256, h512)
+	}
+	if _, err := io.Copy(w, bufio.NewReader(fi)); err != nil {
+		return nil, err
+	}
+
+	digest := &ArtifactDigest{
+		Path:   path,
+		SHA256: hex.EncodeToString(h256.Sum(nil)),
+	}
+	if withSHA512 {
+		digest.SHA512 = hex.EncodeToString(h512.Sum(nil))
+	}
+	return digest, nil
+}
+
+// writeChecksumFile will write a sidecar in the format used by sha256sum(1)
+func writeChecksumFile(path, sum string, usr *UserInfo) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(strings.TrimSuffix(path, filepath.Ext(path))))
+	if err := ioutil.WriteFile(path, []byte(line), 00644); err != nil {
+		return err
+	}
+	if usr != nil {
+		return os.Chown(path, usr.UID, usr.GID)
+	}
+	return nil
+}
+
+// WriteArtifactChecksums will compute the checksums of the given artifact,
+// writing them alongside it as sidecar files.
+func WriteArtifactChecksums(path string, usr *UserInfo) (*ArtifactDigest, error) {
+	digest, err := computeArtifactDigest(path, ArtifactSHA512)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeChecksumFile(path+ArtifactSHA256Suffix, digest.SHA256, usr); err != nil {
+		return nil, err
+	}
+	if digest.SHA512 != "" {
+		if err := writeChecksumFile(path+ArtifactSHA512Suffix, digest.SHA512, usr); err != nil {
+			return nil, err
+		}
+	}
+	log.WithFields(log.Fields{
+		"file":   filepath.Base(path),
+		"sha256": digest.SHA256,
+	}).Debug("Wrote artifact checksums")
+	return digest, nil
+}
+
+// VerifyArtifactChecksum will verify the artifact against its sha256sum
+// sidecar file.
+func VerifyArtifactChecksum(path string) error {
+	contents, err := ioutil.ReadFile(path + ArtifactSHA256Suffix)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) < 1 {
+		return fmt.Errorf("Malformed checksum file for %s", filepath.Base(path))
+	}
+	digest, err := computeArtifactDigest(path, false)
+	if err != nil {
+		return err
+	}
+	if digest.SHA256 != fields[0] {
+		return fmt.Errorf("Checksum mismatch for %s: expected %s, got %s", filepath.Base(path), fields[0], digest.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactChecksums(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-artifacts")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	ArtifactSHA512 = true
+	defer func() {
+		ArtifactSHA512 = false
+	}()
+
+	artifact := filepath.Join(tmp, "nano-2.7.5-70-1-x86_64.eopkg")
+	if err := ioutil.WriteFile(artifact, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+
+	digest, err := WriteArtifactChecksums(artifact, nil)
+	if err != nil {
+		t.Fatalf("Failed to write checksums: %v", err)
+	}
+	expected256 := "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+	if digest.SHA256 != expected256 {
+		t.Fatalf("Wrong sha256sum: %v", digest.SHA256)
+	}
+	expected512 := "9e2fc8de0ec3efcdbeb1b43ea4185ddf018cda71aff0e6af42c84a94cebdfea45f82071dd72e12c0c4954529002ccdaa4bb5387262dbb84f16f05d4498870551"
+	if digest.SHA512 != expected512 {
+		t.Fatalf("Wrong sha512sum: %v", digest.SHA512)
+	}
+
+	contents, err := ioutil.ReadFile(artifact + ArtifactSHA256Suffix)
+	if err != nil {
+		t.Fatalf("Missing sha256sum sidecar: %v", err)
+	}
+	if string(contents) != expected256+"  nano-2.7.5-70-1-x86_64.eopkg\n" {
+		t.Fatalf("Wrong sidecar contents: %s", contents)
+	}
+	if contents, err = ioutil.ReadFile(artifact + ArtifactSHA512Suffix); err != nil {
+		t.Fatalf("Missing sha512sum sidecar: %v", err)
+	}
+	if string(contents) != expected512+"  nano-2.7.5-70-1-x86_64.eopkg\n" {
+		t.Fatalf("Wrong sidecar contents: %s", contents)
+	}
+
+	if err := VerifyArtifactChecksum(artifact); err != nil {
+		t.Fatalf("Failed to verify artifact: %v", err)
+	}
+	if err := ioutil.WriteFile(artifact, []byte("corrupt"), 00644); err != nil {
+		t.Fatalf("Failed to corrupt artifact: %v", err)
+	}
+	if err := VerifyArtifactChecksum(artifact); err == nil {
+		t.Fatalf("Corrupt artifact should fail verification")
+	}
+}
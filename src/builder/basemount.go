@@ -0,0 +1,283 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// BaseImageMountDir is where backing images are mounted read-only, to
+// be shared as the lower layer between all overlays using them.
+var BaseImageMountDir = "/var/cache/solbuild/base"
+
+// baseLockSuffix is appended to the mount point of a backing image to find
+// the lockfile recording the processes using it.
+const baseLockSuffix = ".lock"
+
+// A baseMounter is responsible for the actual mount operations of the
+// shared backing images.
+type baseMounter interface {
+	Mount(source, target, fstype string, options ...string) error
+	Unmount(target string) error
+}
+
+// commandMounter mounts backing images with mount(8), rather than through
+// the mount manager, so that a build unmounting everything it owns never
+// takes a base away from another build still using it.
+type commandMounter struct{}
+
+func (commandMounter) Mount(source, target, fstype string, options ...string) error {
+	args := []string{"-t", fstype}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	return commands.ExecStdoutArgs("mount", append(args, source, target))
+}
+
+func (commandMounter) Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+// isMountPoint will determine if a filesystem is mounted at the target
+func isMountPoint(target string) bool {
+	mounts, err := readMountsUnder(target)
+	if err != nil {
+		return false
+	}
+	for _, point := range mounts {
+		if point == filepath.Clean(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// A sharedBase is a single read-only mount of a backing image
+type sharedBase struct {
+	point string // Where the image is mounted
+	refs  int    // Number of overlays in this process using this base
+}
+
+// A baseImageMounts instance tracks the shared read-only mounts of the
+// backing images, so that each image is only mounted once, regardless of
+// the number of overlays referencing it. References are counted within the
+// process, and each process using a base is recorded in a lockfile next to
+// its mount point, so that builds in separate processes share it too.
+type baseImageMounts struct {
+	lock    *sync.Mutex
+	mounts  map[string]*sharedBase
+	mounter func() baseMounter
+	mounted func(point string) bool
+
+	// Loop devices cannot be set up within a user namespace, so without
+	// loopMounts the backing images must already be mounted by root.
+	loopMounts bool
+}
+
+// sharedBases is the process wide set of backing image mounts
+var sharedBases = newBaseImageMounts(func() baseMounter {
+	return commandMounter{}
+})
+
+// newBaseImageMounts will return a new tracker using the given mounter
+func newBaseImageMounts(mounter func() baseMounter) *baseImageMounts {
+	return &baseImageMounts{
+		lock:       new(sync.Mutex),
+		mounts:     make(map[string]*sharedBase),
+		mounter:    mounter,
+		mounted:    isMountPoint,
+		loopMounts: !InUserNamespace(),
+	}
+}
+
+// getBaseMountPoint will return the shared mount point for the image
+func getBaseMountPoint(back *BackingImage) string {
+	return filepath.Join(BaseImageMountDir, back.Name)
+}
+
+// isProcessAlive will determine if the process still exists
+func isProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// updateBaseUsers will hold the lockfile of the mount point exclusively,
+// shared by every solbuild process, while fn updates the list of processes
+// using the base. Processes that have since exited are dropped first.
+func updateBaseUsers(point string, fn func(pids []int) []int) error {
+	f, err := os.OpenFile(point+baseLockSuffix, os.O_RDWR|os.O_CREATE, 00644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil && isProcessAlive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+
+	var buf strings.Builder
+	for _, pid := range fn(pids) {
+		fmt.Fprintf(&buf, "%d\n", pid)
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(buf.String()), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// CheckMountable will ensure the backing image can be used, returning an
+// error up front if it must be mounted beforehand and isn't.
+func (b *baseImageMounts) CheckMountable(back *BackingImage) error {
+	if b.loopMounts {
+		return nil
+	}
+	if point := getBaseMountPoint(back); !b.mounted(point) {
+		return fmt.Errorf("Loop devices are unavailable without root, so %s must first be mounted read-only at %s by root", back.ImagePath, point)
+	}
+	return nil
+}
+
+// Acquire will ensure the backing image is mounted read-only, and take a
+// reference to it. The mount point must already exist.
+func (b *baseImageMounts) Acquire(back *BackingImage) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if base, ok := b.mounts[back.ImagePath]; ok {
+		base.refs++
+		return nil
+	}
+
+	point := getBaseMountPoint(back)
+	mounter := b.mounter()
+	var mountErr error
+	err := updateBaseUsers(point, func(pids []int) []int {
+		if len(pids) > 0 {
+			return append(pids, os.Getpid())
+		}
+		if !b.loopMounts {
+			// Use the mount provided by root as is
+			if mountErr = b.CheckMountable(back); mountErr != nil {
+				return pids
+			}
+			return append(pids, os.Getpid())
+		}
+		// Nobody is using the base, so any mount was leaked by a crash
+		if b.mounted(point) {
+			log.WithFields(log.Fields{
+				"point": point,
+			}).Debug("Unmounting stale backing image")
+			if mountErr = mounter.Unmount(point); mountErr != nil {
+				return pids
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"image": back.ImagePath,
+			"point": point,
+		}).Debug("Mounting shared backing image")
+
+		if mountErr = mounter.Mount(back.ImagePath, point, "auto", "ro", "loop"); mountErr != nil {
+			return pids
+		}
+		return append(pids, os.Getpid())
+	})
+	if err != nil {
+		return err
+	}
+	if mountErr != nil {
+		return mountErr
+	}
+	b.mounts[back.ImagePath] = &sharedBase{point: point, refs: 1}
+	return nil
+}
+
+// Release will drop a reference to the backing image, unmounting it only
+// once no overlays in any process are using it.
+func (b *baseImageMounts) Release(back *BackingImage) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	base, ok := b.mounts[back.ImagePath]
+	if !ok {
+		return nil
+	}
+	if base.refs > 1 {
+		base.refs--
+		return nil
+	}
+
+	var unmountErr error
+	err := updateBaseUsers(base.point, func(pids []int) []int {
+		// Drop a single reference of our own
+		for i, pid := range pids {
+			if pid == os.Getpid() {
+				pids = append(pids[:i], pids[i+1:]...)
+				break
+			}
+		}
+		if len(pids) > 0 || !b.loopMounts {
+			return pids
+		}
+
+		log.WithFields(log.Fields{
+			"image": back.ImagePath,
+			"point": base.point,
+		}).Debug("Unmounting shared backing image")
+
+		if unmountErr = b.mounter().Unmount(base.point); unmountErr != nil {
+			// Keep our reference so the unmount may be retried
+			return append(pids, os.Getpid())
+		}
+		return pids
+	})
+	if err != nil {
+		return err
+	}
+	if unmountErr != nil {
+		return unmountErr
+	}
+	delete(b.mounts, back.ImagePath)
+	return nil
+}
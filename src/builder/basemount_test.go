@@ -0,0 +1,214 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testMounter records mount operations without touching the system
+type testMounter struct {
+	mounts   map[string]string
+	unmounts int
+}
+
+func (t *testMounter) Mount(source, target, fstype string, options ...string) error {
+	t.mounts[target] = source
+	return nil
+}
+
+func (t *testMounter) Unmount(target string) error {
+	delete(t.mounts, target)
+	t.unmounts++
+	return nil
+}
+
+// newTestBaseMounts will return a base tracker using the test mounter,
+// with the base mount points in a temporary directory.
+func newTestBaseMounts(mounter *testMounter) *baseImageMounts {
+	bases := newBaseImageMounts(func() baseMounter {
+		return mounter
+	})
+	bases.mounted = func(point string) bool {
+		_, ok := mounter.mounts[point]
+		return ok
+	}
+	return bases
+}
+
+// useTestBaseDir will point BaseImageMountDir at a temporary directory
+func useTestBaseDir(t *testing.T) func() {
+	tmp, err := ioutil.TempDir("", "solbuild-base")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	oldDir := BaseImageMountDir
+	BaseImageMountDir = tmp
+	return func() {
+		BaseImageMountDir = oldDir
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestSharedBaseImage(t *testing.T) {
+	defer useTestBaseDir(t)()
+	mounter := &testMounter{mounts: make(map[string]string)}
+	bases := newTestBaseMounts(mounter)
+
+	profile := &Profile{Name: "unstable-x86_64", Image: "unstable-x86_64"}
+	back := NewBackingImage(profile.Image)
+	first := NewOverlay(profile, back, &Package{Name: "nano"})
+	second := NewOverlay(profile, back, &Package{Name: "vim"})
+
+	if first.ImgDir != second.ImgDir {
+		t.Fatalf("Overlays should share the lower layer: %v vs %v", first.ImgDir, second.ImgDir)
+	}
+
+	for _, o := range []*Overlay{first, second} {
+		if err := bases.Acquire(o.Back); err != nil {
+			t.Fatalf("Failed to acquire base image: %v", err)
+		}
+	}
+	if len(mounter.mounts) != 1 {
+		t.Fatalf("Base image should be mounted once: %v", mounter.mounts)
+	}
+	if mounter.mounts[first.ImgDir] != back.ImagePath {
+		t.Fatalf("Wrong base image mount: %v", mounter.mounts)
+	}
+
+	// Tearing down the first overlay must leave the base for the second
+	if err := bases.Release(first.Back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if mounter.unmounts != 0 || len(mounter.mounts) != 1 {
+		t.Fatal("Base image unmounted while still in use")
+	}
+
+	if err := bases.Release(second.Back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if mounter.unmounts != 1 || len(mounter.mounts) != 0 {
+		t.Fatal("Base image should be unmounted once unused")
+	}
+}
+
+func TestSharedBaseImageProcesses(t *testing.T) {
+	defer useTestBaseDir(t)()
+	mounter := &testMounter{mounts: make(map[string]string)}
+	back := NewBackingImage("unstable-x86_64")
+	point := getBaseMountPoint(back)
+
+	// Each tracker stands in for a separate solbuild process
+	first := newTestBaseMounts(mounter)
+	second := newTestBaseMounts(mounter)
+	for _, bases := range []*baseImageMounts{first, second} {
+		if err := bases.Acquire(back); err != nil {
+			t.Fatalf("Failed to acquire base image: %v", err)
+		}
+	}
+	if len(mounter.mounts) != 1 || mounter.unmounts != 0 {
+		t.Fatalf("Base image should be mounted once: %v", mounter.mounts)
+	}
+	users, err := ioutil.ReadFile(point + baseLockSuffix)
+	if err != nil || len(strings.Fields(string(users))) != 2 {
+		t.Fatalf("Both users should be recorded next to the mount point: %q %v", users, err)
+	}
+
+	if err := first.Release(back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if mounter.unmounts != 0 {
+		t.Fatal("Base image unmounted while another process still uses it")
+	}
+	if err := second.Release(back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if mounter.unmounts != 1 || len(mounter.mounts) != 0 {
+		t.Fatal("Base image should be unmounted by the last process")
+	}
+}
+
+func TestSharedBaseImageStale(t *testing.T) {
+	defer useTestBaseDir(t)()
+	mounter := &testMounter{mounts: make(map[string]string)}
+	back := NewBackingImage("unstable-x86_64")
+	point := getBaseMountPoint(back)
+
+	// A crashed build left the base mounted with its reference behind
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatalf("Failed to run process: %v", err)
+	}
+	if err := ioutil.WriteFile(point+baseLockSuffix, []byte(strconv.Itoa(dead.Process.Pid)), 00644); err != nil {
+		t.Fatalf("Failed to write lockfile: %v", err)
+	}
+	mounter.mounts[point] = "old.img"
+
+	bases := newTestBaseMounts(mounter)
+	if err := bases.Acquire(back); err != nil {
+		t.Fatalf("Failed to acquire base image: %v", err)
+	}
+	if mounter.unmounts != 1 || mounter.mounts[point] != back.ImagePath {
+		t.Fatalf("Stale base image should be remounted: %v", mounter.mounts)
+	}
+	if err := bases.Release(back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatal("Stale reference should not keep the base mounted")
+	}
+}
+
+func TestSharedBaseImageUserNamespace(t *testing.T) {
+	defer useTestBaseDir(t)()
+	mounter := &testMounter{mounts: make(map[string]string)}
+	back := NewBackingImage("unstable-x86_64")
+	point := getBaseMountPoint(back)
+
+	bases := newTestBaseMounts(mounter)
+	bases.loopMounts = false
+
+	// Loop devices are off limits, so refuse until root has mounted it
+	if err := bases.CheckMountable(back); err == nil || !strings.Contains(err.Error(), point) {
+		t.Fatalf("Unmounted base should be refused up front: %v", err)
+	}
+	if err := bases.Acquire(back); err == nil {
+		t.Fatal("Unmounted base should not be acquired")
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatal("Base should not be loop mounted in a user namespace")
+	}
+
+	mounter.mounts[point] = back.ImagePath
+	if err := bases.CheckMountable(back); err != nil {
+		t.Fatalf("Mounted base should be usable: %v", err)
+	}
+	if err := bases.Acquire(back); err != nil {
+		t.Fatalf("Failed to acquire base image: %v", err)
+	}
+	if err := bases.Release(back); err != nil {
+		t.Fatalf("Failed to release base image: %v", err)
+	}
+	if mounter.unmounts != 0 || mounter.mounts[point] != back.ImagePath {
+		t.Fatal("Base mounted by root should be left as is")
+	}
+}
@@ -0,0 +1,310 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchPausedSuffix is appended to the checkpoint path to form the file
+// marking the batch as paused. As it lives alongside the checkpoint, a
+// paused batch remains paused when restarted.
+const BatchPausedSuffix = ".paused"
+
+// BatchPollInterval is how often a paused batch checks whether it has been
+// resumed by another process.
+var BatchPollInterval = 5 * time.Second
+
+// BatchState describes whether a batch is dispatching new builds
+type BatchState string
+
+const (
+	// BatchIdle is the state of a batch that isn't running
+	BatchIdle BatchState = "idle"
+
+	// BatchRunning is the state of a batch dispatching builds
+	BatchRunning BatchState = "running"
+
+	// BatchPaused is the state of a batch that won't start new builds
+	BatchPaused BatchState = "paused"
+)
+
+// A BatchBuilder is used by BuildAll to build each individual package
+type BatchBuilder func(pkg *Package) error
+
+// A BatchCheckpoint records each successfully built package within a batch
+// build, so that an interrupted batch may resume where it left off.
+type BatchCheckpoint struct {
+	ArtifactDir string // Where the built packages are collected to
+
+	path      string          // Path to the checkpoint file
+	completed map[string]bool // Set of completed package keys
+}
+
+// checkpointKey will return the unique key for the package in the checkpoint
+func checkpointKey(pkg *Package) string {
+	return fmt.Sprintf("%s %s %d", pkg.Name, pkg.Version, pkg.Release)
+}
+
+// NewBatchCheckpoint will load the checkpoint at the given path, if it exists,
+// otherwise an empty checkpoint is returned.
+func NewBatchCheckpoint(path string) (*BatchCheckpoint, error) {
+	c := &BatchCheckpoint{
+		ArtifactDir: ".",
+		path:        path,
+		completed:   make(map[string]bool),
+	}
+	fi, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	defer fi.Close()
+
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		c.completed[line] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// hasArtifacts will determine whether the built packages still exist
+func (c *BatchCheckpoint) hasArtifacts(pkg *Package) bool {
+	pat := filepath.Join(c.ArtifactDir, fmt.Sprintf("%s-%s-%d-*.eopkg", pkg.Name, pkg.Version, pkg.Release))
+	matches, _ := filepath.Glob(pat)
+	return len(matches) > 0
+}
+
+// IsComplete will determine whether the package was already built in a
+// previous run, and that its artifacts are still present.
+func (c *BatchCheckpoint) IsComplete(pkg *Package) bool {
+	return c.completed[checkpointKey(pkg)] && c.hasArtifacts(pkg)
+}
+
+// MarkComplete will record the package as successfully built, syncing the
+// checkpoint to disk immediately so that it survives a crash.
+func (c *BatchCheckpoint) MarkComplete(pkg *Package) error {
+	key := checkpointKey(pkg)
+	fi, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 00644)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	if _, err := fmt.Fprintf(fi, "%s\n", key); err != nil {
+		return err
+	}
+	if err := fi.Sync(); err != nil {
+		return err
+	}
+	c.completed[key] = true
+	return nil
+}
+
+// Reset will clear the checkpoint, forcing all packages to be rebuilt
+func (c *BatchCheckpoint) Reset() error {
+	c.completed = make(map[string]bool)
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PausePath will return the path of the file marking the batch as paused
+func (c *BatchCheckpoint) PausePath() string {
+	return c.path + BatchPausedSuffix
+}
+
+// IsPaused will determine whether the batch has been paused
+func (c *BatchCheckpoint) IsPaused() bool {
+	return PathExists(c.PausePath())
+}
+
+// SetPaused will persist whether the batch is paused
+func (c *BatchCheckpoint) SetPaused(paused bool) error {
+	if !paused {
+		if err := os.Remove(c.PausePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	fi, err := os.OpenFile(c.PausePath(), os.O_WRONLY|os.O_CREATE, 00644)
+	if err != nil {
+		return err
+	}
+	return fi.Close()
+}
+
+// A BatchRunner builds a batch of packages in order, and may be paused so
+// that no new builds are started until it is resumed. The build in
+// progress when paused is always allowed to complete.
+type BatchRunner struct {
+	Results []BatchResult // Outcome of each package in the last run
+
+	checkpoint *BatchCheckpoint
+	build      BatchBuilder
+	lock       sync.Mutex
+	running    bool
+	resumed    chan bool
+}
+
+// NewBatchRunner will return a runner recording progress to the checkpoint
+func NewBatchRunner(checkpoint *BatchCheckpoint, build BatchBuilder) *BatchRunner {
+	return &BatchRunner{
+		checkpoint: checkpoint,
+		build:      build,
+		resumed:    make(chan bool, 1),
+	}
+}
+
+// Pause will stop the batch from starting any new builds
+func (r *BatchRunner) Pause() error {
+	if err := r.checkpoint.SetPaused(true); err != nil {
+		return err
+	}
+	log.Info("Pausing batch once the current build completes")
+	return nil
+}
+
+// Resume will allow a paused batch to continue with the next build
+func (r *BatchRunner) Resume() error {
+	if err := r.checkpoint.SetPaused(false); err != nil {
+		return err
+	}
+	select {
+	case r.resumed <- true:
+	default:
+	}
+	log.Info("Resuming batch")
+	return nil
+}
+
+// State will return whether the batch is running, paused or idle
+func (r *BatchRunner) State() BatchState {
+	if r.checkpoint.IsPaused() {
+		return BatchPaused
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.running {
+		return BatchRunning
+	}
+	return BatchIdle
+}
+
+// waitWhilePaused will block until the batch is resumed, either through
+// Resume or by another process removing the pause file.
+func (r *BatchRunner) waitWhilePaused() {
+	if !r.checkpoint.IsPaused() {
+		return
+	}
+	log.WithFields(log.Fields{
+		"resume": r.checkpoint.PausePath(),
+	}).Info("Batch is paused, waiting to resume")
+	for r.checkpoint.IsPaused() {
+		select {
+		case <-r.resumed:
+		case <-time.After(BatchPollInterval):
+		}
+	}
+}
+
+// Run will build each of the packages in order, skipping any that the
+// checkpoint shows to be already built, unless force is set. The batch will
+// stop at the first failure, and may later be resumed from the checkpoint.
+// The outcome of every package is recorded within Results.
+func (r *BatchRunner) Run(pkgs []*Package, force bool) error {
+	r.Results = nil
+	if force {
+		if err := r.checkpoint.Reset(); err != nil {
+			return err
+		}
+	}
+	r.lock.Lock()
+	r.running = true
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		r.running = false
+		r.lock.Unlock()
+	}()
+
+	for i, pkg := range pkgs {
+		fields := log.Fields{
+			"package": pkg.Name,
+			"version": pkg.Version,
+			"release": pkg.Release,
+			"index":   fmt.Sprintf("%d/%d", i+1, len(pkgs)),
+		}
+		if r.checkpoint.IsComplete(pkg) {
+			log.WithFields(fields).Info("Skipping previously built package")
+			r.Results = append(r.Results, BatchResult{Package: pkg, Skipped: "Built by a previous run of the batch"})
+			continue
+		}
+
+		r.waitWhilePaused()
+		log.WithFields(fields).Info("Building package in batch")
+		result := BatchResult{Package: pkg, Start: time.Now()}
+		err := r.build(pkg)
+		result.Duration = time.Since(result.Start)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"package": pkg.Name,
+				"error":   err,
+			}).Error("Batch build failed")
+			result.Err = err
+			if buildErr, ok := err.(*BatchBuildError); ok {
+				result.Output = buildErr.Output
+			}
+			r.Results = append(r.Results, result)
+			for _, skipped := range pkgs[i+1:] {
+				r.Results = append(r.Results, BatchResult{Package: skipped, Skipped: "Batch stopped after an earlier failure"})
+			}
+			return err
+		}
+		r.Results = append(r.Results, result)
+
+		if err := r.checkpoint.MarkComplete(pkg); err != nil {
+			log.WithFields(log.Fields{
+				"package": pkg.Name,
+				"error":   err,
+			}).Error("Failed to update batch checkpoint")
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildAll will build each of the packages in order with a new BatchRunner
+func BuildAll(pkgs []*Package, checkpoint *BatchCheckpoint, force bool, build BatchBuilder) error {
+	return NewBatchRunner(checkpoint, build).Run(pkgs, force)
+}
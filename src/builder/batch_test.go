@@ -0,0 +1,206 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildAllResume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-batch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var pkgs []*Package
+	for _, name := range []string{"nano", "vim", "emacs"} {
+		pkgs = append(pkgs, &Package{Name: name, Version: "1.0", Release: 1, Type: PackageTypeYpkg})
+	}
+	checkpointPath := filepath.Join(tmp, "checkpoint")
+
+	var built []string
+	builder := func(crashOn string) BatchBuilder {
+		return func(pkg *Package) error {
+			if pkg.Name == crashOn {
+				return errors.New("simulated crash")
+			}
+			built = append(built, pkg.Name)
+			artifact := filepath.Join(tmp, fmt.Sprintf("%s-%s-%d-1-x86_64.eopkg", pkg.Name, pkg.Version, pkg.Release))
+			return ioutil.WriteFile(artifact, nil, 00644)
+		}
+	}
+
+	// Crash midway through the batch
+	checkpoint, err := NewBatchCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	checkpoint.ArtifactDir = tmp
+	if err := BuildAll(pkgs, checkpoint, false, builder("vim")); err == nil {
+		t.Fatal("Batch should fail on the crashed package")
+	}
+
+	// Restart should only build the unfinished packages
+	built = nil
+	checkpoint, err = NewBatchCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatalf("Failed to reload checkpoint: %v", err)
+	}
+	checkpoint.ArtifactDir = tmp
+	if err := BuildAll(pkgs, checkpoint, false, builder("")); err != nil {
+		t.Fatalf("Resumed batch failed: %v", err)
+	}
+	if len(built) != 2 || built[0] != "vim" || built[1] != "emacs" {
+		t.Fatalf("Resumed batch built the wrong packages: %v", built)
+	}
+
+	// Missing artifacts must be rebuilt
+	os.Remove(filepath.Join(tmp, "nano-1.0-1-1-x86_64.eopkg"))
+	built = nil
+	if err := BuildAll(pkgs, checkpoint, false, builder("")); err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if len(built) != 1 || built[0] != "nano" {
+		t.Fatalf("Batch should only rebuild the missing package: %v", built)
+	}
+
+	// Forcing ignores the checkpoint entirely
+	built = nil
+	if err := BuildAll(pkgs, checkpoint, true, builder("")); err != nil {
+		t.Fatalf("Forced batch failed: %v", err)
+	}
+	if len(built) != 3 {
+		t.Fatalf("Forced batch should rebuild everything: %v", built)
+	}
+}
+
+func TestBatchPause(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-batch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	oldInterval := BatchPollInterval
+	BatchPollInterval = 10 * time.Millisecond
+	defer func() {
+		BatchPollInterval = oldInterval
+	}()
+
+	var pkgs []*Package
+	for _, name := range []string{"nano", "vim", "emacs"} {
+		pkgs = append(pkgs, &Package{Name: name, Version: "1.0", Release: 1, Type: PackageTypeYpkg})
+	}
+	checkpoint, err := NewBatchCheckpoint(filepath.Join(tmp, "checkpoint"))
+	if err != nil {
+		t.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	checkpoint.ArtifactDir = tmp
+
+	started := make(chan string)
+	finish := make(chan bool)
+	runner := NewBatchRunner(checkpoint, func(pkg *Package) error {
+		started <- pkg.Name
+		<-finish
+		artifact := filepath.Join(tmp, fmt.Sprintf("%s-%s-%d-1-x86_64.eopkg", pkg.Name, pkg.Version, pkg.Release))
+		return ioutil.WriteFile(artifact, nil, 00644)
+	})
+	if state := runner.State(); state != BatchIdle {
+		t.Fatalf("Runner should be idle before running: %s", state)
+	}
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(pkgs, false)
+	}()
+
+	// Pause while the first package is in flight, it must still complete
+	if name := <-started; name != "nano" {
+		t.Fatalf("Wrong first package: %s", name)
+	}
+	if err := runner.Pause(); err != nil {
+		t.Fatalf("Failed to pause batch: %v", err)
+	}
+	if state := runner.State(); state != BatchPaused {
+		t.Fatalf("Runner should report paused: %s", state)
+	}
+	finish <- true
+
+	select {
+	case name := <-started:
+		t.Fatalf("Paused batch started a new build: %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	reloaded, err := NewBatchCheckpoint(filepath.Join(tmp, "checkpoint"))
+	if err != nil {
+		t.Fatalf("Failed to reload checkpoint: %v", err)
+	}
+	reloaded.ArtifactDir = tmp
+	if !reloaded.IsComplete(pkgs[0]) || reloaded.IsComplete(pkgs[1]) {
+		t.Fatalf("Checkpoint should only hold the completed package")
+	}
+	if !reloaded.IsPaused() {
+		t.Fatalf("Paused state should be persisted")
+	}
+
+	if err := runner.Resume(); err != nil {
+		t.Fatalf("Failed to resume batch: %v", err)
+	}
+	for _, expected := range []string{"vim", "emacs"} {
+		if name := <-started; name != expected {
+			t.Fatalf("Wrong package after resume: %s", name)
+		}
+		if state := runner.State(); state != BatchRunning {
+			t.Fatalf("Runner should report running: %s", state)
+		}
+		finish <- true
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	// A restarted batch stays paused until resumed by another process
+	if err := checkpoint.SetPaused(true); err != nil {
+		t.Fatalf("Failed to pause batch: %v", err)
+	}
+	os.Remove(filepath.Join(tmp, "emacs-1.0-1-1-x86_64.eopkg"))
+	runner = NewBatchRunner(checkpoint, runner.build)
+	go func() {
+		done <- runner.Run(pkgs, false)
+	}()
+	select {
+	case name := <-started:
+		t.Fatalf("Restarted paused batch started a build: %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := checkpoint.SetPaused(false); err != nil {
+		t.Fatalf("Failed to resume batch: %v", err)
+	}
+	if name := <-started; name != "emacs" {
+		t.Fatalf("Restarted batch should only rebuild emacs: %s", name)
+	}
+	finish <- true
+	if err := <-done; err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+}
@@ -17,12 +17,15 @@
 package builder
 
 import (
+	"builder/source"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/disk"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // CreateDirs creates any directories we may need later on
@@ -74,22 +77,120 @@ func (p *Package) CreateDirs(o *Overlay) error {
 }
 
 // FetchSources will attempt to fetch the sources from the network
-// if necessary
+// if necessary. Sources are dispatched in order of priority, with up to
+// FetchJobs sources fetched at once, and no more than the HostConcurrency
+// of the download policy from one host at once. With AdaptiveFetch, fewer
+// may be used if they fetch no faster. Concurrent downloads
+// share a single progress display, with a line for each. When building,
+// torrent sources are then seeded if SeedTorrents is set.
 func (p *Package) FetchSources(o *Overlay) error {
-	for _, source := range p.Sources {
+	labels := getMetricLabels(p, o)
+	pool := newFetchPool(FetchJobs, AdaptiveFetch)
+
+	var pending []source.Source
+	for _, src := range p.getFetchOrder() {
+		// Streamed straight into the build root instead
+		if p.isStreamed(src) {
+			continue
+		}
 		// Already fetched, skip it
-		if source.IsFetched() {
+		if src.IsFetched() {
+			ActiveMetrics.AddCounter(MetricCacheHits, labels, 1)
 			continue
 		}
-		if err := source.Fetch(); err != nil {
-			log.WithFields(log.Fields{
-				"error":  err,
-				"source": source.GetIdentifier(),
-			}).Error("Failed to fetch source")
-			return err
+		ActiveMetrics.AddCounter(MetricCacheMisses, labels, 1)
+		pending = append(pending, src)
+	}
+	progress := getFetchProgress(p.Name, pending)
+	defer source.ReleaseConnections()
+
+	// Concurrent downloads share one display
+	var display *source.MultiProgress
+	if FetchJobs > 1 && len(pending) > 1 {
+		display = source.NewMultiProgress(os.Stdout)
+		display.SetSummary(func() string { return progress.Status().String() })
+		source.SetMultiProgress(display)
+		defer func() {
+			source.SetMultiProgress(nil)
+			display.Stop()
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var fetchErr error
+	hosts := make(map[string]chan bool)
+	hostLimit := source.GetNetworkPolicy(source.NetworkDownload).Concurrency()
+
+	for _, src := range pending {
+		pool.acquire()
+		lock.Lock()
+		failed := fetchErr != nil
+		lock.Unlock()
+		if failed {
+			pool.release(0, nil)
+			break
 		}
+
+		var hostSem chan bool
+		if h, ok := src.(hostSource); ok {
+			if _, ok := hosts[h.GetHost()]; !ok {
+				hosts[h.GetHost()] = make(chan bool, hostLimit)
+			}
+			hostSem = hosts[h.GetHost()]
+		}
+
+		wg.Add(1)
+		go func(src source.Source) {
+			defer wg.Done()
+			if hostSem != nil {
+				hostSem <- true
+				defer func() { <-hostSem }()
+			}
+			if err := src.Fetch(); err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"source": src.GetIdentifier(),
+				}).Error("Failed to fetch source")
+				lock.Lock()
+				if fetchErr == nil {
+					fetchErr = &FetchError{Source: src.GetIdentifier(), Err: err}
+				}
+				lock.Unlock()
+				pool.release(0, err)
+				return
+			}
+			ActiveMetrics.AddCounter(MetricDownloads, labels, 1)
+			size := int64(-1)
+			if st, err := os.Stat(src.GetBindConfiguration("").BindSource); err == nil && !st.IsDir() {
+				size = st.Size()
+				ActiveMetrics.AddCounter(MetricBytesFetched, labels, float64(size))
+			}
+			pool.release(size, nil)
+			ActiveEvents.Emit(&Event{Type: EventFetchComplete, Package: p.Name, Source: src.GetIdentifier(), Done: size})
+			if progress != nil {
+				progress.Complete(src.GetIdentifier(), size)
+				if display == nil {
+					log.Info(progress.Status().String())
+				}
+			}
+		}(src)
 	}
-	return nil
+	wg.Wait()
+	if fetchErr == nil && o != nil && SeedTorrents {
+		p.SeedSources()
+	}
+	return fetchErr
+}
+
+// GetSourceInfo will return the information and fetch state of each of
+// the package sources, without fetching them.
+func (p *Package) GetSourceInfo() []source.Info {
+	var info []source.Info
+	for _, src := range p.Sources {
+		info = append(info, source.GetInfo(src))
+	}
+	return info
 }
 
 // BindSources will make the sources available to the chroot by bind mounting
@@ -97,10 +198,9 @@ func (p *Package) FetchSources(o *Overlay) error {
 func (p *Package) BindSources(o *Overlay) error {
 	mountMan := disk.GetMountManager()
 
-	for _, source := range p.Sources {
-		sourceDir := p.GetSourceDir(o)
-		bindConfig := source.GetBindConfiguration(sourceDir)
+	sourceDir := p.GetSourceDir(o)
 
+	for _, bindConfig := range p.getSourceBindConfigurations(o) {
 		// Ensure sources tree exists
 		if !PathExists(sourceDir) {
 			if err := os.MkdirAll(sourceDir, 00755); err != nil {
@@ -155,14 +255,9 @@ func (p *Package) BindSources(o *Overlay) error {
 // BindCcache will make the ccache directory available to the build
 func (p *Package) BindCcache(o *Overlay) error {
 	mountMan := disk.GetMountManager()
-	ccacheDir := p.GetCcacheDir(o)
-
-	var ccacheSource string
-	if p.Type == PackageTypeXML {
-		ccacheSource = LegacyCcacheDirectory
-	} else {
-		ccacheSource = CcacheDirectory
-	}
+	bindConfig := p.getCcacheBindConfiguration(o)
+	ccacheDir := bindConfig.BindTarget
+	ccacheSource := bindConfig.BindSource
 
 	log.WithFields(log.Fields{
 		"dir": ccacheDir,
@@ -180,6 +275,15 @@ func (p *Package) BindCcache(o *Overlay) error {
 	return nil
 }
 
+// GetCcacheSource will return the host side ccache directory for the given
+// build type.
+func (p *Package) GetCcacheSource() string {
+	if p.Type == PackageTypeXML {
+		return LegacyCcacheDirectory
+	}
+	return CcacheDirectory
+}
+
 // GetWorkDir will return the externally visible work directory for the
 // given build type.
 func (p *Package) GetWorkDir(o *Overlay) string {
@@ -208,6 +312,18 @@ func (p *Package) GetSourceDirInternal() string {
 	return filepath.Join(BuildUserHome, "YPKG", "sources")
 }
 
+// GetSourceTreeDir will return the externally visible directory that
+// archives are extracted into when the sources are staged
+func (p *Package) GetSourceTreeDir(o *Overlay) string {
+	return filepath.Join(o.MountPoint, p.GetSourceTreeDirInternal()[1:])
+}
+
+// GetSourceTreeDirInternal will return the chroot-internal directory that
+// archives are extracted into, alongside the source directory.
+func (p *Package) GetSourceTreeDirInternal() string {
+	return filepath.Join(filepath.Dir(p.GetSourceDirInternal()), "tree")
+}
+
 // GetCcacheDir will return the externally visible ccache directory
 func (p *Package) GetCcacheDir(o *Overlay) string {
 	return filepath.Join(o.MountPoint, p.GetCcacheDirInternal()[1:])
@@ -306,10 +422,18 @@ func (p *Package) PrepYpkg(notif PidNotifier, usr *UserInfo, pman *EopkgManager,
 			"buildFile": ymlFile,
 			"error":     err,
 		}).Error("Failed to install build dependencies")
-		return err
+		return newBuildToolError("ypkg-install-deps", err)
 	}
 	notif.SetActivePID(0)
 
+	if layer := overlay.Layer; layer != nil && !layer.IsCached() {
+		if err := layer.Store(overlay.UpperDir); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Failed to cache dependency layer")
+		}
+	}
+
 	// Cleanup now
 	log.Debug("Stopping D-BUS")
 	if err := pman.StopDBUS(); err != nil {
@@ -339,7 +463,7 @@ func (p *Package) BuildYpkg(notif PidNotifier, usr *UserInfo, pman *EopkgManager
 	}
 
 	// Now kill networking
-	if !p.CanNetwork {
+	if !p.CanNetwork || source.Offline {
 		if err := DropNetworking(); err != nil {
 			return err
 		}
@@ -348,7 +472,10 @@ func (p *Package) BuildYpkg(notif PidNotifier, usr *UserInfo, pman *EopkgManager
 		if err := overlay.ConfigureNetworking(); err != nil {
 			return err
 		}
-	} else {
+	}
+	if p.CanNetwork && source.Offline {
+		log.Warning("Package has explicitly requested networking, but networking is disabled in offline mode")
+	} else if p.CanNetwork {
 		log.Warning("Package has explicitly requested networking, sandboxing disabled")
 	}
 
@@ -389,11 +516,11 @@ func (p *Package) BuildYpkg(notif PidNotifier, usr *UserInfo, pman *EopkgManager
 	log.WithFields(log.Fields{
 		"package": p.Name,
 	}).Info("Now starting build of package")
-	if err := ChrootExec(notif, overlay.MountPoint, cmd); err != nil {
+	if err := p.ChrootBuild(notif, overlay, cmd); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Error("Failed to build package")
-		return err
+		return newBuildToolError("ypkg-build", err)
 	}
 	notif.SetActivePID(0)
 	return nil
@@ -430,11 +557,11 @@ func (p *Package) BuildXML(notif PidNotifier, pman *EopkgManager, overlay *Overl
 	log.WithFields(log.Fields{
 		"package": p.Name,
 	}).Info("Now starting build of package")
-	if err := ChrootExec(notif, overlay.MountPoint, cmd); err != nil {
+	if err := p.ChrootBuild(notif, overlay, cmd); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Error("Failed to build package")
-		return err
+		return newBuildToolError("eopkg build", err)
 	}
 	notif.SetActivePID(0)
 
@@ -470,6 +597,8 @@ func (p *Package) CollectAssets(overlay *Overlay, usr *UserInfo) error {
 		"numFiles": len(collections),
 	}).Debug("Collecting files")
 
+	var artifacts []*ArtifactDigest
+
 	for _, p := range collections {
 		tgt, err := filepath.Abs(filepath.Join(".", filepath.Base(p)))
 		if err != nil {
@@ -502,12 +631,66 @@ func (p *Package) CollectAssets(overlay *Overlay, usr *UserInfo) error {
 				"file":  filepath.Base(p),
 			}).Error("Error in restoring file ownership")
 		}
+
+		if !strings.HasSuffix(tgt, ".eopkg") {
+			continue
+		}
+		digest, err := WriteArtifactChecksums(tgt, usr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  filepath.Base(p),
+			}).Error("Failed to write artifact checksums")
+			return err
+		}
+		artifacts = append(artifacts, digest)
+	}
+	p.Artifacts = artifacts
+	return nil
+}
+
+// PrepareSystemBase will upgrade the build root and install system.devel,
+// storing the warm snapshot afterwards if enabled.
+func (p *Package) PrepareSystemBase(pman *EopkgManager, overlay *Overlay) error {
+	log.Debug("Upgrading system base")
+	if err := pman.Upgrade(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to upgrade rootfs")
+		return newBuildToolError("eopkg upgrade", err)
+	}
+
+	log.Debug("Asserting system.devel component installation")
+	if err := pman.InstallComponent("system.devel"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to assert system.devel")
+		return newBuildToolError("eopkg install", err)
+	}
+
+	if warm := overlay.Warm; warm != nil && !warm.IsWarm() {
+		if err := warm.Store(overlay); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Failed to store warm overlay")
+		}
 	}
 	return nil
 }
 
 // Build will attempt to build the package in the overlayfs system
-func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Profile, pman *EopkgManager, overlay *Overlay) error {
+func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Profile, pman *EopkgManager, overlay *Overlay) (err error) {
+	// Fail on predictable problems before doing any real work
+	if err := p.CheckValid(); err != nil {
+		return err
+	}
+
+	phases := NewPhaseLog(os.Stdout, QuietMode)
+	phases.Package = p.Name
+	defer func() {
+		recordBuildMetrics(p, overlay, phases.Finish(err), err)
+	}()
+
 	log.WithFields(log.Fields{
 		"profile": overlay.Back.Name,
 		"version": p.Version,
@@ -518,22 +701,47 @@ func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Pro
 
 	usr := GetUserInfo()
 
-	var env []string
-	if p.Type == PackageTypeXML {
-		env = SaneEnvironment("root", "/root")
-	} else {
-		env = SaneEnvironment(BuildUser, BuildUserHome)
+	ChrootEnvironment = p.GetBuildEnvironment()
+
+	// Sources may be fetched from alternates in the profile
+	p.AddSourceAlternates(profile.Alternates)
+	if err := p.ConfigureSignatures(profile.Keyring); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid source signature")
+		return err
+	}
+
+	if err := p.SelectPatches(profile.Name, TargetArch); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid patch series")
+		return err
+	}
+	if len(p.Patches) > 0 && !p.PrepareOnly {
+		// ypkg-build and eopkg unpack their own copy of the sources
+		log.WithFields(log.Fields{
+			"patches": len(p.Patches),
+		}).Warning("Patch series is only applied to prepared build roots, see --prepare")
 	}
-	ChrootEnvironment = env
 
 	// Set up environment
+	phases.Begin("Preparing build root")
 	if err := overlay.CleanExisting(); err != nil {
+		return &OverlayError{Op: "clean", Err: err}
+	}
+
+	// Reuse previously installed build dependencies where possible
+	if err := p.UseDependencyLayer(overlay); err != nil {
+		return err
+	}
+	if err := p.UseWarmSnapshot(overlay); err != nil {
 		return err
 	}
 
 	// Bring up the root
 	if err := p.ActivateRoot(overlay); err != nil {
-		return err
+		return &OverlayError{Op: "mount", Err: err}
 	}
 
 	// Ensure source assets are in place
@@ -544,12 +752,27 @@ func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Pro
 		return err
 	}
 
+	phases.Begin("Fetching sources (%d)", len(p.Sources))
 	log.Debug("Validating sources")
-	if err := p.FetchSources(overlay); err != nil {
+	if err := p.PrepareSources(overlay); err != nil {
+		return err
+	}
+	if err := p.CheckLicenses(); err != nil {
+		return err
+	}
+	if err := p.VerifyInputLock(overlay); err != nil {
 		return err
 	}
 
+	// Package management may only use local caches from here on
+	if source.Offline {
+		if err := DropNetworking(); err != nil {
+			return err
+		}
+	}
+
 	// Set up package manager
+	phases.Begin("Configuring package manager")
 	if err := pman.Init(); err != nil {
 		return err
 	}
@@ -571,20 +794,17 @@ func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Pro
 		return err
 	}
 
-	log.Debug("Upgrading system base")
-	if err := pman.Upgrade(); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to upgrade rootfs")
+	if err := p.BindTempDir(overlay); err != nil {
 		return err
 	}
 
-	log.Debug("Asserting system.devel component installation")
-	if err := pman.InstallComponent("system.devel"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to assert system.devel")
-		return err
+	if overlay.IsWarm() {
+		log.Info("Reusing warm overlay, skipping system base upgrade")
+	} else {
+		phases.Begin("Upgrading system base")
+		if err := p.PrepareSystemBase(pman, overlay); err != nil {
+			return err
+		}
 	}
 
 	// Ensure all directories are in place
@@ -592,16 +812,58 @@ func (p *Package) Build(notif PidNotifier, history *PackageHistory, profile *Pro
 		return err
 	}
 
+	// Leave the prepared root behind for interactive use
+	if p.PrepareOnly {
+		phases.Begin("Staging sources")
+		return p.Prepare(notif, usr, profile, pman, overlay, history)
+	}
+
+	// Secrets are only available for the duration of the build itself
+	if err := p.MountSecrets(overlay); err != nil {
+		return err
+	}
+	if err := p.RecordEnvironment(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to compare build environment to baseline")
+	}
+
 	// Call the relevant build function
 	if p.Type == PackageTypeYpkg {
-		if err := p.BuildYpkg(notif, usr, pman, overlay, history); err != nil {
-			return err
-		}
+		phases.Begin("Running ypkg-build")
+		err = p.BuildYpkg(notif, usr, pman, overlay, history)
 	} else {
-		if err := p.BuildXML(notif, pman, overlay); err != nil {
-			return err
-		}
+		phases.Begin("Running eopkg build")
+		err = p.BuildXML(notif, pman, overlay)
+	}
+	if scrubErr := p.ScrubSecrets(overlay); scrubErr != nil && err == nil {
+		err = scrubErr
+	}
+	if reportErr := p.ReportUpperLayer(overlay); reportErr != nil {
+		log.WithFields(log.Fields{
+			"error": reportErr,
+		}).Warning("Failed to report overlay upper layer size")
+	}
+	p.ReportProvenance()
+	p.ReportHostFeatures(overlay)
+	if err != nil {
+		return err
 	}
 
-	return p.CollectAssets(overlay, usr)
+	phases.Begin("Collecting build artifacts")
+	if err := p.CollectAssets(overlay, usr); err != nil {
+		return err
+	}
+	if err := p.WriteInputLock(overlay, usr); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to write input lock")
+		return err
+	}
+	if err := p.RecordSources(overlay); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to record sources")
+	}
+	return nil
 }
@@ -0,0 +1,118 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// BuildStdoutSuffix is the suffix of the captured stdout of the build
+	BuildStdoutSuffix = ".stdout.log"
+
+	// BuildStderrSuffix is the suffix of the captured stderr of the build
+	BuildStderrSuffix = ".stderr.log"
+)
+
+// CaptureBuildLogs controls whether the stdout and stderr of the build tool
+// are each saved to a file alongside the packages.
+var CaptureBuildLogs = false
+
+// BuildLogs are the paths of the captured output of a build
+type BuildLogs struct {
+	Stdout string // Path of the captured stdout
+	Stderr string // Path of the captured stderr
+}
+
+// NewBuildLogs will return the log paths for the package, within the
+// current directory.
+func NewBuildLogs(p *Package) (*BuildLogs, error) {
+	stdout, err := filepath.Abs(p.Name + BuildStdoutSuffix)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := filepath.Abs(p.Name + BuildStderrSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &BuildLogs{Stdout: stdout, Stderr: stderr}, nil
+}
+
+// createLog will create the log file, owned by the user
+func createLog(path string, usr *UserInfo) (*os.File, error) {
+	fi, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := fi.Chown(usr.UID, usr.GID); err != nil {
+		fi.Close()
+		return nil, err
+	}
+	return fi, nil
+}
+
+// execCaptured will run the command, saving its stdout and stderr to the
+// separate log files while still streaming both to the console. Output is
+// copied as it arrives, so it is never buffered in memory.
+func execCaptured(notif PidNotifier, c *exec.Cmd, logs *BuildLogs, usr *UserInfo) error {
+	stdout, err := createLog(logs.Stdout, usr)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := createLog(logs.Stderr, usr)
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+
+	c.Stdout = io.MultiWriter(os.Stdout, stdout)
+	c.Stderr = io.MultiWriter(os.Stderr, stderr)
+	if err := c.Start(); err != nil {
+		return err
+	}
+	notif.SetActivePID(c.Process.Pid)
+	return c.Wait()
+}
+
+// ChrootBuild will run the build tool within the chroot, capturing its
+// output in Logs when CaptureBuildLogs is set.
+func (p *Package) ChrootBuild(notif PidNotifier, overlay *Overlay, command string) error {
+	if !CaptureBuildLogs {
+		return ChrootExec(notif, overlay.MountPoint, command)
+	}
+	logs, err := NewBuildLogs(p)
+	if err != nil {
+		return err
+	}
+	p.Logs = logs
+	log.WithFields(log.Fields{
+		"stdout": logs.Stdout,
+		"stderr": logs.Stderr,
+	}).Debug("Capturing build output")
+
+	c := exec.Command("chroot", overlay.MountPoint, "/bin/sh", "-c", command)
+	c.Stdin = nil
+	c.Env = ChrootEnvironment
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return execCaptured(notif, c, logs, GetUserInfo())
+}
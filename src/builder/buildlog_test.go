@@ -0,0 +1,85 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pidRecorder records the PID of the running build
+type pidRecorder struct {
+	pid int
+}
+
+func (r *pidRecorder) SetActivePID(pid int) {
+	r.pid = pid
+}
+
+func TestCaptureBuildLogs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-logs")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	logs := &BuildLogs{
+		Stdout: filepath.Join(tmp, "nano"+BuildStdoutSuffix),
+		Stderr: filepath.Join(tmp, "nano"+BuildStderrSuffix),
+	}
+	usr := &UserInfo{UID: os.Getuid(), GID: os.Getgid()}
+	notif := &pidRecorder{}
+
+	// Interleave the streams, with enough output to fill any pipe buffer
+	script := `for i in $(seq 1 5000); do echo "stdout line $i"; echo "stderr line $i" >&2; done`
+	c := exec.Command("/bin/sh", "-c", script)
+	if err := execCaptured(notif, c, logs, usr); err != nil {
+		t.Fatalf("Failed to run build: %v", err)
+	}
+	if notif.pid == 0 {
+		t.Fatalf("Build PID was not recorded")
+	}
+
+	for path, stream := range map[string]string{logs.Stdout: "stdout", logs.Stderr: "stderr"} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s log: %v", stream, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if len(lines) != 5000 {
+			t.Fatalf("Expected 5000 lines of %s, got %d", stream, len(lines))
+		}
+		for _, line := range lines {
+			if !strings.HasPrefix(line, stream+" line ") {
+				t.Fatalf("Unexpected line in %s log: %s", stream, line)
+			}
+		}
+	}
+
+	// Failures are still reported, with the output kept
+	c = exec.Command("/bin/sh", "-c", "echo broken >&2; exit 1")
+	if err := execCaptured(notif, c, logs, usr); err == nil {
+		t.Fatalf("Failed build should return an error")
+	}
+	if b, _ := ioutil.ReadFile(logs.Stderr); string(b) != "broken\n" {
+		t.Fatalf("Failed build output was not captured: %s", b)
+	}
+}
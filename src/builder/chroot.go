@@ -33,13 +33,7 @@ func (p *Package) Chroot(notif PidNotifier, pman *EopkgManager, overlay *Overlay
 		"release": p.Release,
 	}).Debug("Beginning chroot")
 
-	var env []string
-	if p.Type == PackageTypeXML {
-		env = SaneEnvironment("root", "/root")
-	} else {
-		env = SaneEnvironment(BuildUser, BuildUserHome)
-	}
-	ChrootEnvironment = env
+	ChrootEnvironment = p.GetBuildEnvironment()
 
 	if err := p.ActivateRoot(overlay); err != nil {
 		return err
@@ -17,6 +17,7 @@
 package builder
 
 import (
+	"builder/source"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
@@ -29,6 +30,92 @@ type Config struct {
 	DefaultProfile string `toml:"default_profile"` // Name of the default profile to use
 	EnableTmpfs    bool   `toml:"enable_tmpfs"`    // Whether to enable tmpfs builds or
 	TmpfsSize      string `toml:"tmpfs_size"`      // Bounding size on the tmpfs
+
+	DeduplicateSources bool `toml:"deduplicate_sources"` // Hardlink identical sources together
+	MaxCachedVersions  int  `toml:"max_cached_versions"` // Cached files to keep per source name, 0 for all
+
+	DiskHeadroom    uint64 `toml:"disk_headroom"`     // Space in MiB to reserve for builds
+	StrictDiskCheck bool   `toml:"strict_disk_check"` // Refuse to build with insufficient space
+
+	DecompressionJobs int `toml:"decompression_jobs"` // Threads to use for xz decompression of extracted archives
+
+	MaxExtractSize    int64 `toml:"max_extract_size"`    // Most MiB to write when extracting an archive
+	MaxExtractEntries int   `toml:"max_extract_entries"` // Most entries to extract from an archive
+
+	MaxRedirects int `toml:"max_redirects"` // Redirects to follow when fetching, -1 for all
+
+	FetchJobs     int  `toml:"fetch_jobs"`     // Sources to fetch at the same time
+	AdaptiveFetch bool `toml:"adaptive_fetch"` // Adapt fetch_jobs to the measured throughput
+
+	StreamSources bool `toml:"stream_sources"` // Stream uncached archives into prepared build roots
+
+	ReuseConnections bool `toml:"reuse_connections"` // Keep connections open between fetches from one host
+
+	DownloadConnections int `toml:"download_connections"` // Connections to fetch one large file with
+
+	SeedTorrents bool `toml:"seed_torrents"` // Seed torrent sources while building
+
+	IPFSGateway string `toml:"ipfs_gateway"` // HTTP gateway to fetch ipfs:// sources through
+	IPFSDaemon  bool   `toml:"ipfs_daemon"`  // Fetch ipfs:// sources through the local daemon
+
+	Headers map[string]map[string]string `toml:"headers"` // Custom HTTP headers per host
+
+	CredentialsFile string `toml:"credentials_file"` // Credentials for private source hosts
+
+	Mirrors map[string]string `toml:"mirrors"` // URL prefixes to try a mirror for first
+
+	ArchiveURL string `toml:"archive_url"` // Content addressed archive to fetch by hash as a last resort
+
+	Offline bool `toml:"offline"` // Never touch the network, using only local caches
+
+	Hosts  map[string]string `toml:"hosts"`   // Addresses to pin download hosts to
+	DoHURL string            `toml:"doh_url"` // DNS-over-HTTPS resolver for download hosts
+
+	RequireSecureSources bool     `toml:"require_secure_sources"` // Reject sources using plaintext schemes
+	InsecureHosts        []string `toml:"insecure_hosts"`         // Hosts still allowed to use plaintext schemes
+
+	TempDir string `toml:"temp_dir"` // Directory for intermediate files
+
+	SourceDir  string `toml:"source_dir"`  // Where to cache sources
+	OverlayDir string `toml:"overlay_dir"` // Where to create build roots
+
+	Secrets map[string]string `toml:"secrets"` // Secrets exposed only during the build
+
+	CacheDependencyLayers bool `toml:"cache_dependency_layers"` // Reuse installed build dependencies
+
+	OverlayOptions []string `toml:"overlay_options"` // Extra overlayfs mount options
+
+	RecordHostFeatures bool `toml:"record_host_features"` // Capture the kernel and overlayfs features of builds
+
+	ArtifactSHA512 bool `toml:"artifact_sha512"` // Also write sha512sum files for packages
+
+	WarmOverlays bool `toml:"warm_overlays"` // Restore prepared build roots between builds
+
+	KeepFailedOverlays int `toml:"keep_failed_overlays"` // Most recent failed overlays to keep per profile
+
+	MetricsFile string `toml:"metrics_file"` // Where to write Prometheus metrics, if set
+
+	EventSocket string `toml:"event_socket"` // Unix socket to stream build events to, if set
+
+	ScratchDirs []string `toml:"scratch_dirs"` // Chroot paths to mount a tmpfs over
+
+	LowerLayers []string `toml:"lower_layers"` // Read-only layers to stack on the image, topmost first
+
+	WriteInputLocks bool `toml:"write_input_locks"` // Record the exact inputs of each build
+
+	SkipBuilt bool `toml:"skip_built"` // Skip builds whose artifacts already exist
+
+	CaptureBuildLogs bool `toml:"capture_build_logs"` // Save the build stdout and stderr separately
+
+	EnvironmentBaseline string `toml:"environment_baseline"` // Stored build environment to compare against
+
+	FTPMode string `toml:"ftp_mode"` // Passive or active FTP data connections
+
+	LicenseCheck string `toml:"license_check"` // Off, warn or fail for sources without a license file
+
+	TargetArch string `toml:"target_arch"` // Architecture to build for, defaults to the host
+
+	Network map[string]NetworkConfig `toml:"network"` // Retry and timeout policy for network operations
 }
 
 var (
@@ -50,6 +137,32 @@ func NewConfig() (*Config, error) {
 		DefaultProfile: "main-x86_64",
 		EnableTmpfs:    false,
 		TmpfsSize:      "",
+
+		DeduplicateSources: false,
+
+		DiskHeadroom:    DefaultDiskHeadroom,
+		StrictDiskCheck: false,
+
+		DecompressionJobs: 0,
+
+		MaxExtractSize:    source.MaxExtractSize / 1024 / 1024,
+		MaxExtractEntries: source.MaxExtractEntries,
+
+		MaxRedirects: -1,
+
+		FetchJobs: 1,
+
+		DownloadConnections: 1,
+
+		IPFSGateway: source.IPFSGateway,
+
+		CacheDependencyLayers: false,
+
+		CredentialsFile: DefaultCredentialsFile,
+
+		FTPMode: source.FTPModePassive,
+
+		LicenseCheck: LicenseCheckOff,
 	}
 
 	// Reverse because /etc takes precedence in stateless
@@ -0,0 +1,66 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"os"
+)
+
+// DefaultCredentialsFile is where credentials for private source hosts are
+// read from, unless set otherwise in the configuration.
+const DefaultCredentialsFile = "/etc/solbuild/credentials"
+
+// credentialsFile is the layout of the credentials file
+type credentialsFile struct {
+	Hosts map[string]source.Credentials `toml:"host"` // Credentials keyed by host
+}
+
+// LoadCredentials will load the credentials used to fetch sources from
+// private hosts. A missing file is not an error, but the file must not be
+// accessible to other users.
+func LoadCredentials(path string) error {
+	source.HostCredentials = nil
+	if path == "" || !PathExists(path) {
+		return nil
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("Credentials file %s must not be accessible to other users", path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var creds credentialsFile
+	if _, err := toml.Decode(string(b), &creds); err != nil {
+		return err
+	}
+	for host, c := range creds.Hosts {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("Invalid credentials for %s: %v", host, err)
+		}
+	}
+	source.HostCredentials = creds.Hosts
+	return nil
+}
@@ -0,0 +1,56 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	defer func() {
+		source.HostCredentials = nil
+	}()
+	tmp, err := ioutil.TempDir("", "solbuild-credentials")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// No credentials is fine
+	if err := LoadCredentials(filepath.Join(tmp, "credentials")); err != nil {
+		t.Fatalf("Missing credentials file should be ignored: %v", err)
+	}
+
+	path := filepath.Join(tmp, "credentials")
+	contents := "[host.\"gitlab.example.com\"]\ntoken = \"sekrit\"\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 00644); err != nil {
+		t.Fatalf("Failed to write credentials: %v", err)
+	}
+	if err := LoadCredentials(path); err == nil {
+		t.Fatalf("Credentials readable by other users should be rejected")
+	}
+	if err := os.Chmod(path, 00600); err != nil {
+		t.Fatalf("Failed to change mode of credentials: %v", err)
+	}
+	if err := LoadCredentials(path); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+}
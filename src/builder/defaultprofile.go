@@ -0,0 +1,88 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultProfileConfig is the name of the configuration file, within the
+// first of the ConfigPaths, that persists the default profile. As the last
+// system configuration loaded, it overrides any vendor default.
+const DefaultProfileConfig = "99_default_profile.conf"
+
+// GetDefaultProfile will return the name of the profile used when none is
+// given, as set by the configuration files.
+func GetDefaultProfile() (string, error) {
+	config, err := NewConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.DefaultProfile, nil
+}
+
+// SetDefaultProfile will persist the named profile as the default, which
+// must exist locally. The configuration is replaced atomically, so a
+// concurrent reader will only ever see the old or new default.
+func SetDefaultProfile(name string) error {
+	if _, err := NewProfile(name); err != nil {
+		return err
+	}
+
+	dir := ConfigPaths[0]
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".default_profile")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	contents := fmt.Sprintf("# Written by solbuild, the profile used in the absence of \"-p\"\ndefault_profile = %q\n", name)
+	if _, err := tmp.WriteString(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(00644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, DefaultProfileConfig)); err != nil {
+		return err
+	}
+
+	// Another configuration may still take precedence
+	if current, err := GetDefaultProfile(); err == nil && current != name {
+		log.WithFields(log.Fields{
+			"profile": name,
+			"current": current,
+		}).Warning("Default profile is overridden by another configuration file")
+	}
+	return nil
+}
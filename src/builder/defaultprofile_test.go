@@ -0,0 +1,73 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetDefaultProfile(t *testing.T) {
+	oldPaths := ConfigPaths
+	defer func() {
+		ConfigPaths = oldPaths
+	}()
+	tmp, err := ioutil.TempDir("", "solbuild-profile")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	etc := filepath.Join(tmp, "etc")
+	vendor := filepath.Join(tmp, "vendor")
+	ConfigPaths = []string{etc, vendor}
+	if err := os.MkdirAll(vendor, 00755); err != nil {
+		t.Fatalf("Failed to create vendor directory: %v", err)
+	}
+	files := map[string]string{
+		"99_unstable.conf":        "default_profile = \"unstable-x86_64\"\n",
+		"unstable-x86_64.profile": "image = \"unstable-x86_64\"\n",
+		"main-x86_64.profile":     "image = \"main-x86_64\"\n",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(vendor, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if profile, err := GetDefaultProfile(); err != nil || profile != "unstable-x86_64" {
+		t.Fatalf("Wrong vendor default profile: %s %v", profile, err)
+	}
+	if err := SetDefaultProfile("main-x86_64"); err != nil {
+		t.Fatalf("Failed to set default profile: %v", err)
+	}
+	if profile, err := GetDefaultProfile(); err != nil || profile != "main-x86_64" {
+		t.Fatalf("Default profile was not persisted: %s %v", profile, err)
+	}
+
+	if err := SetDefaultProfile("bogus-x86_64"); err != ErrInvalidProfile {
+		t.Fatalf("Expected unknown profile to be rejected, got: %v", err)
+	}
+	if profile, _ := GetDefaultProfile(); profile != "main-x86_64" {
+		t.Fatalf("Rejected profile changed the default: %s", profile)
+	}
+	if entries, _ := ioutil.ReadDir(etc); len(entries) != 1 {
+		t.Fatalf("Temporary files left behind: %d entries", len(entries))
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DependencyLayerDir is where the cached dependency layers are stored
+var DependencyLayerDir = "/var/cache/solbuild/layers"
+
+// CacheDependencyLayers controls whether the build dependencies installed
+// into the overlay are cached as a layer, to be reused by later builds with
+// the same dependencies.
+var CacheDependencyLayers = false
+
+// A DependencyLayer is a cached snapshot of the overlay after installing
+// the build dependencies of a package. When available it is used as an
+// additional lower layer, avoiding the need to reinstall the dependencies.
+type DependencyLayer struct {
+	Key string // Key computed from the base image and dependencies
+	Dir string // Where the layer is stored
+}
+
+// getDependencyLayerKey will compute the key for the given dependencies
+// on top of the backing image. Any change to the image, i.e. an update,
+// will result in a new key.
+func getDependencyLayerKey(back *BackingImage, deps []string) (string, error) {
+	st, err := os.Stat(back.ImagePath)
+	if err != nil {
+		return "", err
+	}
+
+	sorted := make([]string, len(deps))
+	for i, dep := range deps {
+		sorted[i] = strings.TrimSpace(dep)
+	}
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00", back.ImagePath, st.Size(), st.ModTime().UnixNano())
+	// Cached layers are only valid above the same configured layers
+	for _, layer := range LowerLayers {
+		var mtime int64
+		if lst, err := os.Stat(layer); err == nil {
+			mtime = lst.ModTime().UnixNano()
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", layer, mtime)
+	}
+	h.Write([]byte(strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewDependencyLayer will return the dependency layer for the package
+// within the given overlay, or nil if layer caching is not applicable.
+func NewDependencyLayer(o *Overlay) (*DependencyLayer, error) {
+	if !CacheDependencyLayers || o.Package.Type != PackageTypeYpkg || len(o.Package.BuildDeps) < 1 {
+		return nil, nil
+	}
+	key, err := getDependencyLayerKey(o.Back, o.Package.BuildDeps)
+	if err != nil {
+		return nil, err
+	}
+	return &DependencyLayer{
+		Key: key,
+		Dir: filepath.Join(DependencyLayerDir, o.Back.Name, o.Package.Name, key),
+	}, nil
+}
+
+// IsCached will determine whether the layer has already been prepared
+func (d *DependencyLayer) IsCached() bool {
+	return PathExists(d.Dir)
+}
+
+// Store will snapshot the given upper directory as the cached layer, and
+// remove any stale layers for the package.
+func (d *DependencyLayer) Store(upperDir string) error {
+	parent := filepath.Dir(d.Dir)
+	if err := os.MkdirAll(parent, 00755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(parent, ".layer")
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"key": d.Key,
+	}).Debug("Caching dependency layer")
+
+	if err := copyTree(upperDir, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := pruneStaleLayers(parent, d.Key); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := os.Rename(tmpDir, d.Dir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+// copyTree will copy the contents of one directory into another, while
+// preserving ownership, permissions and overlayfs whiteouts.
+func copyTree(source, dest string) error {
+	return commands.ExecStdoutArgs("cp", []string{"-a", source + "/.", dest})
+}
+
+// pruneStaleLayers will remove all layers within the parent directory
+// except for the current key, as their base image or dependencies no
+// longer match.
+func pruneStaleLayers(parent, key string) error {
+	entries, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == key || strings.HasPrefix(entry.Name(), ".layer") {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"key": entry.Name(),
+		}).Debug("Removing stale dependency layer")
+		if err := os.RemoveAll(filepath.Join(parent, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseDependencyLayer will configure the overlay to use the cached layer
+// of build dependencies, if one exists. Otherwise the layer will be cached
+// once the dependencies have been installed.
+func (p *Package) UseDependencyLayer(o *Overlay) error {
+	layer, err := NewDependencyLayer(o)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to determine dependency layer")
+		return err
+	}
+	if layer == nil {
+		return nil
+	}
+	o.Layer = layer
+	if layer.IsCached() {
+		log.WithFields(log.Fields{
+			"key": layer.Key,
+		}).Info("Reusing cached dependency layer")
+		o.LowerDirs = append(o.LowerDirs, layer.Dir)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDependencyLayerKey(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-layer")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	back := &BackingImage{Name: "main-x86_64", ImagePath: filepath.Join(tmp, "main-x86_64.img")}
+	if err := ioutil.WriteFile(back.ImagePath, []byte("image"), 00644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	key, err := getDependencyLayerKey(back, []string{"pkgconfig(zlib)", "nasm"})
+	if err != nil {
+		t.Fatalf("Failed to compute key: %v", err)
+	}
+	same, _ := getDependencyLayerKey(back, []string{"nasm", "pkgconfig(zlib)"})
+	if key != same {
+		t.Fatalf("Key should not depend on dependency order")
+	}
+	changed, _ := getDependencyLayerKey(back, []string{"nasm"})
+	if key == changed {
+		t.Fatalf("Key should change with the dependency set")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(back.ImagePath, later, later); err != nil {
+		t.Fatalf("Failed to update image: %v", err)
+	}
+	updated, _ := getDependencyLayerKey(back, []string{"nasm", "pkgconfig(zlib)"})
+	if key == updated {
+		t.Fatalf("Key should change with the base image")
+	}
+}
+
+func TestDependencyLayerReuse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-layer")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	back := &BackingImage{Name: "main-x86_64", ImagePath: filepath.Join(tmp, "main-x86_64.img")}
+	if err := ioutil.WriteFile(back.ImagePath, []byte("image"), 00644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	upper := filepath.Join(tmp, "upper")
+	if err := os.MkdirAll(upper, 00755); err != nil {
+		t.Fatalf("Failed to create upper dir: %v", err)
+	}
+
+	CacheDependencyLayers = true
+	defer func() {
+		CacheDependencyLayers = false
+	}()
+
+	newLayer := func(deps ...string) *DependencyLayer {
+		pkg := &Package{Name: "nano", Type: PackageTypeYpkg, BuildDeps: deps}
+		layer, err := NewDependencyLayer(&Overlay{Back: back, Package: pkg})
+		if err != nil || layer == nil {
+			t.Fatalf("Failed to get dependency layer: %v", err)
+		}
+		// Keep the layers within the test directory
+		layer.Dir = filepath.Join(tmp, "layers", layer.Key)
+		return layer
+	}
+
+	layer := newLayer("ncurses-devel")
+	if layer.IsCached() {
+		t.Fatalf("Layer should not be cached yet")
+	}
+	if err := layer.Store(upper); err != nil {
+		t.Fatalf("Failed to store layer: %v", err)
+	}
+	if !newLayer("ncurses-devel").IsCached() {
+		t.Fatalf("Unchanged dependencies should reuse the cached layer")
+	}
+
+	changed := newLayer("ncurses-devel", "file-devel")
+	if changed.IsCached() {
+		t.Fatalf("Changed dependencies should not reuse the cached layer")
+	}
+	if err := changed.Store(upper); err != nil {
+		t.Fatalf("Failed to store layer: %v", err)
+	}
+	if layer.IsCached() {
+		t.Fatalf("Stale layer should have been removed")
+	}
+
+	xml := &Package{Name: "nano", Type: PackageTypeXML, BuildDeps: []string{"nasm"}}
+	if layer, _ := NewDependencyLayer(&Overlay{Back: back, Package: xml}); layer != nil {
+		t.Fatalf("Legacy packages should not use dependency layers")
+	}
+}
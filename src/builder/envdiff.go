@@ -0,0 +1,226 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RedactedValue replaces the value of secret variables in the recorded
+// build environment.
+const RedactedValue = "<redacted>"
+
+var (
+	// EnvironmentBaseline is the path of a stored build environment to
+	// compare each build against. When the file doesn't exist yet, the
+	// environment of the next build is stored there.
+	EnvironmentBaseline string
+
+	// secretVariables are name fragments of variables that are never recorded
+	secretVariables = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE_KEY", "API_KEY"}
+)
+
+// An EnvChange is a single variable that differs from the baseline
+type EnvChange struct {
+	Name string
+	Old  string // Empty when the variable was added
+	New  string // Empty when the variable was removed
+}
+
+// An EnvDiff describes how a build environment differs from the baseline
+type EnvDiff struct {
+	Added   []EnvChange
+	Removed []EnvChange
+	Changed []EnvChange
+}
+
+// Empty will determine whether the environments are identical
+func (d *EnvDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// isSecretVariable will determine whether the variable may hold a secret
+func isSecretVariable(name string) bool {
+	if name == SecretsEnvironment {
+		return false
+	}
+	upper := strings.ToUpper(name)
+	for _, frag := range secretVariables {
+		if strings.Contains(upper, frag) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactEnvironment will return a copy of the environment with the values
+// of secret variables, and any value containing a configured secret,
+// replaced.
+func RedactEnvironment(env []string) []string {
+	var ret []string
+	for _, e := range env {
+		name, value := splitVariable(e)
+		redact := isSecretVariable(name)
+		for _, secret := range Secrets {
+			if secret != "" && strings.Contains(value, secret) {
+				redact = true
+				break
+			}
+		}
+		if redact {
+			e = name + "=" + RedactedValue
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// splitVariable will split the NAME=value pair
+func splitVariable(e string) (string, string) {
+	fields := strings.SplitN(e, "=", 2)
+	if len(fields) != 2 {
+		return fields[0], ""
+	}
+	return fields[0], fields[1]
+}
+
+// environmentMap will key the environment by variable name
+func environmentMap(env []string) map[string]string {
+	ret := make(map[string]string)
+	for _, e := range env {
+		name, value := splitVariable(e)
+		ret[name] = value
+	}
+	return ret
+}
+
+// DiffEnvironment will find the variables added, removed or changed in the
+// current environment compared to the baseline, sorted by name.
+func DiffEnvironment(baseline, current []string) *EnvDiff {
+	old := environmentMap(baseline)
+	now := environmentMap(current)
+	diff := &EnvDiff{}
+
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range now {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldValue, inOld := old[name]
+		newValue, inNew := now[name]
+		switch {
+		case !inOld:
+			diff.Added = append(diff.Added, EnvChange{Name: name, New: newValue})
+		case !inNew:
+			diff.Removed = append(diff.Removed, EnvChange{Name: name, Old: oldValue})
+		case oldValue != newValue:
+			diff.Changed = append(diff.Changed, EnvChange{Name: name, Old: oldValue, New: newValue})
+		}
+	}
+	return diff
+}
+
+// ReadEnvironment will load a stored environment, one NAME=value per line.
+// Blank lines and comments are ignored.
+func ReadEnvironment(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env = append(env, line)
+	}
+	return env, sc.Err()
+}
+
+// WriteEnvironment will store the environment, one NAME=value per line
+func WriteEnvironment(path string, env []string) error {
+	return ioutil.WriteFile(path, []byte(strings.Join(env, "\n")+"\n"), 00644)
+}
+
+// RecordEnvironment will capture the environment passed to the build tool,
+// with secrets redacted, and compare it to the baseline if one is set.
+func (p *Package) RecordEnvironment() error {
+	p.Environment = RedactEnvironment(ChrootEnvironment)
+	p.EnvironmentDiff = nil
+
+	for _, e := range p.Environment {
+		log.WithFields(log.Fields{
+			"variable": e,
+		}).Debug("Build environment")
+	}
+	if EnvironmentBaseline == "" {
+		return nil
+	}
+
+	baseline, err := ReadEnvironment(EnvironmentBaseline)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"path": EnvironmentBaseline,
+		}).Info("Storing build environment baseline")
+		return WriteEnvironment(EnvironmentBaseline, p.Environment)
+	}
+
+	p.EnvironmentDiff = DiffEnvironment(baseline, p.Environment)
+	if p.EnvironmentDiff.Empty() {
+		log.Debug("Build environment matches the baseline")
+		return nil
+	}
+	for _, c := range p.EnvironmentDiff.Added {
+		log.WithFields(log.Fields{
+			"variable": c.Name,
+			"value":    c.New,
+		}).Warning("Build environment variable added since baseline")
+	}
+	for _, c := range p.EnvironmentDiff.Removed {
+		log.WithFields(log.Fields{
+			"variable": c.Name,
+			"value":    c.Old,
+		}).Warning("Build environment variable removed since baseline")
+	}
+	for _, c := range p.EnvironmentDiff.Changed {
+		log.WithFields(log.Fields{
+			"variable": c.Name,
+			"old":      c.Old,
+			"new":      c.New,
+		}).Warning("Build environment variable changed since baseline")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordEnvironment(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-env")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	oldEnv := ChrootEnvironment
+	Secrets = map[string]string{"token": "hunter2"}
+	EnvironmentBaseline = filepath.Join(tmp, "environment")
+	defer func() {
+		ChrootEnvironment, Secrets, EnvironmentBaseline = oldEnv, nil, ""
+	}()
+
+	pkg := &Package{Type: PackageTypeXML}
+	ChrootEnvironment = append(pkg.GetBuildEnvironment(), "GITHUB_TOKEN=abc", "URL=https://hunter2@host/")
+	if err := pkg.RecordEnvironment(); err != nil {
+		t.Fatalf("Failed to record environment: %v", err)
+	}
+	expected := append(pkg.GetBuildEnvironment(), "GITHUB_TOKEN="+RedactedValue, "URL="+RedactedValue)
+	if !reflect.DeepEqual(pkg.Environment, expected) {
+		t.Fatalf("Recorded environment does not match: %v", pkg.Environment)
+	}
+	if pkg.EnvironmentDiff != nil {
+		t.Fatalf("Should not diff against a missing baseline")
+	}
+	stored, err := ReadEnvironment(EnvironmentBaseline)
+	if err != nil || !reflect.DeepEqual(stored, expected) {
+		t.Fatalf("Baseline was not stored: %v %v", stored, err)
+	}
+
+	ChrootEnvironment = append(pkg.GetBuildEnvironment(), "GITHUB_TOKEN=def", "CCACHE_DIR=/var/cache")
+	if err := pkg.RecordEnvironment(); err != nil {
+		t.Fatalf("Failed to record environment: %v", err)
+	}
+	diff := pkg.EnvironmentDiff
+	if diff == nil || len(diff.Added) != 1 || diff.Added[0].Name != "CCACHE_DIR" {
+		t.Fatalf("Wrong diff against baseline: %+v", diff)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Name != "URL" || len(diff.Changed) != 0 {
+		t.Fatalf("Wrong diff against baseline: %+v", diff)
+	}
+}
+
+func TestDiffEnvironment(t *testing.T) {
+	baseline := []string{"PATH=/usr/bin", "HOME=/root", "LANG=C"}
+	current := []string{"PATH=/usr/bin:/bin", "HOME=/root", "TERM=xterm"}
+	diff := DiffEnvironment(baseline, current)
+	if diff.Empty() {
+		t.Fatalf("Diff should not be empty")
+	}
+	expected := &EnvDiff{
+		Added:   []EnvChange{{Name: "TERM", New: "xterm"}},
+		Removed: []EnvChange{{Name: "LANG", Old: "C"}},
+		Changed: []EnvChange{{Name: "PATH", Old: "/usr/bin", New: "/usr/bin:/bin"}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("Wrong diff: %+v", diff)
+	}
+	if !DiffEnvironment(baseline, baseline).Empty() {
+		t.Fatalf("Identical environments should not differ")
+	}
+}
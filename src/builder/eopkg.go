@@ -17,6 +17,7 @@
 package builder
 
 import (
+	"builder/source"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/commands"
@@ -201,7 +202,12 @@ func (e *EopkgManager) Upgrade() error {
 	newReqs := []string{
 		"iproute2",
 	}
-	if err := ChrootExec(e.notif, e.root, eopkgCommand("eopkg upgrade -y")); err != nil {
+	upgrade := "eopkg upgrade -y"
+	if source.Offline {
+		// Only use the repository indexes we already have
+		upgrade += " --bypass-update"
+	}
+	if err := ChrootExec(e.notif, e.root, eopkgCommand(upgrade)); err != nil {
 		return err
 	}
 	e.notif.SetActivePID(0)
@@ -0,0 +1,123 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	// ExitBuildTool is the exit status when a build tool fails
+	ExitBuildTool = 2
+
+	// ExitFetch is the exit status when sources cannot be fetched
+	ExitFetch = 3
+
+	// ExitOverlay is the exit status when the build root cannot be set up
+	ExitOverlay = 4
+)
+
+// A FetchError is returned when a package source could not be fetched
+type FetchError struct {
+	Source string // Identifier of the source
+	Err    error  // Underlying error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("Failed to fetch source %s: %v", e.Source, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// An OverlayError is returned when the build root could not be set up or
+// torn down.
+type OverlayError struct {
+	Op  string // Operation that failed, i.e. "mount"
+	Err error  // Underlying error
+}
+
+func (e *OverlayError) Error() string {
+	return fmt.Sprintf("Overlay %s failed: %v", e.Op, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *OverlayError) Unwrap() error {
+	return e.Err
+}
+
+// A BuildToolError is returned when a tool run within the build root
+// fails, such as ypkg-build or eopkg.
+type BuildToolError struct {
+	Phase    string // Phase of the build, i.e. "ypkg-build"
+	ExitCode int    // Exit code of the tool, or -1 if it didn't exit
+	Err      error  // Underlying error
+}
+
+func (e *BuildToolError) Error() string {
+	if e.ExitCode >= 0 {
+		return fmt.Sprintf("%s exited with status %d", e.Phase, e.ExitCode)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Phase, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *BuildToolError) Unwrap() error {
+	return e.Err
+}
+
+// newBuildToolError will wrap the error from running a tool in the given
+// phase, extracting the exit code where possible.
+func newBuildToolError(phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+	code := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			code = status.ExitStatus()
+		}
+	}
+	return &BuildToolError{Phase: phase, ExitCode: code, Err: err}
+}
+
+// ExitCode will return the process exit status to use for the given error,
+// allowing scripts to distinguish between the types of failure.
+func ExitCode(err error) int {
+	var (
+		fetchErr   *FetchError
+		overlayErr *OverlayError
+		toolErr    *BuildToolError
+	)
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &toolErr):
+		return ExitBuildTool
+	case errors.As(err, &fetchErr):
+		return ExitFetch
+	case errors.As(err, &overlayErr):
+		return ExitOverlay
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,83 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// failingSource is a source that can never be fetched
+type failingSource struct{}
+
+func (f *failingSource) IsFetched() bool       { return false }
+func (f *failingSource) Fetch() error          { return errors.New("connection refused") }
+func (f *failingSource) GetIdentifier() string { return "https://example.com/nano.tar.xz" }
+func (f *failingSource) GetBindConfiguration(rootfs string) source.BindConfiguration {
+	return source.BindConfiguration{}
+}
+
+func TestFetchError(t *testing.T) {
+	pkg := &Package{Name: "nano", Sources: []source.Source{&failingSource{}}}
+	err := pkg.FetchSources(&Overlay{})
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) {
+		t.Fatalf("Expected a FetchError, got: %T", err)
+	}
+	if fetchErr.Source != "https://example.com/nano.tar.xz" {
+		t.Fatalf("Wrong source in error: %v", fetchErr.Source)
+	}
+	if ExitCode(err) != ExitFetch {
+		t.Fatalf("Wrong exit code: %d", ExitCode(err))
+	}
+}
+
+func TestBuildToolError(t *testing.T) {
+	err := newBuildToolError("ypkg-build", exec.Command("/bin/sh", "-c", "exit 3").Run())
+	wrapped := fmt.Errorf("build failed: %w", err)
+
+	var toolErr *BuildToolError
+	if !errors.As(wrapped, &toolErr) {
+		t.Fatalf("Expected a BuildToolError, got: %T", err)
+	}
+	if toolErr.ExitCode != 3 || toolErr.Phase != "ypkg-build" {
+		t.Fatalf("Wrong build tool error: %v", toolErr)
+	}
+	if ExitCode(wrapped) != ExitBuildTool {
+		t.Fatalf("Wrong exit code: %d", ExitCode(wrapped))
+	}
+	if newBuildToolError("ypkg-build", nil) != nil {
+		t.Fatalf("Success should not produce an error")
+	}
+}
+
+func TestOverlayError(t *testing.T) {
+	cause := errors.New("no such device")
+	err := &OverlayError{Op: "mount", Err: cause}
+	if !errors.Is(err, cause) {
+		t.Fatalf("OverlayError should unwrap to the cause")
+	}
+	if ExitCode(err) != ExitOverlay {
+		t.Fatalf("Wrong exit code: %d", ExitCode(err))
+	}
+	if ExitCode(cause) != 1 || ExitCode(nil) != 0 {
+		t.Fatalf("Wrong exit code for untyped errors")
+	}
+}
@@ -0,0 +1,161 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// EventPhase is sent when a new phase of the build begins
+	EventPhase = "phase"
+
+	// EventFetchProgress is sent as a source is downloaded
+	EventFetchProgress = "fetch_progress"
+
+	// EventFetchComplete is sent once a source has been fetched
+	EventFetchComplete = "fetch_complete"
+
+	// EventBuildComplete is sent once the build has finished
+	EventBuildComplete = "build_complete"
+)
+
+// EventWriteTimeout is how long a client may block an event before it is
+// disconnected, so a stalled client can never hold up the build.
+var EventWriteTimeout = 5 * time.Second
+
+// An Event describes the progress of a build for external tools. Fields
+// not relevant to the type of event are omitted.
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Package string    `json:"package,omitempty"`
+	Phase   string    `json:"phase,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Done    int64     `json:"done,omitempty"`  // Bytes fetched so far
+	Total   int64     `json:"total,omitempty"` // Total bytes, or -1 if unknown
+	Error   string    `json:"error,omitempty"`
+}
+
+// An EventSink receives build events as they happen
+type EventSink interface {
+	Emit(e *Event)
+}
+
+// noopEvents discards all events
+type noopEvents struct{}
+
+func (n noopEvents) Emit(e *Event) {}
+
+// ActiveEvents receives all events. By default these are discarded.
+var ActiveEvents EventSink = noopEvents{}
+
+// SetEventSink will set the implementation used to receive events, or
+// restore the default no-op implementation if nil.
+func SetEventSink(s EventSink) {
+	if s == nil {
+		s = noopEvents{}
+	}
+	ActiveEvents = s
+}
+
+// An EventServer streams events as newline delimited JSON to each client
+// connected to its Unix socket.
+type EventServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	clients  map[net.Conn]bool
+}
+
+// NewEventServer will listen on the Unix socket at the given path,
+// replacing any stale socket left behind by a previous run.
+func NewEventServer(path string) (*EventServer, error) {
+	if st, err := os.Lstat(path); err == nil && st.Mode()&os.ModeSocket == os.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &EventServer{
+		listener: l,
+		clients:  make(map[net.Conn]bool),
+	}
+	go s.accept()
+	return s, nil
+}
+
+// accept will add each new connection to the clients until closed
+func (s *EventServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		if s.clients == nil {
+			conn.Close()
+		} else {
+			s.clients[conn] = true
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Emit will send the event to every connected client, dropping clients
+// that have gone away or cannot keep up.
+func (s *EventServer) Emit(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(EventWriteTimeout))
+		if _, err := conn.Write(b); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("Dropping event client")
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+}
+
+// Close will disconnect all clients and remove the socket
+func (s *EventServer) Close() error {
+	s.lock.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = nil
+	s.lock.Unlock()
+	return s.listener.Close()
+}
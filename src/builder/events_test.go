@@ -0,0 +1,172 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"builder/source"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// progressSource reports progress while it is fetched
+type progressSource struct {
+	fetchableSource
+	progress source.ProgressFunc
+}
+
+func (p *progressSource) SetProgressFunc(fn source.ProgressFunc) { p.progress = fn }
+func (p *progressSource) Fetch() error {
+	p.progress(2, 4)
+	p.progress(4, 4)
+	return p.fetchableSource.Fetch()
+}
+
+// connectEventClient will connect to the server, waiting until it has
+// been accepted so that no events are missed.
+func connectEventClient(t *testing.T, server *EventServer, path string) net.Conn {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to event socket: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		server.lock.Lock()
+		n := len(server.clients)
+		server.lock.Unlock()
+		if n > 0 {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Event client was never accepted")
+	return nil
+}
+
+func TestEventServer(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-events")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "events.sock")
+	server, err := NewEventServer(path)
+	if err != nil {
+		t.Fatalf("Failed to create event server: %v", err)
+	}
+	defer server.Close()
+	SetEventSink(server)
+	defer SetEventSink(nil)
+
+	conn := connectEventClient(t, server, path)
+	defer conn.Close()
+
+	// Simulate a fetch and build
+	pkg := &Package{
+		Name:    "nano",
+		Sources: []source.Source{&progressSource{fetchableSource: fetchableSource{path: filepath.Join(tmp, "nano.tar.xz")}}},
+	}
+	phases := NewPhaseLog(ioutil.Discard, false)
+	phases.Package = pkg.Name
+	phases.Begin("Fetching sources (%d)", 1)
+	if err := pkg.FetchSources(&Overlay{Back: &BackingImage{Name: "main-x86_64"}}); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	phases.Begin("Running ypkg-build")
+	phases.Finish(errors.New("ypkg-build exited with status 1"))
+
+	expected := []Event{
+		{Type: EventPhase, Package: "nano", Phase: "Fetching sources (1)"},
+		{Type: EventFetchProgress, Package: "nano", Source: filepath.Join(tmp, "nano.tar.xz"), Done: 2, Total: 4},
+		{Type: EventFetchProgress, Package: "nano", Source: filepath.Join(tmp, "nano.tar.xz"), Done: 4, Total: 4},
+		{Type: EventFetchComplete, Package: "nano", Source: filepath.Join(tmp, "nano.tar.xz"), Done: 4},
+		{Type: EventPhase, Package: "nano", Phase: "Running ypkg-build"},
+		{Type: EventBuildComplete, Package: "nano", Error: "ypkg-build exited with status 1"},
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sc := bufio.NewScanner(conn)
+	for i, want := range expected {
+		if !sc.Scan() {
+			t.Fatalf("Missing event %d: %v", i, sc.Err())
+		}
+		var got Event
+		if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+			t.Fatalf("Invalid event '%s': %v", sc.Text(), err)
+		}
+		if got.Time.IsZero() {
+			t.Fatalf("Event %d has no timestamp", i)
+		}
+		got.Time = time.Time{}
+		if got != want {
+			t.Fatalf("Wrong event %d: %+v vs expected %+v", i, got, want)
+		}
+	}
+}
+
+func TestEventServerDisconnect(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-events")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "events.sock")
+	// Stale sockets from a previous run are replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := NewEventServer(path)
+	if err != nil {
+		t.Fatalf("Failed to replace stale socket: %v", err)
+	}
+	conn := connectEventClient(t, server, path)
+	conn.Close()
+
+	// The client is dropped once writes to it fail
+	for i := 0; i < 100; i++ {
+		server.Emit(&Event{Type: EventPhase, Phase: "Running ypkg-build"})
+		server.lock.Lock()
+		n := len(server.clients)
+		server.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(server.clients) != 0 {
+		t.Fatalf("Disconnected client was not dropped")
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Failed to close event server: %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("Socket should be removed on close")
+	}
+	// Emitting after close must not panic
+	server.Emit(&Event{Type: EventBuildComplete})
+}
@@ -0,0 +1,187 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// FailedOverlayDir is the name of the directory within each profile's
+	// cache directory that holds the overlays of failed builds.
+	FailedOverlayDir = ".failed"
+
+	// FailedOverlayMetadata is the name of the metadata file written into
+	// each kept overlay.
+	FailedOverlayMetadata = "failure.json"
+)
+
+var (
+	// KeepFailedOverlays is the number of most recent failed overlays kept
+	// for each profile, for debugging. Older ones are pruned automatically,
+	// and 0 disables keeping them entirely.
+	KeepFailedOverlays = 0
+
+	// ErrOverlayMounted is returned when keeping an overlay that is still
+	// mounted.
+	ErrOverlayMounted = errors.New("Overlay must be unmounted before it can be kept")
+)
+
+// A FailedOverlay is the preserved overlay of a failed build
+type FailedOverlay struct {
+	Package string    `json:"package"`
+	Version string    `json:"version"`
+	Release int       `json:"release"`
+	Profile string    `json:"profile"`
+	Time    time.Time `json:"time"`   // When the build failed
+	Reason  string    `json:"reason"` // Why the build failed
+
+	Dir string `json:"-"` // Where the overlay is kept
+}
+
+// getFailedOverlayDir will return the directory holding the failed
+// overlays of the profile the overlay belongs to.
+func getFailedOverlayDir(o *Overlay) string {
+	return filepath.Join(filepath.Dir(o.BaseDir), FailedOverlayDir)
+}
+
+// KeepFailed will move the overlay of the failed build aside along with
+// metadata describing the failure, then prune the oldest failed overlays
+// beyond KeepFailedOverlays. The overlay must already be unmounted. When
+// tmpfs is used for the upper layer, its contents are already lost.
+func (o *Overlay) KeepFailed(p *Package, reason error) (*FailedOverlay, error) {
+	if KeepFailedOverlays < 1 || !PathExists(o.BaseDir) {
+		return nil, nil
+	}
+	if o.mountedOverlay || o.mountedTmpfs {
+		return nil, ErrOverlayMounted
+	}
+
+	now := time.Now().UTC()
+	failed := &FailedOverlay{
+		Package: p.Name,
+		Version: p.Version,
+		Release: p.Release,
+		Profile: filepath.Base(filepath.Dir(o.BaseDir)),
+		Time:    now,
+		Reason:  reason.Error(),
+	}
+	parent := getFailedOverlayDir(o)
+	if err := os.MkdirAll(parent, 00755); err != nil {
+		return nil, err
+	}
+	failed.Dir = filepath.Join(parent, fmt.Sprintf("%s-%d", p.Name, now.UnixNano()))
+	if err := os.Rename(o.BaseDir, failed.Dir); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(failed, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(failed.Dir, FailedOverlayMetadata), append(b, '\n'), 00644); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"dir":    failed.Dir,
+		"reason": failed.Reason,
+	}).Info("Keeping overlay of failed build")
+
+	if _, err := pruneFailedOverlays(parent, KeepFailedOverlays); err != nil {
+		return failed, err
+	}
+	return failed, nil
+}
+
+// ListFailedOverlays will return the failed overlays kept within the
+// directory, newest first. Overlays without readable metadata are skipped.
+func ListFailedOverlays(dir string) ([]*FailedOverlay, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var failed []*FailedOverlay
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		b, err := ioutil.ReadFile(filepath.Join(path, FailedOverlayMetadata))
+		if err != nil {
+			continue
+		}
+		f := &FailedOverlay{}
+		if err := json.Unmarshal(b, f); err != nil {
+			continue
+		}
+		f.Dir = path
+		failed = append(failed, f)
+	}
+	sort.SliceStable(failed, func(i, j int) bool {
+		return failed[i].Time.After(failed[j].Time)
+	})
+	return failed, nil
+}
+
+// pruneFailedOverlays will remove all but the newest keep failed overlays
+// within the directory, returning those removed.
+func pruneFailedOverlays(dir string, keep int) ([]*FailedOverlay, error) {
+	failed, err := ListFailedOverlays(dir)
+	if err != nil || len(failed) <= keep {
+		return nil, err
+	}
+	pruned := failed[keep:]
+	for _, f := range pruned {
+		log.WithFields(log.Fields{
+			"dir":     f.Dir,
+			"package": f.Package,
+		}).Debug("Pruning failed overlay")
+		if err := os.RemoveAll(f.Dir); err != nil {
+			return nil, err
+		}
+	}
+	return pruned, nil
+}
+
+// PruneFailedOverlays will remove all but the newest keep failed overlays
+// of every profile, returning those removed.
+func PruneFailedOverlays(keep int) ([]*FailedOverlay, error) {
+	dirs, err := filepath.Glob(filepath.Join(OverlayRootDir, "*", FailedOverlayDir))
+	if err != nil {
+		return nil, err
+	}
+	var pruned []*FailedOverlay
+	for _, dir := range dirs {
+		p, err := pruneFailedOverlays(dir, keep)
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, p...)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,94 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeepFailedOverlays(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-failed")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	KeepFailedOverlays = 2
+	defer func() {
+		KeepFailedOverlays = 0
+	}()
+
+	pkg := &Package{Name: "nano", Version: "2.7.5", Release: 70}
+	for i := 0; i <= KeepFailedOverlays; i++ {
+		o := &Overlay{Package: pkg, BaseDir: filepath.Join(tmp, "unstable-x86_64", "nano")}
+		if err := os.MkdirAll(filepath.Join(o.BaseDir, "tmp"), 00755); err != nil {
+			t.Fatalf("Failed to create overlay: %v", err)
+		}
+		failed, err := o.KeepFailed(pkg, fmt.Errorf("build %d failed", i))
+		if err != nil {
+			t.Fatalf("Failed to keep overlay: %v", err)
+		}
+		if PathExists(o.BaseDir) || !PathExists(filepath.Join(failed.Dir, "tmp")) {
+			t.Fatalf("Overlay was not moved aside")
+		}
+	}
+
+	failed, err := ListFailedOverlays(filepath.Join(tmp, "unstable-x86_64", FailedOverlayDir))
+	if err != nil {
+		t.Fatalf("Failed to list failed overlays: %v", err)
+	}
+	if len(failed) != KeepFailedOverlays {
+		t.Fatalf("Expected %d kept overlays, found %d", KeepFailedOverlays, len(failed))
+	}
+	// The oldest is pruned, newest first
+	for i, f := range failed {
+		reason := fmt.Sprintf("build %d failed", KeepFailedOverlays-i)
+		if f.Reason != reason || f.Package != "nano" || f.Version != "2.7.5" || f.Release != 70 || f.Profile != "unstable-x86_64" {
+			t.Fatalf("Wrong metadata for kept overlay: %+v", f)
+		}
+	}
+
+	// Manual pruning applies to every profile
+	rootDir := OverlayRootDir
+	OverlayRootDir = tmp
+	defer func() {
+		OverlayRootDir = rootDir
+	}()
+	pruned, err := PruneFailedOverlays(0)
+	if err != nil {
+		t.Fatalf("Failed to prune overlays: %v", err)
+	}
+	if len(pruned) != KeepFailedOverlays || PathExists(pruned[0].Dir) {
+		t.Fatalf("Expected all overlays to be pruned: %+v", pruned)
+	}
+}
+
+func TestKeepMountedOverlay(t *testing.T) {
+	KeepFailedOverlays = 1
+	defer func() {
+		KeepFailedOverlays = 0
+	}()
+	o := &Overlay{BaseDir: os.TempDir(), mountedOverlay: true}
+	if _, err := o.KeepFailed(&Package{Name: "nano"}, errors.New("failed")); err != ErrOverlayMounted {
+		t.Fatalf("Mounted overlays should not be kept, got: %v", err)
+	}
+}
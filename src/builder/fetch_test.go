@@ -0,0 +1,60 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestManagerFetch(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-fetch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	nano := &fetchableSource{path: filepath.Join(tmp, "nano")}
+	pkg := &Package{
+		Name:    "nano",
+		Version: "2.7.5",
+		Release: 61,
+		Sources: []source.Source{nano},
+	}
+
+	// No overlay or image is needed to fetch
+	m := &Manager{lock: new(sync.Mutex)}
+	if err := m.Fetch(pkg); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if !nano.fetched || !PathExists(nano.path) {
+		t.Fatalf("Source was not fetched")
+	}
+
+	// Invalid packages are refused before fetching
+	invalid := &Package{Sources: []source.Source{&fetchableSource{path: filepath.Join(tmp, "invalid")}}}
+	if err := m.Fetch(invalid); err == nil {
+		t.Fatalf("Invalid package should not be fetched")
+	}
+	if PathExists(filepath.Join(tmp, "invalid")) {
+		t.Fatalf("Source of an invalid package was fetched")
+	}
+}
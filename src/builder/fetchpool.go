@@ -0,0 +1,165 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveImprovement is how much faster a window must be than the best
+	// so far for the concurrency to keep growing.
+	adaptiveImprovement = 0.1
+
+	// adaptiveErrorRate is the share of failed fetches in a window above
+	// which the concurrency is halved.
+	adaptiveErrorRate = 0.25
+)
+
+// AdaptiveFetch controls whether the number of sources fetched at once
+// adapts to the measured throughput, up to FetchJobs, instead of always
+// fetching FetchJobs sources at once.
+var AdaptiveFetch = false
+
+// hostSource is implemented by sources fetched from a single host
+type hostSource interface {
+	GetHost() string
+}
+
+// A fetchPool limits the number of sources fetched at once. When adaptive,
+// the limit starts at 1 and grows while each window of fetches is faster
+// than the last, settling once more connections stop helping and halving
+// when too many fetches fail.
+type fetchPool struct {
+	max      int  // Absolute maximum concurrency
+	limit    int  // Current concurrency
+	adaptive bool // Whether the limit adapts to throughput
+	active   int  // Fetches currently running
+	cond     *sync.Cond
+
+	windowStart time.Time // When the current window began
+	windowBytes int64     // Bytes fetched in the current window
+	windowDone  int       // Fetches completed in the current window
+	windowErrs  int       // Fetches failed in the current window
+
+	bestLimit int     // Concurrency with the best throughput so far
+	bestRate  float64 // Best throughput so far, in bytes per second
+
+	now func() time.Time
+}
+
+// newFetchPool will return a pool fetching up to max sources at once
+func newFetchPool(max int, adaptive bool) *fetchPool {
+	if max < 1 {
+		max = 1
+	}
+	p := &fetchPool{
+		max:       max,
+		limit:     max,
+		adaptive:  adaptive,
+		cond:      sync.NewCond(&sync.Mutex{}),
+		bestLimit: 1,
+		now:       time.Now,
+	}
+	if adaptive {
+		p.limit = 1
+	}
+	return p
+}
+
+// Limit will return the current concurrency of the pool
+func (p *fetchPool) Limit() int {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	return p.limit
+}
+
+// acquire will block until another fetch may begin
+func (p *fetchPool) acquire() {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	for p.active >= p.limit {
+		p.cond.Wait()
+	}
+	if p.windowStart.IsZero() {
+		p.windowStart = p.now()
+	}
+	p.active++
+}
+
+// release will record the outcome of a fetch, i.e. the bytes fetched or
+// the error, and permit another fetch to begin.
+func (p *fetchPool) release(bytes int64, err error) {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	p.active--
+	p.windowDone++
+	if err != nil {
+		p.windowErrs++
+	} else if bytes > 0 {
+		p.windowBytes += bytes
+	}
+	if p.adaptive && p.windowDone >= p.limit {
+		p.adjust()
+	}
+	p.cond.Broadcast()
+}
+
+// adjust will update the limit at the end of a window of fetches, which
+// is as long as the limit itself.
+func (p *fetchPool) adjust() {
+	now := p.now()
+	rate := 0.0
+	if elapsed := now.Sub(p.windowStart).Seconds(); elapsed > 0 {
+		rate = float64(p.windowBytes) / elapsed
+	}
+	previous := p.limit
+
+	switch {
+	case float64(p.windowErrs)/float64(p.windowDone) > adaptiveErrorRate:
+		p.limit /= 2
+		if p.limit < 1 {
+			p.limit = 1
+		}
+		p.bestLimit, p.bestRate = p.limit, 0
+	case rate > p.bestRate*(1+adaptiveImprovement):
+		p.bestLimit, p.bestRate = p.limit, rate
+		if p.limit < p.max {
+			p.limit++
+		}
+	case p.limit > p.bestLimit:
+		p.limit = p.bestLimit
+	default:
+		// Track the settled throughput, so later gains are noticed
+		p.bestRate = rate
+	}
+
+	if p.limit != previous {
+		log.WithFields(log.Fields{
+			"from":   previous,
+			"to":     p.limit,
+			"rate":   int64(rate),
+			"errors": p.windowErrs,
+		}).Debug("Adjusted fetch concurrency")
+	}
+	p.windowStart = now
+	p.windowBytes = 0
+	p.windowDone = 0
+	p.windowErrs = 0
+}
@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// simulatedNetwork gains 10MB/s of throughput for each of up to 4 fetches
+// at once, then slows as further fetches contend for the link.
+func simulatedNetwork(n int) float64 {
+	if n <= 4 {
+		return float64(n) * 10e6
+	}
+	return 40e6 * (1 - 0.05*float64(n-4))
+}
+
+// runWindow will fetch as many 10MB sources as the pool allows at once,
+// failing the given number of them.
+func runWindow(p *fetchPool, clock *time.Time, failures int) {
+	n := p.Limit()
+	for i := 0; i < n; i++ {
+		p.acquire()
+	}
+	size := int64(10e6)
+	*clock = clock.Add(time.Duration(float64(int64(n)*size) / simulatedNetwork(n) * float64(time.Second)))
+	for i := 0; i < n; i++ {
+		if i < failures {
+			p.release(0, errors.New("connection reset"))
+		} else {
+			p.release(size, nil)
+		}
+	}
+}
+
+func newTestFetchPool(max int) (*fetchPool, *time.Time) {
+	clock := time.Unix(0, 0)
+	p := newFetchPool(max, true)
+	p.now = func() time.Time { return clock }
+	return p, &clock
+}
+
+func TestAdaptiveFetchConverges(t *testing.T) {
+	p, clock := newTestFetchPool(8)
+	if p.Limit() != 1 {
+		t.Fatalf("Adaptive fetching should start with one source, got %d", p.Limit())
+	}
+	for i := 0; i < 20; i++ {
+		runWindow(p, clock, 0)
+	}
+	if p.Limit() != 4 {
+		t.Fatalf("Expected to settle on the optimal concurrency of 4, got %d", p.Limit())
+	}
+
+	// The absolute maximum is respected
+	p, clock = newTestFetchPool(3)
+	for i := 0; i < 20; i++ {
+		runWindow(p, clock, 0)
+	}
+	if p.Limit() != 3 {
+		t.Fatalf("Expected to be capped at 3, got %d", p.Limit())
+	}
+
+	// Fixed concurrency is used without adaptive mode
+	if p := newFetchPool(5, false); p.Limit() != 5 {
+		t.Fatalf("Expected fixed concurrency of 5, got %d", p.Limit())
+	}
+}
+
+func TestAdaptiveFetchBacksOff(t *testing.T) {
+	p, clock := newTestFetchPool(8)
+	for i := 0; i < 20; i++ {
+		runWindow(p, clock, 0)
+	}
+
+	// Half of the fetches failing halves the concurrency
+	runWindow(p, clock, 2)
+	if p.Limit() != 2 {
+		t.Fatalf("Expected to back off to 2 on errors, got %d", p.Limit())
+	}
+	runWindow(p, clock, 0)
+	runWindow(p, clock, 0)
+	if p.Limit() != 4 {
+		t.Fatalf("Expected to recover to 4, got %d", p.Limit())
+	}
+
+	// A single failure within the threshold doesn't halve it
+	runWindow(p, clock, 1)
+	if p.Limit() < 3 {
+		t.Fatalf("Isolated failures should not halve the concurrency, got %d", p.Limit())
+	}
+}
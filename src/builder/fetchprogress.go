@@ -0,0 +1,53 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+)
+
+// getFetchProgress will track the overall progress when fetching several
+// sources, using their remote sizes where these can be found. Sources of
+// unknown size are still tracked, but excluded from the ETA. Progress of
+// each source is also sent to the active event sink.
+func getFetchProgress(pkg string, pending []source.Source) *source.AggregateProgress {
+	var progress *source.AggregateProgress
+	if len(pending) > 1 {
+		progress = source.NewAggregateProgress()
+	}
+	for _, src := range pending {
+		id := src.GetIdentifier()
+		if progress != nil {
+			size := int64(-1)
+			if sized, ok := src.(source.SizedSource); ok {
+				if n, err := sized.GetRemoteSize(); err == nil {
+					size = n
+				}
+			}
+			progress.Add(id, size)
+		}
+		if reporter, ok := src.(source.ProgressSource); ok {
+			reporter.SetProgressFunc(func(done, total int64) {
+				if progress != nil {
+					progress.Update(id, done, total)
+				}
+				ActiveEvents.Emit(&Event{Type: EventFetchProgress, Package: pkg, Source: id, Done: done, Total: total})
+			})
+		}
+	}
+	return progress
+}
@@ -0,0 +1,157 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// MountInfoPath is where the kernel reports our active mounts
+const MountInfoPath = "/proc/self/mountinfo"
+
+// An UnmountError is returned when some mounts could not be removed
+type UnmountError struct {
+	Path   string           // Overlay path being unmounted
+	Failed map[string]error // Mounts that are still active, i.e. busy
+}
+
+// Error will describe the mounts left behind
+func (u *UnmountError) Error() string {
+	var points []string
+	for point, err := range u.Failed {
+		points = append(points, fmt.Sprintf("%s (%v)", point, err))
+	}
+	sort.Strings(points)
+	return fmt.Sprintf("Failed to unmount %d mount(s) under %s: %s", len(points), u.Path, strings.Join(points, ", "))
+}
+
+// unescapeMountPath will decode the octal escapes used by mountinfo, i.e.
+// \040 for a space.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+	var out []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(c))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, path[i])
+	}
+	return string(out)
+}
+
+// getMountsUnder will parse mountinfo for all mount points at or beneath the
+// root, returned in the order they should be unmounted: deepest first, and
+// the most recent mount first when several are stacked on one point.
+func getMountsUnder(mountinfo io.Reader, root string) ([]string, error) {
+	root = filepath.Clean(root)
+	var mounts []string
+
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		point := unescapeMountPath(fields[4])
+		if point != root && !strings.HasPrefix(point, root+"/") {
+			continue
+		}
+		mounts = append(mounts, point)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reverse so that stacked mounts are removed newest first
+	for i, j := 0, len(mounts)-1; i < j; i, j = i+1, j-1 {
+		mounts[i], mounts[j] = mounts[j], mounts[i]
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i], "/") > strings.Count(mounts[j], "/")
+	})
+	return mounts, nil
+}
+
+// readMountsUnder will find the active mounts beneath the root
+func readMountsUnder(root string) ([]string, error) {
+	fi, err := os.Open(MountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	return getMountsUnder(fi, root)
+}
+
+// ForceUnmount will recover from a leaked overlay, i.e. after a crash, by
+// unmounting everything beneath the given path deepest first, before
+// removing the directory. If any mount cannot be removed, an UnmountError
+// is returned and nothing is deleted.
+func ForceUnmount(overlayPath string) error {
+	root, err := filepath.Abs(overlayPath)
+	if err != nil {
+		return err
+	}
+	mounts, err := readMountsUnder(root)
+	if err != nil {
+		return err
+	}
+
+	failed := make(map[string]error)
+	for _, point := range mounts {
+		log.WithFields(log.Fields{
+			"point": point,
+		}).Debug("Unmounting leaked mount")
+		if err := syscall.Unmount(point, 0); err != nil {
+			// Stacked mounts may share a point, keep the first failure
+			if _, ok := failed[point]; !ok {
+				failed[point] = err
+			}
+			continue
+		}
+		delete(failed, point)
+	}
+
+	// Never remove anything that may still be mounted, i.e. bind mounted
+	// host directories.
+	if mounts, err = readMountsUnder(root); err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		for _, point := range mounts {
+			if _, ok := failed[point]; !ok {
+				failed[point] = syscall.EBUSY
+			}
+		}
+		return &UnmountError{Path: root, Failed: failed}
+	}
+	return os.RemoveAll(root)
+}
@@ -0,0 +1,102 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const mountInfoFixture = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+40 22 0:35 / /var/cache/solbuild/main/nano/union rw shared:20 - overlay overlay rw
+41 40 0:5 / /var/cache/solbuild/main/nano/union/dev rw shared:21 - devtmpfs devtmpfs rw
+42 41 0:22 / /var/cache/solbuild/main/nano/union/dev/pts rw shared:22 - devpts devpts rw
+43 40 0:4 / /var/cache/solbuild/main/nano/union/proc rw shared:23 - proc proc rw
+44 40 8:1 /var/lib/solbuild/sources /var/cache/solbuild/main/nano/union/home/build/YPKG/sources/nano\040src.tar.xz ro shared:1 - ext4 /dev/sda1 rw
+45 43 0:36 / /var/cache/solbuild/main/nano/union/proc rw shared:24 - tmpfs tmpfs rw
+46 22 0:37 / /var/cache/solbuild/main/nano-other rw shared:25 - tmpfs tmpfs rw
+`
+
+func TestGetMountsUnder(t *testing.T) {
+	mounts, err := getMountsUnder(strings.NewReader(mountInfoFixture), "/var/cache/solbuild/main/nano/")
+	if err != nil {
+		t.Fatalf("Failed to parse mountinfo: %v", err)
+	}
+	root := "/var/cache/solbuild/main/nano/union"
+	expected := []string{
+		root + "/home/build/YPKG/sources/nano src.tar.xz",
+		root + "/dev/pts",
+		root + "/proc",
+		root + "/proc",
+		root + "/dev",
+		root,
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Fatalf("Mounts not in unmount order: %v", mounts)
+	}
+}
+
+func TestForceUnmount(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Mounting requires root")
+	}
+	tmp, err := ioutil.TempDir("", "solbuild-unmount")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	host := filepath.Join(tmp, "host")
+	union := filepath.Join(tmp, "overlay", "union")
+	nested := filepath.Join(union, "sources")
+	for _, dir := range []string{host, union} {
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(host, "keep"), []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := syscall.Mount("tmpfs", union, "tmpfs", 0, ""); err != nil {
+		t.Skipf("Unable to mount tmpfs: %v", err)
+	}
+	if err := os.MkdirAll(nested, 00755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := syscall.Mount(host, nested, "", syscall.MS_BIND, ""); err != nil {
+		syscall.Unmount(union, 0)
+		t.Skipf("Unable to bind mount: %v", err)
+	}
+
+	if err := ForceUnmount(filepath.Join(tmp, "overlay")); err != nil {
+		t.Fatalf("Failed to force unmount: %v", err)
+	}
+	if mounts, _ := readMountsUnder(tmp); len(mounts) != 0 {
+		t.Fatalf("Mounts were left behind: %v", mounts)
+	}
+	if PathExists(filepath.Join(tmp, "overlay")) {
+		t.Fatalf("Overlay directory should have been removed")
+	}
+	if !PathExists(filepath.Join(host, "keep")) {
+		t.Fatalf("Bind mounted host files must not be removed")
+	}
+}
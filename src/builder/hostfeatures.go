@@ -0,0 +1,122 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// RecordHostFeatures controls whether the kernel and overlayfs features
+	// of the host are captured for each build, to help explain builds that
+	// differ between hosts.
+	RecordHostFeatures = false
+
+	// hostFeaturesRoot is where the proc and sys trees are read from
+	hostFeaturesRoot = "/"
+)
+
+// HostFeatures describes the kernel and overlayfs features in effect for a
+// build on this host.
+type HostFeatures struct {
+	Kernel        string   `json:"kernel"`            // Kernel release, i.e. 4.9.0
+	Metacopy      bool     `json:"metacopy"`          // Whether only metadata is copied up
+	Index         bool     `json:"index"`             // Whether the inodes index is used
+	RedirectDir   string   `json:"redirect_dir"`      // Directory rename handling, i.e. "on"
+	UserNamespace bool     `json:"user_namespace"`    // Whether the build ran in a user namespace
+	Options       []string `json:"options,omitempty"` // Extra overlayfs options in effect
+}
+
+// readHostValue will return the trimmed contents of the file beneath the
+// root, or an empty string if it cannot be read.
+func readHostValue(root, path string) string {
+	b, err := ioutil.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// getOverlayParameter will return the default of the overlayfs module
+// parameter as an "on" or "off" value, or an empty string if unknown.
+func getOverlayParameter(root, name string) string {
+	switch readHostValue(root, filepath.Join("sys/module/overlay/parameters", name)) {
+	case "Y":
+		return "on"
+	case "N":
+		return "off"
+	default:
+		return ""
+	}
+}
+
+// getHostFeatures will describe the host beneath root, with the module
+// defaults overridden by the overlayfs options given to the mount.
+func getHostFeatures(root string, options []string, userns bool) *HostFeatures {
+	values := map[string]string{
+		"metacopy":     getOverlayParameter(root, "metacopy"),
+		"index":        getOverlayParameter(root, "index"),
+		"redirect_dir": getOverlayParameter(root, "redirect_dir"),
+	}
+	for _, opt := range options {
+		fields := strings.SplitN(opt, "=", 2)
+		if _, ok := values[fields[0]]; ok && len(fields) == 2 {
+			values[fields[0]] = fields[1]
+		}
+	}
+	return &HostFeatures{
+		Kernel:        readHostValue(root, "proc/sys/kernel/osrelease"),
+		Metacopy:      values["metacopy"] == "on",
+		Index:         values["index"] == "on",
+		RedirectDir:   values["redirect_dir"],
+		UserNamespace: userns,
+		Options:       options,
+	}
+}
+
+// captureHostFeatures will record the features in effect for the mounted
+// overlay, if enabled.
+func (o *Overlay) captureHostFeatures() {
+	if !RecordHostFeatures {
+		return
+	}
+	var options []string
+	if !o.noExtraOptions {
+		options = OverlayOptions
+	}
+	o.Features = getHostFeatures(hostFeaturesRoot, options, InUserNamespace())
+}
+
+// ReportHostFeatures will record the host features captured during the
+// overlay setup within HostFeatures, logging them for the build report.
+func (p *Package) ReportHostFeatures(o *Overlay) {
+	p.HostFeatures = o.Features
+	if o.Features == nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"kernel":       o.Features.Kernel,
+		"metacopy":     o.Features.Metacopy,
+		"index":        o.Features.Index,
+		"redirect_dir": o.Features.RedirectDir,
+		"userns":       o.Features.UserNamespace,
+		"options":      strings.Join(o.Features.Options, ","),
+	}).Info("Build host features")
+}
@@ -0,0 +1,83 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeHostTree will lay out a stubbed proc and sys tree beneath root
+func writeHostTree(t *testing.T, root string, files map[string]string) {
+	for path, contents := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents+"\n"), 00644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func TestGetHostFeatures(t *testing.T) {
+	root, err := ioutil.TempDir("", "solbuild-hostfeatures")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	writeHostTree(t, root, map[string]string{
+		"proc/sys/kernel/osrelease":                  "4.9.0-solus",
+		"sys/module/overlay/parameters/metacopy":     "N",
+		"sys/module/overlay/parameters/index":        "Y",
+		"sys/module/overlay/parameters/redirect_dir": "N",
+	})
+
+	tests := []struct {
+		options  []string
+		userns   bool
+		expected HostFeatures
+	}{
+		{nil, false, HostFeatures{Kernel: "4.9.0-solus", Index: true, RedirectDir: "off"}},
+		{
+			[]string{"metacopy=on", "index=off", "redirect_dir=follow", "volatile"},
+			true,
+			HostFeatures{
+				Kernel:        "4.9.0-solus",
+				Metacopy:      true,
+				RedirectDir:   "follow",
+				UserNamespace: true,
+				Options:       []string{"metacopy=on", "index=off", "redirect_dir=follow", "volatile"},
+			},
+		},
+	}
+	for _, test := range tests {
+		features := getHostFeatures(root, test.options, test.userns)
+		if !reflect.DeepEqual(*features, test.expected) {
+			t.Fatalf("Expected features %+v, got %+v", test.expected, *features)
+		}
+	}
+
+	// Kernels without the parameters are described as unknown
+	if features := getHostFeatures(filepath.Join(root, "missing"), nil, false); features.Kernel != "" || features.RedirectDir != "" {
+		t.Fatalf("Missing host tree should leave features unknown: %+v", *features)
+	}
+}
@@ -0,0 +1,264 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// InputLockSuffix is appended to the package name to form the name of the
+// input lock written alongside the build artifacts.
+const InputLockSuffix = ".inputs.json"
+
+var (
+	// WriteInputLocks controls whether an input lock is written after each
+	// successful build.
+	WriteInputLocks = false
+
+	// ToolVersion is the version of solbuild recorded in input locks
+	ToolVersion = "1.3.0"
+
+	// ErrImageDrift is returned when replaying a lock against a different
+	// backing image.
+	ErrImageDrift = errors.New("Backing image does not match the input lock")
+)
+
+// A LockedSource records the exact source used by a build
+type LockedSource struct {
+	Identifier string `json:"identifier"`
+	URL        string `json:"url"`       // Where the source was actually fetched from
+	Algorithm  string `json:"algorithm"` // Algorithm of the digest, i.e. sha256
+	Digest     string `json:"digest"`
+
+	// UpstreamDigest is the sha256 of the source as fetched, when it was
+	// normalized before caching.
+	UpstreamDigest string `json:"upstream_digest,omitempty"`
+
+	// Provenance records when and where the cached source was fetched
+	Provenance *source.Provenance `json:"provenance,omitempty"`
+}
+
+// An InputLock records the exact inputs of a build, so that it may be
+// reproduced and verified later on.
+type InputLock struct {
+	Package     string         `json:"package"`
+	Version     string         `json:"version"`
+	Release     int            `json:"release"`
+	Image       string         `json:"image"`        // Name of the backing image
+	ImageDigest string         `json:"image_digest"` // sha256 of the backing image
+	Tool        string         `json:"tool"`         // Version of solbuild
+	Environment []string       `json:"environment"`
+	Sources     []LockedSource `json:"sources"`
+	Host        *HostFeatures  `json:"host,omitempty"` // Features of the build host, if recorded
+}
+
+// A SourceDriftError is returned when a source no longer matches the
+// digest recorded in the input lock.
+type SourceDriftError struct {
+	Identifier string
+	Expected   string
+	Found      string
+}
+
+// Error will describe the drifted source
+func (s *SourceDriftError) Error() string {
+	if s.Found == "" {
+		return fmt.Sprintf("Source %s is not recorded in the input lock", s.Identifier)
+	}
+	return fmt.Sprintf("Source %s does not match the input lock, expected %s, found %s", s.Identifier, s.Expected, s.Found)
+}
+
+// effectiveSource is implemented by sources that know where they were
+// actually fetched from.
+type effectiveSource interface {
+	GetEffectiveURL() string
+}
+
+// getLockedSource will record the digest of the cached source. Cached files
+// are hashed directly, while other sources, i.e. git, use their validator.
+func getLockedSource(s source.Source) (LockedSource, error) {
+	info := source.GetInfo(s)
+	locked := LockedSource{
+		Identifier: info.Identifier,
+		URL:        info.Identifier,
+		Algorithm:  info.Algorithm,
+		Digest:     info.Validator,
+	}
+	if e, ok := s.(effectiveSource); ok {
+		locked.URL = e.GetEffectiveURL()
+	}
+	if ps, ok := s.(source.ProvenanceSource); ok {
+		if prov, err := ps.GetProvenance(); err == nil {
+			locked.Provenance = prov
+			locked.URL = prov.EffectiveURL
+		}
+	}
+	if st, err := os.Stat(info.CachePath); err == nil && st.Mode().IsRegular() {
+		digest, err := computeArtifactDigest(info.CachePath, false)
+		if err != nil {
+			return locked, err
+		}
+		if info.Algorithm == "sha256" && info.Validator != digest.SHA256 {
+			locked.UpstreamDigest = info.Validator
+		}
+		locked.Algorithm = "sha256"
+		locked.Digest = digest.SHA256
+	}
+	return locked, nil
+}
+
+// NewInputLock will record the current inputs of the package build
+func NewInputLock(p *Package, o *Overlay) (*InputLock, error) {
+	lock := &InputLock{
+		Package:     p.Name,
+		Version:     p.Version,
+		Release:     p.Release,
+		Image:       o.Back.Name,
+		Tool:        ToolVersion,
+		Environment: ChrootEnvironment,
+		Host:        o.Features,
+	}
+	digest, err := computeArtifactDigest(o.Back.ImagePath, false)
+	if err != nil {
+		return nil, err
+	}
+	lock.ImageDigest = digest.SHA256
+
+	for _, s := range p.Sources {
+		locked, err := getLockedSource(s)
+		if err != nil {
+			return nil, err
+		}
+		lock.Sources = append(lock.Sources, locked)
+	}
+	return lock, nil
+}
+
+// ReadInputLock will load a previously written input lock
+func ReadInputLock(path string) (*InputLock, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &InputLock{}
+	if err := json.Unmarshal(b, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Write will store the input lock at the given path, owned by the user
+func (l *InputLock) Write(path string, usr *UserInfo) error {
+	b, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 00644); err != nil {
+		return err
+	}
+	return os.Chown(path, usr.UID, usr.GID)
+}
+
+// Verify will ensure the current inputs match those of the lock, failing
+// if the backing image or any source differs. Differences in the tool
+// version or environment are only reported.
+func (l *InputLock) Verify(current *InputLock) error {
+	if l.ImageDigest != current.ImageDigest {
+		return ErrImageDrift
+	}
+	recorded := make(map[string]LockedSource)
+	for _, s := range l.Sources {
+		recorded[s.Identifier] = s
+	}
+	for _, s := range current.Sources {
+		locked, ok := recorded[s.Identifier]
+		if !ok {
+			return &SourceDriftError{Identifier: s.Identifier}
+		}
+		if locked.Algorithm != s.Algorithm || locked.Digest != s.Digest {
+			return &SourceDriftError{Identifier: s.Identifier, Expected: locked.Digest, Found: s.Digest}
+		}
+		delete(recorded, s.Identifier)
+	}
+	for id, locked := range recorded {
+		return &SourceDriftError{Identifier: id, Expected: locked.Digest, Found: "nothing"}
+	}
+	if l.Tool != current.Tool {
+		log.WithFields(log.Fields{
+			"locked":  l.Tool,
+			"current": current.Tool,
+		}).Warning("Replaying input lock with a different solbuild version")
+	}
+	if !reflect.DeepEqual(l.Environment, current.Environment) {
+		log.Warning("Replaying input lock with a different build environment")
+	}
+	if l.Host != nil && current.Host != nil && !reflect.DeepEqual(l.Host, current.Host) {
+		log.WithFields(log.Fields{
+			"locked":  l.Host.Kernel,
+			"current": current.Host.Kernel,
+		}).Warning("Replaying input lock with different host features")
+	}
+	return nil
+}
+
+// VerifyInputLock will compare the inputs of the build to the replay lock,
+// if one has been set.
+func (p *Package) VerifyInputLock(o *Overlay) error {
+	if p.ReplayLock == nil {
+		return nil
+	}
+	current, err := NewInputLock(p, o)
+	if err != nil {
+		return err
+	}
+	if err := p.ReplayLock.Verify(current); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Build inputs differ from the input lock")
+		return err
+	}
+	log.Info("Build inputs match the input lock")
+	return nil
+}
+
+// WriteInputLock will write the input lock of a successful build into the
+// current directory, alongside the build artifacts.
+func (p *Package) WriteInputLock(o *Overlay, usr *UserInfo) error {
+	if !WriteInputLocks {
+		return nil
+	}
+	lock, err := NewInputLock(p, o)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(p.Name + InputLockSuffix)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("Writing input lock")
+	return lock.Write(path, usr)
+}
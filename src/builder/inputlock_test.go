@@ -0,0 +1,124 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInputLockReplay(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-inputlock")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	image := filepath.Join(tmp, "main-x86_64.img")
+	if err := ioutil.WriteFile(image, []byte("image"), 00644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	src := &fetchableSource{path: filepath.Join(tmp, "nano.tar.xz")}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	pkg := &Package{Name: "nano", Version: "2.7.5", Release: 1, Sources: []source.Source{src}}
+	overlay := &Overlay{Back: &BackingImage{Name: "main-x86_64", ImagePath: image}}
+
+	lock, err := NewInputLock(pkg, overlay)
+	if err != nil {
+		t.Fatalf("Failed to create input lock: %v", err)
+	}
+	if len(lock.Sources) != 1 || lock.Sources[0].Digest != "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762" {
+		t.Fatalf("Wrong source digest recorded: %+v", lock.Sources)
+	}
+
+	usr := &UserInfo{UID: os.Getuid(), GID: os.Getgid()}
+	lockPath := filepath.Join(tmp, "nano"+InputLockSuffix)
+	if err := lock.Write(lockPath, usr); err != nil {
+		t.Fatalf("Failed to write input lock: %v", err)
+	}
+	if pkg.ReplayLock, err = ReadInputLock(lockPath); err != nil {
+		t.Fatalf("Failed to read input lock: %v", err)
+	}
+	if err := pkg.VerifyInputLock(overlay); err != nil {
+		t.Fatalf("Replay against an unchanged cache should succeed: %v", err)
+	}
+
+	// Drift the cached source
+	if err := ioutil.WriteFile(src.path, []byte("vim"), 00644); err != nil {
+		t.Fatalf("Failed to modify source: %v", err)
+	}
+	err = pkg.VerifyInputLock(overlay)
+	if drift, ok := err.(*SourceDriftError); !ok || drift.Identifier != src.path {
+		t.Fatalf("Drifted source should be rejected, got: %v", err)
+	}
+
+	// Drift the backing image
+	if err := ioutil.WriteFile(src.path, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to restore source: %v", err)
+	}
+	if err := ioutil.WriteFile(image, []byte("image2"), 00644); err != nil {
+		t.Fatalf("Failed to modify image: %v", err)
+	}
+	if err := pkg.VerifyInputLock(overlay); err != ErrImageDrift {
+		t.Fatalf("Different backing image should be rejected, got: %v", err)
+	}
+}
+
+func TestInputLockTransformedSource(t *testing.T) {
+	oldSourceDir := source.SourceDir
+	defer source.SetSourceDir(oldSourceDir)
+
+	tmp, err := ioutil.TempDir("", "solbuild-inputlock")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	source.SetSourceDir(tmp)
+
+	// Normalized content cached under its own digest, linked from upstream
+	upstream := "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+	normalized := "3929a2d0db4d8e6375b657dcf56d69a6c6f7476e5e8b7f6453a598b02fe40d1d"
+	if err := os.MkdirAll(filepath.Join(tmp, normalized), 00755); err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, normalized, "nano-2.7.5.tar.xz"), []byte("NANO"), 00644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	if err := os.Symlink(normalized, filepath.Join(tmp, upstream)); err != nil {
+		t.Fatalf("Failed to link source: %v", err)
+	}
+
+	src, err := source.NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", upstream, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	locked, err := getLockedSource(src)
+	if err != nil {
+		t.Fatalf("Failed to lock source: %v", err)
+	}
+	if locked.Digest != normalized || locked.UpstreamDigest != upstream {
+		t.Fatalf("Expected digest %s from upstream %s, got %+v", normalized, upstream, locked)
+	}
+	if locked.URL != src.URI {
+		t.Fatalf("Upstream URL should be recorded: %s", locked.URL)
+	}
+}
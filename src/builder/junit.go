@@ -0,0 +1,202 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// JUnitOutputLimit is the most bytes of build output kept for each failed
+// package in a JUnit report, keeping the end where the failure is.
+var JUnitOutputLimit = 64 * 1024
+
+// A BatchResult records the outcome of a single package within a batch
+type BatchResult struct {
+	Package  *Package
+	Start    time.Time
+	Duration time.Duration
+	Skipped  string // Why the package wasn't built, if it wasn't
+	Err      error  // Why the build failed, if it did
+	Output   string // Captured output of a failed build
+}
+
+// A BatchBuildError is returned by a BatchBuilder to include the output
+// of the failed build within reports.
+type BatchBuildError struct {
+	Err    error
+	Output string
+}
+
+func (e *BatchBuildError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap will return the underlying error
+func (e *BatchBuildError) Unwrap() error {
+	return e.Err
+}
+
+// An OutputTail keeps the last bytes written to it, to capture the end of
+// the build output without buffering all of it.
+type OutputTail struct {
+	limit int
+	lock  sync.Mutex
+	buf   []byte
+}
+
+// NewOutputTail will return an OutputTail keeping up to limit bytes
+func NewOutputTail(limit int) *OutputTail {
+	return &OutputTail{limit: limit}
+}
+
+// Write will append the data, discarding the oldest beyond the limit
+func (t *OutputTail) Write(b []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > t.limit {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.limit:]...)
+	}
+	return len(b), nil
+}
+
+// String will return the captured output
+func (t *OutputTail) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return string(t.buf)
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Output  string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Skipped   *junitSkipped `xml:"skipped"`
+	Failure   *junitFailure `xml:"failure"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Hostname  string          `xml:"hostname,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTime will format the duration as seconds, as JUnit expects
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// tailOutput will return the end of the output, within JUnitOutputLimit
+func tailOutput(output string) string {
+	if len(output) <= JUnitOutputLimit {
+		return output
+	}
+	return output[len(output)-JUnitOutputLimit:]
+}
+
+// newJUnitReport will describe the batch results as a single test suite,
+// with each package as a test case.
+func newJUnitReport(name string, results []BatchResult) *junitTestSuites {
+	suite := junitTestSuite{
+		Name:      name,
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Hostname:  "localhost",
+	}
+	if host, err := os.Hostname(); err == nil {
+		suite.Hostname = host
+	}
+	if len(results) > 0 && !results[0].Start.IsZero() {
+		suite.Timestamp = results[0].Start.UTC().Format("2006-01-02T15:04:05")
+	}
+
+	var total time.Duration
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("%s-%s-%d", r.Package.Name, r.Package.Version, r.Package.Release),
+			Classname: r.Package.Name,
+			Time:      junitTime(r.Duration),
+		}
+		switch {
+		case r.Err != nil:
+			tc.Failure = &junitFailure{
+				Message: r.Err.Error(),
+				Type:    fmt.Sprintf("%T", r.Err),
+				Output:  tailOutput(r.Output),
+			}
+			suite.Failures++
+		case r.Skipped != "":
+			tc.Skipped = &junitSkipped{Message: r.Skipped}
+			suite.Skipped++
+		}
+		total += r.Duration
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = junitTime(total)
+
+	return &junitTestSuites{
+		Name:     name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+}
+
+// WriteJUnitReport will write the results of a batch as JUnit XML, for
+// use by CI systems. Output is escaped, with any characters that cannot
+// appear in XML replaced.
+func WriteJUnitReport(path, name string, results []BatchResult) error {
+	b, err := xml.MarshalIndent(newJUnitReport(name, results), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 00644)
+}
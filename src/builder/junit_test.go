@@ -0,0 +1,148 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestJUnitReport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-junit")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var pkgs []*Package
+	for _, name := range []string{"nano", "vim", "emacs", "joe"} {
+		pkgs = append(pkgs, &Package{Name: name, Version: "1.0", Release: 1, Type: PackageTypeYpkg})
+	}
+	checkpoint, err := NewBatchCheckpoint(filepath.Join(tmp, "checkpoint"))
+	if err != nil {
+		t.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	checkpoint.ArtifactDir = tmp
+	if err := ioutil.WriteFile(filepath.Join(tmp, "nano-1.0-1-1-x86_64.eopkg"), nil, 00644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	if err := checkpoint.MarkComplete(pkgs[0]); err != nil {
+		t.Fatalf("Failed to mark package complete: %v", err)
+	}
+
+	output := "checking for <stdio.h>... no\n\x1b[31merror\x1b[0m: \"a\" & 'b' ]]>\n"
+	runner := NewBatchRunner(checkpoint, func(pkg *Package) error {
+		if pkg.Name == "emacs" {
+			return &BatchBuildError{Err: errors.New("exit status 2"), Output: output}
+		}
+		return nil
+	})
+	if err := runner.Run(pkgs, false); err == nil {
+		t.Fatalf("Batch should have failed")
+	}
+
+	path := filepath.Join(tmp, "report.xml")
+	if err := WriteJUnitReport(path, "solbuild batch", runner.Results); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if strings.Contains(string(b), "<stdio.h>") || strings.Contains(string(b), "\x1b") {
+		t.Fatalf("Output was not escaped:\n%s", b)
+	}
+
+	// Check the structure and required attributes of the JUnit schema
+	var report struct {
+		XMLName xml.Name `xml:"testsuites"`
+		Suites  []struct {
+			Name      string `xml:"name,attr"`
+			Tests     string `xml:"tests,attr"`
+			Failures  string `xml:"failures,attr"`
+			Errors    string `xml:"errors,attr"`
+			Skipped   string `xml:"skipped,attr"`
+			Time      string `xml:"time,attr"`
+			Timestamp string `xml:"timestamp,attr"`
+			Hostname  string `xml:"hostname,attr"`
+			Cases     []struct {
+				Name      string `xml:"name,attr"`
+				Classname string `xml:"classname,attr"`
+				Time      string `xml:"time,attr"`
+				Skipped   *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"skipped"`
+				Failure *struct {
+					Message string `xml:"message,attr"`
+					Type    string `xml:"type,attr"`
+					Output  string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(b, &report); err != nil {
+		t.Fatalf("Report is not well formed XML: %v", err)
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("Expected a single test suite, got %d", len(report.Suites))
+	}
+	suite := report.Suites[0]
+	if suite.Name == "" || suite.Timestamp == "" || suite.Hostname == "" {
+		t.Fatalf("Test suite is missing required attributes: %+v", suite)
+	}
+	if suite.Tests != "4" || suite.Failures != "1" || suite.Errors != "0" || suite.Skipped != "2" {
+		t.Fatalf("Wrong test suite counts: %+v", suite)
+	}
+	if _, err := strconv.ParseFloat(suite.Time, 64); err != nil {
+		t.Fatalf("Invalid test suite time: %v", err)
+	}
+	for i, tc := range suite.Cases {
+		if tc.Name != pkgs[i].Name+"-1.0-1" || tc.Classname != pkgs[i].Name {
+			t.Fatalf("Wrong test case name: %+v", tc)
+		}
+		if _, err := strconv.ParseFloat(tc.Time, 64); err != nil {
+			t.Fatalf("Invalid test case time: %v", err)
+		}
+		failed := tc.Failure != nil
+		skipped := tc.Skipped != nil
+		if failed != (i == 2) || skipped != (i == 0 || i == 3) {
+			t.Fatalf("Wrong outcome for %s: failed %v, skipped %v", tc.Name, failed, skipped)
+		}
+	}
+	failure := suite.Cases[2].Failure
+	if failure.Message != "exit status 2" || failure.Type == "" {
+		t.Fatalf("Wrong failure: %+v", failure)
+	}
+	if expected := strings.Replace(output, "\x1b", "�", -1); failure.Output != expected {
+		t.Fatalf("Failure output was not preserved: %q", failure.Output)
+	}
+}
+
+func TestOutputTail(t *testing.T) {
+	tail := NewOutputTail(8)
+	tail.Write([]byte("nano is a "))
+	tail.Write([]byte("text editor"))
+	if out := tail.String(); out != "t editor" {
+		t.Fatalf("Wrong output tail: %q", out)
+	}
+}
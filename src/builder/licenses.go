@@ -0,0 +1,130 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+const (
+	// LicenseCheckOff disables checking sources for license files
+	LicenseCheckOff = "off"
+
+	// LicenseCheckWarn warns about sources without a license file
+	LicenseCheckWarn = "warn"
+
+	// LicenseCheckFail fails the build for sources without a license file
+	LicenseCheckFail = "fail"
+)
+
+// LicenseCheck controls how sources without any license or notice file
+// are handled.
+var LicenseCheck = LicenseCheckOff
+
+// SetLicenseCheck will validate and set the license check mode
+func SetLicenseCheck(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "":
+		LicenseCheck = LicenseCheckOff
+	case LicenseCheckOff, LicenseCheckWarn, LicenseCheckFail:
+		LicenseCheck = mode
+	default:
+		return fmt.Errorf("Unknown license check mode: %s", mode)
+	}
+	return nil
+}
+
+// A MissingLicenseError is returned when a source contains no license or
+// notice file, and the license check is set to fail.
+type MissingLicenseError struct {
+	Source string // Identifier of the source
+}
+
+func (e *MissingLicenseError) Error() string {
+	return fmt.Sprintf("No license file found in source %s", e.Source)
+}
+
+// getSourceLicenses will return the license files of the fetched source,
+// whether a tree such as a git clone or an archive. Sources that can't be
+// inspected, such as plain files, are not checked.
+func getSourceLicenses(s source.Source) ([]string, bool, error) {
+	path := s.GetBindConfiguration("").BindSource
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if st.IsDir() {
+		licenses, err := source.FindLicenseFiles(path)
+		return licenses, true, err
+	}
+	licenses, err := source.ListArchiveLicenses(path)
+	if err == source.ErrUnsupportedArchive {
+		return nil, false, nil
+	}
+	return licenses, true, err
+}
+
+// CheckLicenses will look for license and notice files within each source,
+// recording those found within Licenses. Sources without any are reported
+// according to LicenseCheck.
+func (p *Package) CheckLicenses() error {
+	if LicenseCheck == LicenseCheckOff {
+		return nil
+	}
+	p.Licenses = make(map[string][]string)
+
+	var missing error
+	for _, s := range p.Sources {
+		// Streamed sources are never available to check
+		if p.isStreamed(s) {
+			continue
+		}
+		licenses, checked, err := getSourceLicenses(s)
+		if err != nil {
+			return err
+		}
+		if !checked {
+			log.WithFields(log.Fields{
+				"source": s.GetIdentifier(),
+			}).Debug("Cannot check source for license files")
+			continue
+		}
+		p.Licenses[s.GetIdentifier()] = licenses
+		if len(licenses) > 0 {
+			log.WithFields(log.Fields{
+				"source":   s.GetIdentifier(),
+				"licenses": strings.Join(licenses, ", "),
+			}).Info("Found license files in source")
+			continue
+		}
+		fields := log.Fields{"source": s.GetIdentifier()}
+		if LicenseCheck == LicenseCheckFail {
+			log.WithFields(fields).Error("No license file found in source")
+			if missing == nil {
+				missing = &MissingLicenseError{Source: s.GetIdentifier()}
+			}
+		} else {
+			log.WithFields(fields).Warning("No license file found in source")
+		}
+	}
+	return missing
+}
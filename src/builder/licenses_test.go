@@ -0,0 +1,81 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckLicenses(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-licenses")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	licensed := filepath.Join(tmp, "nano")
+	unlicensed := filepath.Join(tmp, "vim")
+	for path, contents := range map[string]string{
+		filepath.Join(licensed, "README"):         "nano",
+		filepath.Join(licensed, "COPYING"):        "GPL",
+		filepath.Join(licensed, ".git", "NOTICE"): "ignored",
+		filepath.Join(unlicensed, "README"):       "vim",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	defer SetLicenseCheck(LicenseCheckOff)
+
+	// Disabled by default
+	p := &Package{Sources: []source.Source{&treeSource{path: unlicensed}}}
+	if err := p.CheckLicenses(); err != nil || p.Licenses != nil {
+		t.Fatalf("License check should be off by default: %v", err)
+	}
+
+	SetLicenseCheck(LicenseCheckWarn)
+	p = &Package{Sources: []source.Source{&treeSource{path: licensed}, &treeSource{path: unlicensed}}}
+	if err := p.CheckLicenses(); err != nil {
+		t.Fatalf("Missing licenses should only warn: %v", err)
+	}
+	expected := map[string][]string{licensed: {"COPYING"}, unlicensed: nil}
+	if !reflect.DeepEqual(p.Licenses, expected) {
+		t.Fatalf("Expected licenses %v, got %v", expected, p.Licenses)
+	}
+
+	SetLicenseCheck(LicenseCheckFail)
+	err = p.CheckLicenses()
+	if missing, ok := err.(*MissingLicenseError); !ok || missing.Source != unlicensed {
+		t.Fatalf("Expected missing license for %s, got: %v", unlicensed, err)
+	}
+	p = &Package{Sources: []source.Source{&treeSource{path: licensed}}}
+	if err := p.CheckLicenses(); err != nil {
+		t.Fatalf("Licensed sources should pass: %v", err)
+	}
+
+	if err := SetLicenseCheck("strict"); err == nil {
+		t.Fatalf("Unknown modes should be rejected")
+	}
+}
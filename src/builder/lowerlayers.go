@@ -0,0 +1,90 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxLowerLayers is the most extra lower layers that may be configured,
+// leaving room beneath the overlayfs limit for the image and cached layers.
+const MaxLowerLayers = 32
+
+// LowerLayers are extra read-only directories stacked on top of the backing
+// image in every overlay, such as preinstalled toolchains. Like the overlayfs
+// lowerdir option, the first layer is the topmost.
+var LowerLayers []string
+
+// ValidateLowerLayers will ensure each layer is an existing directory given
+// by absolute path, that may be safely used as an overlayfs lower layer.
+func ValidateLowerLayers(dirs []string) error {
+	if len(dirs) > MaxLowerLayers {
+		return fmt.Errorf("Too many lower layers: %d, at most %d are supported", len(dirs), MaxLowerLayers)
+	}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("Lower layer must be absolute: '%s'", dir)
+		}
+		clean := filepath.Clean(dir)
+		// overlayfs uses ':' and ',' to separate layers and options
+		if strings.ContainsAny(clean, ":,") {
+			return fmt.Errorf("Lower layer may not contain ':' or ',': '%s'", dir)
+		}
+		if seen[clean] {
+			return fmt.Errorf("Lower layer is listed twice: '%s'", dir)
+		}
+		seen[clean] = true
+		// Build roots are transient and may never be stacked
+		if clean == OverlayRootDir || strings.HasPrefix(clean, OverlayRootDir+"/") {
+			return fmt.Errorf("Lower layer may not be within %s: '%s'", OverlayRootDir, dir)
+		}
+		st, err := os.Stat(clean)
+		if err != nil {
+			return fmt.Errorf("Lower layer is not accessible: %v", err)
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("Lower layer is not a directory: '%s'", dir)
+		}
+	}
+	return nil
+}
+
+// SetLowerLayers will validate and set the extra lower layers
+func SetLowerLayers(dirs []string) error {
+	if err := ValidateLowerLayers(dirs); err != nil {
+		return err
+	}
+	LowerLayers = nil
+	for _, dir := range dirs {
+		LowerLayers = append(LowerLayers, filepath.Clean(dir))
+	}
+	return nil
+}
+
+// getLowerDirs will return every read-only layer of the overlay, topmost
+// first. Cached layers were prepared on top of the configured layers, so
+// they come first, with the backing image always at the bottom.
+func (o *Overlay) getLowerDirs() []string {
+	var dirs []string
+	dirs = append(dirs, o.LowerDirs...)
+	dirs = append(dirs, o.Layers...)
+	return append(dirs, o.ImgDir)
+}
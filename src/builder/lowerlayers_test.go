@@ -0,0 +1,76 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLowerLayers(t *testing.T) {
+	defer func() {
+		LowerLayers = nil
+	}()
+	tmp, err := ioutil.TempDir("", "solbuild-layers")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	toolchains := filepath.Join(tmp, "toolchains")
+	fonts := filepath.Join(tmp, "fonts")
+	for _, dir := range []string{toolchains, fonts} {
+		if err := os.Mkdir(dir, 00755); err != nil {
+			t.Fatalf("Failed to create layer: %v", err)
+		}
+	}
+	if err := SetLowerLayers([]string{toolchains + "/", fonts}); err != nil {
+		t.Fatalf("Failed to set valid layers: %v", err)
+	}
+
+	overlay := NewOverlay(&Profile{Name: "main-x86_64"}, &BackingImage{Name: "main-x86_64"}, &Package{Name: "nano"})
+	overlay.ImgDir = "/img"
+	overlay.LowerDirs = append(overlay.LowerDirs, "/deps")
+	options := strings.Join(overlay.getOverlayOptions(), ",")
+	if want := "lowerdir=/deps:" + toolchains + ":" + fonts + ":/img,"; !strings.HasPrefix(options, want) {
+		t.Fatalf("Wrong lower layers in mount: %v", options)
+	}
+
+	file := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(file, nil, 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	invalid := [][]string{
+		{"relative/layer"},
+		{filepath.Join(tmp, "missing")},
+		{file},
+		{fonts, fonts + "/"},
+		{filepath.Join(OverlayRootDir, "main-x86_64")},
+		{tmp + ":/img"},
+	}
+	for _, dirs := range invalid {
+		if err := SetLowerLayers(dirs); err == nil {
+			t.Fatalf("Invalid layers should be rejected: %v", dirs)
+		}
+	}
+	if len(LowerLayers) != 2 {
+		t.Fatalf("Invalid layers should not replace the existing ones")
+	}
+}
@@ -17,6 +17,7 @@
 package builder
 
 import (
+	"builder/source"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/disk"
@@ -77,6 +78,10 @@ type Manager struct {
 	history *PackageHistory // Given package history, if any
 
 	activePID int // Active PID
+
+	id string // Unique ID of this build within the registry
+
+	metrics *MetricsRegistry // Metrics to write out, if enabled
 }
 
 // NewManager will return a newly initialised manager instance
@@ -91,11 +96,53 @@ func NewManager() (*Manager, error) {
 		updateMode: false,
 		lockfile:   nil,
 		didStart:   false,
+		id:         newBuildID(),
 	}
 
 	// Now load the configuration in
 	if config, err := NewConfig(); err == nil {
 		man.config = config
+		source.DeduplicateSources = config.DeduplicateSources
+		source.MaxCachedVersions = config.MaxCachedVersions
+		source.DecompressionJobs = config.DecompressionJobs
+		if config.MaxExtractSize > 0 {
+			source.MaxExtractSize = config.MaxExtractSize * 1024 * 1024
+		}
+		if config.MaxExtractEntries > 0 {
+			source.MaxExtractEntries = config.MaxExtractEntries
+		}
+		source.MaxRedirects = config.MaxRedirects
+		FetchJobs = config.FetchJobs
+		AdaptiveFetch = config.AdaptiveFetch
+		StreamSources = config.StreamSources
+		RecordHostFeatures = config.RecordHostFeatures
+		source.ReuseConnections = config.ReuseConnections
+		source.RangeConnections = config.DownloadConnections
+		SeedTorrents = config.SeedTorrents
+		if config.IPFSGateway != "" {
+			source.IPFSGateway = config.IPFSGateway
+		}
+		source.IPFSDaemon = config.IPFSDaemon
+		source.HostHeaders = config.Headers
+		source.Mirrors = config.Mirrors
+		source.ArchiveURL = config.ArchiveURL
+		if config.Offline {
+			// The --offline flag may already be set
+			source.Offline = true
+		}
+		source.StaticHosts = config.Hosts
+		source.DoHURL = config.DoHURL
+		source.RequireSecureSchemes = config.RequireSecureSources
+		source.InsecureHosts = config.InsecureHosts
+		Secrets = config.Secrets
+		CacheDependencyLayers = config.CacheDependencyLayers
+		ArtifactSHA512 = config.ArtifactSHA512
+		EnvironmentBaseline = config.EnvironmentBaseline
+		WarmOverlays = config.WarmOverlays
+		KeepFailedOverlays = config.KeepFailedOverlays
+		WriteInputLocks = config.WriteInputLocks
+		SkipBuilt = config.SkipBuilt
+		CaptureBuildLogs = config.CaptureBuildLogs
 	} else {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -103,6 +150,87 @@ func NewManager() (*Manager, error) {
 		return nil, err
 	}
 
+	if err := SetCachePaths(man.config); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid cache paths")
+		return nil, err
+	}
+
+	if err := SetTempDir(man.config.TempDir); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"dir":   man.config.TempDir,
+		}).Error("Invalid temporary directory")
+		return nil, err
+	}
+
+	if err := source.EnsureSourceDir(); err != nil {
+		return nil, err
+	}
+
+	if man.config.MetricsFile != "" {
+		man.metrics = NewMetricsRegistry()
+		SetMetrics(man.metrics)
+	}
+
+	if err := SetOverlayOptions(man.config.OverlayOptions); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid overlayfs options")
+		return nil, err
+	}
+
+	if err := SetScratchDirs(man.config.ScratchDirs); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid scratch directories")
+		return nil, err
+	}
+
+	if err := SetLowerLayers(man.config.LowerLayers); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid lower layers")
+		return nil, err
+	}
+
+	if err := SetTargetArch(man.config.TargetArch); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid target architecture")
+		return nil, err
+	}
+
+	if err := SetLicenseCheck(man.config.LicenseCheck); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid license check mode")
+		return nil, err
+	}
+
+	if err := source.SetFTPMode(man.config.FTPMode); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid FTP mode")
+		return nil, err
+	}
+
+	if err := SetNetworkPolicy(man.config.Network); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Invalid network configuration")
+		return nil, err
+	}
+
+	if err := LoadCredentials(man.config.CredentialsFile); err != nil {
+		log.WithFields(log.Fields{
+			"path":  man.config.CredentialsFile,
+			"error": err,
+		}).Error("Failed to load source credentials")
+		return nil, err
+	}
+
 	man.lock = new(sync.Mutex)
 	return man, nil
 }
@@ -133,8 +261,10 @@ func (m *Manager) SetProfile(profile string) error {
 		return err
 	}
 
-	if !IsValidImage(prof.Image) {
-		EmitImageError(prof.Image)
+	// Use the backing image for the target architecture
+	image := GetArchImage(prof.Image, TargetArch)
+	if !IsValidImage(image) {
+		EmitImageError(image)
 		return ErrInvalidImage
 	}
 
@@ -142,11 +272,29 @@ func (m *Manager) SetProfile(profile string) error {
 		return ErrManagerInitialised
 	}
 
+	if err := source.SetProxy(prof.Proxy); err != nil {
+		return err
+	}
+	if err := source.SetLimitRate(prof.LimitRate); err != nil {
+		return err
+	}
+	if err := source.SetS3Config(prof.S3); err != nil {
+		return err
+	}
+	if err := source.SetSFTPConfig(prof.SFTP); err != nil {
+		return err
+	}
+
 	m.profile = prof
-	m.image = NewBackingImage(m.profile.Image)
+	m.image = NewBackingImage(image)
 	return nil
 }
 
+// GetID will return the unique ID for this manager's builds
+func (m *Manager) GetID() string {
+	return m.id
+}
+
 // GetProfile will return the profile associated with this builder
 func (m *Manager) GetProfile() *Profile {
 	m.lock.Lock()
@@ -183,6 +331,9 @@ func (m *Manager) SetPackage(pkg *Package) error {
 		}
 	}
 
+	// Only fetch the sources needed for the target architecture
+	pkg.SelectSources(TargetArch)
+
 	m.pkg = pkg
 	m.overlay = NewOverlay(m.profile, m.image, m.pkg)
 	m.pkgManager = NewEopkgManager(m, m.overlay.MountPoint)
@@ -216,8 +367,14 @@ func (m *Manager) Cleanup() {
 	log.Debug("Acquiring global lock")
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	// Already cleaned up, i.e. cancelled by ID
+	if !m.didStart {
+		return
+	}
 	log.Debug("Cleaning up")
 
+	source.StopSeeding()
+
 	if m.pkgManager != nil {
 		// Potentially unnecessary but meh
 		m.pkgManager.StopDBUS()
@@ -257,10 +414,12 @@ func (m *Manager) Cleanup() {
 		MurderDeathKill(deathPoint)
 	}
 
-	// Unmount anything we may have mounted
+	// Unmount anything we may have mounted. Shared backing images are not
+	// mounted by the mount manager, so other builds keep them.
 	disk.GetMountManager().UnmountAll()
 
 	// Finally clean out the lock files
+	m.didStart = false
 	if m.lockfile != nil {
 		if err := m.lockfile.Unlock(); err != nil {
 			log.WithFields(log.Fields{
@@ -321,6 +480,16 @@ func (m *Manager) SigIntCleanup() {
 	}()
 }
 
+// writeMetrics will write out the metrics collected during this run
+func (m *Manager) writeMetrics() {
+	if err := m.metrics.WriteMetricsFile(m.config.MetricsFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  m.config.MetricsFile,
+		}).Error("Failed to write metrics")
+	}
+}
+
 // Build will attempt to build the package associated with this manager,
 // automatically handling any required cleanups.
 func (m *Manager) Build() error {
@@ -335,6 +504,47 @@ func (m *Manager) Build() error {
 	}
 	m.lock.Unlock()
 
+	// Nothing to lock or check if the package is already built
+	if outputDir, err := filepath.Abs("."); err == nil && m.pkg.ShouldSkipBuild(outputDir) {
+		log.WithFields(log.Fields{
+			"package": m.pkg.Name,
+			"version": m.pkg.Version,
+			"release": m.pkg.Release,
+		}).Info("Package already built, skipping")
+		return nil
+	}
+
+	// Without root, the backing image must be usable before we go any further
+	if err := sharedBases.CheckMountable(m.overlay.Back); err != nil {
+		return err
+	}
+
+	// Make the build cancellable by ID, pruning it once complete
+	if err := ActiveBuilds.Register(m.id, m); err != nil {
+		return err
+	}
+	defer ActiveBuilds.Unregister(m.id)
+
+	if m.metrics != nil {
+		defer m.writeMetrics()
+	}
+
+	if m.config.EventSocket != "" {
+		events, err := NewEventServer(m.config.EventSocket)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  m.config.EventSocket,
+			}).Error("Failed to listen for event clients")
+			return err
+		}
+		SetEventSink(events)
+		defer func() {
+			SetEventSink(nil)
+			events.Close()
+		}()
+	}
+
 	// Now get on with the real work!
 	defer m.Cleanup()
 	m.SigIntCleanup()
@@ -347,7 +557,21 @@ func (m *Manager) Build() error {
 		return err
 	}
 
-	return m.pkg.Build(m, m.history, m.GetProfile(), m.pkgManager, m.overlay)
+	if err := m.pkg.CheckDiskSpace(m.overlay, m.config.DiskHeadroom, m.config.StrictDiskCheck); err != nil {
+		return err
+	}
+
+	err := m.pkg.Build(m, m.history, m.GetProfile(), m.pkgManager, m.overlay)
+	if err != nil && KeepFailedOverlays > 0 && !m.IsCancelled() {
+		// The overlay must be torn down before it can be moved aside
+		m.Cleanup()
+		if _, keepErr := m.overlay.KeepFailed(m.pkg, err); keepErr != nil {
+			log.WithFields(log.Fields{
+				"error": keepErr,
+			}).Warning("Failed to keep overlay of failed build")
+		}
+	}
+	return err
 }
 
 // Chroot will enter the build environment to allow users to introspect it
@@ -367,6 +591,10 @@ func (m *Manager) Chroot() error {
 	defer m.Cleanup()
 	m.SigIntCleanup()
 
+	if err := sharedBases.CheckMountable(m.overlay.Back); err != nil {
+		return err
+	}
+
 	if err := m.doLock(m.overlay.LockPath, "chroot"); err != nil {
 		return err
 	}
@@ -374,6 +602,36 @@ func (m *Manager) Chroot() error {
 	return m.pkg.Chroot(m, m.pkgManager, m.overlay)
 }
 
+// Fetch will download and verify the sources of the package into the
+// source cache, without preparing an overlay or building. This allows
+// sources to be fetched ahead of time, i.e. while another build runs.
+// Alternates and the keyring of the profile are used, if one is set.
+func (m *Manager) Fetch(pkg *Package) error {
+	if m.IsCancelled() {
+		return ErrInterrupted
+	}
+	m.lock.Lock()
+	profile := m.profile
+	m.lock.Unlock()
+
+	if err := pkg.CheckValid(); err != nil {
+		return err
+	}
+
+	// Only fetch the sources needed for the target architecture
+	pkg.SelectSources(TargetArch)
+
+	keyring := ""
+	if profile != nil {
+		pkg.AddSourceAlternates(profile.Alternates)
+		keyring = profile.Keyring
+	}
+	if err := pkg.ConfigureSignatures(keyring); err != nil {
+		return err
+	}
+	return pkg.FetchSources(nil)
+}
+
 // Update will attempt to update the base image
 func (m *Manager) Update() error {
 	if m.IsCancelled() {
@@ -399,6 +657,13 @@ func (m *Manager) Update() error {
 		return err
 	}
 
+	// Only update from the local package cache
+	if source.Offline {
+		if err := DropNetworking(); err != nil {
+			return err
+		}
+	}
+
 	return m.image.Update(m, m.pkgManager)
 }
 
@@ -0,0 +1,231 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetricDownloads counts the sources downloaded
+	MetricDownloads = "solbuild_source_downloads_total"
+
+	// MetricBytesFetched counts the bytes of sources downloaded
+	MetricBytesFetched = "solbuild_source_fetched_bytes_total"
+
+	// MetricCacheHits counts the sources already present in the cache
+	MetricCacheHits = "solbuild_source_cache_hits_total"
+
+	// MetricCacheMisses counts the sources that had to be fetched
+	MetricCacheMisses = "solbuild_source_cache_misses_total"
+
+	// MetricBuilds counts completed builds, labelled by result
+	MetricBuilds = "solbuild_builds_total"
+
+	// MetricBuildDuration observes the duration of builds in seconds
+	MetricBuildDuration = "solbuild_build_duration_seconds"
+
+	// MetricUpperLayerBytes observes the bytes builds wrote to the upper layer
+	MetricUpperLayerBytes = "solbuild_upper_layer_bytes"
+)
+
+// Metrics is implemented by anything wishing to receive the metrics of the
+// fetch and build paths, i.e. to export them to a monitoring system.
+type Metrics interface {
+
+	// AddCounter will increase the named counter by value
+	AddCounter(name string, labels map[string]string, value float64)
+
+	// Observe will record a single observation, i.e. a duration
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// noopMetrics discards all metrics, and is used by default
+type noopMetrics struct{}
+
+func (n noopMetrics) AddCounter(name string, labels map[string]string, value float64) {}
+func (n noopMetrics) Observe(name string, labels map[string]string, value float64)    {}
+
+// ActiveMetrics receives all metrics. By default these are discarded.
+var ActiveMetrics Metrics = noopMetrics{}
+
+// SetMetrics will set the implementation used to record metrics, or restore
+// the default no-op implementation if nil.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	ActiveMetrics = m
+}
+
+// A summary tracks the count and sum of observations
+type summary struct {
+	count uint64
+	sum   float64
+}
+
+// A MetricsRegistry is a simple in-memory Metrics implementation, which can
+// be written out in the Prometheus text exposition format.
+type MetricsRegistry struct {
+	lock      *sync.Mutex
+	counters  map[string]float64
+	summaries map[string]*summary
+}
+
+// NewMetricsRegistry will return a new, empty, registry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		lock:      new(sync.Mutex),
+		counters:  make(map[string]float64),
+		summaries: make(map[string]*summary),
+	}
+}
+
+// metricKey will return the series key in the Prometheus format, with the
+// labels in a stable order.
+func metricKey(name string, labels map[string]string) string {
+	if len(labels) < 1 {
+		return name
+	}
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+// AddCounter will increase the named counter by value
+func (r *MetricsRegistry) AddCounter(name string, labels map[string]string, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.counters[metricKey(name, labels)] += value
+}
+
+// Observe will record a single observation
+func (r *MetricsRegistry) Observe(name string, labels map[string]string, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := metricKey(name, labels)
+	s, ok := r.summaries[key]
+	if !ok {
+		s = &summary{}
+		r.summaries[key] = s
+	}
+	s.count++
+	s.sum += value
+}
+
+// GetCounter will return the current value of the counter
+func (r *MetricsRegistry) GetCounter(name string, labels map[string]string) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.counters[metricKey(name, labels)]
+}
+
+// GetObservations will return the number and sum of observations
+func (r *MetricsRegistry) GetObservations(name string, labels map[string]string) (uint64, float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s, ok := r.summaries[metricKey(name, labels)]; ok {
+		return s.count, s.sum
+	}
+	return 0, 0
+}
+
+// WriteTo will write all metrics in the Prometheus text exposition format
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var lines []string
+	for key, value := range r.counters {
+		lines = append(lines, fmt.Sprintf("%s %v", key, value))
+	}
+	for key, s := range r.summaries {
+		name, labels := key, ""
+		if i := strings.Index(key, "{"); i >= 0 {
+			name, labels = key[:i], key[i:]
+		}
+		lines = append(lines, fmt.Sprintf("%s_count%s %d", name, labels, s.count))
+		lines = append(lines, fmt.Sprintf("%s_sum%s %v", name, labels, s.sum))
+	}
+	sort.Strings(lines)
+
+	var written int64
+	for _, line := range lines {
+		n, err := fmt.Fprintln(w, line)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// getMetricLabels will return the common labels for the package build
+func getMetricLabels(p *Package, o *Overlay) map[string]string {
+	labels := map[string]string{
+		"package": p.Name,
+	}
+	if o != nil && o.Back != nil {
+		labels["profile"] = o.Back.Name
+	}
+	return labels
+}
+
+// recordBuildMetrics will record the duration and result of a build
+func recordBuildMetrics(p *Package, o *Overlay, elapsed time.Duration, err error) {
+	labels := getMetricLabels(p, o)
+	ActiveMetrics.Observe(MetricBuildDuration, labels, elapsed.Seconds())
+	if err != nil {
+		labels["result"] = "failure"
+	} else {
+		labels["result"] = "success"
+	}
+	ActiveMetrics.AddCounter(MetricBuilds, labels, 1)
+}
+
+// WriteMetricsFile will atomically write the metrics to the given path,
+// suitable for collection by the node_exporter textfile collector.
+func (r *MetricsRegistry) WriteMetricsFile(path string) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := r.WriteTo(out); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
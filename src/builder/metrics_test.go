@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fetchableSource is a fake source that "downloads" into a local file
+type fetchableSource struct {
+	path    string
+	fetched bool
+}
+
+func (f *fetchableSource) IsFetched() bool { return f.fetched }
+func (f *fetchableSource) Fetch() error {
+	f.fetched = true
+	return ioutil.WriteFile(f.path, []byte("nano"), 00644)
+}
+func (f *fetchableSource) GetIdentifier() string { return f.path }
+func (f *fetchableSource) GetBindConfiguration(rootfs string) source.BindConfiguration {
+	return source.BindConfiguration{BindSource: f.path, BindTarget: filepath.Join(rootfs, "nano.tar.xz")}
+}
+
+func TestMetrics(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-metrics")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	registry := NewMetricsRegistry()
+	SetMetrics(registry)
+	defer SetMetrics(nil)
+
+	pkg := &Package{
+		Name: "nano",
+		Sources: []source.Source{
+			&fetchableSource{path: filepath.Join(tmp, "cached"), fetched: true},
+			&fetchableSource{path: filepath.Join(tmp, "nano.tar.xz")},
+		},
+	}
+	overlay := &Overlay{Back: &BackingImage{Name: "main-x86_64"}}
+	if err := pkg.FetchSources(overlay); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+
+	labels := map[string]string{"package": "nano", "profile": "main-x86_64"}
+	expected := map[string]float64{
+		MetricCacheHits:    1,
+		MetricCacheMisses:  1,
+		MetricDownloads:    1,
+		MetricBytesFetched: 4,
+	}
+	for name, value := range expected {
+		if got := registry.GetCounter(name, labels); got != value {
+			t.Fatalf("Wrong value for %s: %v", name, got)
+		}
+	}
+
+	recordBuildMetrics(pkg, overlay, 90*time.Second, nil)
+	recordBuildMetrics(pkg, overlay, 30*time.Second, errors.New("failed"))
+	if count, sum := registry.GetObservations(MetricBuildDuration, labels); count != 2 || sum != 120 {
+		t.Fatalf("Wrong build durations: %d %v", count, sum)
+	}
+	for _, result := range []string{"success", "failure"} {
+		resultLabels := map[string]string{"package": "nano", "profile": "main-x86_64", "result": result}
+		if got := registry.GetCounter(MetricBuilds, resultLabels); got != 1 {
+			t.Fatalf("Wrong build count for %s: %v", result, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := registry.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	for _, line := range []string{
+		`solbuild_builds_total{package="nano",profile="main-x86_64",result="success"} 1`,
+		`solbuild_build_duration_seconds_count{package="nano",profile="main-x86_64"} 2`,
+		`solbuild_source_downloads_total{package="nano",profile="main-x86_64"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Missing '%s' from exported metrics:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// GetBindConfigurations will return the ordered set of bind mounts that
+// Build will establish for this package within the given overlay.
+//
+// This covers the shared package cache, any local repos enabled by the
+// profile, the package sources and lastly the ccache directory.
+func (p *Package) GetBindConfigurations(o *Overlay, profile *Profile) []source.BindConfiguration {
+	binds := p.getHostBindConfigurations(o, profile)
+	binds = append(binds, p.getSourceBindConfigurations(o)...)
+	return append(binds, p.getCcacheBindConfiguration(o))
+}
+
+// getHostBindConfigurations will return the bind mounts for the package
+// cache, local repos and temporary directory, which are set up before the
+// sources.
+func (p *Package) getHostBindConfigurations(o *Overlay, profile *Profile) []source.BindConfiguration {
+	pman := NewEopkgManager(nil, o.MountPoint)
+	binds := []source.BindConfiguration{
+		{
+			BindSource: pman.cacheSource,
+			BindTarget: pman.cacheTarget,
+		},
+	}
+	if profile != nil {
+		binds = append(binds, p.getRepoBindConfigurations(o, profile)...)
+	}
+	if TempDir != "" {
+		binds = append(binds, p.getTempBindConfiguration(o))
+	}
+	return binds
+}
+
+// getRepoBindConfigurations will return the bind mounts for the local repos
+func (p *Package) getRepoBindConfigurations(o *Overlay, profile *Profile) []source.BindConfiguration {
+	var binds []source.BindConfiguration
+	for _, repo := range getAddRepos(profile) {
+		if !repo.Local {
+			continue
+		}
+		binds = append(binds, source.BindConfiguration{
+			BindSource: repo.URI,
+			BindTarget: o.getLocalRepoTarget(repo),
+		})
+	}
+	return binds
+}
+
+// getCcacheBindConfiguration will return the bind mount for the ccache
+func (p *Package) getCcacheBindConfiguration(o *Overlay) source.BindConfiguration {
+	return source.BindConfiguration{
+		BindSource: p.GetCcacheSource(),
+		BindTarget: p.GetCcacheDir(o),
+	}
+}
+
+// getSourceBindConfigurations will return the bind mounts for all sources
+func (p *Package) getSourceBindConfigurations(o *Overlay) []source.BindConfiguration {
+	var binds []source.BindConfiguration
+	sourceDir := p.GetSourceDir(o)
+	for _, s := range p.Sources {
+		binds = append(binds, s.GetBindConfiguration(sourceDir))
+	}
+	return binds
+}
+
+// shellQuote will quote the argument for safe use within a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// GetMountScript will render the mount sequence used by Build as a shell
+// script, allowing users to reproduce or inspect the build environment
+// outside of solbuild.
+func (p *Package) GetMountScript(o *Overlay, profile *Profile) string {
+	var buf bytes.Buffer
+
+	mount := func(src, tgt, fstype string, options []string) {
+		fmt.Fprintf(&buf, "mount -t %s", fstype)
+		if len(options) > 0 {
+			fmt.Fprintf(&buf, " -o %s", shellQuote(strings.Join(options, ",")))
+		}
+		fmt.Fprintf(&buf, " %s %s\n", shellQuote(src), shellQuote(tgt))
+	}
+	bind := func(b source.BindConfiguration, readOnly bool) {
+		fmt.Fprintf(&buf, "mkdir -p %s\n", shellQuote(b.BindTarget))
+		if readOnly {
+			fmt.Fprintf(&buf, "mount --bind -o ro %s %s\n", shellQuote(b.BindSource), shellQuote(b.BindTarget))
+		} else {
+			fmt.Fprintf(&buf, "mount --bind %s %s\n", shellQuote(b.BindSource), shellQuote(b.BindTarget))
+		}
+	}
+
+	fmt.Fprintf(&buf, "#!/bin/sh\n")
+	fmt.Fprintf(&buf, "# Mount sequence for %s (%s)\n", p.Name, o.Back.Name)
+	fmt.Fprintf(&buf, "set -e\n\n")
+
+	if o.EnableTmpfs {
+		fmt.Fprintf(&buf, "mkdir -p %s\n", shellQuote(o.BaseDir))
+		mount("tmpfs-root", o.BaseDir, "tmpfs", o.getTmpfsOptions())
+	}
+	for _, dir := range []string{o.WorkDir, o.UpperDir, o.ImgDir, o.MountPoint} {
+		fmt.Fprintf(&buf, "mkdir -p %s\n", shellQuote(dir))
+	}
+	mount(o.Back.ImagePath, o.ImgDir, "auto", []string{"ro", "loop"})
+	mount("overlay", o.MountPoint, "overlay", o.getOverlayOptions())
+
+	for _, vfs := range o.getVFSMounts() {
+		fmt.Fprintf(&buf, "mkdir -p %s\n", shellQuote(vfs.target))
+		mount(vfs.source, vfs.target, vfs.fstype, vfs.options)
+	}
+
+	for _, b := range p.getHostBindConfigurations(o, profile) {
+		bind(b, false)
+	}
+	// Sources are always exposed read-only
+	for _, b := range p.getSourceBindConfigurations(o) {
+		bind(b, true)
+	}
+	bind(p.getCcacheBindConfiguration(o), false)
+
+	return buf.String()
+}
@@ -0,0 +1,88 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	mountScriptTestPackage = `
+name: nano
+version: 2.7.5
+release: 61
+source:
+    - https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz : a64d24e6bc4fc448376d038f9a755af77f8e748c9051b6e45bf85e783a7e67e4
+    - https://example.com/extra-1.0.tar.gz : 0000000000000000000000000000000000000000000000000000000000000000
+`
+)
+
+func TestMountScript(t *testing.T) {
+	pkg, err := NewYmlPackageFromBytes([]byte(mountScriptTestPackage))
+	if err != nil {
+		t.Fatalf("Failed to load package: %v", err)
+	}
+	profile, err := NewProfileFromPath(ProfileTestFile)
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+	// Enable all repos so that the local ones are bound
+	profile.AddRepos = []string{"*"}
+	overlay := NewOverlay(profile, NewBackingImage(profile.Image), pkg)
+
+	binds := pkg.GetBindConfigurations(overlay, profile)
+	// package cache, two local repos, two sources, ccache
+	if len(binds) != 6 {
+		t.Fatalf("Invalid number of bind configurations: %d", len(binds))
+	}
+	if binds[0].BindSource != PackageCacheDirectory {
+		t.Fatalf("Package cache should be bound first: %v", binds[0].BindSource)
+	}
+	if binds[1].BindSource != "/var/lib/myrepo" {
+		t.Fatalf("Wrong local repo bind: %v", binds[1].BindSource)
+	}
+	if binds[2].BindSource != "/var/lib/myOtherRepo" {
+		t.Fatalf("Wrong local repo bind: %v", binds[2].BindSource)
+	}
+	if binds[5].BindSource != CcacheDirectory {
+		t.Fatalf("Ccache should be bound last: %v", binds[5].BindSource)
+	}
+
+	script := pkg.GetMountScript(overlay, profile)
+	offset := 0
+	for i, b := range binds {
+		cmd := fmt.Sprintf("mount --bind %s %s", shellQuote(b.BindSource), shellQuote(b.BindTarget))
+		if i == 3 || i == 4 {
+			cmd = fmt.Sprintf("mount --bind -o ro %s %s", shellQuote(b.BindSource), shellQuote(b.BindTarget))
+		}
+		idx := strings.Index(script[offset:], cmd)
+		if idx < 0 {
+			t.Fatalf("Missing or misordered bind in script: %v", cmd)
+		}
+		offset += idx + len(cmd)
+	}
+
+	overlayIdx := strings.Index(script, "mount -t overlay")
+	if overlayIdx < 0 || overlayIdx > strings.Index(script, "mount --bind") {
+		t.Fatal("Overlay must be mounted before any binds")
+	}
+	if !strings.Contains(script, fmt.Sprintf("lowerdir=%s", overlay.ImgDir)) {
+		t.Fatal("Overlay options missing from script")
+	}
+}
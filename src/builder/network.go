@@ -0,0 +1,89 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	"time"
+)
+
+// NetworkDefault is the name of the network configuration applying to all
+// operations.
+const NetworkDefault = "default"
+
+// NetworkConfig tunes the network policy, either for all operations or for
+// a single operation. Unset values keep the default.
+type NetworkConfig struct {
+	Retries         *int `toml:"retries"`          // Attempts to make after the first fails
+	BackoffBase     *int `toml:"backoff_base"`     // Seconds to wait before the first retry
+	ConnectTimeout  *int `toml:"connect_timeout"`  // Seconds to wait for a connection
+	TransferTimeout *int `toml:"transfer_timeout"` // Seconds to allow for a transfer
+	HostConcurrency *int `toml:"host_concurrency"` // Concurrent requests per host
+	RateLimitWait   *int `toml:"rate_limit_wait"`  // Seconds to wait on rate limits in total
+}
+
+// getOverride will convert the configuration into a network policy override
+func (n NetworkConfig) getOverride() (source.NetworkOverride, error) {
+	values := []*int{n.Retries, n.BackoffBase, n.ConnectTimeout, n.TransferTimeout, n.HostConcurrency, n.RateLimitWait}
+	for _, v := range values {
+		if v != nil && *v < 0 {
+			return source.NetworkOverride{}, fmt.Errorf("Network settings cannot be negative: %d", *v)
+		}
+	}
+	return source.NetworkOverride{
+		Retries:         n.Retries,
+		BackoffBase:     getSeconds(n.BackoffBase),
+		ConnectTimeout:  getSeconds(n.ConnectTimeout),
+		TransferTimeout: getSeconds(n.TransferTimeout),
+		HostConcurrency: n.HostConcurrency,
+		RateLimitWait:   getSeconds(n.RateLimitWait),
+	}, nil
+}
+
+// getSeconds will convert an optional number of seconds into a duration
+func getSeconds(seconds *int) *time.Duration {
+	if seconds == nil {
+		return nil
+	}
+	d := time.Duration(*seconds) * time.Second
+	return &d
+}
+
+// SetNetworkPolicy will apply the network configuration, keyed by the
+// operation name or NetworkDefault.
+func SetNetworkPolicy(config map[string]NetworkConfig) error {
+	defaults := source.NetworkDefaults
+	overrides := make(map[string]source.NetworkOverride)
+	for op, conf := range config {
+		if op != NetworkDefault && !source.IsValidNetworkOperation(op) {
+			return fmt.Errorf("Unknown network operation: %s", op)
+		}
+		override, err := conf.getOverride()
+		if err != nil {
+			return err
+		}
+		if op == NetworkDefault {
+			defaults = defaults.Merge(override)
+		} else {
+			overrides[op] = override
+		}
+	}
+	source.NetworkDefaults = defaults
+	source.NetworkOverrides = overrides
+	return nil
+}
@@ -0,0 +1,56 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"testing"
+	"time"
+)
+
+func TestSetNetworkPolicy(t *testing.T) {
+	oldDefaults, oldOverrides := source.NetworkDefaults, source.NetworkOverrides
+	defer func() {
+		source.NetworkDefaults, source.NetworkOverrides = oldDefaults, oldOverrides
+	}()
+
+	connect, transfer, noRetries, negative := 30, 3600, 0, -1
+	err := SetNetworkPolicy(map[string]NetworkConfig{
+		NetworkDefault:         {ConnectTimeout: &connect},
+		source.NetworkDownload: {TransferTimeout: &transfer},
+		source.NetworkImage:    {Retries: &noRetries},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set network policy: %v", err)
+	}
+	download := source.GetNetworkPolicy(source.NetworkDownload)
+	if download.ConnectTimeout != 30*time.Second || download.TransferTimeout != time.Hour {
+		t.Fatalf("Network configuration was not applied: %+v", download)
+	}
+	if download.Retries != oldDefaults.Retries {
+		t.Fatalf("Unset values should keep the default: %d", download.Retries)
+	}
+	if image := source.GetNetworkPolicy(source.NetworkImage); image.Retries != 0 {
+		t.Fatalf("Retries should be able to be disabled: %d", image.Retries)
+	}
+	if err := SetNetworkPolicy(map[string]NetworkConfig{"upload": {}}); err == nil {
+		t.Fatalf("Should not accept an unknown operation")
+	}
+	if err := SetNetworkPolicy(map[string]NetworkConfig{NetworkDefault: {Retries: &negative}}); err == nil {
+		t.Fatalf("Should not accept negative values")
+	}
+}
@@ -0,0 +1,37 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"strings"
+	"testing"
+)
+
+func TestOfflineRemoteRepos(t *testing.T) {
+	source.Offline = true
+	defer func() {
+		source.Offline = false
+	}()
+
+	pkg := &Package{Name: "nano"}
+	repos := []*Repo{{Name: "Solus", URI: "https://packages.solus-project.com/unstable/eopkg-index.xml.xz"}}
+	err := pkg.addRepos(nil, &Overlay{}, nil, repos)
+	if err == nil || !strings.Contains(err.Error(), "Solus") {
+		t.Fatalf("Remote repositories should be refused offline, got: %v", err)
+	}
+}
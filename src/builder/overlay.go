@@ -23,13 +23,12 @@ import (
 	"github.com/solus-project/libosdev/disk"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-const (
-	// OverlayRootDir is the root in which we form all solbuild cache paths,
-	// these are the temp build roots that we happily throw away.
-	OverlayRootDir = "/var/cache/solbuild"
-)
+// OverlayRootDir is the root in which we form all solbuild cache paths,
+// these are the temp build roots that we happily throw away.
+var OverlayRootDir = "/var/cache/solbuild"
 
 // An Overlay is formed from a backing image & Package combination.
 // Using this Overlay we can bring up new temporary build roots using the
@@ -41,7 +40,7 @@ type Overlay struct {
 	BaseDir    string // BaseDir is the base directory containing the root
 	WorkDir    string // WorkDir is the overlayfs workdir lock
 	UpperDir   string // UpperDir is where real inode changes happen (tmp)
-	ImgDir     string // Where the profile is mounted (ro)
+	ImgDir     string // Where the profile is mounted (ro), shared between overlays
 	MountPoint string // The actual mount point for the union'd directories
 	LockPath   string // Path to the lockfile for this overlay
 
@@ -50,10 +49,19 @@ type Overlay struct {
 
 	ExtraMounts []string // Any extra mounts to take care of when cleaning up
 
+	Layers    []string         // Configured read-only layers above the image
+	LowerDirs []string         // Additional read-only layers above the image
+	Layer     *DependencyLayer // Cached dependency layer, if any
+	Warm      *WarmSnapshot    // Warm snapshot to restore, if any
+
+	Features *HostFeatures // Kernel and overlayfs features, when recorded
+
 	mountedImg     bool // Whether we mounted the image or not
 	mountedOverlay bool // Whether we mounted the overlay or not
 	mountedVFS     bool // Whether we mounted vfs or not
 	mountedTmpfs   bool // Whether we mounted tmpfs or not
+	noExtraOptions bool // Whether the kernel rejected OverlayOptions
+	restoredWarm   bool // Whether we restored the warm snapshot
 }
 
 // NewOverlay creates a new Overlay for us in builds, etc.
@@ -71,9 +79,10 @@ func NewOverlay(profile *Profile, back *BackingImage, pkg *Package) *Overlay {
 		BaseDir:        basedir,
 		WorkDir:        filepath.Join(basedir, "work"),
 		UpperDir:       filepath.Join(basedir, "tmp"),
-		ImgDir:         filepath.Join(basedir, "img"),
+		ImgDir:         getBaseMountPoint(back),
 		MountPoint:     filepath.Join(basedir, "union"),
 		LockPath:       fmt.Sprintf("%s.lock", basedir),
+		Layers:         append([]string(nil), LowerLayers...),
 		mountedImg:     false,
 		mountedOverlay: false,
 		mountedVFS:     false,
@@ -152,15 +161,7 @@ func (o *Overlay) Mount() error {
 			"size":  o.TmpfsSize,
 		}).Debug("Mounting root tmpfs")
 
-		var tmpfsOptions []string
-		if o.TmpfsSize != "" {
-			tmpfsOptions = append(tmpfsOptions, fmt.Sprintf("size=%s", o.TmpfsSize))
-		}
-		tmpfsOptions = append(tmpfsOptions, []string{
-			"rw",
-			"relatime",
-		}...)
-		if err := mountMan.Mount("tmpfs-root", o.BaseDir, "tmpfs", tmpfsOptions...); err != nil {
+		if err := mountMan.Mount("tmpfs-root", o.BaseDir, "tmpfs", o.getTmpfsOptions()...); err != nil {
 			log.WithFields(log.Fields{
 				"point": o.BaseDir,
 				"size":  o.TmpfsSize,
@@ -174,11 +175,23 @@ func (o *Overlay) Mount() error {
 		return err
 	}
 
-	// First up, mount the backing image
+	// Roll back to the prepared state from a previous build
+	if o.Warm != nil && o.Warm.IsWarm() {
+		if err := o.Warm.Restore(o); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to restore warm overlay")
+			return err
+		}
+		o.restoredWarm = true
+	}
+
+	// First up, mount the backing image. This is shared read-only with any
+	// other overlays using the same image.
 	log.WithFields(log.Fields{
 		"point": o.Back.ImagePath,
 	}).Debug("Mounting backing image")
-	if err := mountMan.Mount(o.Back.ImagePath, o.ImgDir, "auto", "ro", "loop"); err != nil {
+	if err := sharedBases.Acquire(o.Back); err != nil {
 		log.WithFields(log.Fields{
 			"point": o.Back.ImagePath,
 			"error": err,
@@ -190,16 +203,23 @@ func (o *Overlay) Mount() error {
 	// Now mount the overlayfs
 	log.WithFields(log.Fields{
 		"upper":   o.UpperDir,
-		"lower":   o.ImgDir,
+		"lower":   strings.Join(o.getLowerDirs(), ":"),
 		"workdir": o.WorkDir,
 		"target":  o.MountPoint,
 	}).Debug("Mounting overlayfs")
 
 	// Mounting overlayfs..
-	err := mountMan.Mount("overlay", o.MountPoint, "overlay",
-		fmt.Sprintf("lowerdir=%s", o.ImgDir),
-		fmt.Sprintf("upperdir=%s", o.UpperDir),
-		fmt.Sprintf("workdir=%s", o.WorkDir))
+	err := mountMan.Mount("overlay", o.MountPoint, "overlay", o.getOverlayOptions()...)
+
+	// Older kernels may not support the extra options, so try without
+	if err != nil && len(OverlayOptions) > 0 {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"options": strings.Join(OverlayOptions, ","),
+		}).Warning("Kernel rejected overlayfs options, retrying without them")
+		o.noExtraOptions = true
+		err = mountMan.Mount("overlay", o.MountPoint, "overlay", o.getOverlayOptions()...)
+	}
 
 	// Check non-fatal..
 	if err != nil {
@@ -210,6 +230,7 @@ func (o *Overlay) Mount() error {
 		return err
 	}
 	o.mountedOverlay = true
+	o.captureHostFeatures()
 
 	// Must be done here before we do any more overlayfs work
 	if err := EnsureEopkgLayout(o.MountPoint); err != nil {
@@ -219,6 +240,12 @@ func (o *Overlay) Mount() error {
 	return nil
 }
 
+// IsWarm will determine whether the overlay was restored from a warm
+// snapshot, and is therefore already prepared.
+func (o *Overlay) IsWarm() bool {
+	return o.restoredWarm
+}
+
 // Unmount will tear down the overlay mount again
 func (o *Overlay) Unmount() error {
 	mountMan := disk.GetMountManager()
@@ -242,18 +269,19 @@ func (o *Overlay) Unmount() error {
 		o.mountedVFS = false
 	}
 
-	if o.mountedImg {
-		if err := mountMan.Unmount(o.ImgDir); err != nil {
-			return err
-		}
-		o.mountedImg = false
-	}
 	if o.mountedOverlay {
 		if err := mountMan.Unmount(o.MountPoint); err != nil {
 			return err
 		}
 		o.mountedOverlay = false
 	}
+	// Only drop our reference, other overlays may still use the image
+	if o.mountedImg {
+		if err := sharedBases.Release(o.Back); err != nil {
+			return err
+		}
+		o.mountedImg = false
+	}
 	if o.mountedTmpfs {
 		if err := mountMan.Unmount(o.UpperDir); err != nil {
 			return err
@@ -263,28 +291,67 @@ func (o *Overlay) Unmount() error {
 	return nil
 }
 
+// A vfsMount is a virtual filesystem that we mount inside the chroot
+type vfsMount struct {
+	source  string   // Source as passed to mount
+	target  string   // Target within the overlay
+	fstype  string   // Filesystem type
+	options []string // Mount options
+}
+
+// getVFSMounts will return the virtual filesystems required by the chroot,
+// in the order in which they must be mounted.
+func (o *Overlay) getVFSMounts() []vfsMount {
+	return []vfsMount{
+		{"devtmpfs", filepath.Join(o.MountPoint, "dev"), "devtmpfs", []string{"nosuid", "mode=755"}},
+		{"devpts", filepath.Join(o.MountPoint, "dev/pts"), "devpts", []string{"gid=5", "mode=620", "nosuid", "noexec"}},
+		{"proc", filepath.Join(o.MountPoint, "proc"), "proc", []string{"nosuid", "noexec"}},
+		{"sysfs", filepath.Join(o.MountPoint, "sys"), "sysfs", nil},
+		{"tmpfs-shm", filepath.Join(o.MountPoint, "dev/shm"), "tmpfs", nil},
+	}
+}
+
+// getTmpfsOptions will return the mount options for the root tmpfs
+func (o *Overlay) getTmpfsOptions() []string {
+	var tmpfsOptions []string
+	if o.TmpfsSize != "" {
+		tmpfsOptions = append(tmpfsOptions, fmt.Sprintf("size=%s", o.TmpfsSize))
+	}
+	return append(tmpfsOptions, []string{
+		"rw",
+		"relatime",
+	}...)
+}
+
+// getOverlayOptions will return the mount options for the overlayfs itself
+func (o *Overlay) getOverlayOptions() []string {
+	options := []string{
+		fmt.Sprintf("lowerdir=%s", strings.Join(o.getLowerDirs(), ":")),
+		fmt.Sprintf("upperdir=%s", o.UpperDir),
+		fmt.Sprintf("workdir=%s", o.WorkDir),
+	}
+	if !o.noExtraOptions {
+		options = append(options, OverlayOptions...)
+	}
+	return options
+}
+
 // MountVFS will bring up virtual filesystems within the chroot
 func (o *Overlay) MountVFS() error {
 	mountMan := disk.GetMountManager()
 
-	vfsPoints := []string{
-		filepath.Join(o.MountPoint, "dev"),
-		filepath.Join(o.MountPoint, "dev/pts"),
-		filepath.Join(o.MountPoint, "proc"),
-		filepath.Join(o.MountPoint, "sys"),
-		filepath.Join(o.MountPoint, "dev/shm"),
-	}
+	vfsMounts := o.getVFSMounts()
 
-	for _, p := range vfsPoints {
-		if PathExists(p) {
+	for _, vfs := range vfsMounts {
+		if PathExists(vfs.target) {
 			continue
 		}
 
 		log.WithFields(log.Fields{
-			"dir": p,
+			"dir": vfs.target,
 		}).Debug("Creating VFS directory")
 
-		if err := os.MkdirAll(p, 00755); err != nil {
+		if err := os.MkdirAll(vfs.target, 00755); err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
 			}).Error("Failed to create VFS directory")
@@ -292,60 +359,19 @@ func (o *Overlay) MountVFS() error {
 		}
 	}
 
-	// Bring up dev
-	log.WithFields(log.Fields{
-		"vfs": "/dev",
-	}).Debug("Mounting vfs")
-	if err := mountMan.Mount("devtmpfs", vfsPoints[0], "devtmpfs", "nosuid", "mode=755"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to mount /dev")
-		return err
-	}
-	o.mountedVFS = true
-
-	// Bring up dev/pts
-	log.WithFields(log.Fields{
-		"vfs": "/dev/pts",
-	}).Debug("Mounting vfs")
-	if err := mountMan.Mount("devpts", vfsPoints[1], "devpts", "gid=5", "mode=620", "nosuid", "noexec"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to mount /dev/pts")
-		return err
-	}
-
-	// Bring up proc
-	log.WithFields(log.Fields{
-		"vfs": "/proc",
-	}).Debug("Mounting vfs")
-	if err := mountMan.Mount("proc", vfsPoints[2], "proc", "nosuid", "noexec"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to mount /proc")
-		return err
-	}
-
-	// Bring up sys
-	log.WithFields(log.Fields{
-		"vfs": "/sys",
-	}).Debug("Mounting vfs")
-	if err := mountMan.Mount("sysfs", vfsPoints[3], "sysfs"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to mount /sys")
-		return err
-	}
-
-	// Bring up shm
-	log.WithFields(log.Fields{
-		"vfs": "/dev/shm",
-	}).Debug("Mounting vfs")
-	if err := mountMan.Mount("tmpfs-shm", vfsPoints[4], "tmpfs"); err != nil {
+	for _, vfs := range vfsMounts {
+		vfsPath := "/" + vfs.target[len(o.MountPoint)+1:]
 		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to mount /sys")
-		return err
+			"vfs": vfsPath,
+		}).Debug("Mounting vfs")
+		if err := mountMan.Mount(vfs.source, vfs.target, vfs.fstype, vfs.options...); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"vfs":   vfsPath,
+			}).Error("Failed to mount vfs")
+			return err
+		}
+		o.mountedVFS = true
 	}
 	return nil
 }
@@ -0,0 +1,64 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// OverlayOptions are additional mount options passed to overlayfs, such
+	// as "volatile" or "metacopy=on", to tune performance.
+	OverlayOptions []string
+
+	// overlayOptionNames are the overlayfs options that may be configured.
+	// The layer directories are always managed by solbuild itself.
+	overlayOptionNames = map[string]bool{
+		"index":        true,
+		"metacopy":     true,
+		"nfs_export":   true,
+		"redirect_dir": true,
+		"userxattr":    true,
+		"volatile":     true,
+		"xino":         true,
+	}
+)
+
+// ValidateOverlayOptions will ensure all of the given overlayfs options
+// are known, and don't interfere with the layers set up by solbuild.
+func ValidateOverlayOptions(options []string) error {
+	for _, opt := range options {
+		name := strings.SplitN(strings.TrimSpace(opt), "=", 2)[0]
+		if !overlayOptionNames[name] {
+			return fmt.Errorf("Unsupported overlayfs option: '%s'", opt)
+		}
+	}
+	return nil
+}
+
+// SetOverlayOptions will validate and set the extra overlayfs options
+func SetOverlayOptions(options []string) error {
+	if err := ValidateOverlayOptions(options); err != nil {
+		return err
+	}
+	OverlayOptions = nil
+	for _, opt := range options {
+		OverlayOptions = append(OverlayOptions, strings.TrimSpace(opt))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverlayOptions(t *testing.T) {
+	defer func() {
+		OverlayOptions = nil
+	}()
+	if err := SetOverlayOptions([]string{"volatile", " metacopy=on"}); err != nil {
+		t.Fatalf("Failed to set valid options: %v", err)
+	}
+
+	overlay := &Overlay{ImgDir: "/img", UpperDir: "/upper", WorkDir: "/work"}
+	options := strings.Join(overlay.getOverlayOptions(), ",")
+	if options != "lowerdir=/img,upperdir=/upper,workdir=/work,volatile,metacopy=on" {
+		t.Fatalf("Configured options missing from mount: %v", options)
+	}
+
+	// Falling back when the kernel rejects them
+	overlay.noExtraOptions = true
+	if options := strings.Join(overlay.getOverlayOptions(), ","); strings.Contains(options, "volatile") {
+		t.Fatalf("Options should be dropped on fallback: %v", options)
+	}
+
+	for _, opt := range []string{"lowerdir=/", "upperdir=/tmp", "bogus"} {
+		err := SetOverlayOptions([]string{opt})
+		if err == nil || !strings.Contains(err.Error(), opt) {
+			t.Fatalf("Option '%s' should be rejected clearly: %v", opt, err)
+		}
+	}
+	if len(OverlayOptions) != 2 {
+		t.Fatalf("Invalid options should not replace the existing ones")
+	}
+}
@@ -0,0 +1,207 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// PatchesDir is the directory alongside the build file holding patches
+	PatchesDir = "patches"
+
+	// PatchSeriesFile lists the patches within PatchesDir in the order they
+	// must be applied, along with any conditions.
+	PatchSeriesFile = "series"
+)
+
+// ErrNoPatchTarget is returned when patches apply but no source tree exists
+var ErrNoPatchTarget = errors.New("No unpacked source tree to apply patches to")
+
+// A Patch is a single entry in the patch series. Patches without conditions
+// always apply, otherwise the active profile and architecture must match.
+type Patch struct {
+	Name     string   // File name within PatchesDir
+	Profiles []string // Profiles the patch is restricted to
+	Arches   []string // Architectures the patch is restricted to
+}
+
+// A PatchError is returned when a patch does not apply cleanly
+type PatchError struct {
+	Patch string // Name of the patch
+	Err   error  // Why the patch does not apply
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("Patch %s does not apply: %v", e.Patch, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// Matches will determine whether the patch applies to the profile and arch
+func (p *Patch) Matches(profile, arch string) bool {
+	return matchesCondition(p.Profiles, profile) && matchesCondition(p.Arches, arch)
+}
+
+// matchesCondition returns true for an empty condition, or one naming value
+func matchesCondition(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePatchSeries will load the patch series from the given path. Each
+// line names a patch, optionally followed by profile= and arch= conditions
+// taking a comma separated list, i.e.:
+//
+//	fix-tests.patch
+//	unstable-only.patch profile=unstable-x86_64
+//	arm-fix.patch arch=aarch64
+func ParsePatchSeries(path string) ([]Patch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patches []Patch
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		patch := Patch{Name: fields[0]}
+		if patch.Name != filepath.Base(patch.Name) || patch.Name == ".." {
+			return nil, fmt.Errorf("%s:%d: Invalid patch name '%s'", path, line, patch.Name)
+		}
+		for _, cond := range fields[1:] {
+			kv := strings.SplitN(cond, "=", 2)
+			if len(kv) != 2 || kv[1] == "" {
+				return nil, fmt.Errorf("%s:%d: Invalid condition '%s'", path, line, cond)
+			}
+			values := strings.Split(kv[1], ",")
+			switch kv[0] {
+			case "profile":
+				patch.Profiles = append(patch.Profiles, values...)
+			case "arch":
+				patch.Arches = append(patch.Arches, values...)
+			default:
+				return nil, fmt.Errorf("%s:%d: Unknown condition '%s'", path, line, kv[0])
+			}
+		}
+		patches = append(patches, patch)
+	}
+	return patches, sc.Err()
+}
+
+// GetPatchesDir will return the host side directory holding the patches
+func (p *Package) GetPatchesDir() string {
+	return filepath.Join(filepath.Dir(p.Path), PatchesDir)
+}
+
+// SelectPatches will find the patches in the series applicable to the
+// profile and architecture, keeping the order of the series. Packages
+// without a series have no patches.
+func (p *Package) SelectPatches(profile, arch string) error {
+	p.Patches = nil
+	series, err := ParsePatchSeries(filepath.Join(p.GetPatchesDir(), PatchSeriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, patch := range series {
+		if !patch.Matches(profile, arch) {
+			log.WithFields(log.Fields{
+				"patch":   patch.Name,
+				"profile": profile,
+				"arch":    arch,
+			}).Debug("Skipping patch for other targets")
+			continue
+		}
+		p.Patches = append(p.Patches, patch)
+	}
+	return nil
+}
+
+// getPatchTarget will find the first source tree staged by StageSources,
+// being the extracted primary archive or a checkout, which the patches are
+// applied to.
+func (p *Package) getPatchTarget() (string, error) {
+	if len(p.SourceTrees) == 0 {
+		return "", ErrNoPatchTarget
+	}
+	return p.SourceTrees[0], nil
+}
+
+// ApplyPatches will apply each selected patch, in order, to the staged
+// source tree with the leading path component stripped, as patch -p1 does.
+// The patches applied are recorded in AppliedPatches.
+func (p *Package) ApplyPatches(o *Overlay) error {
+	p.AppliedPatches = nil
+	if len(p.Patches) == 0 {
+		return nil
+	}
+	target, err := p.getPatchTarget()
+	if err != nil {
+		return err
+	}
+	for _, patch := range p.Patches {
+		if err := applyPatchFile(target, filepath.Join(p.GetPatchesDir(), patch.Name)); err != nil {
+			log.WithFields(log.Fields{
+				"patch": patch.Name,
+				"error": err,
+			}).Error("Failed to apply patch")
+			return &PatchError{Patch: patch.Name, Err: err}
+		}
+		log.WithFields(log.Fields{
+			"patch":  patch.Name,
+			"target": target,
+		}).Info("Applied patch")
+		p.AppliedPatches = append(p.AppliedPatches, patch.Name)
+	}
+	return nil
+}
+
+// ReportPatches will log the patches applied to the prepared source tree,
+// in order, for the build report.
+func (p *Package) ReportPatches() {
+	if len(p.AppliedPatches) == 0 {
+		return
+	}
+	log.WithFields(log.Fields{
+		"patches": strings.Join(p.AppliedPatches, ", "),
+		"target":  p.SourceTrees[0],
+	}).Info("Applied patches")
+}
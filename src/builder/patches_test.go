@@ -0,0 +1,237 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	testPatchFix = `--- a/README
++++ b/README
+@@ -1 +1 @@
+-nano
++nano 2.7.5
+`
+	testPatchUnstable = `--- a/README
++++ b/README
+@@ -1 +1,2 @@
+ nano 2.7.5
++unstable
+`
+	testPatchArm = `--- a/README
++++ b/README
+@@ -1 +1 @@
+-vim
++vim on arm
+`
+)
+
+// treeSource is an unpacked source tree, such as a git checkout
+type treeSource struct {
+	path string
+}
+
+func (s *treeSource) IsFetched() bool       { return true }
+func (s *treeSource) Fetch() error          { return nil }
+func (s *treeSource) GetIdentifier() string { return s.path }
+func (s *treeSource) GetBindConfiguration(rootfs string) source.BindConfiguration {
+	return source.BindConfiguration{BindSource: s.path, BindTarget: filepath.Join(rootfs, "nano")}
+}
+
+func TestParsePatchSeries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-patches")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	series := filepath.Join(tmp, PatchSeriesFile)
+	contents := "# Applied in order\nfix.patch\n\nunstable.patch profile=unstable-x86_64,unstable-aarch64\narm.patch arch=aarch64 profile=main-aarch64\n"
+	if err := ioutil.WriteFile(series, []byte(contents), 00644); err != nil {
+		t.Fatalf("Failed to write series: %v", err)
+	}
+	patches, err := ParsePatchSeries(series)
+	if err != nil {
+		t.Fatalf("Failed to parse series: %v", err)
+	}
+	expected := []Patch{
+		{Name: "fix.patch"},
+		{Name: "unstable.patch", Profiles: []string{"unstable-x86_64", "unstable-aarch64"}},
+		{Name: "arm.patch", Profiles: []string{"main-aarch64"}, Arches: []string{"aarch64"}},
+	}
+	if !reflect.DeepEqual(patches, expected) {
+		t.Fatalf("Wrong patch series: %+v", patches)
+	}
+
+	for _, line := range []string{"../escape.patch", "fix.patch profile", "fix.patch branch=unstable", "fix.patch arch="} {
+		if err := ioutil.WriteFile(series, []byte(line+"\n"), 00644); err != nil {
+			t.Fatalf("Failed to write series: %v", err)
+		}
+		if _, err := ParsePatchSeries(series); err == nil {
+			t.Fatalf("Accepted invalid series line: '%s'", line)
+		}
+	}
+}
+
+func TestConditionalPatches(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-patches")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	recipe := filepath.Join(tmp, "recipe")
+	files := map[string]string{
+		PatchSeriesFile:  "fix.patch\nunstable.patch profile=unstable-x86_64\narm.patch arch=aarch64\n",
+		"fix.patch":      testPatchFix,
+		"unstable.patch": testPatchUnstable,
+		"arm.patch":      testPatchArm,
+	}
+	if err := os.MkdirAll(filepath.Join(recipe, PatchesDir), 00755); err != nil {
+		t.Fatalf("Failed to create patches directory: %v", err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(recipe, PatchesDir, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	tree := filepath.Join(tmp, "cache", "nano")
+	if err := os.MkdirAll(tree, 00755); err != nil {
+		t.Fatalf("Failed to create source tree: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tree, "README"), []byte("nano\n"), 00644); err != nil {
+		t.Fatalf("Failed to write source tree: %v", err)
+	}
+
+	tests := []struct {
+		profile string
+		arch    string
+		applied []string
+		readme  string
+	}{
+		{"main-x86_64", "x86_64", []string{"fix.patch"}, "nano 2.7.5\n"},
+		{"unstable-x86_64", "x86_64", []string{"fix.patch", "unstable.patch"}, "nano 2.7.5\nunstable\n"},
+	}
+	for i, test := range tests {
+		pkg := &Package{
+			Name:    "nano",
+			Type:    PackageTypeYpkg,
+			Path:    filepath.Join(recipe, "package.yml"),
+			Sources: []source.Source{&treeSource{path: tree}},
+		}
+		overlay := &Overlay{MountPoint: filepath.Join(tmp, "union", test.profile)}
+		if err := pkg.SelectPatches(test.profile, test.arch); err != nil {
+			t.Fatalf("Failed to select patches: %v", err)
+		}
+		if err := pkg.StageSources(overlay); err != nil {
+			t.Fatalf("Failed to stage sources: %v", err)
+		}
+		if err := pkg.ApplyPatches(overlay); err != nil {
+			t.Fatalf("Failed to apply patches for %s: %v", test.profile, err)
+		}
+		if !reflect.DeepEqual(pkg.AppliedPatches, test.applied) {
+			t.Fatalf("Wrong patches applied for test %d: %v", i, pkg.AppliedPatches)
+		}
+		staged := filepath.Join(pkg.GetSourceDir(overlay), "nano", "README")
+		if contents, _ := ioutil.ReadFile(staged); string(contents) != test.readme {
+			t.Fatalf("Wrong patched contents for test %d: %s", i, contents)
+		}
+	}
+	// The cached tree must never be patched
+	if contents, _ := ioutil.ReadFile(filepath.Join(tree, "README")); string(contents) != "nano\n" {
+		t.Fatalf("Patches were applied to the cache: %s", contents)
+	}
+
+	// The arm patch does not apply to this tree
+	pkg := &Package{
+		Name:    "nano",
+		Type:    PackageTypeYpkg,
+		Path:    filepath.Join(recipe, "package.yml"),
+		Sources: []source.Source{&treeSource{path: tree}},
+	}
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union", "aarch64")}
+	if err := pkg.SelectPatches("main-aarch64", "aarch64"); err != nil {
+		t.Fatalf("Failed to select patches: %v", err)
+	}
+	if err := pkg.StageSources(overlay); err != nil {
+		t.Fatalf("Failed to stage sources: %v", err)
+	}
+	err = pkg.ApplyPatches(overlay)
+	if patchErr, ok := err.(*PatchError); !ok || patchErr.Patch != "arm.patch" {
+		t.Fatalf("Expected arm.patch to fail, got: %v", err)
+	}
+	if !reflect.DeepEqual(pkg.AppliedPatches, []string{"fix.patch"}) {
+		t.Fatalf("Wrong patches applied before failure: %v", pkg.AppliedPatches)
+	}
+}
+
+func TestPatchTarball(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-patches")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	recipe := filepath.Join(tmp, "recipe")
+	writeTestTree(t, filepath.Join(recipe, PatchesDir), map[string]string{
+		PatchSeriesFile: "fix.patch\n",
+		"fix.patch":     testPatchFix,
+	})
+	tarball := &archiveSource{path: filepath.Join(tmp, "nano-2.7.5.tar.gz")}
+	writeTestTarball(t, tarball.path, map[string]string{"nano-2.7.5/README": "nano\n"})
+
+	// Packages built from a tarball are patched in the extracted tree
+	pkg := &Package{
+		Name:    "nano",
+		Type:    PackageTypeYpkg,
+		Path:    filepath.Join(recipe, "package.yml"),
+		Sources: []source.Source{tarball},
+	}
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union")}
+	if err := pkg.SelectPatches("main-x86_64", "x86_64"); err != nil {
+		t.Fatalf("Failed to select patches: %v", err)
+	}
+	if err := pkg.StageSources(overlay); err != nil {
+		t.Fatalf("Failed to stage sources: %v", err)
+	}
+	if err := pkg.ApplyPatches(overlay); err != nil {
+		t.Fatalf("Failed to apply patches: %v", err)
+	}
+	readme := filepath.Join(pkg.GetSourceTreeDir(overlay), "nano-2.7.5", "README")
+	if contents, _ := ioutil.ReadFile(readme); string(contents) != "nano 2.7.5\n" {
+		t.Fatalf("Extracted tree was not patched: %q", contents)
+	}
+	if !reflect.DeepEqual(pkg.AppliedPatches, []string{"fix.patch"}) {
+		t.Fatalf("Wrong patches applied: %v", pkg.AppliedPatches)
+	}
+}
+
+func TestNoPatchSeries(t *testing.T) {
+	pkg := &Package{Path: "testdata/package.yml"}
+	if err := pkg.SelectPatches("main-x86_64", "x86_64"); err != nil || len(pkg.Patches) != 0 {
+		t.Fatalf("Packages without a series should have no patches: %v", err)
+	}
+	if err := pkg.ApplyPatches(&Overlay{}); err != nil {
+		t.Fatalf("Applying no patches should not fail: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SourceDirEnvironment may be set to relocate the source cache
+	SourceDirEnvironment = "SOLBUILD_SOURCE_DIR"
+
+	// StagingDirEnvironment may be set to relocate the download staging area
+	StagingDirEnvironment = "SOLBUILD_STAGING_DIR"
+
+	// OverlayDirEnvironment may be set to relocate the build roots
+	OverlayDirEnvironment = "SOLBUILD_OVERLAY_DIR"
+)
+
+// SetOverlayRootDir will relocate the build roots, along with the shared
+// image mounts and dependency layers within it.
+func SetOverlayRootDir(dir string) {
+	OverlayRootDir = dir
+	BaseImageMountDir = filepath.Join(dir, "base")
+	DependencyLayerDir = filepath.Join(dir, "layers")
+}
+
+// validateCachePath will ensure the path is usable as a cache directory
+func validateCachePath(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("Cache path must be absolute: %v", dir)
+	}
+	if st, err := os.Stat(dir); err == nil && !st.IsDir() {
+		return fmt.Errorf("Cache path is not a directory: %v", dir)
+	}
+	return nil
+}
+
+// getCachePath will return the configured path, falling back to the
+// environment. An empty path means the default should be used.
+func getCachePath(configured, env string) (string, error) {
+	dir := strings.TrimSpace(configured)
+	if dir == "" {
+		dir = strings.TrimSpace(os.Getenv(env))
+	}
+	if dir == "" {
+		return "", nil
+	}
+	dir = filepath.Clean(dir)
+	return dir, validateCachePath(dir)
+}
+
+// SetCachePaths will relocate the source cache and build roots. Paths set
+// in the configuration take precedence over the environment, and the
+// defaults are used when neither is set.
+func SetCachePaths(config *Config) error {
+	sourceDir, err := getCachePath(config.SourceDir, SourceDirEnvironment)
+	if err != nil {
+		return err
+	}
+	if sourceDir != "" {
+		source.SetSourceDir(sourceDir)
+	}
+
+	stagingDir, err := getCachePath("", StagingDirEnvironment)
+	if err != nil {
+		return err
+	}
+	if stagingDir != "" {
+		source.SourceStagingDir = stagingDir
+	}
+
+	overlayDir, err := getCachePath(config.OverlayDir, OverlayDirEnvironment)
+	if err != nil {
+		return err
+	}
+	if overlayDir != "" {
+		SetOverlayRootDir(overlayDir)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"os"
+	"testing"
+)
+
+func TestSetCachePaths(t *testing.T) {
+	oldSource, oldStaging, oldGit, oldHg := source.SourceDir, source.SourceStagingDir, source.GitSourceDir, source.HgSourceDir
+	oldOverlay, oldBase, oldLayers := OverlayRootDir, BaseImageMountDir, DependencyLayerDir
+	defer func() {
+		source.SourceDir, source.SourceStagingDir, source.GitSourceDir, source.HgSourceDir = oldSource, oldStaging, oldGit, oldHg
+		OverlayRootDir, BaseImageMountDir, DependencyLayerDir = oldOverlay, oldBase, oldLayers
+		os.Unsetenv(SourceDirEnvironment)
+		os.Unsetenv(StagingDirEnvironment)
+		os.Unsetenv(OverlayDirEnvironment)
+	}()
+
+	// Nothing set keeps the defaults
+	if err := SetCachePaths(&Config{}); err != nil {
+		t.Fatalf("Failed to set cache paths: %v", err)
+	}
+	if source.SourceDir != oldSource || OverlayRootDir != oldOverlay {
+		t.Fatalf("Defaults should be used without configuration")
+	}
+
+	// Environment overrides the defaults
+	os.Setenv(SourceDirEnvironment, "/ci/sources")
+	os.Setenv(StagingDirEnvironment, "/ci/staging")
+	os.Setenv(OverlayDirEnvironment, "/ci/overlay")
+	if err := SetCachePaths(&Config{}); err != nil {
+		t.Fatalf("Failed to set cache paths: %v", err)
+	}
+	if source.SourceDir != "/ci/sources" || source.GitSourceDir != "/ci/sources/git" || source.SourceStagingDir != "/ci/staging" {
+		t.Fatalf("Environment did not relocate the sources: %s %s %s", source.SourceDir, source.GitSourceDir, source.SourceStagingDir)
+	}
+	if OverlayRootDir != "/ci/overlay" || BaseImageMountDir != "/ci/overlay/base" || DependencyLayerDir != "/ci/overlay/layers" {
+		t.Fatalf("Environment did not relocate the overlays: %s", OverlayRootDir)
+	}
+
+	// Configuration overrides the environment
+	if err := SetCachePaths(&Config{SourceDir: "/srv/sources", OverlayDir: "/srv/overlay"}); err != nil {
+		t.Fatalf("Failed to set cache paths: %v", err)
+	}
+	if source.SourceDir != "/srv/sources" || OverlayRootDir != "/srv/overlay" {
+		t.Fatalf("Configuration should take precedence: %s %s", source.SourceDir, OverlayRootDir)
+	}
+
+	// Explicit settings override everything
+	source.SetSourceDir("/explicit")
+	if source.SourceDir != "/explicit" || source.SourceStagingDir != "/explicit/staging" {
+		t.Fatalf("Explicit source dir was not applied: %s", source.SourceDir)
+	}
+
+	os.Setenv(OverlayDirEnvironment, "relative/overlay")
+	if err := SetCachePaths(&Config{}); err == nil {
+		t.Fatalf("Relative paths should be rejected")
+	}
+	if err := SetCachePaths(&Config{SourceDir: "/dev/null"}); err == nil {
+		t.Fatalf("Paths that aren't directories should be rejected")
+	}
+}
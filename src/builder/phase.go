@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// QuietMode will suppress the human readable phase log on the console
+var QuietMode bool
+
+// PhaseTimestampFormat is the format used for each line of the phase log
+const PhaseTimestampFormat = "15:04:05"
+
+// A PhaseLog emits a human readable, timestamped log of each phase within
+// the build, along with the time spent in each phase and the build overall.
+type PhaseLog struct {
+	out        io.Writer        // Where we write the log
+	now        func() time.Time // Clock used for timings
+	start      time.Time        // When the first phase began
+	phase      string           // The currently active phase, if any
+	phaseStart time.Time        // When the active phase began
+
+	Package string // Name of the package, sent with each event
+}
+
+// NewPhaseLog will return a new PhaseLog writing to the given output.
+// If quiet is set, all output is discarded, but timings are still kept.
+func NewPhaseLog(out io.Writer, quiet bool) *PhaseLog {
+	if quiet {
+		out = ioutil.Discard
+	}
+	return &PhaseLog{
+		out: out,
+		now: time.Now,
+	}
+}
+
+// formatDuration will round the duration to whole seconds for display
+func formatDuration(d time.Duration) string {
+	return (d - d%time.Second).String()
+}
+
+// emit will write a single timestamped line to the log
+func (l *PhaseLog) emit(at time.Time, format string, args ...interface{}) {
+	fmt.Fprintf(l.out, "[%s] %s\n", at.Format(PhaseTimestampFormat), fmt.Sprintf(format, args...))
+}
+
+// endPhase will close off the active phase, reporting the time spent in it
+func (l *PhaseLog) endPhase(at time.Time) time.Duration {
+	if l.phase == "" {
+		return 0
+	}
+	elapsed := at.Sub(l.phaseStart)
+	l.emit(at, "%s took %s", l.phase, formatDuration(elapsed))
+	l.phase = ""
+	return elapsed
+}
+
+// Begin will end any active phase, and then start the named phase.
+func (l *PhaseLog) Begin(format string, args ...interface{}) {
+	at := l.now()
+	if l.start.IsZero() {
+		l.start = at
+	}
+	l.endPhase(at)
+	l.phase = fmt.Sprintf(format, args...)
+	l.phaseStart = at
+	l.emit(at, "%s", l.phase)
+	ActiveEvents.Emit(&Event{Type: EventPhase, Time: at, Package: l.Package, Phase: l.phase})
+}
+
+// Finish will end the active phase and report the total duration of the
+// build, returning it for further use.
+func (l *PhaseLog) Finish(err error) time.Duration {
+	at := l.now()
+	if l.start.IsZero() {
+		l.start = at
+	}
+	l.endPhase(at)
+	total := at.Sub(l.start)
+	event := &Event{Type: EventBuildComplete, Time: at, Package: l.Package}
+	if err != nil {
+		l.emit(at, "Build failed after %s", formatDuration(total))
+		event.Error = err.Error()
+	} else {
+		l.emit(at, "Build complete in %s", formatDuration(total))
+	}
+	ActiveEvents.Emit(event)
+	return total
+}
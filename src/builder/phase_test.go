@@ -0,0 +1,68 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhaseLog(t *testing.T) {
+	var buf bytes.Buffer
+	clock := time.Date(2017, 3, 21, 12, 1, 3, 0, time.UTC)
+
+	phases := NewPhaseLog(&buf, false)
+	phases.now = func() time.Time {
+		return clock
+	}
+
+	phases.Begin("Fetching sources (%d)", 3)
+	clock = clock.Add(67 * time.Second)
+	phases.Begin("Running ypkg-build")
+	clock = clock.Add(7*time.Minute + 27*time.Second + 300*time.Millisecond)
+	if total := phases.Finish(nil); total != 8*time.Minute+34*time.Second+300*time.Millisecond {
+		t.Fatalf("Wrong total build time: %v", total)
+	}
+
+	expected := []string{
+		"[12:01:03] Fetching sources (3)",
+		"[12:02:10] Fetching sources (3) took 1m7s",
+		"[12:02:10] Running ypkg-build",
+		"[12:09:37] Running ypkg-build took 7m27s",
+		"[12:09:37] Build complete in 8m34s",
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Wrong number of phase lines: %d vs expected %d", len(lines), len(expected))
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Fatalf("Wrong phase line: '%s' vs expected '%s'", line, expected[i])
+		}
+	}
+
+	buf.Reset()
+	phases = NewPhaseLog(&buf, true)
+	phases.Begin("Fetching sources (%d)", 0)
+	phases.Finish(errors.New("failed"))
+	if buf.Len() != 0 {
+		t.Fatalf("Quiet phase log should not emit output: %s", buf.String())
+	}
+}
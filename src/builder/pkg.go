@@ -62,6 +62,39 @@ type Package struct {
 	Path       string          // Path to the build spec
 	Sources    []source.Source // Each package has 0 or more sources that we fetch
 	CanNetwork bool            // Only applicable to ypkg builds
+	BuildDeps  []string        // Build dependencies, only known for ypkg builds
+
+	Artifacts []*ArtifactDigest // Checksums of the artifacts from the last build
+
+	ReplayLock *InputLock // Inputs the build must match, if replaying
+
+	SourcePriorities map[string]int // Fetch priority hints, keyed by source identifier
+
+	SourceArches map[string][]string // Architectures sources are restricted to, keyed by identifier
+
+	PrepareOnly bool // Prepare the build root without building
+
+	Force bool // Build even when the package has already been built
+
+	UpperLayer *UpperLayerReport // Writes to the overlay upper layer by the last build
+
+	Provenance map[string]*source.Provenance // Where each source of the last build came from, by identifier
+
+	HostFeatures *HostFeatures // Kernel and overlayfs features of the last build, if recorded
+
+	Licenses map[string][]string // License files found in each source, by identifier
+
+	Signatures map[string]string // Detached signatures of sources, by identifier
+	Keyring    string            // Keyring trusted to sign the sources, if set
+
+	Logs *BuildLogs // Captured output of the last build, if enabled
+
+	Patches        []Patch  // Patches applicable to the active profile and architecture
+	AppliedPatches []string // Patches applied by the last build, in order
+	SourceTrees    []string // Source trees staged by the last build, in order
+
+	Environment     []string // Environment of the last build, with secrets redacted
+	EnvironmentDiff *EnvDiff // Changes from the environment baseline, if set
 }
 
 // YmlPackage is a parsed ypkg build file
@@ -71,6 +104,10 @@ type YmlPackage struct {
 	Release    int
 	Networking bool // If set to false (default) we disable networking in the build
 	Source     []map[string]string
+	Builddeps  []string
+	Alternates map[string][]string // Alternate URIs for each source, tried in order
+	Signatures map[string]string   // Detached signature for each source, or a suffix
+	Keyring    string              // Keyring trusted to sign sources, relative to the package
 }
 
 // XMLUpdate represents an update in the package history
@@ -87,6 +124,7 @@ type XMLUpdate struct {
 type XMLArchive struct {
 	Type    string `xml:"type,attr"`
 	SHA1Sum string `xml:"sha1sum,attr"`
+	Name    string `xml:"name,attr"` // Optionally renames the file
 	URI     string `xml:",chardata"`
 }
 
@@ -148,7 +186,11 @@ func NewXMLPackage(path string) (*Package, error) {
 	}
 
 	for _, archive := range xpkg.Source.Archive {
-		source, err := source.New(archive.URI, archive.SHA1Sum, true)
+		uri := archive.URI
+		if archive.Name != "" {
+			uri = strings.TrimSpace(uri) + "#" + archive.Name
+		}
+		source, err := source.New(uri, archive.SHA1Sum, true)
 		if err != nil {
 			return nil, err
 		}
@@ -206,18 +248,34 @@ func NewYmlPackageFromBytes(by []byte) (*Package, error) {
 		Release:    ypkg.Release,
 		Type:       PackageTypeYpkg,
 		CanNetwork: ypkg.Networking,
+		BuildDeps:  ypkg.Builddeps,
+		Signatures: ypkg.Signatures,
+		Keyring:    strings.TrimSpace(ypkg.Keyring),
 	}
 
 	for _, row := range ypkg.Source {
+		arches := splitArches(row[SourceArchKey])
 		for key, value := range row {
+			if key == SourceArchKey {
+				continue
+			}
 			source, err := source.New(key, value, false)
 			if err != nil {
 				return nil, err
 			}
 			ret.Sources = append(ret.Sources, source)
+			if len(arches) == 0 {
+				continue
+			}
+			if ret.SourceArches == nil {
+				ret.SourceArches = make(map[string][]string)
+			}
+			ret.SourceArches[source.GetIdentifier()] = arches
 		}
 	}
 
+	ret.AddSourceAlternates(ypkg.Alternates)
+
 	if ret.Name == "" {
 		return nil, errors.New("ypkg: Missing name in package")
 	}
@@ -0,0 +1,147 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// DefaultDiskHeadroom is the space, in MiB, that we expect a build to
+	// need for intermediate files in the overlay.
+	DefaultDiskHeadroom = 4096
+
+	// PreflightOutputSpace is the minimum space, in MiB, that we want to have
+	// available in the output directory for collecting the build artifacts.
+	PreflightOutputSpace = 100
+)
+
+// ErrInsufficientSpace is returned when the preflight check determines that
+// there isn't enough disk space available to complete the build.
+var ErrInsufficientSpace = errors.New("Insufficient disk space for build")
+
+// freeSpaceFunc returns the free space available to us in bytes, for the
+// filesystem hosting the given path. Overridden in tests.
+var freeSpaceFunc = getFreeSpace
+
+// getFreeSpace will use statfs to determine the free space for the nearest
+// existing directory to the given path.
+func getFreeSpace(path string) (uint64, error) {
+	for !PathExists(path) && path != filepath.Dir(path) {
+		path = filepath.Dir(path)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// A spaceRequirement is the estimated space needed on a given path
+type spaceRequirement struct {
+	path     string // Path that will be written to
+	required uint64 // Estimated bytes required
+}
+
+// getSourceFetchSize will estimate the space needed to fetch all of the
+// sources that aren't yet cached.
+func (p *Package) getSourceFetchSize() uint64 {
+	var total uint64
+	for _, s := range p.Sources {
+		if s.IsFetched() {
+			continue
+		}
+		sized, ok := s.(source.SizedSource)
+		if !ok {
+			continue
+		}
+		size, err := sized.GetRemoteSize()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"source": s.GetIdentifier(),
+			}).Debug("Unable to determine source size")
+			continue
+		}
+		total += uint64(size)
+	}
+	return total
+}
+
+// getSpaceRequirements will return the estimated space requirements for
+// building the package within the given overlay.
+func (p *Package) getSpaceRequirements(o *Overlay, headroom uint64) []spaceRequirement {
+	fetchSize := p.getSourceFetchSize()
+	reqs := []spaceRequirement{
+		{source.SourceDir, fetchSize},
+		{".", PreflightOutputSpace * 1024 * 1024},
+	}
+	// Downloads are staged in the temporary directory first
+	if TempDir != "" {
+		reqs = append(reqs, spaceRequirement{TempDir, fetchSize})
+	}
+	// tmpfs builds don't touch the disk for intermediate files
+	if !o.EnableTmpfs {
+		reqs = append(reqs, spaceRequirement{OverlayRootDir, headroom * 1024 * 1024})
+	}
+	return reqs
+}
+
+// CheckDiskSpace will estimate the space required by the build, and compare
+// it against the free space on the relevant filesystems. The headroom is the
+// space, in MiB, to reserve for the build itself.
+//
+// When strict is set, insufficient space will result in ErrInsufficientSpace,
+// otherwise a warning is emitted and the build may continue.
+func (p *Package) CheckDiskSpace(o *Overlay, headroom uint64, strict bool) error {
+	log.Debug("Checking available disk space")
+
+	ok := true
+	for _, req := range p.getSpaceRequirements(o, headroom) {
+		free, err := freeSpaceFunc(req.path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  req.path,
+			}).Debug("Unable to determine free space")
+			continue
+		}
+		if free >= req.required {
+			continue
+		}
+		ok = false
+		fields := log.Fields{
+			"path":     req.path,
+			"required": req.required,
+			"free":     free,
+		}
+		if strict {
+			log.WithFields(fields).Error("Insufficient disk space for build")
+		} else {
+			log.WithFields(fields).Warning("Disk space looks insufficient for build")
+		}
+	}
+
+	if !ok && strict {
+		return ErrInsufficientSpace
+	}
+	return nil
+}
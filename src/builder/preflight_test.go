@@ -0,0 +1,59 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"testing"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	pkg := &Package{Name: "nano", Version: "2.7.5", Release: 61, Type: PackageTypeYpkg}
+	profile := &Profile{Name: "unstable-x86_64", Image: "unstable-x86_64"}
+	overlay := NewOverlay(profile, NewBackingImage(profile.Image), pkg)
+
+	defer func() {
+		freeSpaceFunc = getFreeSpace
+	}()
+
+	// Plenty of space everywhere
+	freeSpaceFunc = func(path string) (uint64, error) {
+		return 1024 * 1024 * 1024 * 1024, nil
+	}
+	if err := pkg.CheckDiskSpace(overlay, DefaultDiskHeadroom, true); err != nil {
+		t.Fatalf("Preflight should pass with sufficient space: %v", err)
+	}
+
+	// Overlay storage is too small for the headroom
+	freeSpaceFunc = func(path string) (uint64, error) {
+		if path == OverlayRootDir {
+			return 1024 * 1024, nil
+		}
+		return 1024 * 1024 * 1024 * 1024, nil
+	}
+	if err := pkg.CheckDiskSpace(overlay, DefaultDiskHeadroom, true); err != ErrInsufficientSpace {
+		t.Fatalf("Strict preflight should fail with insufficient space: %v", err)
+	}
+	if err := pkg.CheckDiskSpace(overlay, DefaultDiskHeadroom, false); err != nil {
+		t.Fatalf("Non-strict preflight should only warn: %v", err)
+	}
+
+	// tmpfs builds don't need overlay storage on disk
+	overlay.EnableTmpfs = true
+	if err := pkg.CheckDiskSpace(overlay, DefaultDiskHeadroom, true); err != nil {
+		t.Fatalf("Preflight should ignore overlay storage for tmpfs: %v", err)
+	}
+}
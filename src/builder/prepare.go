@@ -0,0 +1,192 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StreamSources controls whether archives that are not yet cached are
+// streamed straight into their source tree when preparing a build root,
+// rather than being cached first. Builds always cache their sources, as the
+// build tools need the archives themselves.
+var StreamSources = false
+
+// A streamingSource can be fetched straight into extraction
+type streamingSource interface {
+	FetchExtract(dest string) error
+}
+
+// isStreamed will determine if the source is streamed into its source tree
+// by StageSources, instead of being fetched by FetchSources.
+func (p *Package) isStreamed(src source.Source) bool {
+	if !StreamSources || !p.PrepareOnly || src.IsFetched() {
+		return false
+	}
+	if _, ok := src.(streamingSource); !ok {
+		return false
+	}
+	_, ok := source.TrimArchiveSuffix(filepath.Base(src.GetBindConfiguration("").BindTarget))
+	return ok
+}
+
+// StageSources will place a copy of each source within the build root,
+// rather than bind mounting them, so that they remain available after
+// solbuild has exited. These are copies so the cache cannot be modified.
+// Archives are also extracted into the source tree directory, and each
+// unpacked tree is recorded in SourceTrees, in order. Sources that are
+// streamed with StreamSources are only extracted.
+func (p *Package) StageSources(o *Overlay) error {
+	p.SourceTrees = nil
+	sourceDir := p.GetSourceDir(o)
+	for _, src := range p.Sources {
+		bind := src.GetBindConfiguration(sourceDir)
+		if p.isStreamed(src) {
+			extract := src.(streamingSource).FetchExtract
+			tree, err := extractSource(extract, filepath.Base(bind.BindTarget), p.GetSourceTreeDir(o))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"source": src.GetIdentifier(),
+					"error":  err,
+				}).Error("Failed to stream source")
+				return err
+			}
+			p.SourceTrees = append(p.SourceTrees, tree)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(bind.BindTarget), 00755); err != nil {
+			return err
+		}
+		st, err := os.Stat(bind.BindSource)
+		if err != nil {
+			return err
+		}
+		if st.IsDir() {
+			if err := os.MkdirAll(bind.BindTarget, 00755); err != nil {
+				return err
+			}
+			if err = copyTree(bind.BindSource, bind.BindTarget); err == nil {
+				p.SourceTrees = append(p.SourceTrees, bind.BindTarget)
+			}
+		} else {
+			err = disk.CopyFile(bind.BindSource, bind.BindTarget)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"source": bind.BindSource,
+				"error":  err,
+			}).Error("Failed to stage source")
+			return err
+		}
+		if _, ok := source.TrimArchiveSuffix(bind.BindTarget); !ok || st.IsDir() {
+			continue
+		}
+		extract := func(dest string) error {
+			return source.ExtractTo(bind.BindSource, dest)
+		}
+		tree, err := extractSource(extract, filepath.Base(bind.BindTarget), p.GetSourceTreeDir(o))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"source": bind.BindSource,
+				"error":  err,
+			}).Error("Failed to extract source")
+			return err
+		}
+		p.SourceTrees = append(p.SourceTrees, tree)
+	}
+	return nil
+}
+
+// extractSource will extract the named archive into its own tree within
+// dir, returning the path of the tree. Archives holding a single top level
+// directory, as most release tarballs do, are extracted as that directory,
+// and others into a directory named after the archive.
+func extractSource(extract func(dest string) error, name, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(dir, ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extract(tmp); err != nil {
+		return "", err
+	}
+
+	root, _ := source.TrimArchiveSuffix(name)
+	from := tmp
+	if entries, err := ioutil.ReadDir(tmp); err != nil {
+		return "", err
+	} else if len(entries) == 1 && entries[0].IsDir() {
+		root = entries[0].Name()
+		from = filepath.Join(tmp, root)
+	}
+	tree := filepath.Join(dir, root)
+	if err := os.RemoveAll(tree); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(from, 00755); err != nil {
+		return "", err
+	}
+	return tree, os.Rename(from, tree)
+}
+
+// Prepare will set up the build root without running the build itself,
+// installing the build dependencies, staging and extracting the sources,
+// and applying any patches. The prepared
+// root is left behind for interactive use, until the next build of the
+// package or the cache is deleted.
+func (p *Package) Prepare(notif PidNotifier, usr *UserInfo, profile *Profile, pman *EopkgManager, overlay *Overlay, h *PackageHistory) error {
+	if p.Type == PackageTypeYpkg {
+		if err := p.PrepYpkg(notif, usr, pman, overlay, h); err != nil {
+			return err
+		}
+	}
+	if err := p.StageSources(overlay); err != nil {
+		return err
+	}
+	if err := p.ApplyPatches(overlay); err != nil {
+		return err
+	}
+	p.ReportPatches()
+	if err := EnsureEopkgLayout(overlay.MountPoint); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"root": overlay.MountPoint,
+	}).Info("Build root prepared")
+	fmt.Printf("\nThe build root for %s has been prepared without building.\n", p.Name)
+	fmt.Printf("Enter it with:\n\n    solbuild chroot -p %s %s\n\n", profile.Name, p.Path)
+	fmt.Printf("Sources are available in %s\n", p.GetSourceDirInternal())
+	if len(p.SourceTrees) > 0 {
+		fmt.Printf("Extracted sources are available in %s\n", p.GetSourceTreeDirInternal())
+	}
+	if len(p.AppliedPatches) > 0 {
+		fmt.Printf("Patches applied: %s\n", strings.Join(p.AppliedPatches, ", "))
+	}
+	fmt.Printf("The build root is removed by the next build, or: solbuild delete-cache\n")
+	return nil
+}
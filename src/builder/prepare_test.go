@@ -0,0 +1,258 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"archive/tar"
+	"builder/source"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// archiveSource is a cached archive, staged under its own name
+type archiveSource struct {
+	path string
+}
+
+func (s *archiveSource) IsFetched() bool       { return true }
+func (s *archiveSource) Fetch() error          { return nil }
+func (s *archiveSource) GetIdentifier() string { return s.path }
+func (s *archiveSource) GetBindConfiguration(rootfs string) source.BindConfiguration {
+	return source.BindConfiguration{BindSource: s.path, BindTarget: filepath.Join(rootfs, filepath.Base(s.path))}
+}
+
+// writeTestTarball will write a gzipped tarball holding the files
+func writeTestTarball(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create tarball: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		hdr := &tar.Header{Name: name, Mode: 00644, Size: int64(len(contents)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatalf("Failed to write member: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tarball: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close tarball: %v", err)
+	}
+}
+
+func TestStageSources(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-prepare")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cache := filepath.Join(tmp, "cache")
+	if err := os.MkdirAll(cache, 00755); err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	primary := &archiveSource{path: filepath.Join(cache, "nano-2.7.5.tar.gz")}
+	writeTestTarball(t, primary.path, map[string]string{
+		"nano-2.7.5/README":     "nano\n",
+		"nano-2.7.5/src/nano.c": "int main;",
+	})
+	extra := &archiveSource{path: filepath.Join(cache, "extras.tar.gz")}
+	writeTestTarball(t, extra.path, map[string]string{"COPYING": "GPL", "AUTHORS": "nano"})
+	desktop := &archiveSource{path: filepath.Join(cache, "nano.desktop")}
+	if err := ioutil.WriteFile(desktop.path, []byte("[Desktop Entry]"), 00644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	recipe := filepath.Join(tmp, "recipe")
+	if err := os.MkdirAll(filepath.Join(recipe, PatchesDir), 00755); err != nil {
+		t.Fatalf("Failed to create patches directory: %v", err)
+	}
+	for name, contents := range map[string]string{PatchSeriesFile: "fix.patch\n", "fix.patch": testPatchFix} {
+		if err := ioutil.WriteFile(filepath.Join(recipe, PatchesDir, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	pkg := &Package{
+		Name:    "nano",
+		Type:    PackageTypeYpkg,
+		Path:    filepath.Join(recipe, "package.yml"),
+		Sources: []source.Source{primary, extra, desktop},
+	}
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union")}
+	if err := pkg.SelectPatches("main-x86_64", "x86_64"); err != nil {
+		t.Fatalf("Failed to select patches: %v", err)
+	}
+	if err := pkg.StageSources(overlay); err != nil {
+		t.Fatalf("Failed to stage sources: %v", err)
+	}
+	if err := pkg.ApplyPatches(overlay); err != nil {
+		t.Fatalf("Failed to apply patches: %v", err)
+	}
+
+	// Archives are staged as they are for the build tools
+	staged := filepath.Join(pkg.GetSourceDir(overlay), "nano.desktop")
+	if contents, err := ioutil.ReadFile(staged); err != nil || string(contents) != "[Desktop Entry]" {
+		t.Fatalf("Source was not staged in the build root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(pkg.GetSourceDir(overlay), "nano-2.7.5.tar.gz")); err != nil {
+		t.Fatalf("Archive was not staged in the build root: %v", err)
+	}
+
+	// Archives are extracted, with the patches applied to the first
+	trees := pkg.GetSourceTreeDir(overlay)
+	expected := []string{filepath.Join(trees, "nano-2.7.5"), filepath.Join(trees, "extras")}
+	if !reflect.DeepEqual(pkg.SourceTrees, expected) {
+		t.Fatalf("Wrong source trees: %v", pkg.SourceTrees)
+	}
+	files := map[string]string{
+		filepath.Join("nano-2.7.5", "README"):     "nano 2.7.5\n",
+		filepath.Join("nano-2.7.5", "src/nano.c"): "int main;",
+		filepath.Join("extras", "COPYING"):        "GPL",
+	}
+	for name, want := range files {
+		if contents, err := ioutil.ReadFile(filepath.Join(trees, name)); err != nil || string(contents) != want {
+			t.Fatalf("Wrong extracted contents of %s: %q %v", name, contents, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(trees, "nano.desktop")); !os.IsNotExist(err) {
+		t.Fatalf("Files that are not archives should not be extracted: %v", err)
+	}
+	if !reflect.DeepEqual(pkg.AppliedPatches, []string{"fix.patch"}) {
+		t.Fatalf("Wrong patches applied: %v", pkg.AppliedPatches)
+	}
+
+	// Changes within the prepared root must not touch the cache
+	if err := ioutil.WriteFile(staged, []byte("vim"), 00644); err != nil {
+		t.Fatalf("Failed to modify staged source: %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(desktop.path); string(contents) != "[Desktop Entry]" {
+		t.Fatalf("Staged source should be a copy of the cache")
+	}
+	if mounts, _ := readMountsUnder(overlay.MountPoint); len(mounts) != 0 {
+		t.Fatalf("Staged sources should not be mounted: %v", mounts)
+	}
+}
+
+func TestStageSourcesBomb(t *testing.T) {
+	oldSize := source.MaxExtractSize
+	defer func() { source.MaxExtractSize = oldSize }()
+	source.MaxExtractSize = 4
+
+	tmp, err := ioutil.TempDir("", "solbuild-prepare")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	bomb := &archiveSource{path: filepath.Join(tmp, "nano-2.7.5.tar.gz")}
+	writeTestTarball(t, bomb.path, map[string]string{"nano-2.7.5/README": "nano 2.7.5"})
+	pkg := &Package{Name: "nano", Type: PackageTypeYpkg, Sources: []source.Source{bomb}}
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union")}
+	if err := pkg.StageSources(overlay); err != source.ErrExtractTooLarge {
+		t.Fatalf("Expected the archive to be too large, got: %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(pkg.GetSourceTreeDir(overlay)); len(entries) != 0 {
+		t.Fatalf("Partial extraction was not cleaned up: %d entries remain", len(entries))
+	}
+}
+
+func TestStreamSources(t *testing.T) {
+	oldSourceDir := source.SourceDir
+	defer source.SetSourceDir(oldSourceDir)
+	defer func() {
+		StreamSources = false
+	}()
+
+	tmp, err := ioutil.TempDir("", "solbuild-prepare")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	source.SetSourceDir(filepath.Join(tmp, "cache"))
+
+	archive := filepath.Join(tmp, "upstream", "nano-2.7.5.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(archive), 00755); err != nil {
+		t.Fatalf("Failed to create upstream directory: %v", err)
+	}
+	writeTestTarball(t, archive, map[string]string{"nano-2.7.5/README": "nano\n"})
+	contents, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read tarball: %v", err)
+	}
+	sum := sha256.Sum256(contents)
+
+	src, err := source.NewSimple("file://"+archive, hex.EncodeToString(sum[:]), false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	pkg := &Package{Name: "nano", Type: PackageTypeYpkg, Sources: []source.Source{src}}
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union"), Back: &BackingImage{Name: "main-x86_64"}}
+
+	// Only prepared roots are streamed
+	StreamSources = true
+	if pkg.isStreamed(src) {
+		t.Fatalf("Sources should only be streamed when preparing")
+	}
+	pkg.PrepareOnly = true
+	if err := pkg.FetchSources(overlay); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if src.IsFetched() {
+		t.Fatalf("Streamed source should not be fetched into the cache")
+	}
+	if err := pkg.StageSources(overlay); err != nil {
+		t.Fatalf("Failed to stage streamed sources: %v", err)
+	}
+	tree := filepath.Join(pkg.GetSourceTreeDir(overlay), "nano-2.7.5")
+	if !reflect.DeepEqual(pkg.SourceTrees, []string{tree}) {
+		t.Fatalf("Wrong source trees: %v", pkg.SourceTrees)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(tree, "README")); err != nil || string(b) != "nano\n" {
+		t.Fatalf("Streamed source was not extracted: %q %v", b, err)
+	}
+	if PathExists(filepath.Join(pkg.GetSourceDir(overlay), "nano-2.7.5.tar.gz")) || src.IsFetched() {
+		t.Fatalf("Streamed archive should not be written to disk")
+	}
+
+	// A mismatched stream leaves nothing behind
+	bad, err := source.NewSimple("file://"+archive, testSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	pkg.Sources = []source.Source{bad}
+	overlay.MountPoint = filepath.Join(tmp, "union-bad")
+	if err := pkg.StageSources(overlay); err == nil {
+		t.Fatalf("Mismatched stream should fail to stage")
+	}
+	if entries, _ := ioutil.ReadDir(pkg.GetSourceTreeDir(overlay)); len(entries) != 0 {
+		t.Fatalf("Mismatched stream left %d entries behind", len(entries))
+	}
+}
@@ -0,0 +1,61 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"sort"
+)
+
+// FetchJobs is the maximum number of sources to fetch at the same time
+var FetchJobs = 1
+
+const (
+	// PriorityHigh should be used for sources needed to begin the build,
+	// such as the main tarball.
+	PriorityHigh = 10
+
+	// PriorityNormal is the priority of sources without a hint
+	PriorityNormal = 0
+
+	// PriorityLow should be used for large sources only needed later on
+	PriorityLow = -10
+)
+
+// SetSourcePriority will hint the order in which a source should be fetched,
+// with higher priorities fetched first. The source is identified by its
+// identifier, i.e. the URI.
+func (p *Package) SetSourcePriority(identifier string, priority int) {
+	if p.SourcePriorities == nil {
+		p.SourcePriorities = make(map[string]int)
+	}
+	p.SourcePriorities[identifier] = priority
+}
+
+// getFetchOrder will return the sources in the order they should be fetched.
+// Sources of equal priority retain their declaration order.
+func (p *Package) getFetchOrder() []source.Source {
+	order := make([]source.Source, len(p.Sources))
+	copy(order, p.Sources)
+	if len(p.SourcePriorities) == 0 {
+		return order
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return p.SourcePriorities[order[i].GetIdentifier()] > p.SourcePriorities[order[j].GetIdentifier()]
+	})
+	return order
+}
@@ -0,0 +1,167 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderedSource records the order in which sources begin fetching
+type orderedSource struct {
+	fetchableSource
+	name    string
+	tracker *fetchTracker
+}
+
+type fetchTracker struct {
+	lock    sync.Mutex
+	started []string
+	active  int
+	maximum int
+}
+
+func (o *orderedSource) GetIdentifier() string { return o.name }
+func (o *orderedSource) Fetch() error {
+	o.tracker.lock.Lock()
+	o.tracker.started = append(o.tracker.started, o.name)
+	o.tracker.active++
+	if o.tracker.active > o.tracker.maximum {
+		o.tracker.maximum = o.tracker.active
+	}
+	o.tracker.lock.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	o.tracker.lock.Lock()
+	o.tracker.active--
+	o.tracker.lock.Unlock()
+	return o.fetchableSource.Fetch()
+}
+
+// hostedSource is an orderedSource served by a single host
+type hostedSource struct {
+	orderedSource
+}
+
+func (h *hostedSource) GetHost() string { return "example.com" }
+
+func newOrderedPackage(dir string, tracker *fetchTracker) *Package {
+	pkg := &Package{Name: "nano"}
+	for _, name := range []string{"patches", "docs", "main", "data"} {
+		pkg.Sources = append(pkg.Sources, &orderedSource{
+			fetchableSource: fetchableSource{path: filepath.Join(dir, name)},
+			name:            name,
+			tracker:         tracker,
+		})
+	}
+	return pkg
+}
+
+func TestFetchPriority(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-priority")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() {
+		FetchJobs = 1
+	}()
+	overlay := &Overlay{Back: &BackingImage{Name: "main-x86_64"}}
+
+	// No hints retains the declaration order
+	tracker := &fetchTracker{}
+	pkg := newOrderedPackage(tmp, tracker)
+	if err := pkg.FetchSources(overlay); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if order := fmt.Sprint(tracker.started); order != "[patches docs main data]" {
+		t.Fatalf("Sources without hints should be fetched in order: %s", order)
+	}
+
+	tracker = &fetchTracker{}
+	pkg = newOrderedPackage(tmp, tracker)
+	pkg.SetSourcePriority("main", PriorityHigh)
+	pkg.SetSourcePriority("data", PriorityLow)
+	if err := pkg.FetchSources(overlay); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if order := fmt.Sprint(tracker.started); order != "[main patches docs data]" {
+		t.Fatalf("Sources should be fetched by priority: %s", order)
+	}
+
+	// Concurrent fetches still begin with the highest priority
+	FetchJobs = 2
+	tracker = &fetchTracker{}
+	pkg = newOrderedPackage(tmp, tracker)
+	pkg.SetSourcePriority("main", PriorityHigh)
+	pkg.SetSourcePriority("patches", PriorityLow)
+	if err := pkg.FetchSources(overlay); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if tracker.maximum != 2 {
+		t.Fatalf("Concurrency limit was not honored: %d", tracker.maximum)
+	}
+	if len(tracker.started) != 4 || tracker.started[3] != "patches" {
+		t.Fatalf("Low priority source should be fetched last: %v", tracker.started)
+	}
+	first := tracker.started[0] + " " + tracker.started[1]
+	if first != "main docs" && first != "docs main" {
+		t.Fatalf("Highest priority sources should be dispatched first: %v", tracker.started)
+	}
+}
+
+func TestFetchHostConcurrency(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-priority")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	oldOverrides := source.NetworkOverrides
+	defer func() {
+		FetchJobs = 1
+		source.NetworkOverrides = oldOverrides
+	}()
+	limit := 1
+	source.NetworkOverrides = map[string]source.NetworkOverride{
+		source.NetworkDownload: {HostConcurrency: &limit},
+	}
+
+	// The host limit applies without AdaptiveFetch too
+	FetchJobs = 4
+	tracker := &fetchTracker{}
+	pkg := &Package{Name: "nano"}
+	for _, name := range []string{"patches", "docs", "main", "data"} {
+		pkg.Sources = append(pkg.Sources, &hostedSource{orderedSource{
+			fetchableSource: fetchableSource{path: filepath.Join(tmp, name)},
+			name:            name,
+			tracker:         tracker,
+		}})
+	}
+	if err := pkg.FetchSources(&Overlay{Back: &BackingImage{Name: "main-x86_64"}}); err != nil {
+		t.Fatalf("Failed to fetch sources: %v", err)
+	}
+	if len(tracker.started) != 4 || tracker.maximum != 1 {
+		t.Fatalf("Host concurrency was not honored: %d", tracker.maximum)
+	}
+}
@@ -17,6 +17,7 @@
 package builder
 
 import (
+	"builder/source"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
@@ -42,6 +43,14 @@ type Profile struct {
 	RemoveRepos []string         `toml:"remove_repos"` // A set of repos to remove. ["*"] is valid here.
 	Repos       map[string]*Repo `toml:"repo"`         // Allow defining custom repos
 	AddRepos    []string         `toml:"add_repos"`    // Allow locking to a single set of repos
+
+	Alternates map[string][]string `toml:"alternates"` // Alternate URIs for sources, keyed by source URI
+	Keyring    string              `toml:"keyring"`    // Keyring trusted to sign sources, if not set by the package
+
+	Proxy     *source.ProxyConfig `toml:"proxy"`      // Proxies to use for downloads, in place of the environment
+	LimitRate string              `toml:"limit_rate"` // Maximum rate for all downloads, i.e. 500K
+	S3        *source.S3Config    `toml:"s3"`         // Region, endpoint and credentials for s3:// sources
+	SFTP      *source.SFTPConfig  `toml:"sftp"`       // Key and host key policy for sftp:// sources
 }
 
 var (
@@ -0,0 +1,52 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	log "github.com/Sirupsen/logrus"
+)
+
+// ReportProvenance will record where each cached source of the package was
+// fetched from within Provenance, logging it for the build report. Sources
+// without a provenance record, i.e. git, are skipped.
+func (p *Package) ReportProvenance() {
+	p.Provenance = make(map[string]*source.Provenance)
+	for _, s := range p.Sources {
+		ps, ok := s.(source.ProvenanceSource)
+		if !ok {
+			continue
+		}
+		prov, err := ps.GetProvenance()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"source": s.GetIdentifier(),
+				"error":  err,
+			}).Debug("No provenance recorded for source")
+			continue
+		}
+		p.Provenance[s.GetIdentifier()] = prov
+		log.WithFields(log.Fields{
+			"source":     s.GetIdentifier(),
+			"url":        prov.EffectiveURL,
+			"mirror":     prov.Mirror,
+			"status":     prov.Status,
+			"fetched_at": prov.FetchedAt,
+			"digest":     prov.Digest,
+		}).Info("Source provenance")
+	}
+}
@@ -0,0 +1,129 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownBuild is returned when attempting to cancel a build that
+	// isn't currently active.
+	ErrUnknownBuild = errors.New("No active build with that ID")
+
+	// ErrBuildExists is returned when attempting to register a build ID
+	// that is already in use.
+	ErrBuildExists = errors.New("A build with that ID is already active")
+
+	// ActiveBuilds is the registry of all builds in progress within this
+	// process.
+	ActiveBuilds = NewBuildRegistry()
+)
+
+// A Cancellable build can be cancelled and torn down on request
+type Cancellable interface {
+	SetCancelled()
+	Cleanup()
+}
+
+// A BuildRegistry tracks all of the in-progress builds by their ID, so that
+// a single build may be cancelled without disturbing the others.
+type BuildRegistry struct {
+	lock   *sync.Mutex            // Protects the builds map
+	builds map[string]Cancellable // Active builds by ID
+}
+
+// NewBuildRegistry will return a new, empty, build registry
+func NewBuildRegistry() *BuildRegistry {
+	return &BuildRegistry{
+		lock:   new(sync.Mutex),
+		builds: make(map[string]Cancellable),
+	}
+}
+
+// Register will add the build to the registry under the given ID
+func (r *BuildRegistry) Register(id string, build Cancellable) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.builds[id]; ok {
+		return ErrBuildExists
+	}
+	r.builds[id] = build
+	return nil
+}
+
+// Unregister will remove the build from the registry, typically once it has
+// completed.
+func (r *BuildRegistry) Unregister(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.builds, id)
+}
+
+// GetIDs will return the sorted IDs of all active builds
+func (r *BuildRegistry) GetIDs() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var ids []string
+	for id := range r.builds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CancelBuild will cancel the build with the given ID, and tear it down.
+// The build is removed from the registry immediately.
+func (r *BuildRegistry) CancelBuild(id string) error {
+	r.lock.Lock()
+	build, ok := r.builds[id]
+	if !ok {
+		r.lock.Unlock()
+		return ErrUnknownBuild
+	}
+	delete(r.builds, id)
+	r.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Warning("Cancelling build")
+
+	build.SetCancelled()
+	build.Cleanup()
+	return nil
+}
+
+// CancelBuild will cancel the given build within the global registry
+func CancelBuild(id string) error {
+	return ActiveBuilds.CancelBuild(id)
+}
+
+// newBuildID will generate a new unique ID for a build
+func newBuildID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", os.Getpid())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,69 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"testing"
+)
+
+// testBuild is a fake build to track cancellation
+type testBuild struct {
+	cancelled bool
+	cleaned   bool
+}
+
+func (t *testBuild) SetCancelled() {
+	t.cancelled = true
+}
+
+func (t *testBuild) Cleanup() {
+	t.cleaned = true
+}
+
+func TestBuildRegistry(t *testing.T) {
+	registry := NewBuildRegistry()
+	first := &testBuild{}
+	second := &testBuild{}
+
+	if err := registry.Register("first", first); err != nil {
+		t.Fatalf("Failed to register build: %v", err)
+	}
+	if err := registry.Register("second", second); err != nil {
+		t.Fatalf("Failed to register build: %v", err)
+	}
+	if err := registry.Register("first", second); err != ErrBuildExists {
+		t.Fatalf("Should not register a duplicate build ID: %v", err)
+	}
+
+	if err := registry.CancelBuild("first"); err != nil {
+		t.Fatalf("Failed to cancel build: %v", err)
+	}
+	if !first.cancelled || !first.cleaned {
+		t.Fatal("Cancelled build was not torn down")
+	}
+	if second.cancelled || second.cleaned {
+		t.Fatal("Only the requested build should be torn down")
+	}
+	if err := registry.CancelBuild("first"); err != ErrUnknownBuild {
+		t.Fatalf("Cancelled build should have been removed: %v", err)
+	}
+
+	registry.Unregister("second")
+	if ids := registry.GetIDs(); len(ids) != 0 {
+		t.Fatalf("Registry should be empty: %v", ids)
+	}
+}
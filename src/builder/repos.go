@@ -17,11 +17,13 @@
 package builder
 
 import (
+	"builder/source"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/disk"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 const (
@@ -39,7 +41,7 @@ func (p *Package) addLocalRepo(notif PidNotifier, o *Overlay, pkgManager *EopkgM
 	mman := disk.GetMountManager()
 
 	// Ensure the target mountpoint actually exists ...
-	tgt := filepath.Join(o.MountPoint, BindRepoDir[1:], repo.Name)
+	tgt := o.getLocalRepoTarget(repo)
 	if !PathExists(tgt) {
 		if err := os.MkdirAll(tgt, 00755); err != nil {
 			return err
@@ -122,6 +124,9 @@ func (p *Package) addRepos(notif PidNotifier, o *Overlay, pkgManager *EopkgManag
 			}
 			continue
 		}
+		if source.Offline {
+			return fmt.Errorf("Remote repository %s cannot be added in offline mode", repo.Name)
+		}
 		log.WithFields(log.Fields{
 			"name": repo.Name,
 			"url":  repo.URI,
@@ -162,17 +167,34 @@ func (p *Package) ConfigureRepos(notif PidNotifier, o *Overlay, pkgManager *Eopk
 		return err
 	}
 
+	return p.addRepos(notif, o, pkgManager, getAddRepos(profile))
+}
+
+// getAddRepos will return the repos from the profile that should be added
+// to the rootfs. Wildcard additions are sorted by name to keep the order
+// stable between builds.
+func getAddRepos(profile *Profile) []*Repo {
 	var addRepos []*Repo
 
 	if (len(profile.AddRepos) == 1 && profile.AddRepos[0] == "*") || len(profile.AddRepos) == 0 {
-		for _, repo := range profile.Repos {
-			addRepos = append(addRepos, repo)
+		var names []string
+		for name := range profile.Repos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			addRepos = append(addRepos, profile.Repos[name])
 		}
 	} else {
 		for _, id := range profile.AddRepos {
 			addRepos = append(addRepos, profile.Repos[id])
 		}
 	}
+	return addRepos
+}
 
-	return p.addRepos(notif, o, pkgManager, addRepos)
+// getLocalRepoTarget will return the externally visible bind target for
+// the given local repo.
+func (o *Overlay) getLocalRepoTarget(repo *Repo) string {
+	return filepath.Join(o.MountPoint, BindRepoDir[1:], repo.Name)
 }
@@ -0,0 +1,93 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/disk"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ScratchDirs are paths within the chroot that have a tmpfs mounted
+	// over them, so that writes there never reach the upper layer.
+	ScratchDirs []string
+
+	// scratchMounter is used to mount the scratch tmpfs. Overridden in tests.
+	scratchMounter = func() baseMounter {
+		return disk.GetMountManager()
+	}
+
+	// reservedScratchDirs may never be used as scratch directories
+	reservedScratchDirs = []string{"/", "/dev", "/proc", "/sys"}
+)
+
+// ValidateScratchDirs will ensure the scratch directories are absolute
+// paths that won't interfere with the chroot itself.
+func ValidateScratchDirs(dirs []string) error {
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("Scratch directory must be absolute: '%s'", dir)
+		}
+		clean := filepath.Clean(dir)
+		for _, reserved := range reservedScratchDirs {
+			if clean == reserved || (reserved != "/" && strings.HasPrefix(clean, reserved+"/")) {
+				return fmt.Errorf("Scratch directory is reserved: '%s'", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// SetScratchDirs will validate and set the scratch directories
+func SetScratchDirs(dirs []string) error {
+	if err := ValidateScratchDirs(dirs); err != nil {
+		return err
+	}
+	ScratchDirs = nil
+	for _, dir := range dirs {
+		ScratchDirs = append(ScratchDirs, filepath.Clean(dir))
+	}
+	return nil
+}
+
+// MountScratch will mount a tmpfs over each of the scratch directories
+// within the overlay. These are unmounted along with the overlay.
+func (o *Overlay) MountScratch() error {
+	mountMan := scratchMounter()
+	for _, dir := range ScratchDirs {
+		target := filepath.Join(o.MountPoint, dir[1:])
+		if err := os.MkdirAll(target, 00755); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"dir": dir,
+		}).Debug("Mounting scratch directory")
+		if err := mountMan.Mount("tmpfs-scratch", target, "tmpfs", "mode=1777", "nosuid", "nodev"); err != nil {
+			log.WithFields(log.Fields{
+				"dir":   dir,
+				"error": err,
+			}).Error("Failed to mount scratch directory")
+			return err
+		}
+		o.ExtraMounts = append(o.ExtraMounts, target)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScratchDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-scratch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	mounter := &testMounter{mounts: make(map[string]string)}
+	oldMounter := scratchMounter
+	scratchMounter = func() baseMounter { return mounter }
+	defer func() {
+		scratchMounter = oldMounter
+		ScratchDirs = nil
+	}()
+
+	for _, dir := range []string{"tmp", "/proc/self", "/sys", "/"} {
+		if err := SetScratchDirs([]string{dir}); err == nil {
+			t.Fatalf("Scratch directory '%s' should be rejected", dir)
+		}
+	}
+	if err := SetScratchDirs([]string{"/var/tmp/", "/root/.cache"}); err != nil {
+		t.Fatalf("Failed to set scratch directories: %v", err)
+	}
+
+	overlay := &Overlay{MountPoint: filepath.Join(tmp, "union")}
+	if err := overlay.MountScratch(); err != nil {
+		t.Fatalf("Failed to mount scratch directories: %v", err)
+	}
+	for _, dir := range []string{"var/tmp", "root/.cache"} {
+		target := filepath.Join(overlay.MountPoint, dir)
+		if !PathExists(target) {
+			t.Fatalf("Scratch directory missing: %v", target)
+		}
+		if mounter.mounts[target] != "tmpfs-scratch" {
+			t.Fatalf("Scratch directory not mounted: %v", target)
+		}
+	}
+	if len(overlay.ExtraMounts) != 2 {
+		t.Fatalf("Scratch mounts must be unmounted with the overlay: %v", overlay.ExtraMounts)
+	}
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ChrootSecretsDir is where secrets are made available within the chroot
+	ChrootSecretsDir = "/run/secrets"
+
+	// SecretsEnvironment is the variable pointing builds at the secrets
+	SecretsEnvironment = "SOLBUILD_SECRETS"
+)
+
+// Secrets are made available to the build as files within a tmpfs, only
+// for the duration of the build itself. As they're never written to the
+// overlay, they cannot persist in the build root or the resulting packages.
+var Secrets map[string]string
+
+// secretsMounter is used to mount the tmpfs for secrets. Overridden in tests.
+var secretsMounter = func() baseMounter {
+	return disk.GetMountManager()
+}
+
+// getSecretsDir will return the externally visible secrets directory
+func (p *Package) getSecretsDir(o *Overlay) string {
+	return filepath.Join(o.MountPoint, ChrootSecretsDir[1:])
+}
+
+// validateSecretName ensures the secret can only be written to its own file
+func validateSecretName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid secret name: '%s'", name)
+	}
+	return nil
+}
+
+// MountSecrets will mount a tmpfs within the chroot, and write each of the
+// configured secrets to it, readable only by the build user.
+func (p *Package) MountSecrets(o *Overlay) error {
+	if len(Secrets) < 1 {
+		return nil
+	}
+	for name := range Secrets {
+		if err := validateSecretName(name); err != nil {
+			return err
+		}
+	}
+
+	secretsDir := p.getSecretsDir(o)
+	if err := os.MkdirAll(secretsDir, 00700); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dir":     ChrootSecretsDir,
+		"secrets": len(Secrets),
+	}).Debug("Exposing secrets to build")
+
+	if err := secretsMounter().Mount("tmpfs-secrets", secretsDir, "tmpfs", "mode=0700", "nosuid", "nodev", "noexec"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to mount secrets tmpfs")
+		return err
+	}
+	o.ExtraMounts = append(o.ExtraMounts, secretsDir)
+
+	// Only ypkg builds drop root privileges
+	chown := func(path string) error {
+		if p.Type != PackageTypeYpkg {
+			return nil
+		}
+		return os.Chown(path, BuildUserID, BuildUserGID)
+	}
+
+	if err := chown(secretsDir); err != nil {
+		return err
+	}
+	for name, value := range Secrets {
+		path := filepath.Join(secretsDir, name)
+		if err := ioutil.WriteFile(path, []byte(value), 00400); err != nil {
+			log.WithFields(log.Fields{
+				"secret": name,
+				"error":  err,
+			}).Error("Failed to write secret")
+			return err
+		}
+		if err := chown(path); err != nil {
+			return err
+		}
+	}
+
+	ChrootEnvironment = append(ChrootEnvironment, fmt.Sprintf("%s=%s", SecretsEnvironment, ChrootSecretsDir))
+	return nil
+}
+
+// ScrubSecrets will remove the secrets from the chroot again, along with
+// the environment variable pointing to them.
+func (p *Package) ScrubSecrets(o *Overlay) error {
+	if len(Secrets) < 1 {
+		return nil
+	}
+	secretsDir := p.getSecretsDir(o)
+
+	var env []string
+	for _, e := range ChrootEnvironment {
+		if !strings.HasPrefix(e, SecretsEnvironment+"=") {
+			env = append(env, e)
+		}
+	}
+	ChrootEnvironment = env
+
+	log.Debug("Scrubbing secrets from build")
+	if err := secretsMounter().Unmount(secretsDir); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to unmount secrets tmpfs")
+		return err
+	}
+	var mounts []string
+	for _, m := range o.ExtraMounts {
+		if m != secretsDir {
+			mounts = append(mounts, m)
+		}
+	}
+	o.ExtraMounts = mounts
+
+	// Remove the now empty mountpoint from the overlay
+	return os.RemoveAll(secretsDir)
+}
@@ -0,0 +1,86 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	root, err := ioutil.TempDir("", "solbuild-secrets")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	mounter := &testMounter{mounts: make(map[string]string)}
+	oldMounter, oldEnv := secretsMounter, ChrootEnvironment
+	secretsMounter = func() baseMounter { return mounter }
+	Secrets = map[string]string{"token": "hunter2"}
+	defer func() {
+		secretsMounter, ChrootEnvironment, Secrets = oldMounter, oldEnv, nil
+	}()
+	ChrootEnvironment = []string{"PATH=/usr/bin"}
+
+	pkg := &Package{Type: PackageTypeXML}
+	overlay := &Overlay{MountPoint: root}
+	secretsDir := pkg.getSecretsDir(overlay)
+
+	if err := pkg.MountSecrets(overlay); err != nil {
+		t.Fatalf("Failed to mount secrets: %v", err)
+	}
+	if _, ok := mounter.mounts[secretsDir]; !ok {
+		t.Fatalf("Secrets were not mounted on a tmpfs")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(secretsDir, "token"))
+	if err != nil || string(contents) != "hunter2" {
+		t.Fatalf("Secret not available during the build: %v", err)
+	}
+	if !strings.Contains(strings.Join(ChrootEnvironment, " "), SecretsEnvironment+"="+ChrootSecretsDir) {
+		t.Fatalf("Secrets missing from environment: %v", ChrootEnvironment)
+	}
+
+	if err := pkg.ScrubSecrets(overlay); err != nil {
+		t.Fatalf("Failed to scrub secrets: %v", err)
+	}
+	if len(mounter.mounts) != 0 || len(overlay.ExtraMounts) != 0 {
+		t.Fatalf("Secrets tmpfs still mounted")
+	}
+	if _, err := os.Stat(secretsDir); !os.IsNotExist(err) {
+		t.Fatalf("Secrets left behind in the overlay")
+	}
+	for _, e := range ChrootEnvironment {
+		if strings.Contains(e, SecretsEnvironment) || strings.Contains(e, "hunter2") {
+			t.Fatalf("Secrets left behind in environment: %v", ChrootEnvironment)
+		}
+	}
+}
+
+func TestSecretNames(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../token", "a/b"} {
+		if validateSecretName(name) == nil {
+			t.Fatalf("Accepted invalid secret name: '%s'", name)
+		}
+	}
+	if err := validateSecretName("github_token"); err != nil {
+		t.Fatalf("Rejected valid secret name: %v", err)
+	}
+}
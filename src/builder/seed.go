@@ -0,0 +1,48 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// SeedTorrents controls whether torrent sources are seeded while the build
+// runs, giving back to the swarm they were fetched from.
+var SeedTorrents = false
+
+// seedingSource is implemented by sources that can be seeded to others
+type seedingSource interface {
+	Seed() error
+}
+
+// SeedSources will begin seeding each of the package sources able to be
+// seeded, until source.StopSeeding is called. Failures only warn, as the
+// build can go ahead without them.
+func (p *Package) SeedSources() {
+	for _, src := range p.Sources {
+		seeder, ok := src.(seedingSource)
+		if !ok {
+			continue
+		}
+		if err := seeder.Seed(); err != nil {
+			log.WithFields(log.Fields{
+				"source": src.GetIdentifier(),
+				"error":  err,
+			}).Warning("Failed to seed source")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// signedSource is implemented by sources which may be verified against a
+// detached signature.
+type signedSource interface {
+	SetSignature(uri, keyring string)
+}
+
+// getKeyring will return the keyring trusted to sign the sources, using the
+// keyring of the package, relative to the build spec, in preference to the
+// keyring of the profile.
+func (p *Package) getKeyring(profileKeyring string) string {
+	if p.Keyring == "" {
+		return profileKeyring
+	}
+	if filepath.IsAbs(p.Keyring) || p.Path == "" {
+		return p.Keyring
+	}
+	return filepath.Join(filepath.Dir(p.Path), p.Keyring)
+}
+
+// ConfigureSignatures will require each source with a detached signature
+// to be verified against it when fetched. A signature that cannot be
+// verified, for want of a keyring or support by the source, is an error.
+func (p *Package) ConfigureSignatures(profileKeyring string) error {
+	if len(p.Signatures) == 0 {
+		return nil
+	}
+	keyring := p.getKeyring(profileKeyring)
+	if keyring == "" {
+		return fmt.Errorf("Sources are signed, but no keyring is set in the package or profile")
+	}
+	for id, sig := range p.Signatures {
+		var src signedSource
+		for _, s := range p.Sources {
+			if s.GetIdentifier() != id {
+				continue
+			}
+			signed, ok := s.(signedSource)
+			if !ok {
+				return fmt.Errorf("Source cannot be verified against a signature: %s", id)
+			}
+			src = signed
+		}
+		if src == nil {
+			return fmt.Errorf("Signature is set for an unknown source: %s", id)
+		}
+		src.SetSignature(sig, keyring)
+	}
+	return nil
+}
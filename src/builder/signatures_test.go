@@ -0,0 +1,64 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"testing"
+)
+
+func TestConfigureSignatures(t *testing.T) {
+	nano, err := source.NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	git, err := source.NewGit("https://github.com/solus-project/solbuild.git", "v1.3.0")
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	pkg := &Package{
+		Path:       "/home/build/nano/package.yml",
+		Sources:    []source.Source{nano, git},
+		Signatures: map[string]string{nano.URI: ".sig"},
+	}
+
+	if err := pkg.ConfigureSignatures(""); err == nil {
+		t.Fatalf("Signatures without a keyring should be rejected")
+	}
+	if err := pkg.ConfigureSignatures("/etc/solbuild/keyring.gpg"); err != nil {
+		t.Fatalf("Failed to configure signatures: %v", err)
+	}
+	if sig := nano.GetSignature(); sig != nano.URI+".sig" {
+		t.Fatalf("Wrong signature: %s", sig)
+	}
+	if keyring := pkg.getKeyring("/etc/solbuild/keyring.gpg"); keyring != "/etc/solbuild/keyring.gpg" {
+		t.Fatalf("Profile keyring should be used: %s", keyring)
+	}
+	pkg.Keyring = "upstream.gpg"
+	if keyring := pkg.getKeyring("/etc/solbuild/keyring.gpg"); keyring != "/home/build/nano/upstream.gpg" {
+		t.Fatalf("Package keyring should be relative to the package: %s", keyring)
+	}
+
+	pkg.Signatures = map[string]string{git.GetIdentifier(): ".sig"}
+	if err := pkg.ConfigureSignatures(""); err == nil {
+		t.Fatalf("Git sources cannot be signed")
+	}
+	pkg.Signatures = map[string]string{"https://example.com/missing.tar.xz": ".sig"}
+	if err := pkg.ConfigureSignatures(""); err == nil {
+		t.Fatalf("Signatures for unknown sources should be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"path/filepath"
+)
+
+var (
+	// SkipBuilt controls whether a build is skipped when valid artifacts
+	// for the same name, version and release already exist.
+	SkipBuilt = false
+
+	// ErrNoArtifacts is returned when no artifacts exist for the package
+	ErrNoArtifacts = errors.New("No existing artifacts for the package")
+)
+
+// getBuiltArtifacts will return the artifacts already in the directory for
+// this name, version and release.
+func (p *Package) getBuiltArtifacts(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s-%s-%d-*.eopkg", p.Name, p.Version, p.Release)))
+}
+
+// matchesInputLock will ensure the sources of the package are still those
+// recorded by the input lock of the previous build.
+func (p *Package) matchesInputLock(lock *InputLock) error {
+	if lock.Package != p.Name || lock.Version != p.Version || lock.Release != p.Release {
+		return fmt.Errorf("Input lock is for %s-%s-%d", lock.Package, lock.Version, lock.Release)
+	}
+	recorded := make(map[string]LockedSource)
+	for _, s := range lock.Sources {
+		recorded[s.Identifier] = s
+	}
+	for _, s := range p.Sources {
+		info := source.GetInfo(s)
+		locked, ok := recorded[info.Identifier]
+		if !ok {
+			return &SourceDriftError{Identifier: info.Identifier}
+		}
+		if info.Validator == "" || info.Validator == locked.Digest || info.Validator == locked.UpstreamDigest {
+			continue
+		}
+		return &SourceDriftError{Identifier: info.Identifier, Expected: locked.Digest, Found: info.Validator}
+	}
+	return nil
+}
+
+// CheckBuilt will ensure the directory holds valid artifacts of a previous
+// build of the package. Each artifact must match its checksum sidecar, so
+// partially written or corrupt artifacts are never accepted. When an input
+// lock was written alongside them, the sources must also be unchanged.
+func (p *Package) CheckBuilt(dir string) error {
+	artifacts, err := p.getBuiltArtifacts(dir)
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		return ErrNoArtifacts
+	}
+	for _, artifact := range artifacts {
+		if err := VerifyArtifactChecksum(artifact); err != nil {
+			return err
+		}
+	}
+	lockPath := filepath.Join(dir, p.Name+InputLockSuffix)
+	if !PathExists(lockPath) {
+		return nil
+	}
+	lock, err := ReadInputLock(lockPath)
+	if err != nil {
+		return err
+	}
+	return p.matchesInputLock(lock)
+}
+
+// ShouldSkipBuild will determine if the build can be skipped, as SkipBuilt
+// is set, the build isn't forced, and the package is already built in the
+// given directory.
+func (p *Package) ShouldSkipBuild(dir string) bool {
+	if !SkipBuilt || p.Force || p.PrepareOnly {
+		return false
+	}
+	if err := p.CheckBuilt(dir); err != nil {
+		if err != ErrNoArtifacts {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Info("Existing artifacts are not usable, rebuilding")
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSkipBuilt(t *testing.T) {
+	defer func() {
+		SkipBuilt = false
+	}()
+	tmp, err := ioutil.TempDir("", "solbuild-skipbuilt")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	nano := [2]string{"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", testSHA256}
+	pkg := newValidateTestPackage(t, "nano", "2.7.5", 1, nano)
+
+	SkipBuilt = true
+	if pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Build skipped without any artifacts")
+	}
+
+	artifact := filepath.Join(tmp, "nano-2.7.5-1-1-x86_64.eopkg")
+	if err := ioutil.WriteFile(artifact, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	// Interrupted before the checksum was written
+	if pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Build skipped for an artifact without a checksum")
+	}
+	if _, err := WriteArtifactChecksums(artifact, nil); err != nil {
+		t.Fatalf("Failed to write checksums: %v", err)
+	}
+	if !pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Build not skipped for a valid artifact: %v", pkg.CheckBuilt(tmp))
+	}
+
+	// Already built short-circuits the whole build, before any locking or
+	// disk space checks need an overlay
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	m := &Manager{lock: new(sync.Mutex), pkg: pkg}
+	if err := m.Build(); err != nil {
+		t.Fatalf("Already built package failed to build: %v", err)
+	}
+
+	pkg.Force = true
+	if pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Forced build was skipped")
+	}
+	pkg.Force = false
+
+	// Other releases are not the same build
+	if newValidateTestPackage(t, "nano", "2.7.5", 2, nano).ShouldSkipBuild(tmp) {
+		t.Fatalf("Build skipped for a different release")
+	}
+
+	// Sources have changed since the recorded build
+	lock := &InputLock{Package: "nano", Version: "2.7.5", Release: 1, Sources: []LockedSource{
+		{Identifier: nano[0], Algorithm: "sha256", Digest: testSHA256},
+	}}
+	if err := lock.Write(filepath.Join(tmp, "nano"+InputLockSuffix), &UserInfo{UID: os.Getuid(), GID: os.Getgid()}); err != nil {
+		t.Fatalf("Failed to write input lock: %v", err)
+	}
+	if !pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Build not skipped with matching input lock: %v", pkg.CheckBuilt(tmp))
+	}
+	changed := newValidateTestPackage(t, "nano", "2.7.5", 1, [2]string{nano[0], "51303d8385c59a09090c30a88144f572642ae6bbdcb23adcab3a0c77c7f55a81"})
+	if err := changed.CheckBuilt(tmp); err == nil {
+		t.Fatalf("Build not rebuilt with changed sources")
+	} else if _, ok := err.(*SourceDriftError); !ok {
+		t.Fatalf("Expected source drift, got: %v", err)
+	}
+
+	// Corrupt artifacts are rebuilt
+	if err := ioutil.WriteFile(artifact, []byte("nan"), 00644); err != nil {
+		t.Fatalf("Failed to corrupt artifact: %v", err)
+	}
+	if pkg.ShouldSkipBuild(tmp) {
+		t.Fatalf("Build skipped for a corrupt artifact")
+	}
+}
@@ -0,0 +1,101 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+const (
+	// ArchiveSHA256 is replaced by the sha256sum of the source in ArchiveURL
+	ArchiveSHA256 = "{sha256}"
+
+	// ArchiveSHA1 is replaced by the sha1sum of a legacy source in ArchiveURL
+	ArchiveSHA1 = "{sha1}"
+)
+
+// ArchiveURL, when set, is a content addressed archive to fetch sources
+// from by their hash as a last resort, once the upstream URL and any
+// mirror have failed. It must contain {sha256} and/or {sha1}, i.e.:
+//
+//	https://archive.softwareheritage.org/api/1/content/sha256:{sha256}/raw/
+var ArchiveURL string
+
+// ErrNotInArchive is returned when no archive URL can be formed for a source
+var ErrNotInArchive = errors.New("Source cannot be fetched from the archive by hash")
+
+// getArchiveURLs will return the archive URL for each of the validators
+// the archive can be addressed by.
+func (s *SimpleSource) getArchiveURLs() []string {
+	if ArchiveURL == "" {
+		return nil
+	}
+	placeholder, size := ArchiveSHA256, 64
+	if s.legacy {
+		placeholder, size = ArchiveSHA1, 40
+	}
+	if !strings.Contains(ArchiveURL, placeholder) {
+		return nil
+	}
+	var urls []string
+	for _, v := range s.validators {
+		if len(v) == size {
+			urls = append(urls, strings.Replace(ArchiveURL, placeholder, strings.ToLower(v), -1))
+		}
+	}
+	return urls
+}
+
+// downloadArchive will fetch the source from the archive by its hash,
+// only accepting content matching the expected digest.
+func (s *SimpleSource) downloadArchive(destination string) error {
+	urls := s.getArchiveURLs()
+	if len(urls) == 0 {
+		return ErrNotInArchive
+	}
+	var err error
+	for _, uri := range urls {
+		var archived *SimpleSource
+		if archived, err = NewSimple(uri, s.formatValidators(), s.legacy); err != nil {
+			continue
+		}
+		archived.progress = s.progress
+		log.WithFields(log.Fields{
+			"uri":     s.URI,
+			"archive": uri,
+		}).Info("Fetching source from archive")
+		if err = archived.downloadDirect(destination); err != nil {
+			continue
+		}
+		var hash string
+		if hash, err = s.GetSHA256Sum(destination); err == nil {
+			err = s.verify(destination, hash)
+		}
+		if err != nil {
+			os.Remove(destination)
+			continue
+		}
+		s.effectiveURL = archived.GetEffectiveURL()
+		s.mirror = uri
+		s.status = archived.status
+		return nil
+	}
+	return err
+}
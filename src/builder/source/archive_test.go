@@ -0,0 +1,112 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveURLs(t *testing.T) {
+	defer func() {
+		ArchiveURL = ""
+	}()
+	src, err := NewSimple("https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", strings.ToUpper(nanoSHA256), false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	legacy, err := NewSimple("https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", nanoSHA1, true)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+
+	if urls := src.getArchiveURLs(); len(urls) != 0 {
+		t.Fatalf("Archive should be disabled by default: %v", urls)
+	}
+	ArchiveURL = "https://archive.example.com/content/sha256:{sha256}/raw/"
+	if urls := src.getArchiveURLs(); len(urls) != 1 || urls[0] != "https://archive.example.com/content/sha256:"+nanoSHA256+"/raw/" {
+		t.Fatalf("Wrong archive URLs: %v", urls)
+	}
+	if urls := legacy.getArchiveURLs(); len(urls) != 0 {
+		t.Fatalf("Legacy sources need a {sha1} archive: %v", urls)
+	}
+	ArchiveURL = "https://archive.example.com/{sha1}"
+	if urls := legacy.getArchiveURLs(); len(urls) != 1 || urls[0] != "https://archive.example.com/"+nanoSHA1 {
+		t.Fatalf("Wrong legacy archive URLs: %v", urls)
+	}
+}
+
+func TestArchiveFallback(t *testing.T) {
+	defer func() {
+		ArchiveURL = ""
+		retrySleep = time.Sleep
+	}()
+	retrySleep = func(time.Duration) {}
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	// Upstream has gone away entirely
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	upstream := "ftp://" + l.Addr().String() + "/nano-2.7.5.tar.xz"
+	l.Close()
+
+	archive := newMockFTPServer(t, nanoSHA256, []byte("nano"), true)
+	defer archive.listener.Close()
+	ArchiveURL = strings.Replace(archive.URL(), nanoSHA256, ArchiveSHA256, 1)
+
+	src, err := NewSimple(upstream, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source from the archive: %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(src.GetPath(nanoSHA256)); string(contents) != "nano" {
+		t.Fatalf("Wrong contents from the archive: %s", contents)
+	}
+	if src.GetEffectiveURL() != archive.URL() {
+		t.Fatalf("Effective URL should be the archive: %s", src.GetEffectiveURL())
+	}
+
+	// Archived content must match the expected digest
+	corrupt := newMockFTPServer(t, nanoSHA256, []byte("vim"), true)
+	defer corrupt.listener.Close()
+	ArchiveURL = strings.Replace(corrupt.URL(), nanoSHA256, ArchiveSHA256, 1)
+	os.RemoveAll(SourceDir)
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	src, err = NewSimple(upstream, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err == nil {
+		t.Fatalf("Should not accept corrupt content from the archive")
+	}
+	if src.IsFetched() || PathExists(filepath.Join(GetStagingDir(), src.File)) {
+		t.Fatalf("Corrupt archived content should not be kept")
+	}
+}
@@ -0,0 +1,111 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitRate is the maximum rate in bytes per second shared by all source
+// downloads, or 0 for no limit.
+var LimitRate int64
+
+// bandwidthSleep is used to hold back downloads. Overridden in tests.
+var bandwidthSleep = time.Sleep
+
+// bandwidth schedules the bytes received by all downloads at LimitRate
+var bandwidth struct {
+	lock sync.Mutex
+	next time.Time // When the bytes received so far are due
+}
+
+// ParseRate will parse a rate in bytes per second, as accepted by the
+// --limit-rate option of curl, i.e. 500K or 2M. Suffixes are powers of 1024.
+func ParseRate(value string) (int64, error) {
+	rate := strings.TrimSpace(value)
+	if rate == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(rate[len(rate)-1:]) {
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = 1024 * 1024
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+	limit, err := strconv.ParseInt(rate, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("Invalid rate: %s", value)
+	}
+	return limit * multiplier, nil
+}
+
+// SetLimitRate will parse and set the rate limit for source downloads,
+// starting the schedule afresh.
+func SetLimitRate(rate string) error {
+	limit, err := ParseRate(rate)
+	if err != nil {
+		return err
+	}
+	bandwidth.lock.Lock()
+	defer bandwidth.lock.Unlock()
+	LimitRate = limit
+	bandwidth.next = time.Time{}
+	return nil
+}
+
+// throttle will wait until the n bytes just received fit within LimitRate,
+// accounting for every download in progress.
+func throttle(n int) {
+	limit := LimitRate
+	if limit <= 0 || n <= 0 {
+		return
+	}
+	bandwidth.lock.Lock()
+	now := time.Now()
+	if bandwidth.next.Before(now) {
+		bandwidth.next = now
+	}
+	bandwidth.next = bandwidth.next.Add(time.Duration(int64(n) * int64(time.Second) / limit))
+	delay := bandwidth.next.Sub(now)
+	bandwidth.lock.Unlock()
+	if delay > 0 {
+		bandwidthSleep(delay)
+	}
+}
+
+// throttledReader will hold back reads to stay within LimitRate
+type throttledReader struct {
+	io.Reader
+}
+
+// Read will wait after each read until it fits within LimitRate
+func (t *throttledReader) Read(b []byte) (int, error) {
+	n, err := t.Reader.Read(b)
+	throttle(n)
+	return n, err
+}
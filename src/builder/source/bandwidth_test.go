@@ -0,0 +1,86 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	rates := map[string]int64{
+		"":     0,
+		"0":    0,
+		"2048": 2048,
+		"500K": 500 * 1024,
+		"2m":   2 * 1024 * 1024,
+		" 1G ": 1024 * 1024 * 1024,
+	}
+	for rate, want := range rates {
+		got, err := ParseRate(rate)
+		if err != nil {
+			t.Fatalf("Failed to parse rate %s: %v", rate, err)
+		}
+		if got != want {
+			t.Fatalf("Wrong rate for %s: %d, expected %d", rate, got, want)
+		}
+	}
+	for _, rate := range []string{"K", "fast", "-5M", "1.5M"} {
+		if _, err := ParseRate(rate); err == nil {
+			t.Fatalf("Invalid rate should be rejected: %s", rate)
+		}
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	// Nothing really sleeps, so the last wait is due at the end of it all
+	var slept time.Duration
+	bandwidthSleep = func(d time.Duration) {
+		slept = d
+	}
+	defer func() {
+		bandwidthSleep = time.Sleep
+		LimitRate = 0
+	}()
+
+	// Unlimited by default
+	throttle(1024 * 1024)
+	if slept != 0 {
+		t.Fatalf("Downloads should not be limited by default: %v", slept)
+	}
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+	src, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+
+	// 4 bytes at 1 byte per second are held back for about 4 seconds
+	if err := SetLimitRate("1"); err != nil {
+		t.Fatalf("Failed to set rate limit: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	if slept < 3*time.Second || slept > 4*time.Second {
+		t.Fatalf("Download was not held to the rate limit: %v", slept)
+	}
+}
@@ -0,0 +1,156 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A BzrSource is a bazaar branch referenced by the `ypkg` build spec as
+// `bzr|` URI, such as `bzr|lp:gnu-foo`, pinned to a revision number. As
+// with SvnSource, each revision is stored once under a directory named for
+// the hash of the URI and revision within SourceDir.
+type BzrSource struct {
+	URI      string
+	Revision string
+	BaseName string
+}
+
+// NewBzr will create a new BzrSource for the given URI & revision
+func NewBzr(uri, revision string) (*BzrSource, error) {
+	if err := checkRepositoryURI(uri); err != nil {
+		return nil, err
+	}
+	urlObj, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	// Launchpad shorthand, i.e. lp:gnu-foo, is opaque
+	name := urlObj.Path
+	if name == "" {
+		name = urlObj.Opaque
+	}
+	return &BzrSource{
+		URI:      uri,
+		Revision: revision,
+		BaseName: filepath.Base(name),
+	}, nil
+}
+
+// getHash will return the hash identifying the branch in the cache
+func (b *BzrSource) getHash() string {
+	sum := sha256.Sum256([]byte(b.URI + "@" + b.Revision))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetPath will return the path of the branch within the cache
+func (b *BzrSource) GetPath() string {
+	return filepath.Join(SourceDir, b.getHash(), b.BaseName)
+}
+
+// Fetch will branch the revision into a staging directory beside the
+// cached path, only moving it into place once complete.
+func (b *BzrSource) Fetch() error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if err := checkOffline(b.URI); err != nil {
+		return err
+	}
+	path := b.GetPath()
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(path), "."+b.BaseName+".")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	log.WithFields(log.Fields{
+		"uri":      b.URI,
+		"revision": b.Revision,
+	}).Debug("Branching bazaar source")
+
+	target := filepath.Join(staging, b.BaseName)
+	if err := commands.ExecStdoutArgs("bzr", []string{"branch", "--quiet", "-r", b.Revision, "--", b.URI, target}); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"uri":   b.URI,
+		}).Error("Failed to branch bazaar source")
+		return err
+	}
+	return os.Rename(target, path)
+}
+
+// IsFetched will determine whether the revision is already branched
+func (b *BzrSource) IsFetched() bool {
+	return PathExists(b.GetPath())
+}
+
+// GetBindConfiguration will return a config that enables bind mounting
+// the branch into the container.
+func (b *BzrSource) GetBindConfiguration(rootfs string) BindConfiguration {
+	return BindConfiguration{
+		BindSource: b.GetPath(),
+		BindTarget: filepath.Join(rootfs, b.BaseName),
+	}
+}
+
+// GetIdentifier will return a human readable string to represent this
+// bazaar source in the event of errors.
+func (b *BzrSource) GetIdentifier() string {
+	return fmt.Sprintf("%s@%s", b.URI, b.Revision)
+}
+
+// GetValidator will return the revision that will be branched
+func (b *BzrSource) GetValidator() (string, string) {
+	return "revision", b.Revision
+}
+
+// GetCanonicalPath will return the path of the branch
+func (b *BzrSource) GetCanonicalPath() (string, bool, error) {
+	resolved, err := filepath.EvalSymlinks(b.GetPath())
+	if err != nil {
+		return "", false, err
+	}
+	return resolved, false, nil
+}
+
+// Validate will ensure the bazaar source has a URL and is pinned to a
+// revision number.
+func (b *BzrSource) Validate() error {
+	if b.Revision == "" {
+		return ErrMissingValidator
+	}
+	if rev, err := strconv.Atoi(b.Revision); err != nil || rev < 1 {
+		return fmt.Errorf("Invalid bazaar revision: '%s'", b.Revision)
+	}
+	if u, err := url.Parse(b.URI); err != nil || u.Scheme == "" {
+		return fmt.Errorf("%v: '%s'", ErrUnsupportedScheme, b.URI)
+	}
+	return CheckSecureScheme(b.URI)
+}
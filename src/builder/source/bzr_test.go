@@ -0,0 +1,59 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"testing"
+)
+
+func TestNewBzr(t *testing.T) {
+	src, err := New("bzr|lp:gnu-mailutils", "42", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	bzr, ok := src.(*BzrSource)
+	if !ok {
+		t.Fatalf("Expected a bazaar source, got %T", src)
+	}
+	if bzr.BaseName != "gnu-mailutils" {
+		t.Fatalf("Wrong name for Launchpad branch: %s", bzr.BaseName)
+	}
+	if err := bzr.Validate(); err != nil {
+		t.Fatalf("Source should be valid: %v", err)
+	}
+	if id := bzr.GetIdentifier(); id != "lp:gnu-mailutils@42" {
+		t.Fatalf("Wrong identifier: %s", id)
+	}
+
+	other, err := NewBzr("https://bazaar.launchpad.net/~gnu/mailutils/trunk", "42")
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if other.BaseName != "trunk" || other.GetPath() == bzr.GetPath() {
+		t.Fatalf("Branches should be cached separately: %s", other.GetPath())
+	}
+
+	// bzr must never see the URI as an option
+	if _, err := New("bzr|--Olocking.steal_dead=True", "42", false); err == nil {
+		t.Fatalf("URI starting with a dash should be rejected")
+	}
+
+	bzr.Revision = "tag:1.0"
+	if err := bzr.Validate(); err == nil {
+		t.Fatalf("Non-numeric revisions should be rejected")
+	}
+}
@@ -0,0 +1,199 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CacheStatsLargest is the number of largest entries reported by CacheStats
+const CacheStatsLargest = 10
+
+// A CacheEntry is a single file stored within the source cache
+type CacheEntry struct {
+	Hash string `json:"hash"` // Hash directory containing the file
+	File string `json:"file"` // Name of the file
+	Size int64  `json:"size"` // Size in bytes
+}
+
+// A StagedEntry is a file left in the staging directory, i.e. a partial
+// download from an interrupted fetch
+type StagedEntry struct {
+	File    string    `json:"file"`     // Name of the file
+	Size    int64     `json:"size"`     // Size in bytes
+	ModTime time.Time `json:"modified"` // When the file was last written
+}
+
+// CacheStatsResult describes the disk usage of the source cache
+type CacheStatsResult struct {
+	TotalBytes  int64         `json:"total_bytes"`  // Bytes used by all cached files, counting hardlinks once
+	Files       int           `json:"files"`        // Number of cached files
+	HashDirs    int           `json:"hash_dirs"`    // Number of real hash directories
+	LegacyLinks int           `json:"legacy_links"` // Number of legacy sha1sum symlinks
+	BrokenLinks int           `json:"broken_links"` // Legacy symlinks pointing to missing directories
+	GitBytes    int64         `json:"git_bytes"`    // Bytes used by cached git clones
+	Largest     []CacheEntry  `json:"largest"`      // The largest cached files, largest first
+	StagedBytes int64         `json:"staged_bytes"` // Bytes used by files left in staging
+	Staged      []StagedEntry `json:"staged"`       // Files left in staging, oldest first
+}
+
+// CacheStats will compute the disk usage of the source cache
+func CacheStats() (*CacheStatsResult, error) {
+	return getCacheStats(SourceDir, GitSourceDir, GetStagingDir(), CacheStatsLargest)
+}
+
+// getCacheStats will walk the cache directory, only using stat, to find
+// its disk usage. Git clones and the staging directory are accounted for
+// separately.
+func getCacheStats(sourceDir, gitDir, stagingDir string, largest int) (*CacheStatsResult, error) {
+	// Always list the entries, even when empty, for monitoring
+	result := &CacheStatsResult{Largest: []CacheEntry{}, Staged: []StagedEntry{}}
+	if err := getStagedStats(stagingDir, result); err != nil {
+		return nil, err
+	}
+	if !PathExists(sourceDir) {
+		return result, nil
+	}
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deduplicated sources are hardlinked, so only count each inode once
+	seen := make(map[uint64]bool)
+	var files []CacheEntry
+
+	for _, entry := range entries {
+		path := filepath.Join(sourceDir, entry.Name())
+		if path == gitDir || path == HgSourceDir || path == stagingDir {
+			continue
+		}
+		if entry.Mode()&os.ModeSymlink == os.ModeSymlink {
+			result.LegacyLinks++
+			if !PathExists(path) {
+				result.BrokenLinks++
+			}
+			continue
+		}
+		if !entry.IsDir() {
+			continue
+		}
+		result.HashDirs++
+		cached, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range cached {
+			if !fi.Mode().IsRegular() || isProvenanceFile(fi.Name()) {
+				continue
+			}
+			result.Files++
+			files = append(files, CacheEntry{Hash: entry.Name(), File: fi.Name(), Size: fi.Size()})
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				if seen[st.Ino] {
+					continue
+				}
+				seen[st.Ino] = true
+			}
+			result.TotalBytes += fi.Size()
+		}
+	}
+
+	if PathExists(gitDir) {
+		err := filepath.Walk(gitDir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				result.GitBytes += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].File < files[j].File
+	})
+	if len(files) > largest {
+		files = files[:largest]
+	}
+	result.Largest = append(result.Largest, files...)
+	return result, nil
+}
+
+// getStagedStats will find the files left in the staging directory. No
+// fetch holds on to them once it returns, so while nothing is fetching
+// they're orphaned, and may be removed.
+func getStagedStats(stagingDir string, result *CacheStatsResult) error {
+	if !PathExists(stagingDir) {
+		return nil
+	}
+	err := filepath.Walk(stagingDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+		result.StagedBytes += fi.Size()
+		result.Staged = append(result.Staged, StagedEntry{File: rel, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	sort.SliceStable(result.Staged, func(i, j int) bool {
+		return result.Staged[i].ModTime.Before(result.Staged[j].ModTime)
+	})
+	return err
+}
+
+// String will describe the cache usage for display
+func (r *CacheStatsResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sources:       %s in %d files (%d hash directories)\n", formatBytes(r.TotalBytes), r.Files, r.HashDirs)
+	fmt.Fprintf(&b, "Git clones:    %s\n", formatBytes(r.GitBytes))
+	fmt.Fprintf(&b, "Legacy links:  %d (%d broken)\n", r.LegacyLinks, r.BrokenLinks)
+	fmt.Fprintf(&b, "Staging:       %s in %d files\n", formatBytes(r.StagedBytes), len(r.Staged))
+	if len(r.Largest) > 0 {
+		fmt.Fprintf(&b, "\nLargest sources:\n")
+		for _, entry := range r.Largest {
+			fmt.Fprintf(&b, "  %10s  %s/%s\n", formatBytes(entry.Size), entry.Hash, entry.File)
+		}
+	}
+	if len(r.Staged) > 0 {
+		fmt.Fprintf(&b, "\nLeft in staging:\n")
+		for _, entry := range r.Staged {
+			fmt.Fprintf(&b, "  %10s  %s  %s\n", formatBytes(entry.Size), entry.ModTime.Format("2006-01-02 15:04"), entry.File)
+		}
+	}
+	return b.String()
+}
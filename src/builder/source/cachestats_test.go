@@ -0,0 +1,95 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheStats(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-cachestats")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	gitDir := filepath.Join(tmp, "git")
+	fixtures := map[string]int{
+		"aaaa/nano-2.7.5.tar.xz":   100,
+		"bbbb/vim-8.0.tar.bz2":     300,
+		"cccc/bash-4.4.tar.gz":     200,
+		"git/github.com/x.git/obj": 50,
+
+		// Left behind by an interrupted fetch
+		"staging/nano-2.8.0.tar.xz":             70,
+		"staging/nano-2.8.0.tar.xz.sha256state": 5,
+	}
+	for path, size := range fixtures {
+		full := filepath.Join(tmp, path)
+		if err := os.MkdirAll(filepath.Dir(full), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(full, []byte(strings.Repeat("x", size)), 00644); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+	}
+	// Deduplicated copy of nano, and legacy links
+	if err := os.Link(filepath.Join(tmp, "aaaa/nano-2.7.5.tar.xz"), filepath.Join(tmp, "aaaa/nano.tar.xz")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	if err := os.Symlink("aaaa", filepath.Join(tmp, "1111")); err != nil {
+		t.Fatalf("Failed to create legacy link: %v", err)
+	}
+	if err := os.Symlink("dddd", filepath.Join(tmp, "2222")); err != nil {
+		t.Fatalf("Failed to create legacy link: %v", err)
+	}
+
+	stats, err := getCacheStats(tmp, gitDir, filepath.Join(tmp, "staging"), 2)
+	if err != nil {
+		t.Fatalf("Failed to compute cache stats: %v", err)
+	}
+	if stats.TotalBytes != 600 {
+		t.Fatalf("Hardlinks should only be counted once: %d", stats.TotalBytes)
+	}
+	if stats.Files != 4 || stats.HashDirs != 3 {
+		t.Fatalf("Wrong number of files or directories: %d %d", stats.Files, stats.HashDirs)
+	}
+	if stats.LegacyLinks != 2 || stats.BrokenLinks != 1 {
+		t.Fatalf("Wrong legacy link counts: %d %d", stats.LegacyLinks, stats.BrokenLinks)
+	}
+	if stats.GitBytes != 50 {
+		t.Fatalf("Wrong git usage: %d", stats.GitBytes)
+	}
+	if len(stats.Largest) != 2 || stats.Largest[0].File != "vim-8.0.tar.bz2" || stats.Largest[1].Hash != "cccc" {
+		t.Fatalf("Wrong largest entries: %+v", stats.Largest)
+	}
+
+	if stats.StagedBytes != 75 || len(stats.Staged) != 2 {
+		t.Fatalf("Staging should be reported separately: %d %+v", stats.StagedBytes, stats.Staged)
+	}
+	if out := stats.String(); !strings.Contains(out, "nano-2.8.0.tar.xz") || !strings.Contains(out, "600 B in 4 files") {
+		t.Fatalf("Wrong summary:\n%s", out)
+	}
+
+	if stats, err := getCacheStats(filepath.Join(tmp, "missing"), gitDir, filepath.Join(tmp, "missing", "staging"), 2); err != nil || stats.Files != 0 || stats.Largest == nil {
+		t.Fatalf("Missing cache should be empty: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNoChecksum is returned when no checksum is known for a given file
+	ErrNoChecksum = errors.New("No checksum available for source")
+
+	// SidecarSuffixes are the suffixes checked, in order, for a checksum
+	// file sitting next to the source file.
+	SidecarSuffixes = []string{
+		".sha256sum",
+		".sha256",
+	}
+)
+
+// A ChecksumProvider is used to find the expected validator for a given
+// source file, before passing it on to New.
+type ChecksumProvider interface {
+
+	// GetChecksum will return the validator for the given file name
+	GetChecksum(filename string) (string, error)
+}
+
+// EmbeddedChecksum is a checksum that was embedded directly in the recipe,
+// i.e. the value side of a package.yml source entry.
+type EmbeddedChecksum string
+
+// GetChecksum will return the embedded checksum, regardless of file name
+func (e EmbeddedChecksum) GetChecksum(filename string) (string, error) {
+	if e == "" {
+		return "", ErrNoChecksum
+	}
+	return string(e), nil
+}
+
+// A SidecarChecksum will look for a checksum file next to the source, i.e.
+// nano-2.7.5.tar.xz.sha256sum
+type SidecarChecksum struct {
+	Dir string // Directory containing the sidecar files
+}
+
+// GetChecksum will attempt to read the sidecar file for the given file
+func (s *SidecarChecksum) GetChecksum(filename string) (string, error) {
+	base := filepath.Base(filename)
+	for _, suffix := range SidecarSuffixes {
+		path := filepath.Join(s.Dir, base+suffix)
+		if !PathExists(path) {
+			continue
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		// Sidecars may be bare, or in the "hash  filename" form
+		fields := strings.Fields(string(contents))
+		if len(fields) < 1 {
+			return "", fmt.Errorf("Empty checksum file: %v", path)
+		}
+		return fields[0], nil
+	}
+	return "", ErrNoChecksum
+}
+
+// ManifestChecksums is a set of checksums loaded from a SHA256SUMS style
+// manifest, mapping the base file name to its checksum.
+type ManifestChecksums map[string]string
+
+// NewManifestChecksums will attempt to load the manifest at the given path
+func NewManifestChecksums(path string) (ManifestChecksums, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	return ParseManifestChecksums(fi)
+}
+
+// ParseManifestChecksums will parse a manifest in the format emitted by
+// sha256sum, i.e. "hash  filename", with one file per line. Binary mode
+// markers and comments are permitted.
+func ParseManifestChecksums(r io.Reader) (ManifestChecksums, error) {
+	ret := make(ManifestChecksums)
+	sc := bufio.NewScanner(r)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed checksum manifest on line %d", lineno)
+		}
+		name := strings.TrimPrefix(fields[1], "*")
+		ret[filepath.Base(name)] = fields[0]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetChecksum will return the checksum listed for the given file
+func (m ManifestChecksums) GetChecksum(filename string) (string, error) {
+	if sum, ok := m[filepath.Base(filename)]; ok {
+		return sum, nil
+	}
+	return "", ErrNoChecksum
+}
+
+// NewWithChecksum will return a new source for the specified URL, looking
+// up the validator from the given provider by the source file name.
+func NewWithChecksum(uri string, provider ChecksumProvider, legacy bool) (Source, error) {
+	name := uri
+	if uriObj, err := url.Parse(uri); err == nil {
+		name = filepath.Base(uriObj.Path)
+	}
+	validator, err := provider.GetChecksum(name)
+	if err != nil {
+		return nil, err
+	}
+	return New(uri, validator, legacy)
+}
@@ -0,0 +1,107 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	checksumTestSum      = "a64d24e6bc4fc448376d038f9a755af77f8e748c9051b6e45bf85e783a7e67e4"
+	checksumTestManifest = `
+# Release checksums
+a64d24e6bc4fc448376d038f9a755af77f8e748c9051b6e45bf85e783a7e67e4  nano-2.7.5.tar.xz
+0000000000000000000000000000000000000000000000000000000000000000 *extra-1.0.tar.gz
+`
+)
+
+func TestEmbeddedChecksum(t *testing.T) {
+	sum, err := EmbeddedChecksum(checksumTestSum).GetChecksum("nano-2.7.5.tar.xz")
+	if err != nil {
+		t.Fatalf("Failed to get embedded checksum: %v", err)
+	}
+	if sum != checksumTestSum {
+		t.Fatalf("Wrong embedded checksum: %v", sum)
+	}
+	if _, err := EmbeddedChecksum("").GetChecksum("nano-2.7.5.tar.xz"); err != ErrNoChecksum {
+		t.Fatalf("Empty checksum should not be valid: %v", err)
+	}
+}
+
+func TestSidecarChecksum(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-checksum")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Both bare and sha256sum formatted sidecars are valid
+	sidecars := map[string]string{
+		"nano-2.7.5.tar.xz.sha256sum": checksumTestSum + "  nano-2.7.5.tar.xz\n",
+		"extra-1.0.tar.gz.sha256":     checksumTestSum + "\n",
+	}
+	for name, contents := range sidecars {
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write sidecar: %v", err)
+		}
+	}
+
+	sidecar := &SidecarChecksum{Dir: tmp}
+	for _, name := range []string{"nano-2.7.5.tar.xz", "extra-1.0.tar.gz"} {
+		sum, err := sidecar.GetChecksum(name)
+		if err != nil {
+			t.Fatalf("Failed to read sidecar for %v: %v", name, err)
+		}
+		if sum != checksumTestSum {
+			t.Fatalf("Wrong sidecar checksum for %v: %v", name, sum)
+		}
+	}
+	if _, err := sidecar.GetChecksum("missing.tar.gz"); err != ErrNoChecksum {
+		t.Fatalf("Missing sidecar should not be valid: %v", err)
+	}
+}
+
+func TestManifestChecksums(t *testing.T) {
+	manifest, err := ParseManifestChecksums(strings.NewReader(checksumTestManifest))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	if len(manifest) != 2 {
+		t.Fatalf("Wrong number of manifest entries: %d", len(manifest))
+	}
+	if sum, _ := manifest.GetChecksum("nano-2.7.5.tar.xz"); sum != checksumTestSum {
+		t.Fatalf("Wrong manifest checksum: %v", sum)
+	}
+	if _, err := manifest.GetChecksum("extra-1.0.tar.gz"); err != nil {
+		t.Fatalf("Binary mode entry missing from manifest: %v", err)
+	}
+	if _, err := ParseManifestChecksums(strings.NewReader("garbage")); err == nil {
+		t.Fatal("Should not parse a malformed manifest")
+	}
+
+	src, err := NewWithChecksum("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", manifest, false)
+	if err != nil {
+		t.Fatalf("Failed to create source from manifest: %v", err)
+	}
+	if src.(*SimpleSource).validator != checksumTestSum {
+		t.Fatalf("Wrong validator for source: %v", src.(*SimpleSource).validator)
+	}
+}
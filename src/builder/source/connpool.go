@@ -0,0 +1,83 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	curl "github.com/andelf/go-curl"
+	"net/url"
+	"sync"
+)
+
+var (
+	// ReuseConnections will keep curl handles around between downloads from
+	// the same host, so that their connections are reused rather than
+	// repeating the TCP and TLS handshakes for every source.
+	ReuseConnections bool
+
+	idleHandles     = make(map[string][]*curl.CURL)
+	idleHandlesLock sync.Mutex
+)
+
+// getHandleKey will return the key of the connection pool for the URL
+func getHandleKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// acquireHandle will return a curl handle for the URL, along with the
+// function to call once finished with it. When connections are reused, an
+// idle handle for the same host is reset and returned to the pool after.
+func acquireHandle(u *url.URL) (*curl.CURL, func()) {
+	if !ReuseConnections {
+		hnd := curl.EasyInit()
+		return hnd, hnd.Cleanup
+	}
+
+	key := getHandleKey(u)
+	idleHandlesLock.Lock()
+	var hnd *curl.CURL
+	if idle := idleHandles[key]; len(idle) > 0 {
+		hnd = idle[len(idle)-1]
+		idleHandles[key] = idle[:len(idle)-1]
+	}
+	idleHandlesLock.Unlock()
+
+	// Resetting keeps the live connections, but none of the options
+	if hnd != nil {
+		hnd.Reset()
+	} else {
+		hnd = curl.EasyInit()
+	}
+	return hnd, func() {
+		idleHandlesLock.Lock()
+		idleHandles[key] = append(idleHandles[key], hnd)
+		idleHandlesLock.Unlock()
+	}
+}
+
+// ReleaseConnections will clean up all of the idle curl handles, closing
+// their connections. This should be called once the sources of a build are
+// fetched.
+func ReleaseConnections() {
+	idleHandlesLock.Lock()
+	defer idleHandlesLock.Unlock()
+	for key, idle := range idleHandles {
+		for _, hnd := range idle {
+			hnd.Cleanup()
+		}
+		delete(idleHandles, key)
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("nano"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	defer func() {
+		ReuseConnections = false
+	}()
+
+	tmp, err := ioutil.TempDir("", "solbuild-connpool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, reuse := range []bool{false, true} {
+		ReuseConnections = reuse
+		atomic.StoreInt32(&connections, 0)
+		for i := 0; i < 3; i++ {
+			src, err := NewSimple(fmt.Sprintf("%s/nano-2.7.%d.tar.xz", server.URL, i), nanoSHA256, false)
+			if err != nil {
+				t.Fatalf("Failed to create source: %v", err)
+			}
+			if err := src.downloadCurl(filepath.Join(tmp, src.File)); err != nil {
+				t.Fatalf("Failed to download: %v", err)
+			}
+		}
+		ReleaseConnections()
+
+		expected := int32(3)
+		if reuse {
+			expected = 1
+		}
+		if n := atomic.LoadInt32(&connections); n != expected {
+			t.Fatalf("Expected %d connections with reuse %v, got %d", expected, reuse, n)
+		}
+	}
+	if len(idleHandles) != 0 {
+		t.Fatalf("Idle handles should be released")
+	}
+}
@@ -0,0 +1,81 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// Credentials authenticate downloads from a private host, i.e. a GitLab or
+// Artifactory instance. These are only ever loaded from the credentials
+// file, never from a package, so that secrets stay out of recipes.
+type Credentials struct {
+	Username string            `toml:"username"` // Username for basic auth or FTP logins
+	Password string            `toml:"password"` // Password for basic auth or FTP logins
+	Token    string            `toml:"token"`    // Bearer token, in place of a username
+	Headers  map[string]string `toml:"headers"`  // Extra headers, i.e. PRIVATE-TOKEN
+}
+
+// HostCredentials are the credentials to use when downloading sources, keyed
+// by the host they apply to. These are only sent to the matching host.
+var HostCredentials map[string]Credentials
+
+// ErrAmbiguousCredentials is returned when credentials set both a token and
+// a username, only one of which can be sent.
+var ErrAmbiguousCredentials = errors.New("Credentials may set a token or a username, not both")
+
+// Validate will ensure the credentials can be used
+func (c Credentials) Validate() error {
+	if c.Token != "" && c.Username != "" {
+		return ErrAmbiguousCredentials
+	}
+	return nil
+}
+
+// getHeaders will return the headers needed to authenticate with these
+// credentials over HTTP. Like any custom header, these are dropped when a
+// download is redirected to another host.
+func (c Credentials) getHeaders() map[string]string {
+	ret := make(map[string]string)
+	for key, value := range c.Headers {
+		ret[key] = value
+	}
+	if c.Token != "" {
+		ret["Authorization"] = "Bearer " + c.Token
+	} else if c.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+		ret["Authorization"] = "Basic " + auth
+	}
+	return ret
+}
+
+// getCredentials will return the credentials for the host of this source,
+// matched as for HostHeaders.
+func (s *SimpleSource) getCredentials() (Credentials, bool) {
+	if s.url == nil {
+		return Credentials{}, false
+	}
+	if creds, ok := HostCredentials[s.url.Host]; ok {
+		return creds, true
+	}
+	if s.url.Port() != "" {
+		return Credentials{}, false
+	}
+	creds, ok := HostCredentials[s.url.Hostname()]
+	return creds, ok
+}
@@ -0,0 +1,176 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DeduplicateSources controls whether identical source content fetched under
+// different names is stored only once.
+//
+// As sources are stored in a directory named after their sha256sum, any two
+// files within the same hash directory are byte-identical. With this enabled
+// we hardlink the new name to the existing content instead of keeping a
+// second copy. The file name is unaffected, so GetPath and GetBindConfiguration
+// will continue to work as before.
+var DeduplicateSources = false
+
+// findDuplicate will return the path of an existing file within the hash
+// directory that isn't the given name, if one exists.
+func findDuplicate(hashDir, name string) string {
+	entries, err := ioutil.ReadDir(hashDir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.Name() == name || !entry.Mode().IsRegular() || isProvenanceFile(entry.Name()) {
+			continue
+		}
+		return filepath.Join(hashDir, entry.Name())
+	}
+	return ""
+}
+
+// storeSource will move the staged file into the hash directory under the
+// given name. If deduplication is enabled and identical content already
+// exists in the hash directory, it is hardlinked into place instead and the
+// staged copy is discarded.
+func storeSource(staged, hashDir, name string) error {
+	dest := filepath.Join(hashDir, name)
+
+	if DeduplicateSources {
+		if dupe := findDuplicate(hashDir, name); dupe != "" {
+			// Replace any stale copy under our own name
+			if PathExists(dest) {
+				if err := os.Remove(dest); err != nil {
+					return err
+				}
+			}
+			if err := os.Link(dupe, dest); err == nil {
+				return os.Remove(staged)
+			}
+			// Cannot hardlink (i.e. cross device), keep our own copy instead
+		}
+	}
+
+	return moveFile(staged, dest)
+}
+
+// A DedupResult records the changes made while deduplicating the cache
+type DedupResult struct {
+	Linked     int   // Copies replaced by a hardlink
+	FreedBytes int64 // Bytes reclaimed, once no other hardlink remains
+	Mismatched int   // Copies not matching their hash directory, left untouched
+}
+
+// DeduplicateCache will hardlink the identical copies of each source that
+// were cached under several names, i.e. when renamed, before they were
+// deduplicated as they were stored.
+func DeduplicateCache(dryRun bool) (*DedupResult, error) {
+	return deduplicateCache(SourceDir, dryRun)
+}
+
+// deduplicateCache will deduplicate each real hash directory in turn
+func deduplicateCache(sourceDir string, dryRun bool) (*DedupResult, error) {
+	result := &DedupResult{}
+	if !PathExists(sourceDir) {
+		return result, nil
+	}
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// Legacy links share the directory they point to already
+		if !entry.IsDir() || !isHashDir(entry.Name()) {
+			continue
+		}
+		if err := deduplicateHashDir(filepath.Join(sourceDir, entry.Name()), dryRun, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// deduplicateHashDir will replace each copy within the hash directory with
+// a hardlink to the first. Copies are only identical when their content
+// matches the sha256sum naming the directory, so each is verified first.
+func deduplicateHashDir(hashDir string, dryRun bool, result *DedupResult) error {
+	entries, err := ioutil.ReadDir(hashDir)
+	if err != nil {
+		return err
+	}
+	hash := filepath.Base(hashDir)
+	keeper := ""
+	var keeperInfo os.FileInfo
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || isProvenanceFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(hashDir, entry.Name())
+		if keeperInfo != nil && os.SameFile(keeperInfo, entry) {
+			continue
+		}
+		digests, err := GetDigests(path, ValidatorSHA256)
+		if err != nil {
+			return err
+		}
+		if digests[ValidatorSHA256] != hash {
+			log.WithFields(log.Fields{
+				"path": path,
+			}).Warning("Cached source does not match its sha256sum")
+			result.Mismatched++
+			continue
+		}
+		if keeper == "" {
+			keeper, keeperInfo = path, entry
+			continue
+		}
+
+		// Earlier copies may have shared this one's inode
+		freed := entry.Size()
+		if fi, err := os.Lstat(path); err == nil {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+				freed = 0
+			}
+		}
+		if !dryRun {
+			// Link beside the copy first, so it's replaced atomically
+			tmp := path + ".dedup"
+			os.Remove(tmp)
+			if err := os.Link(keeper, tmp); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+		}
+		log.WithFields(log.Fields{
+			"path":   path,
+			"target": keeper,
+		}).Debug("Hardlinked identical cached source")
+		result.Linked++
+		result.FreedBytes += freed
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduplicateSources(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-dedup")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	DeduplicateSources = true
+	defer func() {
+		DeduplicateSources = false
+	}()
+
+	hashDir := filepath.Join(tmp, "hash")
+	if err := os.MkdirAll(hashDir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+
+	contents := []byte("identical tarball contents")
+	for _, name := range []string{"nano-2.7.5.tar.xz", "nano.tar.xz"} {
+		staged := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(staged, contents, 00644); err != nil {
+			t.Fatalf("Failed to write staged file: %v", err)
+		}
+		if err := storeSource(staged, hashDir, name); err != nil {
+			t.Fatalf("Failed to store source: %v", err)
+		}
+		if PathExists(staged) {
+			t.Fatalf("Staged file should have been consumed: %v", staged)
+		}
+	}
+
+	first, err := os.Stat(filepath.Join(hashDir, "nano-2.7.5.tar.xz"))
+	if err != nil {
+		t.Fatalf("Missing first source: %v", err)
+	}
+	second, err := os.Stat(filepath.Join(hashDir, "nano.tar.xz"))
+	if err != nil {
+		t.Fatalf("Missing second source: %v", err)
+	}
+	if !os.SameFile(first, second) {
+		t.Fatal("Identical sources should share a single copy")
+	}
+
+	// The file name must remain resolvable as before
+	src := &SimpleSource{File: "nano.tar.xz", validator: "hash"}
+	if src.GetBindConfiguration("/sources").BindTarget != "/sources/nano.tar.xz" {
+		t.Fatal("Bind target should be unaffected by deduplication")
+	}
+}
+
+func TestDeduplicateCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-dedup")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	hashDir := filepath.Join(tmp, nanoSHA256)
+	if err := os.MkdirAll(hashDir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+	for name, contents := range map[string]string{
+		"v2.7.5.tar.xz":     "nano",
+		"nano-2.7.5.tar.xz": "nano",
+		"nano.tar.xz":       "nano",
+		"corrupt.tar.xz":    "NANO",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(hashDir, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Failed to write cached source: %v", err)
+		}
+	}
+	// Legacy links are left alone
+	if err := os.Symlink(hashDir, filepath.Join(tmp, "da39a3ee5e6b4b0d3255bfef95601890afd80709")); err != nil {
+		t.Fatalf("Failed to create legacy link: %v", err)
+	}
+
+	result, err := deduplicateCache(tmp, true)
+	if err != nil {
+		t.Fatalf("Failed to deduplicate cache: %v", err)
+	}
+	if result.Linked != 2 || result.Mismatched != 1 {
+		t.Fatalf("Wrong dry run result: %+v", result)
+	}
+	a, _ := os.Stat(filepath.Join(hashDir, "nano-2.7.5.tar.xz"))
+	b, _ := os.Stat(filepath.Join(hashDir, "v2.7.5.tar.xz"))
+	if os.SameFile(a, b) {
+		t.Fatalf("Dry run should not link anything")
+	}
+
+	result, err = deduplicateCache(tmp, false)
+	if err != nil {
+		t.Fatalf("Failed to deduplicate cache: %v", err)
+	}
+	if result.Linked != 2 || result.FreedBytes != 8 || result.Mismatched != 1 {
+		t.Fatalf("Wrong result: %+v", result)
+	}
+	a, _ = os.Stat(filepath.Join(hashDir, "nano-2.7.5.tar.xz"))
+	for _, name := range []string{"nano.tar.xz", "v2.7.5.tar.xz"} {
+		b, _ := os.Stat(filepath.Join(hashDir, name))
+		if !os.SameFile(a, b) {
+			t.Fatalf("%s should be hardlinked", name)
+		}
+	}
+	c, _ := os.Stat(filepath.Join(hashDir, "corrupt.tar.xz"))
+	if os.SameFile(a, c) {
+		t.Fatalf("Mismatched copy should be left untouched")
+	}
+
+	// Already deduplicated
+	if result, err = deduplicateCache(tmp, false); err != nil || result.Linked != 0 {
+		t.Fatalf("Nothing should be left to link: %+v %v", result, err)
+	}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	curl "github.com/andelf/go-curl"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	// StaticHosts pins download hosts to the given address, bypassing DNS
+	// entirely, i.e. { "ftp.gnu.org": "209.51.188.20" }
+	StaticHosts map[string]string
+
+	// DoHURL is a DNS-over-HTTPS resolver, supporting the JSON API, used to
+	// resolve download hosts instead of the system resolver when set.
+	DoHURL string
+
+	dohCache = make(map[string]string)
+	dohLock  sync.Mutex
+)
+
+// dohAnswer is a single record within a DNS-over-HTTPS JSON response
+type dohAnswer struct {
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+// dohResponse is the response of a DNS-over-HTTPS JSON resolver
+type dohResponse struct {
+	Status int         `json:"Status"`
+	Answer []dohAnswer `json:"Answer"`
+}
+
+// dohRecordTypes are the address records we look for, in order
+var dohRecordTypes = []struct {
+	name  string
+	value int
+}{
+	{"A", 1},
+	{"AAAA", 28},
+}
+
+// getDefaultPort will return the port used by the URL
+func getDefaultPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "ftp":
+		return "21"
+	case "http":
+		return "80"
+	default:
+		return "443"
+	}
+}
+
+// newDoHClient will return a client for the resolver, which itself honours
+// StaticHosts so that the resolver may be reached without working DNS.
+func newDoHClient() *http.Client {
+	dialer := &net.Dialer{Timeout: GetNetworkPolicy(NetworkMetadata).ConnectTimeout}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: proxyFromConfig,
+			Dial: func(network, addr string) (net.Conn, error) {
+				if host, port, err := net.SplitHostPort(addr); err == nil {
+					if ip, ok := StaticHosts[host]; ok {
+						addr = net.JoinHostPort(ip, port)
+					}
+				}
+				return dialer.Dial(network, addr)
+			},
+		},
+		Timeout: GetNetworkPolicy(NetworkMetadata).ConnectTimeout,
+	}
+}
+
+// queryDoH will resolve the host with the DNS-over-HTTPS resolver
+func queryDoH(resolver, host string) (string, error) {
+	client := newDoHClient()
+	for _, record := range dohRecordTypes {
+		query := url.Values{}
+		query.Set("name", host)
+		query.Set("type", record.name)
+		sep := "?"
+		if strings.Contains(resolver, "?") {
+			sep = "&"
+		}
+		req, err := http.NewRequest("GET", resolver+sep+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Accept", "application/dns-json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		var answer dohResponse
+		err = json.NewDecoder(resp.Body).Decode(&answer)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("DNS-over-HTTPS resolver returned HTTP status %d", resp.StatusCode)
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, a := range answer.Answer {
+			if a.Type == record.value && net.ParseIP(a.Data) != nil {
+				return a.Data, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("DNS-over-HTTPS resolver has no address for %s", host)
+}
+
+// lookupHost will return the configured address for the host, or an empty
+// string if the system resolver should be used.
+func lookupHost(host string) (string, error) {
+	if ip, ok := StaticHosts[host]; ok {
+		return ip, nil
+	}
+	if DoHURL == "" || net.ParseIP(host) != nil {
+		return "", nil
+	}
+
+	dohLock.Lock()
+	defer dohLock.Unlock()
+	if ip, ok := dohCache[host]; ok {
+		return ip, nil
+	}
+	ip, err := queryDoH(DoHURL, host)
+	if err != nil {
+		return "", err
+	}
+	dohCache[host] = ip
+	return ip, nil
+}
+
+// getResolveEntries will return the curl resolve entries for the URL, in
+// the form host:port:address, if its host has a configured address.
+func getResolveEntries(u *url.URL) ([]string, error) {
+	ip, err := lookupHost(u.Hostname())
+	if err != nil || ip == "" {
+		return nil, err
+	}
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return []string{fmt.Sprintf("%s:%s:%s", u.Hostname(), getDefaultPort(u), ip)}, nil
+}
+
+// setResolveOptions will pin the resolution of the URL's host for curl.
+// Hosts reached via redirects are still resolved by the system.
+func setResolveOptions(hnd *curl.CURL, u *url.URL) error {
+	entries, err := getResolveEntries(u)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		hnd.Setopt(curl.OPT_RESOLVE, entries)
+	}
+	return nil
+}
+
+// resolveAddr will replace the host of the host:port address with its
+// configured address, if any.
+func resolveAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ip, err := lookupHost(host)
+	if err != nil || ip == "" {
+		return addr, err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
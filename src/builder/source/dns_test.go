@@ -0,0 +1,123 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// resetResolver will restore the default resolution
+func resetResolver() {
+	StaticHosts = nil
+	DoHURL = ""
+	dohCache = make(map[string]string)
+}
+
+func TestStaticHosts(t *testing.T) {
+	defer resetResolver()
+	StaticHosts = map[string]string{
+		"ftp.gnu.org": "209.51.188.20",
+		"example.com": "2001:db8::1",
+	}
+
+	tests := map[string]string{
+		"https://ftp.gnu.org/gnu/nano/nano-2.7.5.tar.xz":      "ftp.gnu.org:443:209.51.188.20",
+		"http://ftp.gnu.org/gnu/nano/nano-2.7.5.tar.xz":       "ftp.gnu.org:80:209.51.188.20",
+		"https://ftp.gnu.org:8443/gnu/nano/nano-2.7.5.tar.xz": "ftp.gnu.org:8443:209.51.188.20",
+		"https://example.com/nano-2.7.5.tar.xz":               "example.com:443:[2001:db8::1]",
+	}
+	for uri, want := range tests {
+		u, _ := url.Parse(uri)
+		entries, err := getResolveEntries(u)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", uri, err)
+		}
+		if len(entries) != 1 || entries[0] != want {
+			t.Fatalf("Expected %s for %s, got %v", want, uri, entries)
+		}
+	}
+
+	// Other hosts use the system resolver
+	u, _ := url.Parse("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz")
+	if entries, err := getResolveEntries(u); err != nil || len(entries) != 0 {
+		t.Fatalf("Unconfigured host should not be pinned: %v %v", entries, err)
+	}
+
+	if addr, err := resolveAddr("ftp.gnu.org:21"); err != nil || addr != "209.51.188.20:21" {
+		t.Fatalf("Failed to resolve FTP address: %s %v", addr, err)
+	}
+	if addr, err := resolveAddr("ftp.example.org:21"); err != nil || addr != "ftp.example.org:21" {
+		t.Fatalf("Unconfigured FTP address should be unchanged: %s %v", addr, err)
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	defer resetResolver()
+
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if r.Header.Get("Accept") != "application/dns-json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name, qtype := r.URL.Query().Get("name"), r.URL.Query().Get("type")
+		switch {
+		case name == "ftp.gnu.org" && qtype == "A":
+			fmt.Fprintf(w, `{"Status":0,"Answer":[{"name":"ftp.gnu.org","type":5,"data":"gnu.org."},{"name":"gnu.org","type":1,"data":"209.51.188.20"}]}`)
+		case name == "v6.example.com" && qtype == "AAAA":
+			fmt.Fprintf(w, `{"Status":0,"Answer":[{"name":"v6.example.com","type":28,"data":"2001:db8::1"}]}`)
+		default:
+			fmt.Fprintf(w, `{"Status":3}`)
+		}
+	}))
+	defer server.Close()
+	DoHURL = server.URL + "/dns-query"
+
+	u, _ := url.Parse("https://ftp.gnu.org/gnu/nano/nano-2.7.5.tar.xz")
+	entries, err := getResolveEntries(u)
+	if err != nil {
+		t.Fatalf("Failed to resolve via DoH: %v", err)
+	}
+	if len(entries) != 1 || entries[0] != "ftp.gnu.org:443:209.51.188.20" {
+		t.Fatalf("Unexpected resolve entries: %v", entries)
+	}
+	// Answers are cached for the session
+	if _, err := getResolveEntries(u); err != nil || queries != 1 {
+		t.Fatalf("DoH answer was not cached: %d queries", queries)
+	}
+
+	u, _ = url.Parse("https://v6.example.com/nano-2.7.5.tar.xz")
+	if entries, err := getResolveEntries(u); err != nil || len(entries) != 1 || entries[0] != "v6.example.com:443:[2001:db8::1]" {
+		t.Fatalf("Failed to resolve IPv6 via DoH: %v %v", entries, err)
+	}
+
+	u, _ = url.Parse("https://missing.example.com/nano-2.7.5.tar.xz")
+	if _, err := getResolveEntries(u); err == nil {
+		t.Fatalf("Unresolvable host should fail")
+	}
+
+	// The static map takes precedence
+	StaticHosts = map[string]string{"missing.example.com": "192.0.2.1"}
+	if entries, err := getResolveEntries(u); err != nil || entries[0] != "missing.example.com:443:192.0.2.1" {
+		t.Fatalf("Static host should take precedence: %v %v", entries, err)
+	}
+}
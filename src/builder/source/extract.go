@@ -0,0 +1,389 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// MaxExtractSize is the most bytes that will be written when extracting
+	// a single archive, guarding against decompression bombs.
+	MaxExtractSize int64 = 32 * 1024 * 1024 * 1024
+
+	// MaxExtractEntries is the most entries that will be extracted from a
+	// single archive.
+	MaxExtractEntries = 1000000
+
+	// DecompressionJobs is the number of threads xz and zstd may use when
+	// decompressing an archive, or 0 to leave it to the tools.
+	DecompressionJobs int
+
+	// ErrExtractTooLarge is returned when an archive exceeds MaxExtractSize
+	ErrExtractTooLarge = errors.New("Archive exceeds the maximum extracted size")
+
+	// ErrTooManyEntries is returned when an archive exceeds MaxExtractEntries
+	ErrTooManyEntries = errors.New("Archive exceeds the maximum number of entries")
+
+	// ErrUnsupportedArchive is returned for archives that cannot be extracted
+	ErrUnsupportedArchive = errors.New("Unsupported archive format")
+)
+
+// archiveSuffixes are the file name suffixes of the supported archives
+var archiveSuffixes = []string{
+	".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst", ".zip",
+}
+
+// TrimArchiveSuffix will return the file name without its archive suffix,
+// and whether it names an archive that ExtractTo supports.
+func TrimArchiveSuffix(name string) (string, bool) {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return name, false
+}
+
+// An UnsafePathError is returned for archive entries that would be written
+// outside of the destination.
+type UnsafePathError struct {
+	Name string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("Refusing to extract unsafe path: %s", e.Name)
+}
+
+// An archiveEntry is a single member of an archive being extracted
+type archiveEntry struct {
+	name     string
+	mode     os.FileMode
+	linkname string // Target of symlinks and hardlinks
+	hardlink bool
+	open     func() (io.ReadCloser, error)
+}
+
+// limitedWriter counts the bytes written, failing once the limit is passed
+type limitedWriter struct {
+	w       io.Writer
+	written *int64
+	limit   int64
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if *l.written+int64(len(b)) > l.limit {
+		return 0, ErrExtractTooLarge
+	}
+	n, err := l.w.Write(b)
+	*l.written += int64(n)
+	return n, err
+}
+
+// cleanMemberName will return the cleaned, relative name of the entry, or
+// an UnsafePathError if it is absolute or escapes the destination.
+func cleanMemberName(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", &UnsafePathError{Name: name}
+	}
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", &UnsafePathError{Name: name}
+	}
+	return clean, nil
+}
+
+// An archiveWalker calls fn for each entry within an archive, until fn
+// returns io.EOF or an error.
+type archiveWalker func(fn func(e *archiveEntry) error) error
+
+// walkArchive will call fn for each entry within the archive, based on its
+// file name, until fn returns io.EOF or an error.
+func walkArchive(archive string, fn func(e *archiveEntry) error) error {
+	if strings.HasSuffix(archive, ".zip") {
+		return walkZip(archive, fn)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return walkStream(archive, f, fn)
+}
+
+// walkStream will call fn for each entry within the archive read from r,
+// based on its file name. Zip files need random access, so cannot be read
+// as a stream.
+func walkStream(name string, r io.Reader, fn func(e *archiveEntry) error) error {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return walkTar(r, fn)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		return walkTar(gz, fn)
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"):
+		return walkTar(bzip2.NewReader(r), fn)
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return walkCommand(r, fn, "xz", getDecompressArgs("xz")...)
+	case strings.HasSuffix(name, ".tar.zst"):
+		return walkCommand(r, fn, "zstd", getDecompressArgs("zstd")...)
+	default:
+		return ErrUnsupportedArchive
+	}
+}
+
+// getDecompressArgs will return the arguments for xz or zstd to decompress
+// to stdout, using DecompressionJobs threads if set. zstd only decompresses
+// with a single thread, and warns when asked for more.
+func getDecompressArgs(tool string) []string {
+	args := []string{"-dc"}
+	if DecompressionJobs > 0 && tool == "xz" {
+		args = append(args, fmt.Sprintf("-T%d", DecompressionJobs))
+	}
+	return args
+}
+
+// walkTar will walk each entry of the decompressed tarball
+func walkTar(r io.Reader, fn func(e *archiveEntry) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := &archiveEntry{
+			name:     hdr.Name,
+			mode:     hdr.FileInfo().Mode(),
+			linkname: hdr.Linkname,
+			hardlink: hdr.Typeflag == tar.TypeLink,
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(tr), nil
+			},
+		}
+		if err := fn(e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// walkCommand will walk the tarball decompressed from r by the command,
+// for formats without a decompressor in the standard library.
+func walkCommand(r io.Reader, fn func(e *archiveEntry) error, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	c.Stdin = r
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	if err := walkTar(out, fn); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return err
+	}
+	// Consume any trailing padding so the command may finish
+	if _, err := io.Copy(ioutil.Discard, out); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return err
+	}
+	if err := c.Wait(); err != nil {
+		return fmt.Errorf("%s failed to decompress archive: %v", name, err)
+	}
+	return nil
+}
+
+// walkZip will walk each entry of the zip file
+func walkZip(archive string, fn func(e *archiveEntry) error) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		e := &archiveEntry{
+			name: zf.Name,
+			mode: zf.Mode(),
+			open: zf.Open,
+		}
+		if e.mode&os.ModeSymlink == os.ModeSymlink {
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			e.linkname = string(target)
+		}
+		if err := fn(e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractTo will extract the archive into the destination directory,
+// enforcing MaxExtractSize and MaxExtractEntries. Entries with absolute
+// paths, or that would escape the destination, are rejected. On failure,
+// everything extracted so far is removed.
+func ExtractTo(archive, dest string) error {
+	if err := os.MkdirAll(dest, 00755); err != nil {
+		return err
+	}
+	walk := func(fn func(e *archiveEntry) error) error {
+		return walkArchive(archive, fn)
+	}
+	var created []string
+	err := extractTo(walk, dest, &created)
+	if err != nil {
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+	}
+	return err
+}
+
+// extractTo does the real work of ExtractTo, recording each path created
+func extractTo(walk archiveWalker, dest string, created *[]string) error {
+	var written int64
+	entries := 0
+
+	return walk(func(e *archiveEntry) error {
+		entries++
+		if entries > MaxExtractEntries {
+			return ErrTooManyEntries
+		}
+		name, err := cleanMemberName(e.name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		target := filepath.Join(dest, name)
+		if err := ensureParents(dest, filepath.Dir(name), created); err != nil {
+			return err
+		}
+
+		switch {
+		case e.mode.IsDir():
+			if PathExists(target) {
+				return nil
+			}
+			if err := os.Mkdir(target, 00755); err != nil {
+				return err
+			}
+		case e.mode&os.ModeSymlink == os.ModeSymlink:
+			// Links may only point within the destination
+			if _, err := cleanMemberName(filepath.Join(filepath.Dir(name), e.linkname)); err != nil || filepath.IsAbs(e.linkname) {
+				return &UnsafePathError{Name: e.name + " -> " + e.linkname}
+			}
+			if err := os.Symlink(e.linkname, target); err != nil {
+				return err
+			}
+		case e.hardlink:
+			link, err := cleanMemberName(e.linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(dest, link), target); err != nil {
+				return err
+			}
+		case e.mode.IsRegular():
+			if err := extractFile(e, target, &written); err != nil {
+				*created = append(*created, target)
+				return err
+			}
+		default:
+			// Devices, fifos, etc. have no place in a source tree
+			return nil
+		}
+		*created = append(*created, target)
+		return nil
+	})
+}
+
+// ensureParents will create each missing parent directory of the entry
+func ensureParents(dest, dir string, created *[]string) error {
+	if dir == "." {
+		return nil
+	}
+	if err := ensureParents(dest, filepath.Dir(dir), created); err != nil {
+		return err
+	}
+	path := filepath.Join(dest, dir)
+	if st, err := os.Lstat(path); err == nil {
+		// Never follow a link out of the destination
+		if !st.IsDir() {
+			return &UnsafePathError{Name: dir}
+		}
+		return nil
+	}
+	if err := os.Mkdir(path, 00755); err != nil {
+		return err
+	}
+	*created = append(*created, path)
+	return nil
+}
+
+// extractFile will write a single regular file, counting its size
+func extractFile(e *archiveEntry, target string, written *int64) error {
+	rc, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.mode.Perm()|00200)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(&limitedWriter{w: out, written: written, limit: MaxExtractSize}, rc); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,206 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testMember struct {
+	name string
+	size int64 // Bytes of zeroes, when no body is given
+	body string
+}
+
+// writeTestTarball will write a gzipped tarball of the members
+func writeTestTarball(t *testing.T, path string, members []testMember) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create tarball: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		var r io.Reader = strings.NewReader(m.body)
+		size := int64(len(m.body))
+		if m.body == "" {
+			r = io.LimitReader(zeroReader{}, m.size)
+			size = m.size
+		}
+		hdr := &tar.Header{Name: m.name, Mode: 00644, Size: size, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			t.Fatalf("Failed to write member: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tarball: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close tarball: %v", err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestDecompressArgs(t *testing.T) {
+	defer func() {
+		DecompressionJobs = 0
+	}()
+	if args := strings.Join(getDecompressArgs("xz"), " "); args != "-dc" {
+		t.Fatalf("Threads should be left to the tools by default: %s", args)
+	}
+	DecompressionJobs = 4
+	if args := strings.Join(getDecompressArgs("xz"), " "); args != "-dc -T4" {
+		t.Fatalf("Wrong decompression arguments: %s", args)
+	}
+	if args := strings.Join(getDecompressArgs("zstd"), " "); args != "-dc" {
+		t.Fatalf("zstd cannot decompress with threads: %s", args)
+	}
+}
+
+func TestExtractTo(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-extract")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := filepath.Join(tmp, "nano-2.7.5.tar.gz")
+	writeTestTarball(t, archive, []testMember{
+		{name: "nano-2.7.5/README", body: "nano"},
+		{name: "nano-2.7.5/src/nano.c", body: "int main;"},
+	})
+	dest := filepath.Join(tmp, "out")
+	if err := ExtractTo(archive, dest); err != nil {
+		t.Fatalf("Failed to extract archive: %v", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "nano-2.7.5", "src", "nano.c")); err != nil || string(b) != "int main;" {
+		t.Fatalf("Extracted file has wrong contents: %q %v", b, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "nano-2.7.5", "README")); err != nil || string(b) != "nano" {
+		t.Fatalf("Extracted file has wrong contents: %q %v", b, err)
+	}
+	if name, ok := TrimArchiveSuffix("nano-2.7.5.tar.gz"); !ok || name != "nano-2.7.5" {
+		t.Fatalf("Wrong archive name: %s", name)
+	}
+	if _, ok := TrimArchiveSuffix("nano.desktop"); ok {
+		t.Fatalf("Only archives should have an archive suffix")
+	}
+}
+
+func TestExtractBomb(t *testing.T) {
+	oldSize := MaxExtractSize
+	defer func() { MaxExtractSize = oldSize }()
+	MaxExtractSize = 1024 * 1024
+
+	tmp, err := ioutil.TempDir("", "solbuild-extract")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// 32MiB of zeroes compresses to a few dozen KiB
+	archive := filepath.Join(tmp, "bomb.tar.gz")
+	writeTestTarball(t, archive, []testMember{
+		{name: "bomb/small", body: "nano"},
+		{name: "bomb/zeroes", size: 32 * 1024 * 1024},
+	})
+	if st, err := os.Stat(archive); err != nil || st.Size() >= MaxExtractSize {
+		t.Fatalf("Bomb archive is not compact: %v", err)
+	}
+
+	dest := filepath.Join(tmp, "out")
+	if err := ExtractTo(archive, dest); err != ErrExtractTooLarge {
+		t.Fatalf("Expected bomb to be rejected, got: %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(dest); len(entries) != 0 {
+		t.Fatalf("Partial output was not cleaned up: %d entries remain", len(entries))
+	}
+}
+
+func TestExtractTooManyEntries(t *testing.T) {
+	oldEntries := MaxExtractEntries
+	defer func() { MaxExtractEntries = oldEntries }()
+	MaxExtractEntries = 2
+
+	tmp, err := ioutil.TempDir("", "solbuild-extract")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := filepath.Join(tmp, "many.tar.gz")
+	writeTestTarball(t, archive, []testMember{
+		{name: "a", body: "a"},
+		{name: "b", body: "b"},
+		{name: "c", body: "c"},
+	})
+	if err := ExtractTo(archive, filepath.Join(tmp, "out")); err != ErrTooManyEntries {
+		t.Fatalf("Expected too many entries, got: %v", err)
+	}
+}
+
+func TestExtractUnsafePaths(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-extract")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, name := range []string{"/etc/passwd", "../escape", "nano/../../escape"} {
+		archive := filepath.Join(tmp, "unsafe.zip")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatalf("Failed to create zip: %v", err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add zip member: %v", err)
+		}
+		w.Write([]byte("nano"))
+		zw.Close()
+		f.Close()
+
+		err = ExtractTo(archive, filepath.Join(tmp, "out"))
+		if _, ok := err.(*UnsafePathError); !ok {
+			t.Fatalf("Expected unsafe path error for %s, got: %v", name, err)
+		}
+		if PathExists(filepath.Join(tmp, "escape")) {
+			t.Fatalf("Member %s escaped the destination", name)
+		}
+	}
+}
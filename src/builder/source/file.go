@@ -0,0 +1,114 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// getLocalPath will return the path of a file:// source, which must be an
+// absolute path on this machine.
+func (s *SimpleSource) getLocalPath() (string, error) {
+	if s.url.Host != "" && s.url.Host != "localhost" {
+		return "", fmt.Errorf("Only local file:// sources are supported: %s", s.URI)
+	}
+	if !filepath.IsAbs(s.url.Path) {
+		return "", fmt.Errorf("file:// sources require an absolute path: %s", s.URI)
+	}
+	return filepath.Clean(s.url.Path), nil
+}
+
+// statLocal will return the details of the local file for a file:// source,
+// ensuring it is a regular file.
+func (s *SimpleSource) statLocal() (string, os.FileInfo, error) {
+	path, err := s.getLocalPath()
+	if err != nil {
+		return "", nil, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !st.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("Source is not a regular file: %s", path)
+	}
+	return path, st, nil
+}
+
+// downloadFile will place a file:// source at the destination, hardlinking
+// it where possible and otherwise copying it, i.e. across filesystems.
+func (s *SimpleSource) downloadFile(destination string) error {
+	path, st, err := s.statLocal()
+	if err != nil {
+		return err
+	}
+
+	// A partial copy is never resumed
+	os.Remove(destination)
+	discardHashCheckpoint(destination)
+
+	if err := os.Link(path, destination); err == nil {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Debug("Hardlinked local source")
+		s.reportProgress(st.Size(), st.Size())
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 00644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("Copying local source")
+	reader := &progressReader{
+		Reader: in,
+		total:  st.Size(),
+		fn:     s.reportProgress,
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// streamFile will write the local file of a file:// source to w
+func (s *SimpleSource) streamFile(w io.Writer) error {
+	path, _, err := s.statLocal()
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -0,0 +1,96 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchFile(t *testing.T) {
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	tarball := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(tarball, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+
+	src, err := New("file://"+tarball, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	simple := src.(*SimpleSource)
+	if err := simple.Validate(); err != nil {
+		t.Fatalf("Local source should be valid: %v", err)
+	}
+	if size, err := simple.GetRemoteSize(); err != nil || size != 4 {
+		t.Fatalf("Wrong size for local source: %d (%v)", size, err)
+	}
+
+	// Local sources may still be fetched offline
+	Offline = true
+	defer func() {
+		Offline = false
+	}()
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch local source: %v", err)
+	}
+	if !src.IsFetched() {
+		t.Fatalf("Local source was not cached")
+	}
+
+	// Same filesystem, so the cache holds a hardlink
+	cached, err := os.Stat(simple.GetPath(nanoSHA256))
+	if err != nil {
+		t.Fatalf("Failed to stat cached source: %v", err)
+	}
+	orig, err := os.Stat(tarball)
+	if err != nil {
+		t.Fatalf("Failed to stat tarball: %v", err)
+	}
+	if !os.SameFile(cached, orig) {
+		t.Fatalf("Local source should be hardlinked into the cache")
+	}
+	if !PathExists(tarball) {
+		t.Fatalf("Local source was moved away")
+	}
+
+	// Local files are verified like any other source
+	bad, err := NewSimple("file://"+tarball, "0000000000000000000000000000000000000000000000000000000000000000", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := bad.Fetch(); err == nil {
+		t.Fatalf("Local source with the wrong hash should be rejected")
+	}
+	if !PathExists(tarball) {
+		t.Fatalf("Rejected local source was removed")
+	}
+
+	for _, uri := range []string{"file://nano-2.7.5.tar.xz", "file://example.com/nano-2.7.5.tar.xz", "file://" + tmp} {
+		src, err := NewSimple(uri, nanoSHA256, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		if err := src.Fetch(); err == nil {
+			t.Fatalf("Invalid local source should be rejected: %s", uri)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// queryNameKeys are the query parameters download scripts name the file by
+var queryNameKeys = []string{"filename", "file"}
+
+// isSafeFileName will determine if the name may be used as is in the cache
+func isSafeFileName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00")
+}
+
+// isDownloadQuery will determine if the URL is a download script with a
+// query, rather than a scheme that names the file in its query itself.
+func isDownloadQuery(u *url.URL) bool {
+	return u.RawQuery != "" && u.Scheme != "magnet" && u.Scheme != "ipfs"
+}
+
+// getQueryName will return the file named by the query of a download URL,
+// i.e. https://host/download?file=foo-1.0.tar.xz
+func getQueryName(u *url.URL) (string, bool) {
+	if !isDownloadQuery(u) {
+		return "", false
+	}
+	query := u.Query()
+	for _, key := range queryNameKeys {
+		if name := path.Base(query.Get(key)); isSafeFileName(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// getDispositionName will return the file name given by the last
+// Content-Disposition header of the response, if any.
+func getDispositionName(headers []string) string {
+	name := ""
+	for _, header := range headers {
+		i := strings.Index(header, ":")
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(header[:i]), "Content-Disposition") {
+			continue
+		}
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(header[i+1:]))
+		if err != nil {
+			continue
+		}
+		if base := path.Base(params["filename"]); isSafeFileName(base) {
+			name = base
+		}
+	}
+	return name
+}
+
+// applyDispositionName will rename the staged download to the name the
+// server gave it, when the URL itself didn't name the file.
+func (s *SimpleSource) applyDispositionName(staged string) (string, error) {
+	if !s.guessedName || s.disposition == "" || s.disposition == s.File {
+		return staged, nil
+	}
+	renamed := filepath.Join(filepath.Dir(staged), s.disposition)
+	discardHashCheckpoint(staged)
+	if err := os.Rename(staged, renamed); err != nil {
+		return staged, err
+	}
+	s.File = s.disposition
+	return renamed, nil
+}
+
+// findCachedName will find the name a source was cached under when the
+// server named it, from the provenance of the files in its hash directory.
+func (s *SimpleSource) findCachedName(hash string) (string, bool) {
+	entries, err := ioutil.ReadDir(filepath.Join(SourceDir, hash))
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || isProvenanceFile(entry.Name()) {
+			continue
+		}
+		prov, err := readProvenance(filepath.Join(SourceDir, hash, entry.Name()))
+		if err == nil && prov.URI == s.URI {
+			return entry.Name(), true
+		}
+	}
+	return "", false
+}
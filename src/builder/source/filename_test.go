@@ -0,0 +1,113 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryName(t *testing.T) {
+	src, err := NewSimple("https://example.com/download?file=nano-2.7.5.tar.xz&mirror=1", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.File != "nano-2.7.5.tar.xz" || src.guessedName {
+		t.Fatalf("Source should be named by the query: %s", src.File)
+	}
+
+	src, err = NewSimple("https://example.com/download.php?id=42", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.File != "download.php" || !src.guessedName {
+		t.Fatalf("Source without a query name should be named when fetched: %s", src.File)
+	}
+
+	src, err = NewSimple("https://example.com/download.php?file=../../etc/passwd", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.File != "passwd" {
+		t.Fatalf("Query name should be reduced to its base: %s", src.File)
+	}
+}
+
+func TestDispositionName(t *testing.T) {
+	headers := []string{
+		"HTTP/1.1 302 Found\r\n",
+		"Content-Disposition: attachment; filename=\"redirect.html\"\r\n",
+		"HTTP/1.1 200 OK\r\n",
+		"content-disposition: attachment; filename*=UTF-8''nano-2.7.5.tar.xz\r\n",
+	}
+	if name := getDispositionName(headers); name != "nano-2.7.5.tar.xz" {
+		t.Fatalf("Wrong name from Content-Disposition: %s", name)
+	}
+	for _, header := range []string{
+		"Content-Disposition: attachment; filename=\"../..\"\r\n",
+		"Content-Disposition: attachment; filename=\".bashrc\"\r\n",
+		"Content-Disposition: inline\r\n",
+	} {
+		if name := getDispositionName([]string{header}); name != "" {
+			t.Fatalf("Unsafe name should be ignored: %s", name)
+		}
+	}
+}
+
+func TestFetchDispositionName(t *testing.T) {
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	uri := "https://example.com/download.php?id=42"
+	src, err := NewSimple(uri, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	staged := filepath.Join(GetStagingDir(), src.File)
+	if err := ioutil.WriteFile(staged, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to stage source: %v", err)
+	}
+	src.disposition = "nano-2.7.5.tar.xz"
+	if staged, err = src.applyDispositionName(staged); err != nil {
+		t.Fatalf("Failed to rename staged source: %v", err)
+	}
+	if filepath.Base(staged) != "nano-2.7.5.tar.xz" || src.File != "nano-2.7.5.tar.xz" {
+		t.Fatalf("Source should take the name given by the server: %s", staged)
+	}
+
+	// Cache it as Fetch does, then find it again from a fresh source
+	hashDir := filepath.Join(SourceDir, nanoSHA256)
+	if err := os.MkdirAll(hashDir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+	if err := storeSource(staged, hashDir, src.File); err != nil {
+		t.Fatalf("Failed to store source: %v", err)
+	}
+	if err := writeProvenance(filepath.Join(hashDir, src.File), src.newProvenance(nanoSHA256, nanoSHA256)); err != nil {
+		t.Fatalf("Failed to write provenance: %v", err)
+	}
+
+	src, err = NewSimple(uri, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !src.IsFetched() || src.File != "nano-2.7.5.tar.xz" {
+		t.Fatalf("Source should be found under the name given by the server: %s", src.File)
+	}
+}
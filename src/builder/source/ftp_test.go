@@ -0,0 +1,353 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockFTPServer is a minimal FTP server serving a single file
+type mockFTPServer struct {
+	listener net.Listener
+	name     string
+	contents []byte
+	rest     bool     // Whether REST is supported
+	noEPSV   bool     // Whether EPSV is rejected
+	offsets  []uint64 // Offsets requested via REST
+	modes    []string // Commands used to set up data connections
+	busy     int      // Connections to turn away as if overloaded
+	busyLock sync.Mutex
+}
+
+func newMockFTPServer(t *testing.T, name string, contents []byte, rest bool) *mockFTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	m := &mockFTPServer{listener: l, name: name, contents: contents, rest: rest}
+	go m.serve()
+	return m
+}
+
+func (m *mockFTPServer) URL() string {
+	return fmt.Sprintf("ftp://%s/%s", m.listener.Addr().String(), m.name)
+}
+
+// setBusy will turn away the next n connections
+func (m *mockFTPServer) setBusy(n int) {
+	m.busyLock.Lock()
+	defer m.busyLock.Unlock()
+	m.busy = n
+}
+
+// turnAway will determine whether the connection should be turned away
+func (m *mockFTPServer) turnAway() bool {
+	m.busyLock.Lock()
+	defer m.busyLock.Unlock()
+	if m.busy > 0 {
+		m.busy--
+		return true
+	}
+	return false
+}
+
+func (m *mockFTPServer) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(conn)
+	}
+}
+
+func (m *mockFTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	var data net.Listener
+	var active string
+	var offset uint64
+
+	if m.turnAway() {
+		reply("421 Too many users, try again later")
+		return
+	}
+	reply("220 Ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		cmd := strings.ToUpper(fields[0])
+		switch cmd {
+		case "USER":
+			reply("331 Password required")
+		case "PASS":
+			reply("230 Logged in")
+		case "FEAT":
+			if m.rest {
+				reply("211-Features:\r\n REST STREAM\r\n211 End")
+			} else {
+				reply("211 No features")
+			}
+		case "TYPE", "OPTS":
+			reply("200 OK")
+		case "SIZE":
+			reply("213 %d", len(m.contents))
+		case "EPSV", "PASV":
+			if cmd == "EPSV" && m.noEPSV {
+				reply("502 Command not implemented")
+				continue
+			}
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 Cannot open data connection")
+				continue
+			}
+			m.modes = append(m.modes, cmd)
+			port := data.Addr().(*net.TCPAddr).Port
+			if cmd == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				// Report a private address, as a server behind NAT would
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "PORT":
+			f := strings.Split(fields[1], ",")
+			p1, _ := strconv.Atoi(f[4])
+			p2, _ := strconv.Atoi(f[5])
+			active = fmt.Sprintf("%s:%d", strings.Join(f[:4], "."), p1<<8|p2)
+			m.modes = append(m.modes, cmd)
+			reply("200 PORT command successful")
+		case "REST":
+			if !m.rest {
+				reply("502 Command not implemented")
+				continue
+			}
+			offset, _ = strconv.ParseUint(fields[1], 10, 64)
+			m.offsets = append(m.offsets, offset)
+			reply("350 Restarting at %d", offset)
+		case "LIST", "RETR":
+			if data == nil && active == "" {
+				reply("425 Use EPSV or PORT first")
+				continue
+			}
+			reply("150 Opening data connection")
+			var dconn net.Conn
+			if data != nil {
+				dconn, err = data.Accept()
+				data.Close()
+			} else {
+				dconn, err = net.Dial("tcp", active)
+			}
+			if err == nil {
+				if cmd == "LIST" {
+					fmt.Fprintf(dconn, "-rw-r--r-- 1 ftp ftp %d Jan 01 2017 %s\r\n", len(m.contents), m.name)
+				} else {
+					dconn.Write(m.contents[offset:])
+				}
+				dconn.Close()
+			}
+			data, active, offset = nil, "", 0
+			reply("226 Transfer complete")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestResumeFTP(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	partial := contents[:10]
+
+	for _, rest := range []bool{true, false} {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.xz", contents, rest)
+		defer server.listener.Close()
+
+		tmp, err := ioutil.TempDir("", "solbuild-ftp")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		src, err := NewSimple(server.URL(), "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		dest := filepath.Join(tmp, src.File)
+		if err := ioutil.WriteFile(dest, partial, 00644); err != nil {
+			t.Fatalf("Failed to write partial download: %v", err)
+		}
+		if err := src.download(dest); err != nil {
+			t.Fatalf("Failed to download (REST: %v): %v", rest, err)
+		}
+		if got, _ := ioutil.ReadFile(dest); string(got) != string(contents) {
+			t.Fatalf("Corrupt download (REST: %v): %s", rest, got)
+		}
+		if rest && (len(server.offsets) != 1 || server.offsets[0] != uint64(len(partial))) {
+			t.Fatalf("Download was not resumed: %v", server.offsets)
+		}
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-ftp")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(path, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	good, _ := NewSimple("ftp://example.com/nano-2.7.5.tar.xz", "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762", false)
+	bad, _ := NewSimple("ftp://example.com/nano-2.7.5.tar.xz", "0000000000000000000000000000000000000000000000000000000000000000", false)
+	legacy, _ := NewSimple("ftp://example.com/nano-2.7.5.tar.xz", "e6efbd8aed7a6a63e6ec49365245a32bdc913b43", true)
+	badLegacy, _ := NewSimple("ftp://example.com/nano-2.7.5.tar.xz", "0000000000000000000000000000000000000000", true)
+
+	hash, _ := good.GetSHA256Sum(path)
+	if err := good.verify(path, hash); err != nil {
+		t.Fatalf("Valid download rejected: %v", err)
+	}
+	if err := bad.verify(path, hash); err == nil {
+		t.Fatalf("Corrupt download should be rejected")
+	}
+	if err := legacy.verify(path, hash); err != nil {
+		t.Fatalf("Valid legacy download rejected: %v", err)
+	}
+	if err := badLegacy.verify(path, hash); err == nil {
+		t.Fatalf("Corrupt legacy download should be rejected")
+	}
+}
+
+func TestFTPModes(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	defer SetFTPMode(FTPModePassive)
+
+	tests := []struct {
+		mode   string
+		noEPSV bool
+		want   string
+	}{
+		{FTPModePassive, false, "EPSV"},
+		{FTPModePassive, true, "PASV"},
+		{FTPModeActive, false, "PORT"},
+	}
+	for _, test := range tests {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.xz", contents, true)
+		server.noEPSV = test.noEPSV
+		defer server.listener.Close()
+
+		tmp, err := ioutil.TempDir("", "solbuild-ftp")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		if err := SetFTPMode(test.mode); err != nil {
+			t.Fatalf("Failed to set FTP mode: %v", err)
+		}
+		src, err := NewSimple(server.URL(), "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		dest := filepath.Join(tmp, src.File)
+		if err := src.download(dest); err != nil {
+			t.Fatalf("Failed to download (%s): %v", test.want, err)
+		}
+		if got, _ := ioutil.ReadFile(dest); string(got) != string(contents) {
+			t.Fatalf("Corrupt download (%s): %s", test.want, got)
+		}
+		if len(server.modes) != 1 || server.modes[0] != test.want {
+			t.Fatalf("Expected data connection via %s, got %v", test.want, server.modes)
+		}
+	}
+}
+
+func TestRemoteSizeFTP(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	defer SetFTPMode(FTPModePassive)
+
+	for _, mode := range []string{FTPModePassive, FTPModeActive} {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.xz", contents, true)
+		defer server.listener.Close()
+		if err := SetFTPMode(mode); err != nil {
+			t.Fatalf("Failed to set FTP mode: %v", err)
+		}
+
+		src, err := NewSimple(server.URL(), "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		if size, err := src.GetRemoteSize(); err != nil || size != int64(len(contents)) {
+			t.Fatalf("Wrong remote size (%s): %d %v", mode, size, err)
+		}
+		if _, size, err := src.CheckRemote(); err != nil || size != int64(len(contents)) {
+			t.Fatalf("Wrong size when checking remote (%s): %d %v", mode, size, err)
+		}
+		// SIZE needs no data connection, whatever the mode
+		if len(server.modes) != 0 {
+			t.Fatalf("Size lookup opened a data connection (%s): %v", mode, server.modes)
+		}
+	}
+}
+
+func TestParsePassiveReplies(t *testing.T) {
+	if port, err := parsePASV("Entering Passive Mode (10,0,0,1,4,1)."); err != nil || port != 1025 {
+		t.Fatalf("Failed to parse PASV reply: %d %v", port, err)
+	}
+	if port, err := parseEPSV("Entering Extended Passive Mode (|||1025|)"); err != nil || port != 1025 {
+		t.Fatalf("Failed to parse EPSV reply: %d %v", port, err)
+	}
+	for _, msg := range []string{"Entering Passive Mode", "(10,0,0,1,4)", "(1,2,3,4,a,b)"} {
+		if _, err := parsePASV(msg); err == nil {
+			t.Fatalf("Invalid PASV reply should fail: %s", msg)
+		}
+	}
+	if _, err := parseEPSV("(|1025|)"); err == nil {
+		t.Fatalf("Invalid EPSV reply should fail")
+	}
+}
+
+func TestSetFTPMode(t *testing.T) {
+	defer SetFTPMode(FTPModePassive)
+	if err := SetFTPMode("Active"); err != nil || FTPMode != FTPModeActive {
+		t.Fatalf("Failed to set active mode: %v", err)
+	}
+	if err := SetFTPMode(""); err != nil || FTPMode != FTPModePassive {
+		t.Fatalf("Empty mode should default to passive: %v", err)
+	}
+	if err := SetFTPMode("sideways"); err == nil {
+		t.Fatalf("Invalid mode should be rejected")
+	}
+}
@@ -0,0 +1,279 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FTPModePassive has the server open the data port (EPSV, then PASV),
+	// which works behind NAT.
+	FTPModePassive = "passive"
+
+	// FTPModeActive has the server connect back to us (PORT or EPRT)
+	FTPModeActive = "active"
+)
+
+// FTPMode controls how data connections are established for FTP downloads
+var FTPMode = FTPModePassive
+
+// SetFTPMode will validate and set the FTP data connection mode
+func SetFTPMode(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "":
+		FTPMode = FTPModePassive
+	case FTPModePassive, FTPModeActive:
+		FTPMode = mode
+	default:
+		return fmt.Errorf("Unknown FTP mode: %s", mode)
+	}
+	return nil
+}
+
+// ErrFTPNoRestart is returned when the server cannot resume a download
+var ErrFTPNoRestart = errors.New("FTP server does not support REST")
+
+// ftpConn is a minimal FTP client for downloads, with explicit control over
+// how the data connection is established.
+type ftpConn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+	mode    string
+	noEPSV  bool // Server rejected EPSV, use PASV instead
+}
+
+// dialFTP will connect to the FTP server and await its greeting
+func dialFTP(addr string, timeout time.Duration, mode string) (*ftpConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{
+		conn:    conn,
+		text:    textproto.NewConn(conn),
+		timeout: timeout,
+		mode:    mode,
+	}
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// cmd will issue a command, returning the reply. An expect of 0 accepts
+// any reply, otherwise it is checked as by textproto.
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+// Login will authenticate with the server, and switch to binary mode
+func (c *ftpConn) Login(username, password string) error {
+	code, msg, err := c.cmd(0, "USER %s", username)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case 230:
+	case 331:
+		if _, _, err := c.cmd(2, "PASS %s", password); err != nil {
+			return err
+		}
+	default:
+		return &textproto.Error{Code: code, Msg: msg}
+	}
+	_, _, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// Size will return the size of the remote file
+func (c *ftpConn) Size(path string) (uint64, error) {
+	_, msg, err := c.cmd(213, "SIZE %s", path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(msg), 10, 64)
+}
+
+// parsePASV will find the port within a PASV reply, i.e.
+// "Entering Passive Mode (127,0,0,1,4,1)". The host is ignored, as servers
+// behind NAT commonly report their private address.
+func parsePASV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("Invalid PASV reply: %s", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("Invalid PASV reply: %s", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("Invalid PASV reply: %s", msg)
+	}
+	return p1<<8 | p2, nil
+}
+
+// parseEPSV will find the port within an EPSV reply, i.e.
+// "Entering Extended Passive Mode (|||1025|)"
+func parseEPSV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("Invalid EPSV reply: %s", msg)
+	}
+	fields := strings.Split(msg[start+1:end], "|")
+	if len(fields) != 5 {
+		return 0, fmt.Errorf("Invalid EPSV reply: %s", msg)
+	}
+	return strconv.Atoi(fields[3])
+}
+
+// openPassive will ask the server for a data port and connect to it,
+// falling back to PASV when the server doesn't support EPSV.
+func (c *ftpConn) openPassive() (net.Conn, error) {
+	var port int
+	var err error
+	if !c.noEPSV {
+		var msg string
+		if _, msg, err = c.cmd(229, "EPSV"); err == nil {
+			port, err = parseEPSV(msg)
+		} else if _, ok := err.(*textproto.Error); ok {
+			c.noEPSV = true
+		}
+	}
+	if c.noEPSV {
+		var msg string
+		if _, msg, err = c.cmd(227, "PASV"); err == nil {
+			port, err = parsePASV(msg)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), c.timeout)
+}
+
+// openActive will listen for the server on the address used for the control
+// connection, and tell the server where to connect via PORT or EPRT.
+func (c *ftpConn) openActive() (net.Listener, error) {
+	local := c.conn.LocalAddr().(*net.TCPAddr)
+	l, err := net.Listen("tcp", net.JoinHostPort(local.IP.String(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	if ip := local.IP.To4(); ip != nil {
+		_, _, err = c.cmd(200, "PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+	} else {
+		_, _, err = c.cmd(200, "EPRT |2|%s|%d|", local.IP.String(), port)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ftpData is an open data connection for a transfer
+type ftpData struct {
+	net.Conn
+	c *ftpConn
+}
+
+// Close will close the data connection and await the transfer result
+func (d *ftpData) Close() error {
+	if err := d.Conn.Close(); err != nil {
+		return err
+	}
+	_, _, err := d.c.text.ReadResponse(2)
+	return err
+}
+
+// Retr will begin retrieving the file from the given offset, returning
+// ErrFTPNoRestart if the server cannot resume.
+func (c *ftpConn) Retr(path string, offset uint64) (io.ReadCloser, error) {
+	var conn net.Conn
+	var listener net.Listener
+	var err error
+
+	if c.mode == FTPModeActive {
+		listener, err = c.openActive()
+	} else {
+		conn, err = c.openPassive()
+	}
+	if err != nil {
+		return nil, err
+	}
+	abort := func() {
+		if conn != nil {
+			conn.Close()
+		}
+		if listener != nil {
+			listener.Close()
+		}
+	}
+
+	if offset > 0 {
+		if _, _, err := c.cmd(350, "REST %d", offset); err != nil {
+			abort()
+			if _, ok := err.(*textproto.Error); ok {
+				return nil, ErrFTPNoRestart
+			}
+			return nil, err
+		}
+	}
+	if _, _, err := c.cmd(1, "RETR %s", path); err != nil {
+		abort()
+		return nil, err
+	}
+
+	if listener != nil {
+		if tcp, ok := listener.(*net.TCPListener); ok && c.timeout > 0 {
+			tcp.SetDeadline(time.Now().Add(c.timeout))
+		}
+		conn, err = listener.Accept()
+		listener.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ftpData{Conn: conn, c: c}, nil
+}
+
+// Quit will end the session and close the connection
+func (c *ftpConn) Quit() error {
+	c.cmd(0, "QUIT")
+	return c.text.Close()
+}
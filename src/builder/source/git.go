@@ -28,12 +28,10 @@ import (
 	"strings"
 )
 
-const (
+var (
 	// GitSourceDir is the base directory for all cached git sources
 	GitSourceDir = "/var/lib/solbuild/sources/git"
-)
 
-var (
 	// ErrGitNoContinue is returned when git processing cannot continue
 	ErrGitNoContinue = errors.New("Fatal errors in git fetch")
 )
@@ -97,6 +95,10 @@ func (g *GitSource) CreateCallbacks() git.RemoteCallbacks {
 // Clone will set do a bare mirror clone of the remote repo to the local
 // cache.
 func (g *GitSource) Clone() error {
+	if err := checkOffline(g.URI); err != nil {
+		return err
+	}
+
 	// Attempt cloning
 	log.WithFields(log.Fields{
 		"uri": g.URI,
@@ -127,6 +129,9 @@ func (g *GitSource) HasTag(repo *git.Repository, tagName string) bool {
 
 // fetch will attempt
 func (g *GitSource) fetch(repo *git.Repository) error {
+	if err := checkOffline(g.URI); err != nil {
+		return err
+	}
 	log.WithFields(log.Fields{
 		"uri": g.URI,
 	}).Debug("Git fetching existing clone")
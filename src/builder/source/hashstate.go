@@ -0,0 +1,135 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"os"
+)
+
+// HashCheckpointSuffix is appended to a staged download to form the name
+// of the checkpoint holding its running sha256sum.
+const HashCheckpointSuffix = ".sha256state"
+
+// errStaleCheckpoint is returned when a checkpoint doesn't match its file
+var errStaleCheckpoint = errors.New("Hash checkpoint does not match the staged file")
+
+// A hashCheckpoint is the persisted state of the sha256sum of a partial
+// download, allowing a resumed download to continue hashing where it left
+// off instead of reading the whole file again.
+type hashCheckpoint struct {
+	Size    int64  `json:"size"`  // Bytes of the staged file that were hashed
+	ModTime int64  `json:"mtime"` // Modification time of the staged file, in nanoseconds
+	State   []byte `json:"state"` // Marshalled state of the hash
+}
+
+// A stagedHash is the running sha256sum of a staged download
+type stagedHash struct {
+	hash.Hash
+	size int64 // Bytes hashed so far
+}
+
+// newStagedHash will return a running hash for a fresh download
+func newStagedHash() *stagedHash {
+	return &stagedHash{Hash: sha256.New()}
+}
+
+// Write will add the data to the hash, counting the bytes hashed
+func (h *stagedHash) Write(b []byte) (int, error) {
+	n, err := h.Hash.Write(b)
+	h.size += int64(n)
+	return n, err
+}
+
+// getHashCheckpointPath will return the checkpoint path for the staged file
+func getHashCheckpointPath(path string) string {
+	return path + HashCheckpointSuffix
+}
+
+// discardHashCheckpoint will remove any checkpoint for the staged file
+func discardHashCheckpoint(path string) {
+	os.Remove(getHashCheckpointPath(path))
+}
+
+// loadHashCheckpoint will restore the running hash of the staged file, as
+// long as the checkpoint was taken when the file was last written.
+func loadHashCheckpoint(path string) (*stagedHash, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(getHashCheckpointPath(path))
+	if err != nil {
+		return nil, err
+	}
+	cp := hashCheckpoint{}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	if cp.Size != st.Size() || cp.ModTime != st.ModTime().UnixNano() {
+		return nil, errStaleCheckpoint
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(cp.State); err != nil {
+		return nil, err
+	}
+	return &stagedHash{Hash: h, size: cp.Size}, nil
+}
+
+// saveHashCheckpoint will persist the running hash of the staged file. If
+// the hash doesn't cover the whole file, i.e. after a failed write, any
+// checkpoint is removed instead.
+func saveHashCheckpoint(path string, h *stagedHash) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Size() != h.size {
+		discardHashCheckpoint(path)
+		return errStaleCheckpoint
+	}
+	state, err := h.Hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&hashCheckpoint{
+		Size:    h.size,
+		ModTime: st.ModTime().UnixNano(),
+		State:   state,
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getHashCheckpointPath(path), b, 00644)
+}
+
+// getStagedSHA256 will return the sha256sum of the staged download from
+// its checkpoint, falling back to hashing the whole file when it's missing
+// or inconsistent. The checkpoint is always removed.
+func (s *SimpleSource) getStagedSHA256(path string) (string, error) {
+	defer discardHashCheckpoint(path)
+	if h, err := loadHashCheckpoint(path); err == nil {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return s.GetSHA256Sum(path)
+}
@@ -0,0 +1,188 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeHashCheckpoint(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	sum := sha256.Sum256(contents)
+	expected := hex.EncodeToString(sum[:])
+
+	// Checkpoints covering the partial file, an earlier part of it, or none
+	for _, checkpointed := range []int{10, 5, -1} {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.xz", contents, true)
+		defer server.listener.Close()
+
+		tmp, err := ioutil.TempDir("", "solbuild-hashstate")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		src, err := NewSimple(server.URL(), "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		dest := filepath.Join(tmp, src.File)
+		if checkpointed >= 0 {
+			if err := ioutil.WriteFile(dest, contents[:checkpointed], 00644); err != nil {
+				t.Fatalf("Failed to write partial download: %v", err)
+			}
+			h := newStagedHash()
+			h.Write(contents[:checkpointed])
+			if err := saveHashCheckpoint(dest, h); err != nil {
+				t.Fatalf("Failed to save hash checkpoint: %v", err)
+			}
+		}
+		if checkpointed != 10 {
+			if err := ioutil.WriteFile(dest, contents[:10], 00644); err != nil {
+				t.Fatalf("Failed to write partial download: %v", err)
+			}
+		}
+
+		if err := src.download(dest); err != nil {
+			t.Fatalf("Failed to download: %v", err)
+		}
+		_, err = loadHashCheckpoint(dest)
+		if resumed := err == nil; resumed != (checkpointed == 10) {
+			t.Fatalf("Hash resumed for %d checkpointed bytes: %v", checkpointed, resumed)
+		}
+		hash, err := src.getStagedSHA256(dest)
+		if err != nil {
+			t.Fatalf("Failed to get digest: %v", err)
+		}
+		full, err := src.GetSHA256Sum(dest)
+		if err != nil {
+			t.Fatalf("Failed to hash download: %v", err)
+		}
+		if hash != expected || full != expected {
+			t.Fatalf("Resumed digest %s differs from full rehash %s", hash, full)
+		}
+		if PathExists(dest + HashCheckpointSuffix) {
+			t.Fatalf("Hash checkpoint was not removed")
+		}
+	}
+}
+
+func TestStaleHashCheckpoint(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-hashstate")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dest := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(dest, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write partial download: %v", err)
+	}
+	h := newStagedHash()
+	h.Write([]byte("nan"))
+	if err := saveHashCheckpoint(dest, h); err != errStaleCheckpoint {
+		t.Fatalf("Partial hash should not be checkpointed: %v", err)
+	}
+	h.Write([]byte("o"))
+	if err := saveHashCheckpoint(dest, h); err != nil {
+		t.Fatalf("Failed to save hash checkpoint: %v", err)
+	}
+
+	// Rewritten since the checkpoint was taken
+	if err := ioutil.WriteFile(dest, []byte("NANO"), 00644); err != nil {
+		t.Fatalf("Failed to rewrite download: %v", err)
+	}
+	st, _ := os.Stat(dest)
+	later := st.ModTime().Add(1e9)
+	os.Chtimes(dest, later, later)
+	if _, err := loadHashCheckpoint(dest); err != errStaleCheckpoint {
+		t.Fatalf("Expected stale checkpoint, got: %v", err)
+	}
+	src := &SimpleSource{}
+	if hash, err := src.getStagedSHA256(dest); err != nil || hash != "3929a2d0db4d8e6375b657dcf56d69a6c6f7476e5e8b7f6453a598b02fe40d1d" {
+		t.Fatalf("Stale checkpoint was used: %s %v", hash, err)
+	}
+}
+
+func TestHashWhileDownloading(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	sum := sha256.Sum256(contents)
+	expected := hex.EncodeToString(sum[:])
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "nano-2.7.5.tar.xz", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-hashstate")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src, err := NewSimple(server.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, src.File)
+	if err := src.downloadCurl(dest); err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+
+	// The digest comes from the download itself, not a second read
+	h, err := loadHashCheckpoint(dest)
+	if err != nil {
+		t.Fatalf("Download was not hashed as it was written: %v", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected || h.size != int64(len(contents)) {
+		t.Fatalf("Wrong running digest: %s", got)
+	}
+
+	// A resumed download continues the checkpointed hash
+	if err := ioutil.WriteFile(dest, contents[:10], 00644); err != nil {
+		t.Fatalf("Failed to write partial download: %v", err)
+	}
+	partial := newStagedHash()
+	partial.Write(contents[:10])
+	if err := saveHashCheckpoint(dest, partial); err != nil {
+		t.Fatalf("Failed to save hash checkpoint: %v", err)
+	}
+	if err := src.downloadCurl(dest); err != nil {
+		t.Fatalf("Failed to resume download: %v", err)
+	}
+	if ranges[len(ranges)-1] != "bytes=10-" {
+		t.Fatalf("Download was not resumed: %v", ranges)
+	}
+	if h, err = loadHashCheckpoint(dest); err != nil || hex.EncodeToString(h.Sum(nil)) != expected {
+		t.Fatalf("Resumed download was not hashed as it was written: %v", err)
+	}
+	hash, err := src.getStagedSHA256(dest)
+	if err != nil || hash != expected {
+		t.Fatalf("Wrong digest of resumed download: %s %v", hash, err)
+	}
+}
@@ -0,0 +1,86 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HostHeaders is a set of custom HTTP headers to send when downloading
+// sources, keyed by the host they apply to. These are only sent to the
+// matching host, so unrelated downloads are unaffected, and are dropped
+// when the host redirects elsewhere.
+var HostHeaders map[string]map[string]string
+
+// RedactedValue replaces the value of custom headers in any log output
+const RedactedValue = "<redacted>"
+
+// SetHeader will add a custom HTTP header for this source alone, taking
+// precedence over any headers configured for the host.
+func (s *SimpleSource) SetHeader(key, value string) {
+	if s.headers == nil {
+		s.headers = make(map[string]string)
+	}
+	s.headers[key] = value
+}
+
+// getHeaders will return the merged set of custom headers for this source,
+// including any needed to authenticate with the host.
+func (s *SimpleSource) getHeaders() map[string]string {
+	ret := make(map[string]string)
+	if s.url != nil {
+		for key, value := range HostHeaders[s.url.Host] {
+			ret[key] = value
+		}
+		if s.url.Port() == "" {
+			for key, value := range HostHeaders[s.url.Hostname()] {
+				ret[key] = value
+			}
+		}
+		if creds, ok := s.getCredentials(); ok {
+			for key, value := range creds.getHeaders() {
+				ret[key] = value
+			}
+		}
+	}
+	for key, value := range s.headers {
+		ret[key] = value
+	}
+	return ret
+}
+
+// formatHeaders will return the headers in a form suitable for curl, sorted
+// by key. If redact is set, the values are hidden for safe logging.
+func formatHeaders(headers map[string]string, redact bool) []string {
+	var keys []string
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ret []string
+	for _, key := range keys {
+		value := headers[key]
+		if redact {
+			value = RedactedValue
+		}
+		ret = append(ret, fmt.Sprintf("%s: %s", strings.TrimSpace(key), value))
+	}
+	return ret
+}
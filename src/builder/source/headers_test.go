@@ -0,0 +1,171 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSourceHeaders(t *testing.T) {
+	HostHeaders = map[string]map[string]string{
+		"gitlab.com": {
+			"PRIVATE-TOKEN": "sekrit",
+		},
+	}
+	defer func() {
+		HostHeaders = nil
+	}()
+
+	private, err := NewSimple("https://gitlab.com/api/v4/projects/1/repository/archive.tar.gz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	private.SetHeader("Authorization", "Bearer token")
+	headers := formatHeaders(private.getHeaders(), false)
+	if strings.Join(headers, "\n") != "Authorization: Bearer token\nPRIVATE-TOKEN: sekrit" {
+		t.Fatalf("Wrong headers for matching source: %v", headers)
+	}
+
+	redacted := strings.Join(formatHeaders(private.getHeaders(), true), "\n")
+	if strings.Contains(redacted, "sekrit") || strings.Contains(redacted, "Bearer") {
+		t.Fatalf("Header values leaked into log output: %v", redacted)
+	}
+	if !strings.Contains(redacted, "PRIVATE-TOKEN: "+RedactedValue) {
+		t.Fatalf("Header names should remain visible: %v", redacted)
+	}
+
+	public, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if headers := public.getHeaders(); len(headers) != 0 {
+		t.Fatalf("Unrelated source should not receive headers: %v", headers)
+	}
+}
+
+// redirectServers serve a file from the source host, which may redirect to
+// it on the same host or on another host, recording the headers of the
+// last request for the file each host received.
+type redirectServers struct {
+	source *httptest.Server
+	other  *httptest.Server
+	lock   sync.Mutex
+	seen   map[string]http.Header
+}
+
+func newRedirectServers() *redirectServers {
+	servers := &redirectServers{seen: make(map[string]http.Header)}
+	serve := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			servers.lock.Lock()
+			servers.seen[name] = r.Header
+			servers.lock.Unlock()
+			w.Write([]byte("nano"))
+		}
+	}
+	other := http.NewServeMux()
+	other.HandleFunc("/nano-2.7.5.tar.xz", serve("other"))
+	servers.other = httptest.NewServer(other)
+
+	source := http.NewServeMux()
+	source.HandleFunc("/nano-2.7.5.tar.xz", serve("source"))
+	source.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/nano-2.7.5.tar.xz", http.StatusFound)
+	})
+	source.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, servers.other.URL+"/nano-2.7.5.tar.xz", http.StatusFound)
+	})
+	servers.source = httptest.NewServer(source)
+	return servers
+}
+
+// Close will shut down both hosts
+func (r *redirectServers) Close() {
+	r.source.Close()
+	r.other.Close()
+}
+
+// getHeader will return the header last received by the named host, and
+// forget it.
+func (r *redirectServers) getHeader(name, key string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	header := r.seen[name]
+	delete(r.seen, name)
+	return header.Get(key)
+}
+
+// fetch will download the path from the source host into dir, ensuring the
+// file arrived intact.
+func (r *redirectServers) fetch(t *testing.T, dir, path string) {
+	src, err := NewSimple(r.source.URL+path, "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	src.SetHeader("X-Source", "nano")
+	dest := filepath.Join(dir, "nano-2.7.5.tar.xz")
+	os.Remove(dest)
+	if err := src.download(dest); err != nil {
+		t.Fatalf("Failed to download %s: %v", path, err)
+	}
+	if contents, _ := ioutil.ReadFile(dest); string(contents) != "nano" {
+		t.Fatalf("Redirect body should not be part of the source: %q", contents)
+	}
+	if _, _, err := src.headRequest(); err != nil {
+		t.Fatalf("Failed to check %s: %v", path, err)
+	}
+}
+
+func TestSourceHeadersRedirect(t *testing.T) {
+	servers := newRedirectServers()
+	defer servers.Close()
+	tmp, err := ioutil.TempDir("", "solbuild-headers")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	HostHeaders = map[string]map[string]string{
+		strings.TrimPrefix(servers.source.URL, "http://"): {
+			"PRIVATE-TOKEN": "sekrit",
+		},
+	}
+	defer func() {
+		HostHeaders = nil
+	}()
+
+	// Redirects within the host keep the headers
+	servers.fetch(t, tmp, "/moved")
+	if token := servers.getHeader("source", "PRIVATE-TOKEN"); token != "sekrit" {
+		t.Fatalf("Source host should receive its headers: '%s'", token)
+	}
+
+	// Another host must never see them
+	servers.fetch(t, tmp, "/elsewhere")
+	for _, key := range []string{"PRIVATE-TOKEN", "X-Source"} {
+		if value := servers.getHeader("other", key); value != "" {
+			t.Fatalf("Header %s leaked to another host: '%s'", key, value)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// HgSourceDir is the base directory for all cached mercurial sources
+	HgSourceDir = "/var/lib/solbuild/sources/hg"
+
+	// ErrHgNoChangeset is returned when the changeset cannot be found, even
+	// after pulling from the remote repository.
+	ErrHgNoChangeset = errors.New("Changeset not found in mercurial repository")
+)
+
+// A HgSource is a mercurial repository referenced by the `ypkg` build spec
+// as `hg|` URI, which must have a changeset to check out. The clone is kept
+// in the cache and pulled into when the changeset is missing, rather than
+// cloned again for each build.
+type HgSource struct {
+	URI       string
+	Changeset string
+	BaseName  string
+	ClonePath string // This is where we will have cloned into
+}
+
+// NewHg will create a new HgSource for the given URI & changeset
+func NewHg(uri, changeset string) (*HgSource, error) {
+	if err := checkRepositoryURI(uri); err != nil {
+		return nil, err
+	}
+	urlObj, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	bs := filepath.Base(urlObj.Path)
+
+	return &HgSource{
+		URI:       uri,
+		Changeset: changeset,
+		BaseName:  bs,
+		ClonePath: filepath.Join(HgSourceDir, urlObj.Host, filepath.Dir(urlObj.Path), bs),
+	}, nil
+}
+
+// resolve will return the full node ID of the revision within the local
+// clone, or an error if it isn't known.
+func (h *HgSource) resolve(rev string) (string, error) {
+	out, err := exec.Command("hg", "log", "-R", h.ClonePath, "-r", rev, "--template", "{node}").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// hasChangeset will determine whether the changeset is in the local clone
+func (h *HgSource) hasChangeset() bool {
+	node, err := h.resolve(h.Changeset)
+	return err == nil && node != ""
+}
+
+// Clone will clone the remote repository into the cache, without checking
+// out a working copy until the changeset is known.
+func (h *HgSource) Clone() error {
+	if err := checkOffline(h.URI); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uri": h.URI,
+	}).Debug("Cloning mercurial source")
+	if err := os.MkdirAll(filepath.Dir(h.ClonePath), 00755); err != nil {
+		return err
+	}
+	return commands.ExecStdoutArgs("hg", []string{"clone", "--noupdate", "--", h.URI, h.ClonePath})
+}
+
+// pull will fetch any new changesets into the existing clone
+func (h *HgSource) pull() error {
+	if err := checkOffline(h.URI); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uri": h.URI,
+	}).Debug("Pulling into existing mercurial clone")
+	return commands.ExecStdoutArgs("hg", []string{"pull", "-R", h.ClonePath, "--", h.URI})
+}
+
+// update will check out the changeset, discarding any local changes and
+// untracked files from earlier builds.
+func (h *HgSource) update() error {
+	if err := commands.ExecStdoutArgs("hg", []string{"update", "-R", h.ClonePath, "--clean", "-r", h.Changeset}); err != nil {
+		return err
+	}
+	return commands.ExecStdoutArgs("hg", []string{"--config", "extensions.purge=", "purge", "-R", h.ClonePath, "--all"})
+}
+
+// Fetch will clone the repository if it isn't cached yet, otherwise pull
+// into the existing clone when the changeset is missing, then check out
+// the changeset.
+func (h *HgSource) Fetch() error {
+	if !PathExists(h.ClonePath) {
+		if err := h.Clone(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"uri":   h.URI,
+			}).Error("Failed to clone remote repository")
+			return err
+		}
+	} else if !h.hasChangeset() {
+		if err := h.pull(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"uri":   h.URI,
+			}).Error("Failed to pull remote repository")
+			return err
+		}
+	}
+
+	if !h.hasChangeset() {
+		return ErrHgNoChangeset
+	}
+	return h.update()
+}
+
+// IsFetched will check that the working copy is at the changeset, without
+// touching the network.
+func (h *HgSource) IsFetched() bool {
+	if !PathExists(h.ClonePath) {
+		return false
+	}
+	wanted, err := h.resolve(h.Changeset)
+	if err != nil {
+		return false
+	}
+	current, err := h.resolve(".")
+	return err == nil && current == wanted
+}
+
+// GetBindConfiguration will return a config that enables bind mounting
+// the mercurial clone from the host side into the container.
+func (h *HgSource) GetBindConfiguration(sourcedir string) BindConfiguration {
+	return BindConfiguration{
+		h.ClonePath,
+		filepath.Join(sourcedir, h.BaseName),
+	}
+}
+
+// GetIdentifier will return a human readable string to represent this
+// mercurial source in the event of errors.
+func (h *HgSource) GetIdentifier() string {
+	return fmt.Sprintf("%s#%s", h.URI, h.Changeset)
+}
+
+// GetValidator will return the changeset that will be checked out
+func (h *HgSource) GetValidator() (string, string) {
+	return "changeset", h.Changeset
+}
+
+// GetCanonicalPath will return the path of the local clone
+func (h *HgSource) GetCanonicalPath() (string, bool, error) {
+	resolved, err := filepath.EvalSymlinks(h.ClonePath)
+	if err != nil {
+		return "", false, err
+	}
+	return resolved, false, nil
+}
+
+// Validate will ensure the mercurial source has a URL and a changeset
+func (h *HgSource) Validate() error {
+	if h.Changeset == "" {
+		return ErrMissingValidator
+	}
+	if u, err := url.Parse(h.URI); err != nil || u.Scheme == "" {
+		return fmt.Errorf("%v: '%s'", ErrUnsupportedScheme, h.URI)
+	}
+	return CheckSecureScheme(h.URI)
+}
@@ -0,0 +1,54 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHg(t *testing.T) {
+	src, err := New("hg|https://hg.mozilla.org/projects/nspr", "a1b2c3d4e5f6", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	hg, ok := src.(*HgSource)
+	if !ok {
+		t.Fatalf("Expected a mercurial source, got %T", src)
+	}
+	if hg.URI != "https://hg.mozilla.org/projects/nspr" || hg.Changeset != "a1b2c3d4e5f6" {
+		t.Fatalf("Wrong source: %+v", hg)
+	}
+	if expected := filepath.Join(HgSourceDir, "hg.mozilla.org", "projects", "nspr"); hg.ClonePath != expected {
+		t.Fatalf("Expected clone at %s, got %s", expected, hg.ClonePath)
+	}
+	if id := hg.GetIdentifier(); id != "https://hg.mozilla.org/projects/nspr#a1b2c3d4e5f6" {
+		t.Fatalf("Wrong identifier: %s", id)
+	}
+	if err := hg.Validate(); err != nil {
+		t.Fatalf("Source should be valid: %v", err)
+	}
+	hg.Changeset = ""
+	if err := hg.Validate(); err != ErrMissingValidator {
+		t.Fatalf("Expected a missing changeset, got: %v", err)
+	}
+
+	// hg must never see the URI as an option
+	if _, err := New("hg|--config=hooks.pre-clone=touch /tmp/pwn", "a1b2c3d4e5f6", false); err == nil {
+		t.Fatalf("URI starting with a dash should be rejected")
+	}
+}
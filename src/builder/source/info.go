@@ -0,0 +1,68 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"path/filepath"
+)
+
+// A ValidatedSource is able to report how it will be validated once fetched
+type ValidatedSource interface {
+	Source
+
+	// GetValidator will return the validation algorithm and the expected
+	// value, i.e. "sha256" and the hash.
+	GetValidator() (string, string)
+}
+
+// Info describes a source and its fetch state, without fetching it.
+type Info struct {
+	Identifier string // Identifier of the source, i.e. the URI
+	File       string // Resolved file name of the source
+	Algorithm  string // Algorithm used to validate the source, if known
+	Validator  string // Expected value for validation, if known
+	CachePath  string // Where the source is cached locally
+	Fetched    bool   // Whether the source is already cached
+}
+
+// GetInfo will return the information for the given source
+func GetInfo(s Source) Info {
+	bind := s.GetBindConfiguration("/")
+	info := Info{
+		Identifier: s.GetIdentifier(),
+		File:       filepath.Base(bind.BindTarget),
+		CachePath:  bind.BindSource,
+		Fetched:    s.IsFetched(),
+	}
+	if v, ok := s.(ValidatedSource); ok {
+		info.Algorithm, info.Validator = v.GetValidator()
+	}
+	return info
+}
+
+// GetValidator will return the hash algorithm and expected hash
+func (s *SimpleSource) GetValidator() (string, string) {
+	if algorithm, ok := s.algorithms[s.validator]; ok {
+		return algorithm, s.validator
+	}
+	return getValidatorAlgorithm(s.validator, s.legacy), s.validator
+}
+
+// GetValidator will return the ref that will be checked out
+func (g *GitSource) GetValidator() (string, string) {
+	return "ref", g.Ref
+}
@@ -0,0 +1,81 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// cachedSource is a fake source with a fixed fetch state
+type cachedSource struct {
+	name    string
+	fetched bool
+}
+
+func (c *cachedSource) IsFetched() bool { return c.fetched }
+func (c *cachedSource) Fetch() error    { return nil }
+func (c *cachedSource) GetIdentifier() string {
+	return "fake://" + c.name
+}
+func (c *cachedSource) GetBindConfiguration(rootfs string) BindConfiguration {
+	return BindConfiguration{
+		BindSource: filepath.Join("/cache", c.name),
+		BindTarget: filepath.Join(rootfs, c.name),
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	simple, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "not-a-real-hash", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	legacy, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "not-a-real-sha1", true)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	git, err := NewGit("https://github.com/solus-project/solbuild.git", "v1.3.0")
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+
+	tests := []struct {
+		src       Source
+		file      string
+		algorithm string
+		path      string
+		fetched   bool
+	}{
+		{&cachedSource{"cached.tar.xz", true}, "cached.tar.xz", "", "/cache/cached.tar.xz", true},
+		{&cachedSource{"uncached.tar.xz", false}, "uncached.tar.xz", "", "/cache/uncached.tar.xz", false},
+		{simple, "nano-2.7.5.tar.xz", "sha256", filepath.Join(SourceDir, "not-a-real-hash", "nano-2.7.5.tar.xz"), false},
+		{legacy, "nano-2.7.5.tar.xz", "sha1", filepath.Join(SourceDir, "not-a-real-sha1", "nano-2.7.5.tar.xz"), false},
+		{git, "solbuild.git", "ref", git.ClonePath, false},
+	}
+	for _, test := range tests {
+		info := GetInfo(test.src)
+		if info.Identifier != test.src.GetIdentifier() {
+			t.Fatalf("Wrong identifier: %v", info.Identifier)
+		}
+		if info.File != test.file || info.Algorithm != test.algorithm || info.CachePath != test.path {
+			t.Fatalf("Wrong info for %s: %+v", info.Identifier, info)
+		}
+		if info.Fetched != test.fetched {
+			t.Fatalf("Wrong fetch state for %s: %v", info.Identifier, info.Fetched)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// IPFSGateway is the HTTP gateway used to fetch ipfs:// sources, unless
+	// IPFSDaemon is set
+	IPFSGateway = "https://ipfs.io"
+
+	// IPFSDaemon fetches ipfs:// sources through the local IPFS daemon with
+	// the ipfs command, which verifies every block against the CID
+	IPFSDaemon = false
+
+	// ErrIPFSUnverified is returned for an ipfs:// source without a
+	// validator when fetched through a gateway, which could serve anything.
+	ErrIPFSUnverified = errors.New("IPFS sources fetched through a gateway require a validator")
+)
+
+// getIPFSName will return the name given to the content by the filename
+// parameter, as used by gateways, when the URL doesn't name a file.
+func getIPFSName(u *url.URL) (string, bool) {
+	if u.Scheme != "ipfs" || (u.Path != "" && u.Path != "/") {
+		return "", false
+	}
+	return filepath.Base(u.Query().Get("filename")), true
+}
+
+// getIPFSPath will return the IPFS path of the source, i.e. /ipfs/CID/file
+func (s *SimpleSource) getIPFSPath() string {
+	return "/ipfs/" + s.url.Host + s.url.Path
+}
+
+// getIPFSKey will return the key the source is cached by when it has no
+// validator, which is the sha256sum of its IPFS path.
+func (s *SimpleSource) getIPFSKey() string {
+	sum := sha256.Sum256([]byte(s.getIPFSPath()))
+	return hex.EncodeToString(sum[:])
+}
+
+// isContentAddressed will determine whether the source is trusted by its
+// CID alone, as it is fetched through the daemon without a validator.
+func (s *SimpleSource) isContentAddressed() bool {
+	return s.url.Scheme == "ipfs" && len(s.validators) == 0
+}
+
+// checkIPFS will ensure an ipfs:// source without a validator is only
+// fetched through the daemon.
+func (s *SimpleSource) checkIPFS() error {
+	if s.isContentAddressed() && !IPFSDaemon {
+		return ErrIPFSUnverified
+	}
+	return nil
+}
+
+// getIPFSSource will return the source to download the content from over
+// HTTP, through the IPFSGateway.
+func (s *SimpleSource) getIPFSSource() (*SimpleSource, error) {
+	if s.url.Host == "" {
+		return nil, fmt.Errorf("IPFS source must name a CID: %s", s.URI)
+	}
+	fetch, err := NewSimple(strings.TrimSuffix(IPFSGateway, "/")+s.getIPFSPath(), "", s.legacy)
+	if err != nil {
+		return nil, err
+	}
+	fetch.File = s.File
+	fetch.headers = s.headers
+	fetch.progress = s.progress
+	return fetch, nil
+}
+
+// downloadIPFS will fetch the content through the local IPFS daemon
+func (s *SimpleSource) downloadIPFS(destination string) error {
+	discardHashCheckpoint(destination)
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	log.WithFields(log.Fields{
+		"uri": s.URI,
+	}).Info("Fetching source from the IPFS daemon")
+	return s.streamIPFS(out)
+}
+
+// streamIPFS will write the content from the local IPFS daemon to w
+func (s *SimpleSource) streamIPFS(w io.Writer) error {
+	var stderr strings.Builder
+	cmd := exec.Command("ipfs", "cat", s.getIPFSPath())
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to fetch %s from IPFS: %v: %s", s.URI, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const nanoCID = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+// fakeIPFS stands in for the ipfs command, printing "nano" for any path
+const fakeIPFS = `#!/bin/sh
+[ "$1" = cat ] || exit 1
+printf nano
+`
+
+func TestIPFSSource(t *testing.T) {
+	oldGateway := IPFSGateway
+	defer func() { IPFSGateway = oldGateway }()
+	IPFSGateway = "https://dweb.link/"
+
+	src, err := NewSimple("ipfs://"+nanoCID+"/nano-2.7.5.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Validate(); err != nil {
+		t.Fatalf("IPFS source with a validator should be valid: %v", err)
+	}
+	fetch, err := src.getFetchSource()
+	if err != nil {
+		t.Fatalf("Failed to get gateway source: %v", err)
+	}
+	if fetch.URI != "https://dweb.link/ipfs/"+nanoCID+"/nano-2.7.5.tar.xz" {
+		t.Fatalf("Wrong gateway URL: %s", fetch.URI)
+	}
+
+	src, err = NewSimple("ipfs://"+nanoCID+"?filename=nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.File != "nano-2.7.5.tar.xz" {
+		t.Fatalf("IPFS source should be named by filename: %s", src.File)
+	}
+	if err := src.Validate(); err != ErrIPFSUnverified {
+		t.Fatalf("Gateway source without a validator should be rejected, got: %v", err)
+	}
+	if err := src.Fetch(); err != ErrIPFSUnverified {
+		t.Fatalf("Gateway source without a validator should not be fetched, got: %v", err)
+	}
+}
+
+func TestFetchIPFSDaemon(t *testing.T) {
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	defer func() { IPFSDaemon = false }()
+	IPFSDaemon = true
+
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+	bin := filepath.Join(tmp, "bin")
+	if err := os.MkdirAll(bin, 00755); err != nil {
+		t.Fatalf("Failed to create bin directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "ipfs"), []byte(fakeIPFS), 00755); err != nil {
+		t.Fatalf("Failed to write ipfs script: %v", err)
+	}
+	os.Setenv("PATH", bin+":"+oldPath)
+
+	src, err := NewSimple("ipfs://"+nanoCID+"?filename=nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Validate(); err != nil {
+		t.Fatalf("Daemon source without a validator should be valid: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch from IPFS: %v", err)
+	}
+	if !PathExists(filepath.Join(SourceDir, nanoSHA256, src.File)) {
+		t.Fatalf("IPFS content was not cached by its hash")
+	}
+
+	// A fresh source finds the content by its IPFS path
+	src, err = NewSimple("ipfs://"+nanoCID+"?filename=nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !src.IsFetched() {
+		t.Fatalf("IPFS source should be found by its path")
+	}
+}
@@ -0,0 +1,102 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// licenseNames are the recognized names of license and notice files, which
+// may be followed by a suffix such as ".txt", "-MIT" or ".LIB".
+var licenseNames = []string{
+	"copying",
+	"copyright",
+	"licence",
+	"license",
+	"notice",
+	"unlicense",
+}
+
+// IsLicenseFile will determine whether the path names a license or notice
+// file, ignoring case.
+func IsLicenseFile(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	for _, name := range licenseNames {
+		if base == name {
+			return true
+		}
+		if strings.HasPrefix(base, name) && strings.ContainsRune(".-_", rune(base[len(name)])) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindLicenseFiles will return the license and notice files within the
+// extracted source tree, relative to it and sorted.
+func FindLicenseFiles(dir string) ([]string, error) {
+	var licenses []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !fi.Mode().IsRegular() || !IsLicenseFile(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		licenses = append(licenses, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(licenses)
+	return licenses, nil
+}
+
+// ListArchiveLicenses will return the license and notice files that the
+// archive would extract, sorted, without extracting it.
+func ListArchiveLicenses(archive string) ([]string, error) {
+	var licenses []string
+	entries := 0
+	err := walkArchive(archive, func(e *archiveEntry) error {
+		entries++
+		if entries > MaxExtractEntries {
+			return ErrTooManyEntries
+		}
+		name, err := cleanMemberName(e.name)
+		if err != nil || !e.mode.IsRegular() || !IsLicenseFile(name) {
+			return nil
+		}
+		licenses = append(licenses, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(licenses)
+	return licenses, nil
+}
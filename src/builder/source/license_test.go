@@ -0,0 +1,68 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsLicenseFile(t *testing.T) {
+	for _, name := range []string{"LICENSE", "nano-2.7.5/COPYING", "COPYING.LIB", "License.txt", "LICENSE-MIT", "NOTICE", "licence_gpl"} {
+		if !IsLicenseFile(name) {
+			t.Fatalf("Expected a license file: %s", name)
+		}
+	}
+	for _, name := range []string{"README", "licenses", "src/license.c/main.c", "COPYINGS"} {
+		if IsLicenseFile(name) {
+			t.Fatalf("Not a license file: %s", name)
+		}
+	}
+}
+
+func TestListArchiveLicenses(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-license")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	licensed := filepath.Join(tmp, "nano-2.7.5.tar.gz")
+	writeTestTarball(t, licensed, []testMember{
+		{name: "nano-2.7.5/README", body: "nano"},
+		{name: "nano-2.7.5/COPYING", body: "GPL"},
+		{name: "nano-2.7.5/doc/LICENSE.txt", body: "FDL"},
+	})
+	unlicensed := filepath.Join(tmp, "vim-8.0.tar.gz")
+	writeTestTarball(t, unlicensed, []testMember{
+		{name: "vim-8.0/README", body: "vim"},
+	})
+
+	licenses, err := ListArchiveLicenses(licensed)
+	if err != nil {
+		t.Fatalf("Failed to list licenses: %v", err)
+	}
+	if expected := []string{"nano-2.7.5/COPYING", "nano-2.7.5/doc/LICENSE.txt"}; !reflect.DeepEqual(licenses, expected) {
+		t.Fatalf("Expected licenses %v, got %v", expected, licenses)
+	}
+	if licenses, err := ListArchiveLicenses(unlicensed); err != nil || len(licenses) != 0 {
+		t.Fatalf("Expected no licenses, got %v %v", licenses, err)
+	}
+}
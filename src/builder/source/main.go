@@ -17,11 +17,14 @@
 package source
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-const (
+var (
 	// SourceDir is where we store all tarballs
 	SourceDir = "/var/lib/solbuild/sources"
 
@@ -29,6 +32,27 @@ const (
 	SourceStagingDir = "/var/lib/solbuild/sources/staging"
 )
 
+// TempDir, when set, overrides where downloads are staged before they are
+// moved into the source cache.
+var TempDir string
+
+// SetSourceDir will relocate the source cache, along with the staging and
+// git directories within it.
+func SetSourceDir(dir string) {
+	SourceDir = dir
+	SourceStagingDir = filepath.Join(dir, "staging")
+	GitSourceDir = filepath.Join(dir, "git")
+	HgSourceDir = filepath.Join(dir, "hg")
+}
+
+// GetStagingDir will return the directory used to stage downloads
+func GetStagingDir() string {
+	if TempDir == "" {
+		return SourceStagingDir
+	}
+	return filepath.Join(TempDir, "solbuild-staging")
+}
+
 // A BindConfiguration is used by a source as a way to express bind
 // mounts required for a given source.
 //
@@ -46,7 +70,16 @@ type BindConfiguration struct {
 // spec file.
 //
 // Source's may be of multiple types, but all are abstracted and dealt
-// with by the interfaces.
+// with by the interfaces. Implementations registered via RegisterScheme
+// must also follow this contract:
+//
+//   - IsFetched must be cheap and never touch the network.
+//   - Fetch must leave the source in the local cache, such that IsFetched
+//     then returns true, and must validate the content where possible.
+//   - GetBindConfiguration must return a BindSource that exists once the
+//     source has been fetched.
+//   - GetIdentifier must be stable and unique, as it is used in logs and
+//     to key per-source state.
 type Source interface {
 
 	// IsFetched is called during the early build process to determine
@@ -70,13 +103,23 @@ type Source interface {
 //
 // Validator is the value by which the source will be validated, depending
 // on implementation. For example, the SimpleSource backend will expect a
-// hashsum: sha256sum for package.yml, and sha1sum for legacy.
+// hashsum: sha256sum for package.yml, and sha1sum for legacy. Several
+// acceptable hashsums may be given, separated by ValidatorSeparator.
 //
 // The legacy argument will determine whether special care should be taken
 // for legacy packages (i.e. sha1sum vs sha256sum).
 //
-// In all cases, New will fallback to the SimpleSource implementation
+// Plaintext schemes are rejected while RequireSecureSchemes is set. Any
+// factory registered for the URI scheme with RegisterScheme is used
+// first. In all other cases, New will fallback to the SimpleSource
+// implementation
 func New(uri, validator string, legacy bool) (Source, error) {
+	if err := CheckSecureScheme(trimVCSPrefix(uri)); err != nil {
+		return nil, err
+	}
+	if factory := getSchemeFactory(uri); factory != nil {
+		return factory(uri, validator, legacy)
+	}
 	if legacy {
 		return NewSimple(uri, validator, legacy)
 	}
@@ -84,9 +127,40 @@ func New(uri, validator string, legacy bool) (Source, error) {
 	if strings.HasPrefix(uri, "git|") {
 		return NewGit(uri[len("git|"):], validator)
 	}
+	if strings.HasPrefix(uri, "hg|") {
+		return NewHg(uri[len("hg|"):], validator)
+	}
+	if strings.HasPrefix(uri, "svn|") {
+		return NewSvn(uri[len("svn|"):], validator)
+	}
+	if strings.HasPrefix(uri, "bzr|") {
+		return NewBzr(uri[len("bzr|"):], validator)
+	}
 	return NewSimple(uri, validator, legacy)
 }
 
+// vcsPrefixes are the prefixes of URIs naming version control sources
+var vcsPrefixes = []string{"git|", "hg|", "svn|", "bzr|"}
+
+// trimVCSPrefix will return the URI without any version control prefix
+func trimVCSPrefix(uri string) string {
+	for _, prefix := range vcsPrefixes {
+		if strings.HasPrefix(uri, prefix) {
+			return uri[len(prefix):]
+		}
+	}
+	return uri
+}
+
+// checkRepositoryURI will refuse a repository URI that the version control
+// tool would read as an option, i.e. "--config=...".
+func checkRepositoryURI(uri string) error {
+	if uri == "" || strings.HasPrefix(uri, "-") {
+		return fmt.Errorf("Invalid repository URI: %s", uri)
+	}
+	return nil
+}
+
 // PathExists is a helper function to determine the existence of a file path
 func PathExists(path string) bool {
 	if st, err := os.Stat(path); err == nil && st != nil {
@@ -94,3 +168,30 @@ func PathExists(path string) bool {
 	}
 	return false
 }
+
+// moveFile will rename the file into place, falling back to a copy when the
+// destination is on another filesystem, i.e. a separate temporary directory.
+func moveFile(source, dest string) error {
+	if err := os.Rename(source, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(source)
+}
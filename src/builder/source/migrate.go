@@ -0,0 +1,238 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// KeepLegacyLinks controls whether MigrateLegacyCache leaves the sha1sum
+// links in place for compatibility. Removed links are restored on demand
+// when a legacy package is next built.
+var KeepLegacyLinks = true
+
+// A MigrateResult records the changes made while migrating the cache
+type MigrateResult struct {
+	Migrated   int // sha1sum directories moved to their sha256sum
+	Verified   int // sha1sum links to verified sha256sum directories
+	Removed    int // Redundant sha1sum links removed
+	Dangling   int // sha1sum links to missing directories, removed
+	Mismatched int // Entries that failed verification, left untouched
+}
+
+// isLegacyHash will determine if the name looks like a sha1sum
+func isLegacyHash(name string) bool {
+	if len(name) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// fileDigests will return the sha1sum and sha256sum of the file
+func fileDigests(path string) (string, string, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer fi.Close()
+	h1, h256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h256), fi); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h256.Sum(nil)), nil
+}
+
+// getLegacyFile will return the single cached file within the directory
+func getLegacyFile(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && !isProvenanceFile(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("Expected 1 file in %s, found %d", dir, len(files))
+	}
+	return files[0], nil
+}
+
+// MigrateLegacyCache will ensure that every legacy sha1sum entry within the
+// source cache is stored under its sha256sum, with the sha1sum reduced to a
+// symlink, or removed entirely unless KeepLegacyLinks is set.
+func MigrateLegacyCache() error {
+	result, err := migrateLegacyCache(SourceDir, KeepLegacyLinks)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"migrated":   result.Migrated,
+		"verified":   result.Verified,
+		"removed":    result.Removed,
+		"dangling":   result.Dangling,
+		"mismatched": result.Mismatched,
+	}).Info("Migrated legacy source cache")
+	return nil
+}
+
+// migrateLegacyCache will migrate each sha1sum entry within the cache.
+// Content is only ever moved or unlinked once both of its hashes have been
+// verified, so anything unexpected is left untouched.
+func migrateLegacyCache(sourceDir string, keepLinks bool) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	if !PathExists(sourceDir) {
+		return result, nil
+	}
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !isLegacyHash(entry.Name()) {
+			continue
+		}
+		if entry.Mode()&os.ModeSymlink == os.ModeSymlink {
+			err = migrateLegacyLink(sourceDir, entry.Name(), keepLinks, result)
+		} else if entry.IsDir() {
+			err = migrateLegacyDir(sourceDir, entry.Name(), keepLinks, result)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// migrateLegacyLink will verify an existing sha1sum link, removing it when
+// dangling, or when redundant and links are not being kept.
+func migrateLegacyLink(sourceDir, sha1sum string, keepLinks bool, result *MigrateResult) error {
+	link := filepath.Join(sourceDir, sha1sum)
+	if !PathExists(link) {
+		log.WithFields(log.Fields{
+			"sha1": sha1sum,
+		}).Warning("Removing dangling legacy source link")
+		result.Dangling++
+		return os.Remove(link)
+	}
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return err
+	}
+	file, err := getLegacyFile(target)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"sha1":  sha1sum,
+			"error": err,
+		}).Warning("Cannot verify legacy source link")
+		result.Mismatched++
+		return nil
+	}
+	got1, got256, err := fileDigests(filepath.Join(target, file))
+	if err != nil {
+		return err
+	}
+	if got1 != sha1sum || got256 != filepath.Base(target) {
+		log.WithFields(log.Fields{
+			"sha1":   sha1sum,
+			"target": target,
+		}).Warning("Legacy source link does not match its content")
+		result.Mismatched++
+		return nil
+	}
+
+	result.Verified++
+	if keepLinks {
+		return nil
+	}
+	result.Removed++
+	return os.Remove(link)
+}
+
+// migrateLegacyDir will move the content of a real sha1sum directory into
+// its sha256sum directory, leaving a symlink behind if requested.
+func migrateLegacyDir(sourceDir, sha1sum string, keepLinks bool, result *MigrateResult) error {
+	legacyDir := filepath.Join(sourceDir, sha1sum)
+	file, err := getLegacyFile(legacyDir)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"sha1":  sha1sum,
+			"error": err,
+		}).Warning("Cannot migrate legacy source directory")
+		result.Mismatched++
+		return nil
+	}
+	got1, sha256sum, err := fileDigests(filepath.Join(legacyDir, file))
+	if err != nil {
+		return err
+	}
+	if got1 != sha1sum {
+		log.WithFields(log.Fields{
+			"sha1": sha1sum,
+			"file": file,
+		}).Warning("Legacy source does not match its sha1sum")
+		result.Mismatched++
+		return nil
+	}
+
+	hashDir := filepath.Join(sourceDir, sha256sum)
+	if err := os.MkdirAll(hashDir, 00755); err != nil {
+		return err
+	}
+	dest := filepath.Join(hashDir, file)
+	if PathExists(dest) {
+		// Already cached under the sha256sum, only trust a verified copy
+		if _, existing, err := fileDigests(dest); err != nil || existing != sha256sum {
+			log.WithFields(log.Fields{
+				"path": dest,
+			}).Warning("Cached source does not match its sha256sum")
+			result.Mismatched++
+			return nil
+		}
+	} else if err := moveFile(filepath.Join(legacyDir, file), dest); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(legacyDir); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"sha1":   sha1sum,
+		"sha256": sha256sum,
+		"source": file,
+	}).Info("Migrated legacy source")
+	result.Migrated++
+
+	if !keepLinks {
+		return nil
+	}
+	return os.Symlink(sha256sum, legacyDir)
+}
@@ -0,0 +1,133 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	nanoSHA1   = "e6efbd8aed7a6a63e6ec49365245a32bdc913b43"
+	nanoSHA256 = "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+)
+
+// writeCacheFile will write a source file into the cache fixture
+func writeCacheFile(t *testing.T, dir, name, contents string) {
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func TestMigrateLegacyCache(t *testing.T) {
+	for _, keepLinks := range []bool{true, false} {
+		tmp, err := ioutil.TempDir("", "solbuild-migrate")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		// Legacy source in a real sha1sum directory
+		writeCacheFile(t, filepath.Join(tmp, nanoSHA1), "nano-2.7.5.tar.xz", "nano")
+		// Modern source, which must be left alone
+		modern := "0000000000000000000000000000000000000000000000000000000000000000"
+		writeCacheFile(t, filepath.Join(tmp, modern), "extra-1.0.tar.gz", "extra")
+		// Dangling legacy link
+		dangling := "1111111111111111111111111111111111111111"
+		if err := os.Symlink("2222222222222222222222222222222222222222222222222222222222222222", filepath.Join(tmp, dangling)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		// Legacy directory whose content doesn't match
+		mismatched := "3333333333333333333333333333333333333333"
+		writeCacheFile(t, filepath.Join(tmp, mismatched), "corrupt-1.0.tar.gz", "corrupt")
+
+		result, err := migrateLegacyCache(tmp, keepLinks)
+		if err != nil {
+			t.Fatalf("Failed to migrate cache: %v", err)
+		}
+		if result.Migrated != 1 || result.Dangling != 1 || result.Mismatched != 1 {
+			t.Fatalf("Unexpected migration result: %+v", result)
+		}
+		if !PathExists(filepath.Join(tmp, nanoSHA256, "nano-2.7.5.tar.xz")) {
+			t.Fatalf("Legacy source was not moved to its sha256sum")
+		}
+		if isSymlink(filepath.Join(tmp, nanoSHA1)) != keepLinks {
+			t.Fatalf("Legacy link kept: %v, expected %v", isSymlink(filepath.Join(tmp, nanoSHA1)), keepLinks)
+		}
+		if isSymlink(filepath.Join(tmp, dangling)) {
+			t.Fatalf("Dangling link was not removed")
+		}
+		if !PathExists(filepath.Join(tmp, mismatched, "corrupt-1.0.tar.gz")) {
+			t.Fatalf("Mismatched source should be left untouched")
+		}
+		if !PathExists(filepath.Join(tmp, modern, "extra-1.0.tar.gz")) {
+			t.Fatalf("Modern source should be left untouched")
+		}
+
+		// Migrating again only verifies the links
+		result, err = migrateLegacyCache(tmp, keepLinks)
+		if err != nil {
+			t.Fatalf("Failed to migrate cache again: %v", err)
+		}
+		if result.Migrated != 0 || result.Mismatched != 1 {
+			t.Fatalf("Unexpected second migration result: %+v", result)
+		}
+		if keepLinks && result.Verified != 1 {
+			t.Fatalf("Existing link was not verified: %+v", result)
+		}
+	}
+}
+
+func TestMigrateLegacyLinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-migrate")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	writeCacheFile(t, filepath.Join(tmp, nanoSHA256), "nano-2.7.5.tar.xz", "nano")
+	if err := os.Symlink(nanoSHA256, filepath.Join(tmp, nanoSHA1)); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	// Link to content with another sha1sum
+	wrong := "4444444444444444444444444444444444444444"
+	if err := os.Symlink(nanoSHA256, filepath.Join(tmp, wrong)); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	result, err := migrateLegacyCache(tmp, false)
+	if err != nil {
+		t.Fatalf("Failed to migrate cache: %v", err)
+	}
+	if result.Verified != 1 || result.Removed != 1 || result.Mismatched != 1 {
+		t.Fatalf("Unexpected migration result: %+v", result)
+	}
+	if isSymlink(filepath.Join(tmp, nanoSHA1)) {
+		t.Fatalf("Redundant link was not removed")
+	}
+	if !isSymlink(filepath.Join(tmp, wrong)) {
+		t.Fatalf("Mismatched link should be left untouched")
+	}
+	if !PathExists(filepath.Join(tmp, nanoSHA256, "nano-2.7.5.tar.xz")) {
+		t.Fatalf("Source content should never be removed")
+	}
+}
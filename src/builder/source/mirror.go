@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// Mirrors maps URL prefixes to the prefix of a mirror, i.e. an internal
+// caching mirror, which is tried before the original URL. As sources are
+// stored by their hash, the cache is correct regardless of which served it.
+var Mirrors map[string]string
+
+// getMirrorURL will return the mirror URL for the given URI, using the
+// longest matching prefix.
+func getMirrorURL(uri string) (string, bool) {
+	match := ""
+	for prefix := range Mirrors {
+		if prefix != "" && strings.HasPrefix(uri, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return "", false
+	}
+	return Mirrors[match] + uri[len(match):], true
+}
+
+// AddAlternates will add alternate URIs for this source, which are tried in
+// order should the source fail to download or fail verification. Duplicate
+// URIs, and the source URI itself, are ignored.
+func (s *SimpleSource) AddAlternates(uris ...string) {
+	for _, uri := range uris {
+		if uri = strings.TrimSpace(uri); uri == "" || uri == s.URI {
+			continue
+		}
+		known := false
+		for _, alt := range s.alternates {
+			if alt == uri {
+				known = true
+				break
+			}
+		}
+		if !known {
+			s.alternates = append(s.alternates, uri)
+		}
+	}
+}
+
+// GetAlternates will return the alternate URIs for this source, in order
+func (s *SimpleSource) GetAlternates() []string {
+	return s.alternates
+}
+
+// fetchAlternates will download the source from each alternate URI in turn
+// into destination, until one passes verification. The sha256sum of the
+// download is returned.
+func (s *SimpleSource) fetchAlternates(destination string, cause error) (string, error) {
+	for _, uri := range s.alternates {
+		log.WithFields(log.Fields{
+			"uri":       s.URI,
+			"alternate": uri,
+			"error":     cause,
+		}).Warning("Failed to fetch source, trying alternate")
+
+		// Never resume a partial download from another server
+		os.Remove(destination)
+		discardHashCheckpoint(destination)
+
+		alt, err := NewSimple(uri, s.formatValidators(), s.legacy)
+		if err != nil {
+			cause = err
+			continue
+		}
+		alt.progress = s.progress
+		hash, err := alt.fetchVerified(destination, alt.downloadDirect)
+		if err != nil {
+			cause = err
+			continue
+		}
+		log.WithFields(log.Fields{
+			"uri":       s.URI,
+			"alternate": uri,
+		}).Info("Fetched source from alternate")
+		s.validator = alt.validator
+		s.effectiveURL = alt.GetEffectiveURL()
+		s.mirror = uri
+		s.status = alt.status
+		return hash, nil
+	}
+	return "", cause
+}
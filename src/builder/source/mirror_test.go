@@ -0,0 +1,202 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetMirrorURL(t *testing.T) {
+	Mirrors = map[string]string{
+		"https://www.nano-editor.org/":     "https://mirror.internal/cache/nano/",
+		"https://www.nano-editor.org/dist": "https://mirror.internal/dist",
+	}
+	defer func() {
+		Mirrors = nil
+	}()
+
+	if uri, ok := getMirrorURL("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz"); !ok || uri != "https://mirror.internal/dist/v2.7/nano-2.7.5.tar.xz" {
+		t.Fatalf("Longest prefix should win: %v", uri)
+	}
+	if uri, ok := getMirrorURL("https://www.nano-editor.org/nano.tar.xz"); !ok || uri != "https://mirror.internal/cache/nano/nano.tar.xz" {
+		t.Fatalf("Wrong mirror URL: %v", uri)
+	}
+	if _, ok := getMirrorURL("https://ftp.gnu.org/gnu/bash/bash-4.4.tar.gz"); ok {
+		t.Fatalf("Unmatched URLs should not be rewritten")
+	}
+}
+
+func TestMirrorFallback(t *testing.T) {
+	upstreamHits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cache/nano-2.7.5.tar.xz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("mirror"))
+	}))
+	defer mirror.Close()
+
+	Mirrors = map[string]string{upstream.URL + "/": mirror.URL + "/cache/"}
+	defer func() {
+		Mirrors = nil
+	}()
+
+	tmp, err := ioutil.TempDir("", "solbuild-mirror")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		file     string
+		contents string
+		upstream int
+	}{
+		{"nano-2.7.5.tar.xz", "mirror", 0},
+		{"vim-8.0.tar.bz2", "upstream", 1},
+	}
+	for _, test := range tests {
+		src, err := NewSimple(upstream.URL+"/"+test.file, "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		dest := filepath.Join(tmp, test.file)
+		if err := src.download(dest); err != nil {
+			t.Fatalf("Failed to download %s: %v", test.file, err)
+		}
+		if contents, _ := ioutil.ReadFile(dest); string(contents) != test.contents {
+			t.Fatalf("Wrong contents for %s: %s", test.file, contents)
+		}
+		if upstreamHits != test.upstream {
+			t.Fatalf("Wrong number of upstream requests for %s: %d", test.file, upstreamHits)
+		}
+	}
+}
+
+func TestTruncatedMirror(t *testing.T) {
+	contents := "nano is a small and friendly text editor"
+	var ranges []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "nano-2.7.5.tar.xz", time.Time{}, strings.NewReader(contents))
+	}))
+	defer upstream.Close()
+
+	// The mirror hangs up part way through, with different content
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.Write([]byte("MIRROR"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer mirror.Close()
+
+	Mirrors = map[string]string{upstream.URL + "/": mirror.URL + "/"}
+	defer func() {
+		Mirrors = nil
+	}()
+
+	tmp, err := ioutil.TempDir("", "solbuild-mirror")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src, err := NewSimple(upstream.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, src.File)
+	if err := src.downloadUpstream(dest); err != nil {
+		t.Fatalf("Failed to fall back to upstream: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("Upstream should not resume the partial download of the mirror: %v", ranges)
+	}
+	if got, _ := ioutil.ReadFile(dest); string(got) != contents {
+		t.Fatalf("Wrong contents after falling back to upstream: %s", got)
+	}
+}
+
+func TestFetchAlternates(t *testing.T) {
+	retrySleep = func(time.Duration) {}
+	defer func() {
+		retrySleep = time.Sleep
+	}()
+
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	corrupt := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("corrupt"), true)
+	defer corrupt.listener.Close()
+	good := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer good.listener.Close()
+
+	src, err := NewSimple("ftp://127.0.0.1:1/nano-2.7.5.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	src.AddAlternates(corrupt.URL(), "", src.URI, good.URL(), corrupt.URL())
+	if alts := src.GetAlternates(); len(alts) != 2 || alts[0] != corrupt.URL() || alts[1] != good.URL() {
+		t.Fatalf("Wrong alternates: %v", alts)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch from alternates: %v", err)
+	}
+	if !src.IsFetched() {
+		t.Fatalf("Source was not cached")
+	}
+	prov, err := src.GetProvenance()
+	if err != nil {
+		t.Fatalf("Failed to read provenance: %v", err)
+	}
+	if prov.URI != src.URI || prov.Mirror != good.URL() {
+		t.Fatalf("Wrong alternate recorded: %+v", prov)
+	}
+
+	// Every alternate failing reports the failure
+	bad, err := NewSimple("ftp://127.0.0.1:1/nano-2.7.5.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	bad.AddAlternates(corrupt.URL())
+	SetSourceDir(filepath.Join(tmp, "empty"))
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := bad.Fetch(); err == nil {
+		t.Fatalf("Corrupt alternate should not be accepted")
+	}
+}
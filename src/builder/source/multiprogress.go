@@ -0,0 +1,166 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MultiProgressInterval is the shortest time between redraws of a
+// MultiProgress, so that fast downloads don't flood the terminal.
+var MultiProgressInterval = 200 * time.Millisecond
+
+// multiNameWidth is the width of the file name column
+const multiNameWidth = 32
+
+var (
+	multiLock     sync.Mutex
+	multiProgress *MultiProgress
+)
+
+// A MultiProgress draws the progress of several concurrent downloads as a
+// block of lines, one per download, redrawn in place. Individual progress
+// bars would otherwise trample each other on the terminal.
+type MultiProgress struct {
+	lock  sync.Mutex
+	out   io.Writer
+	bars  []*multiBar
+	drawn int // Lines drawn by the last render
+	last  time.Time
+	now   func() time.Time
+
+	summary func() string // Describes the downloads as a whole, if set
+}
+
+// multiBar is the line of a single download within a MultiProgress
+type multiBar struct {
+	parent   *MultiProgress
+	name     string
+	done     int64
+	total    int64
+	finished bool
+}
+
+// NewMultiProgress will create a new display, drawing to out
+func NewMultiProgress(out io.Writer) *MultiProgress {
+	return &MultiProgress{
+		out: out,
+		now: time.Now,
+	}
+}
+
+// SetMultiProgress will draw all downloads that start from now on with the
+// given display, or with a progress bar each when nil.
+func SetMultiProgress(m *MultiProgress) {
+	multiLock.Lock()
+	defer multiLock.Unlock()
+	multiProgress = m
+}
+
+// getMultiProgress will return the active display, if any
+func getMultiProgress() *MultiProgress {
+	multiLock.Lock()
+	defer multiLock.Unlock()
+	return multiProgress
+}
+
+// newBar will add a line for the named download
+func (m *MultiProgress) newBar(name string, total int64) barRenderer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	bar := &multiBar{parent: m, name: name, total: total}
+	m.bars = append(m.bars, bar)
+	return bar
+}
+
+// render will redraw every line in place, unless the last redraw was too
+// recent and force is not set. The lock must be held.
+func (m *MultiProgress) render(force bool) {
+	now := m.now()
+	if !force && now.Sub(m.last) < MultiProgressInterval {
+		return
+	}
+	m.last = now
+	if m.drawn > 0 {
+		fmt.Fprintf(m.out, "\x1b[%dA", m.drawn)
+	}
+	for _, bar := range m.bars {
+		fmt.Fprintf(m.out, "\r\x1b[K%s\n", bar)
+	}
+	m.drawn = len(m.bars)
+	if m.summary != nil {
+		fmt.Fprintf(m.out, "\r\x1b[K%s\n", m.summary())
+		m.drawn++
+	}
+}
+
+// SetSummary will draw a line below the downloads, i.e. the overall
+// progress, using fn each time the display is redrawn.
+func (m *MultiProgress) SetSummary(fn func() string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.summary = fn
+}
+
+// Stop will draw the final state of every download
+func (m *MultiProgress) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.render(true)
+}
+
+func (b *multiBar) Start() {
+	b.parent.lock.Lock()
+	defer b.parent.lock.Unlock()
+	b.parent.render(true)
+}
+
+func (b *multiBar) Set(done, total int64) {
+	b.parent.lock.Lock()
+	defer b.parent.lock.Unlock()
+	b.done = done
+	if total > 0 {
+		b.total = total
+	}
+	b.parent.render(false)
+}
+
+func (b *multiBar) Finish() {
+	b.parent.lock.Lock()
+	defer b.parent.lock.Unlock()
+	b.finished = true
+	b.parent.render(true)
+}
+
+// String will describe the download as a single line
+func (b *multiBar) String() string {
+	name := b.name
+	if len(name) > multiNameWidth {
+		name = name[:multiNameWidth-3] + "..."
+	}
+	ret := fmt.Sprintf("%-*s %10s", multiNameWidth, name, formatBytes(b.done))
+	if b.total > 0 {
+		ret += fmt.Sprintf(" / %-10s %3.0f%%", formatBytes(b.total), float64(b.done)*100/float64(b.total))
+	}
+	if b.finished {
+		ret += " done"
+	}
+	return ret
+}
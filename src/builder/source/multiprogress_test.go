@@ -0,0 +1,74 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiProgress(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Unix(0, 0)
+	m := NewMultiProgress(&out)
+	m.now = func() time.Time { return now }
+	m.SetSummary(func() string { return "summary" })
+
+	SetMultiProgress(m)
+	defer SetMultiProgress(nil)
+
+	nano := newProgressBar("nano-2.7.5.tar.xz", 2048)
+	vim := newProgressBar("vim-8.0.tar.bz2", 0)
+	SetMultiProgress(nil)
+	if _, ok := newProgressBar("bash-4.4.tar.gz", 0).bar.(*multiBar); ok {
+		t.Fatalf("Progress bar should not be multiplexed once unset")
+	}
+
+	nano.Start()
+	vim.Start()
+	out.Reset()
+
+	// Redraws are throttled
+	nano.Set(1024, 2048)
+	if out.Len() != 0 {
+		t.Fatalf("Progress was redrawn too soon: %q", out.String())
+	}
+	now = now.Add(MultiProgressInterval)
+	vim.Set(512, -1)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "\x1b[3A") {
+		t.Fatalf("Expected the previous lines to be redrawn in place: %q", out.String())
+	}
+	if !strings.Contains(lines[0], "nano-2.7.5.tar.xz") || !strings.Contains(lines[0], "1.0 KiB / 2.0 KiB") || !strings.Contains(lines[0], "50%") {
+		t.Fatalf("Wrong line for nano: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "vim-8.0.tar.bz2") || !strings.Contains(lines[1], "512 B") || strings.Contains(lines[1], "%") {
+		t.Fatalf("Wrong line for vim: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "summary") {
+		t.Fatalf("Missing summary line: %q", lines[2])
+	}
+
+	// Finishing always redraws
+	out.Reset()
+	nano.Finish()
+	if !strings.Contains(out.String(), "nano-2.7.5.tar.xz") || !strings.Contains(out.String(), " done\n") {
+		t.Fatalf("Finished download not drawn: %q", out.String())
+	}
+}
@@ -0,0 +1,50 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Offline forbids sources from touching the network, i.e. on airgapped
+// build machines. Any source that is not already cached fails immediately
+// with an OfflineError, rather than waiting on a connection that can never
+// succeed.
+var Offline = false
+
+// An OfflineError is returned when a source would need the network while
+// Offline is set.
+type OfflineError struct {
+	URI string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("Network access is disabled in offline mode, and the source is not cached: %s", e.URI)
+}
+
+// checkOffline will return an OfflineError for the URI in offline mode,
+// unless it names a local file.
+func checkOffline(uri string) error {
+	if !Offline {
+		return nil
+	}
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return nil
+	}
+	return &OfflineError{URI: uri}
+}
@@ -0,0 +1,80 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"testing"
+)
+
+func TestOffline(t *testing.T) {
+	Offline = true
+	defer func() {
+		Offline = false
+	}()
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+
+	simple, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	var sources []Source
+	for _, uri := range []string{"hg|https://hg.mozilla.org/projects/nspr", "svn|https://svn.apache.org/repos/asf/subversion/trunk", "bzr|lp:bzr"} {
+		src, err := New(uri, "1234", false)
+		if err != nil {
+			t.Fatalf("Failed to create source %s: %v", uri, err)
+		}
+		sources = append(sources, src)
+	}
+	git, err := NewGit("https://github.com/solus-project/solbuild.git", "v1.3.0")
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	sources = append(sources, simple, git)
+
+	for _, src := range sources {
+		if err := src.Fetch(); err == nil {
+			t.Fatalf("Fetching %s should fail offline", src.GetIdentifier())
+		} else if _, ok := err.(*OfflineError); !ok {
+			t.Fatalf("Expected an offline error for %s, got: %v", src.GetIdentifier(), err)
+		}
+	}
+	if _, err := simple.GetRemoteSize(); err == nil {
+		t.Fatalf("Remote size should not be requested offline")
+	}
+	if len(server.offsets) != 0 {
+		t.Fatalf("Server was contacted offline")
+	}
+
+	// Cached sources are still usable
+	Offline = false
+	if err := simple.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	Offline = true
+	cached, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !cached.IsFetched() {
+		t.Fatalf("Cached source should be found offline")
+	}
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"github.com/andelf/go-curl"
+	"time"
+)
+
+const (
+	// NetworkDownload is the operation name for fetching sources
+	NetworkDownload = "download"
+
+	// NetworkMetadata is the operation name for size and validation lookups
+	NetworkMetadata = "metadata"
+
+	// NetworkImage is the operation name for fetching backing images
+	NetworkImage = "image"
+)
+
+// A NetworkPolicy controls the retries and timeouts of network operations.
+type NetworkPolicy struct {
+	Retries         int           // Attempts to make after the first fails
+	BackoffBase     time.Duration // Initial wait between attempts, doubled each time
+	ConnectTimeout  time.Duration // Maximum time to establish a connection
+	TransferTimeout time.Duration // Maximum time for a whole transfer, 0 for no limit
+	HostConcurrency int           // Maximum concurrent requests to a single host
+	RateLimitWait   time.Duration // Maximum total wait on a rate limiting server
+}
+
+var (
+	// NetworkDefaults is the policy used by any operation without an override
+	NetworkDefaults = NetworkPolicy{
+		Retries:         3,
+		BackoffBase:     30 * time.Second,
+		ConnectTimeout:  2 * time.Minute,
+		TransferTimeout: 0,
+		HostConcurrency: 2,
+		RateLimitWait:   5 * time.Minute,
+	}
+
+	// NetworkOverrides replaces fields of the default policy for individual
+	// operations, keyed by operation name.
+	NetworkOverrides = make(map[string]NetworkOverride)
+)
+
+// A NetworkOverride replaces fields of a NetworkPolicy. Only fields that are
+// set are overridden, so that a field may still be overridden with zero, such
+// as to disable retries.
+type NetworkOverride struct {
+	Retries         *int
+	BackoffBase     *time.Duration
+	ConnectTimeout  *time.Duration
+	TransferTimeout *time.Duration
+	HostConcurrency *int
+	RateLimitWait   *time.Duration
+}
+
+// IsValidNetworkOperation will determine if the name is a known operation
+func IsValidNetworkOperation(op string) bool {
+	switch op {
+	case NetworkDownload, NetworkMetadata, NetworkImage:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetNetworkPolicy will return the policy to use for the given operation
+func GetNetworkPolicy(op string) NetworkPolicy {
+	policy := NetworkDefaults
+	if override, ok := NetworkOverrides[op]; ok {
+		policy = policy.Merge(override)
+	}
+	return policy
+}
+
+// Merge will return a copy of the policy with all set fields of the
+// override applied.
+func (p NetworkPolicy) Merge(override NetworkOverride) NetworkPolicy {
+	if override.Retries != nil {
+		p.Retries = *override.Retries
+	}
+	if override.BackoffBase != nil {
+		p.BackoffBase = *override.BackoffBase
+	}
+	if override.ConnectTimeout != nil {
+		p.ConnectTimeout = *override.ConnectTimeout
+	}
+	if override.TransferTimeout != nil {
+		p.TransferTimeout = *override.TransferTimeout
+	}
+	if override.HostConcurrency != nil {
+		p.HostConcurrency = *override.HostConcurrency
+	}
+	if override.RateLimitWait != nil {
+		p.RateLimitWait = *override.RateLimitWait
+	}
+	return p
+}
+
+// Backoff will return how long to wait before the given retry attempt,
+// counting from zero.
+func (p NetworkPolicy) Backoff(attempt int) time.Duration {
+	if attempt > 16 {
+		attempt = 16
+	}
+	return p.BackoffBase << uint(attempt)
+}
+
+// Concurrency will return the per-host concurrency, which is at least 1
+func (p NetworkPolicy) Concurrency() int {
+	if p.HostConcurrency < 1 {
+		return 1
+	}
+	return p.HostConcurrency
+}
+
+// setCurlOptions will apply the timeouts of the policy to the curl handle
+func (p NetworkPolicy) setCurlOptions(hnd *curl.CURL) {
+	hnd.Setopt(curl.OPT_CONNECTTIMEOUT, int(p.ConnectTimeout/time.Second))
+	hnd.Setopt(curl.OPT_TIMEOUT, int(p.TransferTimeout/time.Second))
+}
+
+// CurlArgs will return the arguments to pass to the curl command to apply
+// this policy, for downloads made outside of libcurl.
+func (p NetworkPolicy) CurlArgs() []string {
+	args := []string{
+		"--connect-timeout", fmt.Sprintf("%d", int(p.ConnectTimeout/time.Second)),
+		"--retry", fmt.Sprintf("%d", p.Retries),
+		"--retry-delay", fmt.Sprintf("%d", int(p.BackoffBase/time.Second)),
+	}
+	if p.TransferTimeout > 0 {
+		args = append(args, "--max-time", fmt.Sprintf("%d", int(p.TransferTimeout/time.Second)))
+	}
+	return args
+}
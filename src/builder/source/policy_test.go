@@ -0,0 +1,121 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	oldDefaults, oldOverrides := NetworkDefaults, NetworkOverrides
+	defer func() {
+		NetworkDefaults, NetworkOverrides = oldDefaults, oldOverrides
+	}()
+
+	NetworkDefaults = NetworkPolicy{
+		Retries:         2,
+		BackoffBase:     time.Second,
+		ConnectTimeout:  10 * time.Second,
+		HostConcurrency: 2,
+		RateLimitWait:   time.Minute,
+	}
+	retries, noRetries, transfer := 5, 0, time.Hour
+	NetworkOverrides = map[string]NetworkOverride{
+		NetworkImage:    {Retries: &retries, TransferTimeout: &transfer},
+		NetworkMetadata: {Retries: &noRetries},
+	}
+
+	if policy := GetNetworkPolicy(NetworkDownload); policy != NetworkDefaults {
+		t.Fatalf("Operation without override should use the defaults: %+v", policy)
+	}
+	image := GetNetworkPolicy(NetworkImage)
+	if image.Retries != 5 || image.TransferTimeout != time.Hour || image.ConnectTimeout != 10*time.Second {
+		t.Fatalf("Override was not merged with the defaults: %+v", image)
+	}
+	if metadata := GetNetworkPolicy(NetworkMetadata); metadata.Retries != 0 {
+		t.Fatalf("Override should be able to disable retries: %+v", metadata)
+	}
+	if wait := image.Backoff(0); wait != time.Second {
+		t.Fatalf("Wrong initial backoff: %v", wait)
+	}
+	if wait := image.Backoff(3); wait != 8*time.Second {
+		t.Fatalf("Backoff should double with each attempt: %v", wait)
+	}
+
+	expected := []string{"--connect-timeout", "10", "--retry", "5", "--retry-delay", "1", "--max-time", "3600"}
+	if args := image.CurlArgs(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Wrong curl arguments for image policy: %v", args)
+	}
+	if (NetworkPolicy{}).Concurrency() != 1 {
+		t.Fatalf("Concurrency should be at least 1")
+	}
+}
+
+// blockingSource counts how many checks run at once
+type blockingSource struct {
+	*SimpleSource
+	lock    *sync.Mutex
+	active  *int
+	maximum *int
+}
+
+func (b *blockingSource) CheckRemote() (int, int64, error) {
+	b.lock.Lock()
+	*b.active++
+	if *b.active > *b.maximum {
+		*b.maximum = *b.active
+	}
+	b.lock.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	b.lock.Lock()
+	*b.active--
+	b.lock.Unlock()
+	return 200, -1, nil
+}
+
+func TestValidationHostConcurrency(t *testing.T) {
+	oldOverrides := NetworkOverrides
+	defer func() {
+		NetworkOverrides = oldOverrides
+	}()
+	limit := 3
+	NetworkOverrides = map[string]NetworkOverride{
+		NetworkMetadata: {HostConcurrency: &limit},
+	}
+
+	var lock sync.Mutex
+	var active, maximum int
+	var sources []Source
+	for i := 0; i < 12; i++ {
+		simple, err := NewSimple("https://example.com/nano-2.7.5.tar.xz", "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		sources = append(sources, &blockingSource{simple, &lock, &active, &maximum})
+	}
+	for _, result := range ValidateSources(sources, nil) {
+		if result.Err != nil {
+			t.Fatalf("Unexpected validation error: %v", result.Err)
+		}
+	}
+	if maximum < 1 || maximum > 3 {
+		t.Fatalf("Host concurrency of the metadata policy was not honored: %d", maximum)
+	}
+}
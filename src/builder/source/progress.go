@@ -0,0 +1,197 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A ProgressFunc receives the bytes downloaded so far, and the total size
+// of the download, or -1 when unknown.
+type ProgressFunc func(done, total int64)
+
+// A ProgressSource is able to report its progress while being fetched
+type ProgressSource interface {
+	Source
+
+	// SetProgressFunc will set the function to call as the download
+	// progresses.
+	SetProgressFunc(fn ProgressFunc)
+}
+
+// SetProgressFunc will report download progress to the given function
+func (s *SimpleSource) SetProgressFunc(fn ProgressFunc) {
+	s.progress = fn
+}
+
+// reportProgress will pass progress to the progress function, if any
+func (s *SimpleSource) reportProgress(done, total int64) {
+	if s.progress != nil {
+		s.progress(done, total)
+	}
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	io.Reader
+	done  int64
+	total int64
+	fn    func(done, total int64)
+}
+
+// Read will report progress after each read
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	p.done += int64(n)
+	p.fn(p.done, p.total)
+	return n, err
+}
+
+// progressEntry is the progress of a single source
+type progressEntry struct {
+	done  int64
+	total int64 // -1 when unknown
+}
+
+// An AggregateProgress tracks the combined progress of several downloads
+type AggregateProgress struct {
+	lock    sync.Mutex
+	start   time.Time
+	now     func() time.Time
+	entries map[string]*progressEntry
+}
+
+// A ProgressStatus is a snapshot of the aggregate progress
+type ProgressStatus struct {
+	Done    int64         // Bytes downloaded across all sources
+	Total   int64         // Combined size of the sources with a known size
+	Unknown int           // Number of sources without a known size
+	Percent float64       // Completion of the known sizes, -1 if none are known
+	ETA     time.Duration // Estimated time remaining, 0 if unknown
+}
+
+// NewAggregateProgress will create a new tracker, starting the clock
+func NewAggregateProgress() *AggregateProgress {
+	return &AggregateProgress{
+		start:   time.Now(),
+		now:     time.Now,
+		entries: make(map[string]*progressEntry),
+	}
+}
+
+// Add will begin tracking a source of the given size, or -1 when unknown
+func (a *AggregateProgress) Add(id string, total int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if total <= 0 {
+		total = -1
+	}
+	a.entries[id] = &progressEntry{total: total}
+}
+
+// Update will record the progress of a source. A known total replaces an
+// unknown size, i.e. once the server has sent the Content-Length.
+func (a *AggregateProgress) Update(id string, done, total int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entry, ok := a.entries[id]
+	if !ok {
+		entry = &progressEntry{total: -1}
+		a.entries[id] = entry
+	}
+	entry.done = done
+	if entry.total < 0 && total > 0 {
+		entry.total = total
+	}
+}
+
+// Complete will mark a source as fully downloaded, with the given size
+func (a *AggregateProgress) Complete(id string, size int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entry, ok := a.entries[id]
+	if !ok {
+		entry = &progressEntry{}
+		a.entries[id] = entry
+	}
+	if entry.total < 0 {
+		entry.total = size
+	}
+	entry.done = entry.total
+}
+
+// Status will compute the combined progress, with an ETA based on the
+// average rate so far.
+func (a *AggregateProgress) Status() ProgressStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	status := ProgressStatus{Percent: -1}
+	var knownDone int64
+	for _, entry := range a.entries {
+		status.Done += entry.done
+		if entry.total < 0 {
+			status.Unknown++
+			continue
+		}
+		status.Total += entry.total
+		knownDone += entry.done
+	}
+	if status.Total <= 0 {
+		return status
+	}
+	status.Percent = float64(knownDone) * 100 / float64(status.Total)
+
+	elapsed := a.now().Sub(a.start)
+	if knownDone > 0 && knownDone < status.Total && elapsed > 0 {
+		remaining := float64(status.Total - knownDone)
+		status.ETA = time.Duration(remaining / float64(knownDone) * float64(elapsed))
+	}
+	return status
+}
+
+// formatBytes will return a human readable size
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// String will describe the progress for display
+func (p ProgressStatus) String() string {
+	if p.Percent < 0 {
+		return fmt.Sprintf("Fetched %s", formatBytes(p.Done))
+	}
+	ret := fmt.Sprintf("Fetched %s of %s (%.0f%%)", formatBytes(p.Done), formatBytes(p.Total), p.Percent)
+	if p.Unknown > 0 {
+		ret += fmt.Sprintf(", %d of unknown size", p.Unknown)
+	}
+	if p.ETA > 0 {
+		ret += fmt.Sprintf(", ETA %v", p.ETA.Round(time.Second))
+	}
+	return ret
+}
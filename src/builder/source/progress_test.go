@@ -0,0 +1,88 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestAggregateProgress(t *testing.T) {
+	progress := NewAggregateProgress()
+	start := progress.start
+	progress.now = func() time.Time {
+		return start.Add(10 * time.Second)
+	}
+
+	progress.Add("nano", 1000)
+	progress.Add("vim", 3000)
+	progress.Add("patches", -1)
+
+	progress.Update("nano", 1000, 1000)
+	progress.Update("patches", 50, -1)
+	status := progress.Status()
+	if status.Done != 1050 || status.Total != 4000 || status.Unknown != 1 {
+		t.Fatalf("Wrong aggregate bytes: %+v", status)
+	}
+	if status.Percent != 25 {
+		t.Fatalf("Percentage should only consider known sizes: %v", status.Percent)
+	}
+	// 1000 bytes in 10 seconds, leaving 3000 bytes
+	if status.ETA != 30*time.Second {
+		t.Fatalf("Wrong ETA: %v", status.ETA)
+	}
+	if s := status.String(); s != "Fetched 1.0 KiB of 3.9 KiB (25%), 1 of unknown size, ETA 30s" {
+		t.Fatalf("Wrong status description: %s", s)
+	}
+
+	// The server revealing the size makes it known
+	progress.Update("patches", 100, 1000)
+	progress.Complete("vim", 3000)
+	status = progress.Status()
+	if status.Unknown != 0 || status.Total != 5000 || status.Percent != 82 {
+		t.Fatalf("Wrong aggregate after completion: %+v", status)
+	}
+
+	// Without any known sizes, only the bytes are reported
+	unknown := NewAggregateProgress()
+	unknown.Add("nano", -1)
+	unknown.Update("nano", 2048, -1)
+	status = unknown.Status()
+	if status.Percent != -1 || status.ETA != 0 || status.String() != "Fetched 2.0 KiB" {
+		t.Fatalf("Unknown sizes should degrade gracefully: %+v", status)
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var done, total int64
+	reader := &progressReader{
+		Reader: bytes.NewReader([]byte("nano")),
+		done:   10,
+		total:  14,
+		fn: func(d, t int64) {
+			done, total = d, t
+		},
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if done != 14 || total != 14 {
+		t.Fatalf("Wrong progress reported: %d/%d", done, total)
+	}
+}
@@ -0,0 +1,122 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/cheggaaa/pb"
+)
+
+// A barRenderer draws a progress bar to the terminal
+type barRenderer interface {
+	Start()
+	Set(done, total int64)
+	Finish()
+}
+
+// pbRenderer draws progress bars with the pb library
+type pbRenderer struct {
+	bar *pb.ProgressBar
+}
+
+func (r *pbRenderer) Start() {
+	r.bar.Start()
+}
+
+func (r *pbRenderer) Set(done, total int64) {
+	r.bar.Total = total
+	r.bar.Set64(done)
+	r.bar.Update()
+}
+
+func (r *pbRenderer) Finish() {
+	r.bar.Update()
+	r.bar.Finish()
+}
+
+// newBarRenderer will create the renderer for a download. It may be
+// replaced to simulate rendering failures.
+var newBarRenderer = func(name string, total int64) barRenderer {
+	bar := pb.New64(total).Prefix(name)
+	bar.SetUnits(pb.U_BYTES)
+	bar.SetMaxWidth(80)
+	bar.ShowSpeed = true
+	return &pbRenderer{bar: bar}
+}
+
+// A progressBar shows the progress of a download, but never allows a
+// failure to render, i.e. on an exotic terminal, to interrupt the download
+// itself. Once rendering fails, the bar is disabled for the rest of the
+// download.
+type progressBar struct {
+	name   string
+	bar    barRenderer
+	failed bool
+}
+
+// newProgressBar will create a progress bar for the named download, drawn
+// as part of the active MultiProgress if one is set. The total is -1 or 0
+// when unknown.
+func newProgressBar(name string, total int64) *progressBar {
+	p := &progressBar{name: name}
+	p.guard(func() {
+		if m := getMultiProgress(); m != nil {
+			p.bar = m.newBar(name, total)
+			return
+		}
+		p.bar = newBarRenderer(name, total)
+	})
+	return p
+}
+
+// guard will run fn, disabling the progress bar should it panic
+func (p *progressBar) guard(fn func()) {
+	if p.failed {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.failed = true
+			log.WithFields(log.Fields{
+				"file":  p.name,
+				"error": r,
+			}).Warning("Progress bar failed, continuing download without it")
+		}
+	}()
+	fn()
+}
+
+// Start will begin rendering the progress bar
+func (p *progressBar) Start() {
+	p.guard(func() {
+		p.bar.Start()
+	})
+}
+
+// Set will update the progress bar with the bytes downloaded so far
+func (p *progressBar) Set(done, total int64) {
+	p.guard(func() {
+		p.bar.Set(done, total)
+	})
+}
+
+// Finish will stop rendering the progress bar
+func (p *progressBar) Finish() {
+	p.guard(func() {
+		p.bar.Finish()
+	})
+}
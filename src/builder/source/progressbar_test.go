@@ -0,0 +1,71 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"os"
+	"testing"
+)
+
+// brokenRenderer panics whenever it is drawn
+type brokenRenderer struct{}
+
+func (b brokenRenderer) Start()                { panic("terminal is too exotic") }
+func (b brokenRenderer) Set(done, total int64) { panic("terminal is too exotic") }
+func (b brokenRenderer) Finish()               { panic("terminal is too exotic") }
+
+func TestBrokenProgressBar(t *testing.T) {
+	oldRenderer := newBarRenderer
+	defer func() { newBarRenderer = oldRenderer }()
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+
+	renderers := map[string]func(string, int64) barRenderer{
+		"create": func(name string, total int64) barRenderer { panic("no terminal") },
+		"render": func(name string, total int64) barRenderer { return brokenRenderer{} },
+	}
+	for failure, renderer := range renderers {
+		newBarRenderer = renderer
+		src, err := NewSimple(server.URL(), "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		os.RemoveAll(SourceDir)
+		if err := EnsureSourceDir(); err != nil {
+			t.Fatalf("Failed to create source directory: %v", err)
+		}
+		if err := src.Fetch(); err != nil {
+			t.Fatalf("Failed to fetch with broken progress bar (%s): %v", failure, err)
+		}
+		if !src.IsFetched() {
+			t.Fatalf("Source was not cached with broken progress bar (%s)", failure)
+		}
+	}
+
+	// Once failed, the bar is never drawn again
+	bar := newProgressBar("nano-2.7.5.tar.xz", 4)
+	bar.Start()
+	if !bar.failed {
+		t.Fatalf("Progress bar failure was not caught")
+	}
+	bar.Set(1, 4)
+	bar.Finish()
+}
@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// ProvenanceSuffix is appended to the name of a cached source to form the
+// name of the sidecar recording where it came from.
+const ProvenanceSuffix = ".provenance.json"
+
+// Provenance records when and where a cached source was fetched from
+type Provenance struct {
+	URI          string    `json:"uri"`              // Declared URI of the source
+	EffectiveURL string    `json:"effective_url"`    // Where the source was actually fetched from
+	Mirror       string    `json:"mirror,omitempty"` // Mirror or archive used instead of upstream
+	Status       int       `json:"status,omitempty"` // HTTP status of the final response
+	FetchedAt    time.Time `json:"fetched_at"`
+	Algorithm    string    `json:"algorithm"`           // Algorithm of the digest, i.e. sha256
+	Digest       string    `json:"digest"`              // Verified digest of the cached file
+	Signature    string    `json:"signature,omitempty"` // Detached signature the source was verified against
+
+	// UpstreamDigest is the sha256 of the source as fetched, when it was
+	// normalized before caching.
+	UpstreamDigest string `json:"upstream_digest,omitempty"`
+}
+
+// A ProvenanceSource is able to report where its cached copy came from
+type ProvenanceSource interface {
+	Source
+
+	// GetProvenance will return the provenance of the cached source
+	GetProvenance() (*Provenance, error)
+}
+
+// isProvenanceFile will determine if the cache entry is a sidecar
+func isProvenanceFile(name string) bool {
+	return strings.HasSuffix(name, ProvenanceSuffix)
+}
+
+// getProvenancePath will return the sidecar path for the cached file
+func getProvenancePath(path string) string {
+	return path + ProvenanceSuffix
+}
+
+// readProvenance will load the sidecar of the cached file
+func readProvenance(path string) (*Provenance, error) {
+	b, err := ioutil.ReadFile(getProvenancePath(path))
+	if err != nil {
+		return nil, err
+	}
+	prov := &Provenance{}
+	if err := json.Unmarshal(b, prov); err != nil {
+		return nil, err
+	}
+	return prov, nil
+}
+
+// writeProvenance will store the sidecar next to the cached file
+func writeProvenance(path string, prov *Provenance) error {
+	b, err := json.MarshalIndent(prov, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getProvenancePath(path), append(b, '\n'), 00644)
+}
+
+// newProvenance will record the download that was just completed
+func (s *SimpleSource) newProvenance(hash, upstream string) *Provenance {
+	prov := &Provenance{
+		URI:          s.URI,
+		EffectiveURL: s.GetEffectiveURL(),
+		Mirror:       s.mirror,
+		Status:       s.status,
+		FetchedAt:    time.Now().UTC(),
+		Algorithm:    "sha256",
+		Digest:       hash,
+		Signature:    s.signature,
+	}
+	if upstream != hash {
+		prov.UpstreamDigest = upstream
+	}
+	return prov
+}
+
+// GetProvenance will return the provenance recorded when the source was
+// fetched, which remains available on cache hits from earlier runs.
+func (s *SimpleSource) GetProvenance() (*Provenance, error) {
+	return readProvenance(s.GetPath(s.validator))
+}
@@ -0,0 +1,78 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"testing"
+)
+
+func TestFetchProvenance(t *testing.T) {
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+
+	src, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	if !PathExists(src.GetPath(nanoSHA256) + ProvenanceSuffix) {
+		t.Fatalf("Provenance sidecar was not written")
+	}
+	prov, err := src.GetProvenance()
+	if err != nil {
+		t.Fatalf("Failed to read provenance: %v", err)
+	}
+	if prov.URI != server.URL() || prov.EffectiveURL != server.URL() || prov.Mirror != "" {
+		t.Fatalf("Wrong provenance location: %+v", prov)
+	}
+	if prov.Algorithm != "sha256" || prov.Digest != nanoSHA256 || prov.UpstreamDigest != "" {
+		t.Fatalf("Wrong provenance digest: %+v", prov)
+	}
+	if prov.FetchedAt.IsZero() {
+		t.Fatalf("Provenance is missing the fetch time")
+	}
+
+	// A later run only hits the cache
+	cached, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !cached.IsFetched() {
+		t.Fatalf("Source should be cached")
+	}
+	hit, err := cached.GetProvenance()
+	if err != nil {
+		t.Fatalf("Failed to read provenance on cache hit: %v", err)
+	}
+	if hit.EffectiveURL != prov.EffectiveURL || hit.Digest != prov.Digest || !hit.FetchedAt.Equal(prov.FetchedAt) {
+		t.Fatalf("Provenance changed on cache hit: %+v != %+v", hit, prov)
+	}
+
+	// Sidecars are not cached sources
+	stats, err := getCacheStats(SourceDir, GitSourceDir, GetStagingDir(), CacheStatsLargest)
+	if err != nil {
+		t.Fatalf("Failed to get cache stats: %v", err)
+	}
+	if stats.Files != 1 {
+		t.Fatalf("Provenance sidecar counted as a cached file: %d files", stats.Files)
+	}
+}
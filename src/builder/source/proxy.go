@@ -0,0 +1,126 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"github.com/andelf/go-curl"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyConfig is the set of proxies to use for downloads, per scheme
+type ProxyConfig struct {
+	HTTP    string   `toml:"http"`     // Proxy for http:// sources
+	HTTPS   string   `toml:"https"`    // Proxy for https:// sources
+	FTP     string   `toml:"ftp"`      // Proxy for ftp:// sources, which must speak HTTP
+	NoProxy []string `toml:"no_proxy"` // Hosts and domains that are always fetched directly
+}
+
+// Proxy is the proxy configuration for downloads. When set, it replaces any
+// proxy that libcurl would otherwise pick up from the environment.
+var Proxy *ProxyConfig
+
+// SetProxy will validate and set the proxy configuration for downloads
+func SetProxy(config *ProxyConfig) error {
+	if config == nil {
+		Proxy = nil
+		return nil
+	}
+	for _, proxy := range []string{config.HTTP, config.HTTPS, config.FTP} {
+		if proxy == "" {
+			continue
+		}
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid proxy URL: %s", proxy)
+		}
+	}
+	Proxy = config
+	return nil
+}
+
+// getProxy will return the proxy to use for the URL, or an empty string
+// when it should be fetched directly.
+func getProxy(u *url.URL) string {
+	if Proxy == nil {
+		return ""
+	}
+	var proxy string
+	switch u.Scheme {
+	case "http":
+		proxy = Proxy.HTTP
+	case "https":
+		proxy = Proxy.HTTPS
+	case "ftp":
+		proxy = Proxy.FTP
+	}
+	if proxy == "" || isNoProxy(u.Hostname()) {
+		return ""
+	}
+	return proxy
+}
+
+// isNoProxy will determine whether the host is exempt from the proxy,
+// matching the host itself or any subdomain of a no_proxy entry.
+func isNoProxy(host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range Proxy.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if entry == "" {
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// setProxyOptions will configure the curl handle to use the configured
+// proxy for the URL. An empty proxy stops libcurl from using one set in
+// the environment instead.
+func setProxyOptions(hnd *curl.CURL, u *url.URL) {
+	if Proxy == nil {
+		return
+	}
+	hnd.Setopt(curl.OPT_PROXY, getProxy(u))
+}
+
+// proxyFromConfig will return the configured proxy for the request, for use
+// with net/http clients, falling back to the environment when unset.
+func proxyFromConfig(req *http.Request) (*url.URL, error) {
+	if Proxy == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if proxy := getProxy(req.URL); proxy != "" {
+		return url.Parse(proxy)
+	}
+	return nil, nil
+}
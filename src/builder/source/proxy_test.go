@@ -0,0 +1,73 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	defer SetProxy(nil)
+
+	if err := SetProxy(&ProxyConfig{HTTP: "proxy.example.com"}); err == nil {
+		t.Fatalf("Proxy without a scheme should be rejected")
+	}
+	err := SetProxy(&ProxyConfig{
+		HTTP:    "http://proxy.example.com:3128",
+		FTP:     "http://ftp-proxy.example.com:3128",
+		NoProxy: []string{".internal.example.com", "localhost:8080"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set proxy: %v", err)
+	}
+
+	tests := map[string]string{
+		"http://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz":   "http://proxy.example.com:3128",
+		"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz":  "",
+		"ftp://ftp.gnu.org/gnu/nano/nano-2.7.5.tar.xz":         "http://ftp-proxy.example.com:3128",
+		"http://internal.example.com/nano-2.7.5.tar.xz":        "",
+		"http://mirror.internal.example.com/nano-2.7.5.tar.xz": "",
+		"http://notinternal.example.com/nano-2.7.5.tar.xz":     "http://proxy.example.com:3128",
+		"http://LOCALHOST:8080/nano-2.7.5.tar.xz":              "",
+	}
+	for uri, want := range tests {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatalf("Failed to parse URL: %v", err)
+		}
+		if got := getProxy(u); got != want {
+			t.Fatalf("Wrong proxy for %s: '%s', expected '%s'", uri, got, want)
+		}
+	}
+
+	// Configured proxies replace those of the environment
+	req, err := http.NewRequest("GET", "https://dns.example.com/dns-query", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if proxy, err := proxyFromConfig(req); err != nil || proxy != nil {
+		t.Fatalf("Unconfigured scheme should not be proxied: %v (%v)", proxy, err)
+	}
+
+	Proxy.NoProxy = []string{"*"}
+	u, _ := url.Parse("http://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz")
+	if proxy := getProxy(u); proxy != "" {
+		t.Fatalf("Wildcard no_proxy should disable the proxy, got: %s", proxy)
+	}
+}
@@ -0,0 +1,225 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// A PrunePolicy selects the cached sources to remove. Sources are only
+// recorded as used when a build or fetch finds them in the cache.
+type PrunePolicy struct {
+	MaxAge  time.Duration // Remove sources unused for longer than this, if set
+	MaxSize int64         // Remove the least recently used sources beyond this many bytes, if set
+	DryRun  bool          // Only report what would be removed
+}
+
+// A PrunedSource is a cached file removed from the source cache
+type PrunedSource struct {
+	CacheEntry
+	LastUsed time.Time
+}
+
+// PruneResult records the changes made while pruning the source cache
+type PruneResult struct {
+	Removed       []PrunedSource // Cached files removed, least recently used first
+	FreedBytes    int64          // Bytes freed, counting hardlinks once
+	DanglingLinks int            // Links to missing hash directories, removed
+}
+
+// isHashDir will determine if the cache entry is named by a sha256sum
+func isHashDir(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// getCachedSources will find every file in a real hash directory, least
+// recently used first.
+func getCachedSources(sourceDir string) ([]PrunedSource, error) {
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	var cached []PrunedSource
+	for _, entry := range entries {
+		if !entry.IsDir() || !isHashDir(entry.Name()) {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(sourceDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if !fi.Mode().IsRegular() || isProvenanceFile(fi.Name()) {
+				continue
+			}
+			cached = append(cached, PrunedSource{
+				CacheEntry: CacheEntry{Hash: entry.Name(), File: fi.Name(), Size: fi.Size()},
+				LastUsed:   getLastUsed(fi),
+			})
+		}
+	}
+	sort.SliceStable(cached, func(i, j int) bool {
+		return cached[i].LastUsed.Before(cached[j].LastUsed)
+	})
+	return cached, nil
+}
+
+// getDiskUsage will return the bytes used by the cached files, counting
+// hardlinked copies once.
+func getDiskUsage(sourceDir string, cached []PrunedSource) int64 {
+	seen := make(map[uint64]bool)
+	var total int64
+	for _, c := range cached {
+		fi, err := os.Stat(filepath.Join(sourceDir, c.Hash, c.File))
+		if err != nil {
+			continue
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			if seen[st.Ino] {
+				continue
+			}
+			seen[st.Ino] = true
+		}
+		total += fi.Size()
+	}
+	return total
+}
+
+// getFreedBytes will return the bytes freed by removing the cached file,
+// which are none while another hardlink remains. A dry run leaves every
+// link in place, so it counts the links it would have removed from each
+// inode in unlinked instead.
+func getFreedBytes(sourceDir string, c PrunedSource, unlinked map[uint64]uint64) int64 {
+	fi, err := os.Stat(filepath.Join(sourceDir, c.Hash, c.File))
+	if err != nil {
+		return c.Size
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return c.Size
+	}
+	links := uint64(st.Nlink)
+	if unlinked != nil {
+		links -= unlinked[st.Ino]
+		unlinked[st.Ino]++
+	}
+	if links > 1 {
+		return 0
+	}
+	return c.Size
+}
+
+// removeCachedSource will remove the cached file along with its sidecar,
+// and the hash directory with any links to it once empty.
+func removeCachedSource(sourceDir string, c PrunedSource) error {
+	path := filepath.Join(sourceDir, c.Hash, c.File)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(getProvenancePath(path))
+	hashDir := filepath.Dir(path)
+	if remaining, err := ioutil.ReadDir(hashDir); err != nil || len(remaining) > 0 {
+		return nil
+	}
+	if err := os.Remove(hashDir); err != nil {
+		return err
+	}
+	return removeHashLinks(sourceDir, c.Hash)
+}
+
+// removeDanglingLinks will remove the links within the cache pointing at
+// missing hash directories, i.e. legacy sha1sum links left behind when
+// their target was deleted.
+func removeDanglingLinks(sourceDir string, dryRun bool) (int, error) {
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		link := filepath.Join(sourceDir, entry.Name())
+		if entry.Mode()&os.ModeSymlink != os.ModeSymlink || PathExists(link) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(link); err != nil {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// PruneCache will remove the cached sources selected by the policy from
+// the source cache, along with any dangling links.
+func PruneCache(policy PrunePolicy) (*PruneResult, error) {
+	return pruneCache(SourceDir, policy, time.Now())
+}
+
+// pruneCache will first remove the sources unused since the maximum age,
+// and then the least recently used sources until the cache fits in the
+// maximum size.
+func pruneCache(sourceDir string, policy PrunePolicy, now time.Time) (*PruneResult, error) {
+	result := &PruneResult{}
+	if !PathExists(sourceDir) {
+		return result, nil
+	}
+	cached, err := getCachedSources(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	usage := getDiskUsage(sourceDir, cached)
+
+	var unlinked map[uint64]uint64
+	if policy.DryRun {
+		unlinked = make(map[uint64]uint64)
+	}
+	for _, c := range cached {
+		expired := policy.MaxAge > 0 && now.Sub(c.LastUsed) > policy.MaxAge
+		oversized := policy.MaxSize > 0 && usage > policy.MaxSize
+		if !expired && !oversized {
+			// Everything else was used more recently
+			break
+		}
+		freed := getFreedBytes(sourceDir, c, unlinked)
+		if !policy.DryRun {
+			if err := removeCachedSource(sourceDir, c); err != nil {
+				return result, err
+			}
+		}
+		usage -= freed
+		result.FreedBytes += freed
+		result.Removed = append(result.Removed, c)
+	}
+
+	if result.DanglingLinks, err = removeDanglingLinks(sourceDir, policy.DryRun); err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCachedSource will cache the contents under a fake hash, last used
+// the given number of days ago.
+func writeCachedSource(t *testing.T, sourceDir, hash, name string, size int, days int) {
+	dir := filepath.Join(sourceDir, hash)
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", size)), 00644); err != nil {
+		t.Fatalf("Failed to write cached source: %v", err)
+	}
+	used := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	if err := os.Chtimes(path, used, used); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-prune")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	old := strings.Repeat("a", 64)
+	stale := strings.Repeat("b", 64)
+	recent := strings.Repeat("c", 64)
+	writeCachedSource(t, tmp, old, "nano-2.7.4.tar.xz", 100, 200)
+	writeCachedSource(t, tmp, stale, "nano-2.7.5.tar.xz", 100, 30)
+	writeCachedSource(t, tmp, recent, "nano-2.8.0.tar.xz", 100, 1)
+
+	// Legacy links to a pruned source, and one left dangling already
+	if err := os.Symlink(filepath.Join(tmp, old), filepath.Join(tmp, strings.Repeat("1", 40))); err != nil {
+		t.Fatalf("Failed to create legacy link: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmp, "missing"), filepath.Join(tmp, strings.Repeat("2", 40))); err != nil {
+		t.Fatalf("Failed to create dangling link: %v", err)
+	}
+	// Anything not named by a hash is never touched
+	if err := os.MkdirAll(filepath.Join(tmp, "staging"), 00755); err != nil {
+		t.Fatalf("Failed to create staging directory: %v", err)
+	}
+	writeCachedSource(t, tmp, "staging", "partial.tar.xz", 100, 365)
+
+	now := time.Now()
+	result, err := pruneCache(tmp, PrunePolicy{MaxAge: 90 * 24 * time.Hour, DryRun: true}, now)
+	if err != nil {
+		t.Fatalf("Failed to prune cache: %v", err)
+	}
+	if len(result.Removed) != 1 || result.DanglingLinks != 1 || !PathExists(filepath.Join(tmp, old)) {
+		t.Fatalf("Dry run should only report changes: %+v", result)
+	}
+
+	result, err = pruneCache(tmp, PrunePolicy{MaxAge: 90 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatalf("Failed to prune cache: %v", err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Hash != old || result.FreedBytes != 100 {
+		t.Fatalf("Only the source unused for 90 days should be removed: %+v", result)
+	}
+	if PathExists(filepath.Join(tmp, old)) || PathExists(filepath.Join(tmp, strings.Repeat("1", 40))) {
+		t.Fatalf("Pruned hash directory and its legacy link should be removed")
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, strings.Repeat("2", 40))); err == nil || result.DanglingLinks != 1 {
+		t.Fatalf("Dangling links should be removed: %d", result.DanglingLinks)
+	}
+	if !PathExists(filepath.Join(tmp, "staging", "partial.tar.xz")) {
+		t.Fatalf("Staging directory should not be pruned")
+	}
+
+	// Least recently used first, until the cache fits
+	result, err = pruneCache(tmp, PrunePolicy{MaxSize: 150}, now)
+	if err != nil {
+		t.Fatalf("Failed to prune cache: %v", err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Hash != stale {
+		t.Fatalf("Least recently used source should be removed: %+v", result)
+	}
+	if !PathExists(filepath.Join(tmp, recent, "nano-2.8.0.tar.xz")) {
+		t.Fatalf("Most recently used source should be kept")
+	}
+}
+
+func TestPruneDryRunHardlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-prune")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Deduplicated copies under two names, and a lone older source
+	hash := strings.Repeat("a", 64)
+	writeCachedSource(t, tmp, hash, "nano-2.7.5.tar.xz", 100, 20)
+	if err := os.Link(filepath.Join(tmp, hash, "nano-2.7.5.tar.xz"), filepath.Join(tmp, hash, "v2.7.5.tar.xz")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	writeCachedSource(t, tmp, strings.Repeat("b", 64), "vim-8.0.tar.bz2", 100, 30)
+
+	// Only removing both names frees the copy, and then the cache fits
+	now := time.Now()
+	dry, err := pruneCache(tmp, PrunePolicy{MaxSize: 50, DryRun: true}, now)
+	if err != nil {
+		t.Fatalf("Failed to prune cache: %v", err)
+	}
+	actual, err := pruneCache(tmp, PrunePolicy{MaxSize: 50}, now)
+	if err != nil {
+		t.Fatalf("Failed to prune cache: %v", err)
+	}
+	if dry.FreedBytes != 200 || len(dry.Removed) != 3 {
+		t.Fatalf("Wrong dry run result: %d %d", dry.FreedBytes, len(dry.Removed))
+	}
+	if actual.FreedBytes != dry.FreedBytes || len(actual.Removed) != len(dry.Removed) {
+		t.Fatalf("Dry run should match the real run: %d %d", actual.FreedBytes, len(actual.Removed))
+	}
+}
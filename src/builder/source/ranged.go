@@ -0,0 +1,244 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	curl "github.com/andelf/go-curl"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// RangeConnections is the number of connections used to download a
+	// single large file from servers supporting byte ranges. A value of 1
+	// disables ranged downloads.
+	RangeConnections = 1
+
+	// RangeMinSize is the smallest file, in bytes, that will be downloaded
+	// with several connections.
+	RangeMinSize int64 = 64 * 1024 * 1024
+
+	// errRangesUnsupported is returned when a ranged download isn't possible
+	errRangesUnsupported = errors.New("Server does not support byte ranges")
+
+	// errRangeOverflow is returned when a server sends more than requested
+	errRangeOverflow = errors.New("Server sent more data than the requested range")
+)
+
+// A byteRange is an inclusive range of bytes within a file
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// splitRanges will split the file into n contiguous ranges of roughly
+// equal size.
+func splitRanges(size int64, n int) []byteRange {
+	if n < 1 {
+		n = 1
+	}
+	if int64(n) > size {
+		n = int(size)
+	}
+	var ranges []byteRange
+	chunk := size / int64(n)
+	for i := 0; i < n; i++ {
+		r := byteRange{start: int64(i) * chunk, end: int64(i+1)*chunk - 1}
+		if i == n-1 {
+			r.end = size - 1
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// hasHeader will determine whether the final response within the raw
+// headers has the header set to the given value.
+func hasHeader(headers []string, name, value string) bool {
+	for i := len(headers) - 1; i >= 0; i-- {
+		// Stop at the status line of the final response
+		if strings.HasPrefix(headers[i], "HTTP/") {
+			break
+		}
+		fields := strings.SplitN(headers[i], ":", 2)
+		if len(fields) == 2 && strings.EqualFold(strings.TrimSpace(fields[0]), name) {
+			return strings.EqualFold(strings.TrimSpace(fields[1]), value)
+		}
+	}
+	return false
+}
+
+// rangeWriter writes a single range into its place within the file
+type rangeWriter struct {
+	file   *os.File
+	offset int64
+	end    int64
+}
+
+// Write will write the data at the current offset within the range
+func (w *rangeWriter) Write(b []byte) (int, error) {
+	if w.offset+int64(len(b)) > w.end+1 {
+		return 0, errRangeOverflow
+	}
+	n, err := w.file.WriteAt(b, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// downloadRange will fetch the range of the source into its place within
+// the file, calling progress with the number of bytes written.
+func (s *SimpleSource) downloadRange(file *os.File, r byteRange, progress func(int64)) error {
+	hnd, release := acquireHandle(s.url)
+	defer release()
+
+	hnd.Setopt(curl.OPT_RANGE, fmt.Sprintf("%d-%d", r.start, r.end))
+	GetNetworkPolicy(NetworkDownload).setCurlOptions(hnd)
+	setProxyOptions(hnd, s.url)
+	if err := setResolveOptions(hnd, s.url); err != nil {
+		return err
+	}
+	hnd.Setopt(curl.OPT_USERAGENT, fmt.Sprintf("solbuild 1.3.0"))
+
+	out := &rangeWriter{file: file, offset: r.start, end: r.end}
+	var writeErr error
+	writer := func(data []byte) bool {
+		throttle(len(data))
+		if _, writeErr = out.Write(data); writeErr != nil {
+			return false
+		}
+		progress(int64(len(data)))
+		return true
+	}
+
+	if err := s.performRequest(hnd, s.requestHeaders("GET"), writer); err != nil {
+		if writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+	if err := checkRedirect(hnd); err != nil {
+		return err
+	}
+	// Anything other than a partial response means the range was ignored
+	if info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE); err == nil {
+		if code, ok := info.(int); !ok || code != http.StatusPartialContent {
+			return errRangesUnsupported
+		}
+	}
+	if out.offset != r.end+1 {
+		return fmt.Errorf("Incomplete range %d-%d, stopped at %d", r.start, r.end, out.offset)
+	}
+	return nil
+}
+
+// downloadRanged will fetch the source over several connections at once,
+// each fetching one range of the file. errRangesUnsupported is returned
+// when the server doesn't support ranges, or the file is too small to
+// benefit from them.
+func (s *SimpleSource) downloadRanged(destination string) (err error) {
+	if RangeConnections < 2 {
+		return errRangesUnsupported
+	}
+	status, size, headers, err := s.headRequestHeaders()
+	if err != nil {
+		return err
+	}
+	if status >= 400 || size < RangeMinSize || size < int64(RangeConnections) || !hasHeader(headers, "Accept-Ranges", "bytes") {
+		return errRangesUnsupported
+	}
+
+	discardHashCheckpoint(destination)
+	file, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// The file is sparse until every range completes, so can't be resumed
+	defer func() {
+		if err != nil {
+			os.Remove(destination)
+		}
+	}()
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+
+	ranges := splitRanges(size, RangeConnections)
+	log.WithFields(log.Fields{
+		"uri":         s.URI,
+		"size":        size,
+		"connections": len(ranges),
+	}).Debug("Downloading source in ranges")
+
+	pbar := newProgressBar(filepath.Base(destination), size)
+	pbar.Start()
+	defer pbar.Finish()
+
+	var lock sync.Mutex
+	var done int64
+	progress := func(n int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		done += n
+		pbar.Set(done, size)
+		s.reportProgress(done, size)
+	}
+
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r byteRange) {
+			defer wg.Done()
+			errs[i] = s.downloadRange(file, r, progress)
+		}(i, r)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	s.status = status
+	return file.Close()
+}
+
+// downloadHTTP will fetch the source in ranges when possible, falling back
+// to a single stream otherwise.
+func (s *SimpleSource) downloadHTTP(destination string) error {
+	if getResumeOffset(destination) > 0 {
+		return s.downloadRateLimited(destination)
+	}
+	err := s.downloadRanged(destination)
+	if err == nil {
+		return nil
+	}
+	if err != errRangesUnsupported {
+		log.WithFields(log.Fields{
+			"uri":   s.URI,
+			"error": err,
+		}).Warning("Ranged download failed, falling back to a single connection")
+	}
+	return s.downloadRateLimited(destination)
+}
@@ -0,0 +1,157 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		size   int64
+		n      int
+		ranges []byteRange
+	}{
+		{100, 1, []byteRange{{0, 99}}},
+		{100, 4, []byteRange{{0, 24}, {25, 49}, {50, 74}, {75, 99}}},
+		{10, 3, []byteRange{{0, 2}, {3, 5}, {6, 9}}},
+		{2, 4, []byteRange{{0, 0}, {1, 1}}},
+		{5, 0, []byteRange{{0, 4}}},
+	}
+	for _, test := range tests {
+		ranges := splitRanges(test.size, test.n)
+		if len(ranges) != len(test.ranges) {
+			t.Fatalf("Wrong ranges for %d/%d: %v", test.size, test.n, ranges)
+		}
+		for i := range ranges {
+			if ranges[i] != test.ranges[i] {
+				t.Fatalf("Wrong ranges for %d/%d: %v", test.size, test.n, ranges)
+			}
+		}
+	}
+}
+
+func TestHasHeader(t *testing.T) {
+	headers := []string{
+		"HTTP/1.1 301 Moved Permanently\r\n",
+		"Accept-Ranges: bytes\r\n",
+		"\r\n",
+		"HTTP/1.1 200 OK\r\n",
+		"accept-ranges: none\r\n",
+		"\r\n",
+	}
+	if hasHeader(headers, "Accept-Ranges", "bytes") {
+		t.Fatalf("Should only consider the final response")
+	}
+	if !hasHeader(headers[:3], "Accept-Ranges", "bytes") {
+		t.Fatalf("Failed to find Accept-Ranges header")
+	}
+}
+
+func TestRangeWriter(t *testing.T) {
+	f, err := ioutil.TempFile("", "solbuild-range")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := &rangeWriter{file: f, offset: 2, end: 5}
+	if _, err := w.Write([]byte("nano")); err != nil {
+		t.Fatalf("Failed to write range: %v", err)
+	}
+	if _, err := w.Write([]byte("x")); err != errRangeOverflow {
+		t.Fatalf("Expected overflow error, got: %v", err)
+	}
+	(&rangeWriter{file: f, offset: 0, end: 1}).Write([]byte("ab"))
+	if contents, _ := ioutil.ReadFile(f.Name()); string(contents) != "abnano" {
+		t.Fatalf("Wrong contents after ranged writes: %s", contents)
+	}
+}
+
+func TestRangedDownload(t *testing.T) {
+	contents := bytes.Repeat([]byte("nano-2.7.5"), 1000)
+	var lock sync.Mutex
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nano-2.7.5.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		lock.Unlock()
+		http.ServeContent(w, r, "nano-2.7.5.tar.xz", time.Time{}, bytes.NewReader(contents))
+	})
+	mux.HandleFunc("/noranges.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		lock.Unlock()
+		w.Write(contents)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	RangeConnections, RangeMinSize = 4, 1024
+	defer func() {
+		RangeConnections, RangeMinSize = 1, 64*1024*1024
+	}()
+
+	src, err := NewSimple(server.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, src.File)
+	if err := src.download(dest); err != nil {
+		t.Fatalf("Failed to download ranged source: %v", err)
+	}
+	if got, _ := ioutil.ReadFile(dest); !bytes.Equal(got, contents) {
+		t.Fatalf("Ranged download was not reassembled correctly")
+	}
+	// HEAD request plus one request per connection
+	if len(ranges) != 5 || !strings.HasPrefix(strings.Join(ranges, ","), ",bytes=") {
+		t.Fatalf("Wrong requests for ranged download: %v", ranges)
+	}
+
+	ranges = nil
+	single, err := NewSimple(server.URL+"/noranges.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest = filepath.Join(tmp, single.File)
+	if err := single.download(dest); err != nil {
+		t.Fatalf("Failed to fall back to a single connection: %v", err)
+	}
+	if got, _ := ioutil.ReadFile(dest); !bytes.Equal(got, contents) {
+		t.Fatalf("Fallback download has the wrong contents")
+	}
+	if len(ranges) != 2 || ranges[1] != "" {
+		t.Fatalf("Should fall back to a single request without ranges: %v", ranges)
+	}
+}
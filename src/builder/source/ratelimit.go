@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitSleep is used to wait between attempts. Overridden in tests.
+var rateLimitSleep = time.Sleep
+
+// A RateLimitError is returned when the server responds with HTTP 429
+type RateLimitError struct {
+	URI  string
+	Wait time.Duration // How long the server asked us to wait, if known
+}
+
+// Error will return a description of the rate limiting
+func (r *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limited by server for %s, retry after %v", r.URI, r.Wait)
+}
+
+// parseRetryAfter will parse the value of the Retry-After header, which may
+// either be a number of seconds or a HTTP-date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	when, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := when.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// getRetryAfter will find the wait duration within the raw response headers,
+// returning 0 when the server didn't specify one.
+func getRetryAfter(headers []string) time.Duration {
+	// Only the headers of the final response are relevant
+	for i := len(headers) - 1; i >= 0; i-- {
+		fields := strings.SplitN(headers[i], ":", 2)
+		if len(fields) != 2 || !strings.EqualFold(strings.TrimSpace(fields[0]), "Retry-After") {
+			continue
+		}
+		if wait, ok := parseRetryAfter(fields[1], time.Now()); ok {
+			return wait
+		}
+		break
+	}
+	return 0
+}
+
+// downloadRateLimited will download the source, waiting and retrying as
+// requested by the server whenever it responds with HTTP 429. Without a
+// Retry-After header, the backoff of the download policy is used.
+func (s *SimpleSource) downloadRateLimited(destination string) error {
+	policy := GetNetworkPolicy(NetworkDownload)
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		err := s.downloadCurl(destination)
+		limited, ok := err.(*RateLimitError)
+		if !ok {
+			return err
+		}
+		wait := limited.Wait
+		if wait == 0 {
+			wait = policy.Backoff(attempt)
+		}
+		if attempt >= policy.Retries || waited+wait > policy.RateLimitWait {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"uri":  s.URI,
+			"wait": wait,
+		}).Warning("Source server is rate limiting requests, waiting to retry")
+		rateLimitSleep(wait)
+		waited += wait
+	}
+}
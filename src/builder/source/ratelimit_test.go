@@ -0,0 +1,118 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"Wed, 01 Mar 2017 12:01:30 GMT", 90 * time.Second, true},
+		{"Wed, 01 Mar 2017 11:00:00 GMT", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		wait, ok := parseRetryAfter(test.value, now)
+		if ok != test.ok || wait != test.wait {
+			t.Fatalf("Wrong result for '%s': %v %v", test.value, wait, ok)
+		}
+	}
+	if wait := getRetryAfter([]string{"HTTP/1.1 429 Too Many Requests\r\n", "Retry-After: 7\r\n"}); wait != 7*time.Second {
+		t.Fatalf("Failed to find Retry-After header: %v", wait)
+	}
+	if wait := getRetryAfter([]string{"HTTP/1.1 429 Too Many Requests\r\n"}); wait != 0 {
+		t.Fatalf("Should not find a wait without Retry-After: %v", wait)
+	}
+}
+
+func TestRateLimitedDownload(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nano-2.7.5.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("nano"))
+	})
+	mux.HandleFunc("/busy.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var waits []time.Duration
+	rateLimitSleep = func(d time.Duration) {
+		waits = append(waits, d)
+	}
+	defer func() {
+		rateLimitSleep = time.Sleep
+	}()
+
+	src, err := NewSimple(server.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, src.File)
+	if err := src.download(dest); err != nil {
+		t.Fatalf("Failed to download rate limited source: %v", err)
+	}
+	if len(waits) != 2 || waits[0] != 2*time.Second || waits[1] != 2*time.Second {
+		t.Fatalf("Retry-After was not respected: %v", waits)
+	}
+	if contents, _ := ioutil.ReadFile(dest); string(contents) != "nano" {
+		t.Fatalf("Wrong contents after retrying: %s", contents)
+	}
+
+	waits = nil
+	busy, err := NewSimple(server.URL+"/busy.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	err = busy.download(filepath.Join(tmp, busy.File))
+	if _, ok := err.(*RateLimitError); !ok {
+		t.Fatalf("Expected rate limit error, got: %v", err)
+	}
+	if len(waits) != 0 {
+		t.Fatalf("Should not wait beyond the policy RateLimitWait: %v", waits)
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+// ErrBrokenLink is returned when a legacy symlink points to a missing entry
+var ErrBrokenLink = errors.New("Legacy source link is broken")
+
+// A Resolvable source is able to report its canonical location within the
+// source cache.
+type Resolvable interface {
+
+	// GetCanonicalPath will return the fully resolved cache path for the
+	// source, and whether it was reached via a legacy symlink.
+	GetCanonicalPath() (string, bool, error)
+}
+
+// resolveCachePath will resolve the given cached source path, determining
+// whether its hash directory is a legacy sha1sum symlink to the real sha256sum
+// directory.
+func resolveCachePath(path string) (string, bool, error) {
+	legacy := false
+	if st, err := os.Lstat(filepath.Dir(path)); err == nil {
+		legacy = st.Mode()&os.ModeSymlink == os.ModeSymlink
+	} else {
+		return "", false, err
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if legacy && os.IsNotExist(err) {
+			return "", true, ErrBrokenLink
+		}
+		return "", legacy, err
+	}
+	return resolved, legacy, nil
+}
+
+// GetCanonicalPath will return the real path of the cached source. Legacy
+// sources are keyed by their sha1sum, which is a symlink to the directory
+// named after the sha256sum.
+func (s *SimpleSource) GetCanonicalPath() (string, bool, error) {
+	return resolveCachePath(s.GetPath(s.validator))
+}
+
+// GetCanonicalPath will return the path of the local clone. git sources are
+// never stored via legacy links.
+func (g *GitSource) GetCanonicalPath() (string, bool, error) {
+	resolved, err := filepath.EvalSymlinks(g.ClonePath)
+	if err != nil {
+		return "", false, err
+	}
+	return resolved, false, nil
+}
+
+// isSymlink will determine whether the path is a symlink, dangling or not
+func isSymlink(path string) bool {
+	st, err := os.Lstat(path)
+	return err == nil && st.Mode()&os.ModeSymlink == os.ModeSymlink
+}
+
+// healLegacyLink will restore a missing or dangling legacy sha1sum symlink
+// for the source within the given cache directory, when the content is
+// still cached under its sha256sum. This avoids downloading the source again.
+func (s *SimpleSource) healLegacyLink(sourceDir string) (bool, error) {
+	link := filepath.Join(sourceDir, s.validator)
+	if PathExists(link) && !isSymlink(link) {
+		return false, nil
+	}
+
+	candidates, err := filepath.Glob(filepath.Join(sourceDir, "*", s.File))
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range candidates {
+		hashDir := filepath.Dir(candidate)
+		hash := filepath.Base(hashDir)
+		if hash == s.validator || len(hash) != sha256.Size*2 || isSymlink(hashDir) {
+			continue
+		}
+		digests, err := GetDigests(candidate, ValidatorSHA1, ValidatorSHA256)
+		if err != nil || digests[ValidatorSHA1] != s.validator {
+			continue
+		}
+		// Never link to content that doesn't match its own hash
+		if digests[ValidatorSHA256] != hash {
+			log.WithFields(log.Fields{
+				"path": candidate,
+			}).Warning("Cached source does not match its sha256sum")
+			continue
+		}
+
+		if isSymlink(link) {
+			if err := os.Remove(link); err != nil {
+				return false, err
+			}
+		}
+		if err := os.Symlink(hash, link); err != nil {
+			return false, err
+		}
+		log.WithFields(log.Fields{
+			"source": s.File,
+			"sha1":   s.validator,
+			"sha256": hash,
+		}).Info("Restored legacy source link")
+		return true, nil
+	}
+	return false, nil
+}
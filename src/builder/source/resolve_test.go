@@ -0,0 +1,137 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCachePath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-resolve")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, _ = filepath.EvalSymlinks(tmp)
+
+	sha256Dir := filepath.Join(tmp, "a64d24e6bc4fc448376d038f9a755af77f8e748c9051b6e45bf85e783a7e67e4")
+	sha1Dir := filepath.Join(tmp, "c5ba2d7c4ed4e5ddc0ab10b4e8b6b19b3b2e0ec4")
+	if err := os.MkdirAll(sha256Dir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+	canonical := filepath.Join(sha256Dir, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(canonical, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	if err := os.Symlink(filepath.Base(sha256Dir), sha1Dir); err != nil {
+		t.Fatalf("Failed to create legacy link: %v", err)
+	}
+
+	// Direct sha256sum entry
+	path, legacy, err := resolveCachePath(canonical)
+	if err != nil {
+		t.Fatalf("Failed to resolve direct entry: %v", err)
+	}
+	if path != canonical || legacy {
+		t.Fatalf("Wrong resolution for direct entry: %v (legacy: %v)", path, legacy)
+	}
+
+	// Legacy sha1sum entry
+	path, legacy, err = resolveCachePath(filepath.Join(sha1Dir, "nano-2.7.5.tar.xz"))
+	if err != nil {
+		t.Fatalf("Failed to resolve legacy entry: %v", err)
+	}
+	if path != canonical || !legacy {
+		t.Fatalf("Wrong resolution for legacy entry: %v (legacy: %v)", path, legacy)
+	}
+
+	// Broken legacy entry
+	if err := os.RemoveAll(sha256Dir); err != nil {
+		t.Fatalf("Failed to remove hash directory: %v", err)
+	}
+	if _, legacy, err = resolveCachePath(filepath.Join(sha1Dir, "nano-2.7.5.tar.xz")); err != ErrBrokenLink || !legacy {
+		t.Fatalf("Broken legacy entry should be reported: %v", err)
+	}
+}
+
+func TestHealLegacyLink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-resolve")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	contents := []byte("nano")
+	sum1 := sha1.Sum(contents)
+	sum256 := sha256.Sum256(contents)
+	sha1sum := hex.EncodeToString(sum1[:])
+	sha256sum := hex.EncodeToString(sum256[:])
+
+	sha256Dir := filepath.Join(tmp, sha256sum)
+	if err := os.MkdirAll(sha256Dir, 00755); err != nil {
+		t.Fatalf("Failed to create hash directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sha256Dir, "nano-2.7.5.tar.xz"), contents, 00644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	src, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", sha1sum, true)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	link := filepath.Join(tmp, sha1sum)
+
+	// Missing legacy link
+	if healed, err := src.healLegacyLink(tmp); err != nil || !healed {
+		t.Fatalf("Failed to restore missing link: %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != sha256sum {
+		t.Fatalf("Wrong legacy link: %v %v", target, err)
+	}
+
+	// Dangling legacy link
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("Failed to remove link: %v", err)
+	}
+	if err := os.Symlink("0000000000000000000000000000000000000000000000000000000000000000", link); err != nil {
+		t.Fatalf("Failed to create dangling link: %v", err)
+	}
+	if healed, err := src.healLegacyLink(tmp); err != nil || !healed {
+		t.Fatalf("Failed to restore dangling link: %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != sha256sum {
+		t.Fatalf("Wrong legacy link: %v %v", target, err)
+	}
+
+	// Corrupt content must not be linked
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("Failed to remove link: %v", err)
+	}
+	corrupt := filepath.Join(tmp, "1111111111111111111111111111111111111111111111111111111111111111")
+	if err := os.Rename(sha256Dir, corrupt); err != nil {
+		t.Fatalf("Failed to rename hash directory: %v", err)
+	}
+	if healed, _ := src.healLegacyLink(tmp); healed || isSymlink(link) {
+		t.Fatalf("Should not link content with a mismatched sha256sum")
+	}
+}
@@ -0,0 +1,49 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"os"
+)
+
+var (
+	// ForceFreshDownloads will discard any partial download left in the
+	// staging directory by an interrupted fetch, instead of resuming it.
+	ForceFreshDownloads = false
+
+	// errResumeUnsupported is returned when the server ignored the range
+	// requested to resume a download.
+	errResumeUnsupported = errors.New("Server cannot resume the download")
+)
+
+// getResumeOffset will return the size of any partial download left at
+// the destination by an interrupted fetch, or 0 to start afresh.
+func getResumeOffset(destination string) int64 {
+	st, err := os.Stat(destination)
+	if err != nil || !st.Mode().IsRegular() || st.Size() == 0 {
+		return 0
+	}
+	if ForceFreshDownloads {
+		log.WithFields(log.Fields{
+			"path": destination,
+		}).Debug("Discarding partial download")
+		return 0
+	}
+	return st.Size()
+}
@@ -0,0 +1,88 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetResumeOffset(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-resume")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() {
+		ForceFreshDownloads = false
+	}()
+
+	partial := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if offset := getResumeOffset(partial); offset != 0 {
+		t.Fatalf("Missing downloads should start afresh, got %d", offset)
+	}
+	if err := ioutil.WriteFile(partial, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write partial download: %v", err)
+	}
+	if offset := getResumeOffset(partial); offset != 4 {
+		t.Fatalf("Expected to resume from 4, got %d", offset)
+	}
+	ForceFreshDownloads = true
+	if offset := getResumeOffset(partial); offset != 0 {
+		t.Fatalf("Fresh downloads should not resume, got %d", offset)
+	}
+}
+
+func TestResumeHTTP(t *testing.T) {
+	contents := []byte("nano is a small and friendly text editor")
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "nano-2.7.5.tar.xz", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-resume")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src, err := NewSimple(server.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, src.File)
+	if err := ioutil.WriteFile(dest, contents[:10], 00644); err != nil {
+		t.Fatalf("Failed to write partial download: %v", err)
+	}
+	if err := src.downloadCurl(dest); err != nil {
+		t.Fatalf("Failed to resume download: %v", err)
+	}
+	if b, err := ioutil.ReadFile(dest); err != nil || !bytes.Equal(b, contents) {
+		t.Fatalf("Resumed download has wrong contents: %q %v", b, err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=10-" {
+		t.Fatalf("Expected a single range request from 10, got %v", ranges)
+	}
+}
@@ -0,0 +1,105 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/andelf/go-curl"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"syscall"
+	"time"
+)
+
+// retrySleep is used to wait between fetch attempts. Overridden in tests.
+var retrySleep = time.Sleep
+
+// An HTTPStatusError is returned when the server responds with an error
+type HTTPStatusError struct {
+	URI  string
+	Code int
+}
+
+// Error will return a description of the failed request
+func (h *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Server returned HTTP status %d for %s", h.Code, h.URI)
+}
+
+// IsRetryable will determine whether the fetch error is transient, such as
+// a timeout, a reset connection or a server error, so that the fetch may
+// succeed if tried again. Anything else, such as a missing file or a
+// checksum mismatch, is permanent.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *HTTPStatusError:
+		return e.Code >= 500 || e.Code == http.StatusRequestTimeout
+	case *textproto.Error:
+		// FTP uses 4xx replies for transient failures
+		return e.Code >= 400 && e.Code < 500
+	case curl.CurlError:
+		switch e {
+		case curl.E_COULDNT_RESOLVE_HOST, curl.E_COULDNT_CONNECT, curl.E_PARTIAL_FILE,
+			curl.E_OPERATION_TIMEDOUT, curl.E_SSL_CONNECT_ERROR, curl.E_GOT_NOTHING,
+			curl.E_SEND_ERROR, curl.E_RECV_ERROR:
+			return true
+		}
+		return false
+	case *net.OpError:
+		if e.Timeout() {
+			return true
+		}
+		return IsRetryable(e.Err)
+	case *os.SyscallError:
+		return IsRetryable(e.Err)
+	case syscall.Errno:
+		switch e {
+		case syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.ETIMEDOUT, syscall.EPIPE:
+			return true
+		}
+		return false
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// fetchRetrying will download and verify the source, retrying transient
+// failures with the exponential backoff of the download policy. A partial
+// download is kept between attempts, so that it may be resumed.
+func (s *SimpleSource) fetchRetrying(destination string) (string, error) {
+	policy := GetNetworkPolicy(NetworkDownload)
+	for attempt := 0; ; attempt++ {
+		hash, err := s.fetchVerified(destination, s.download)
+		if err == nil || attempt >= policy.Retries || !IsRetryable(err) {
+			return hash, err
+		}
+		wait := policy.Backoff(attempt)
+		log.WithFields(log.Fields{
+			"uri":     s.URI,
+			"error":   err,
+			"attempt": attempt + 1,
+			"wait":    wait,
+		}).Warning("Transient failure fetching source, waiting to retry")
+		retrySleep(wait)
+	}
+}
@@ -0,0 +1,114 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	retryable := []error{
+		&HTTPStatusError{Code: 503},
+		&HTTPStatusError{Code: 408},
+		&textproto.Error{Code: 421},
+		&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+		io.ErrUnexpectedEOF,
+	}
+	for _, err := range retryable {
+		if !IsRetryable(err) {
+			t.Fatalf("Error should be retryable: %v", err)
+		}
+	}
+	permanent := []error{
+		nil,
+		&HTTPStatusError{Code: 404},
+		&textproto.Error{Code: 550},
+		&RateLimitError{URI: "https://example.com"},
+		&OfflineError{URI: "https://example.com"},
+		errors.New("Checksum mismatch"),
+	}
+	for _, err := range permanent {
+		if IsRetryable(err) {
+			t.Fatalf("Error should not be retryable: %v", err)
+		}
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	var waits []time.Duration
+	retrySleep = func(d time.Duration) {
+		waits = append(waits, d)
+	}
+	defer func() {
+		retrySleep = time.Sleep
+	}()
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	// The server turns away the first two connections
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+	server.setBusy(2)
+
+	src, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Fetch should succeed after retrying: %v", err)
+	}
+	base := GetNetworkPolicy(NetworkDownload).BackoffBase
+	if len(waits) != 2 || waits[0] != base || waits[1] != base*2 {
+		t.Fatalf("Wrong backoff between attempts: %v", waits)
+	}
+
+	// Checksum mismatches are never retried
+	waits = nil
+	bad, err := NewSimple(server.URL(), "0000000000000000000000000000000000000000000000000000000000000000", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := bad.Fetch(); err == nil {
+		t.Fatalf("Fetch should fail on checksum mismatch")
+	}
+	if len(waits) != 0 {
+		t.Fatalf("Checksum mismatch should not be retried: %v", waits)
+	}
+
+	// Retries are capped by the policy
+	waits = nil
+	server.setBusy(GetNetworkPolicy(NetworkDownload).Retries + 1)
+	src, err = NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); !IsRetryable(err) {
+		t.Fatalf("Expected transient error once retries are exhausted, got: %v", err)
+	}
+	if len(waits) != GetNetworkPolicy(NetworkDownload).Retries {
+		t.Fatalf("Wrong number of retries: %v", waits)
+	}
+}
@@ -0,0 +1,77 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"sync"
+)
+
+// A URLRewriter is called before each download with the URI of a source,
+// returning the URL to fetch it from instead, such as a presigned URL for
+// an object store. Returning the URI unchanged fetches it as normal.
+type URLRewriter func(uri string) (string, error)
+
+var (
+	rewriterLock sync.RWMutex
+	urlRewriter  URLRewriter
+)
+
+// SetURLRewriter will install the pre-fetch hook used to rewrite source URLs.
+// The identifier and cache location of sources are unaffected, only the URL
+// that is fetched changes. Passing nil removes the hook.
+func SetURLRewriter(rewriter URLRewriter) {
+	rewriterLock.Lock()
+	defer rewriterLock.Unlock()
+	urlRewriter = rewriter
+}
+
+// getFetchURL will return the URL to actually download the URI from
+func getFetchURL(uri string) (string, error) {
+	rewriterLock.RLock()
+	rewriter := urlRewriter
+	rewriterLock.RUnlock()
+	if rewriter == nil {
+		return uri, nil
+	}
+	return rewriter(uri)
+}
+
+// getFetchSource will return the source to download from, which differs
+// from this source when the pre-fetch hook has rewritten the URL.
+func (s *SimpleSource) getFetchSource() (*SimpleSource, error) {
+	fetchURL, err := getFetchURL(s.URI)
+	if err != nil {
+		return s, err
+	}
+	fetch := s
+	if fetchURL != s.URI {
+		if fetch, err = NewSimple(fetchURL, "", s.legacy); err != nil {
+			return nil, err
+		}
+		fetch.headers = s.headers
+		fetch.progress = s.progress
+	}
+	// Objects in S3 are fetched over HTTP, as is IPFS content without the
+	// daemon
+	switch {
+	case fetch.url.Scheme == "s3":
+		return fetch.getS3Source()
+	case fetch.url.Scheme == "ipfs" && !IPFSDaemon:
+		return fetch.getIPFSSource()
+	}
+	return fetch, nil
+}
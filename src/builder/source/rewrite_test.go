@@ -0,0 +1,89 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func presign(uri string) (string, error) {
+	return uri + "?X-Amz-Expires=300&X-Amz-Signature=abc", nil
+}
+
+func TestURLRewriter(t *testing.T) {
+	SetURLRewriter(presign)
+	defer SetURLRewriter(nil)
+
+	src, err := NewSimple("https://bucket.s3.amazonaws.com/nano-2.7.5.tar.xz", "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	bind := src.GetBindConfiguration("/")
+
+	fetch, err := src.getFetchSource()
+	if err != nil {
+		t.Fatalf("Failed to rewrite source: %v", err)
+	}
+	if fetch.URI != "https://bucket.s3.amazonaws.com/nano-2.7.5.tar.xz?X-Amz-Expires=300&X-Amz-Signature=abc" {
+		t.Fatalf("Rewritten URL was not used: %s", fetch.URI)
+	}
+	if src.GetIdentifier() != "https://bucket.s3.amazonaws.com/nano-2.7.5.tar.xz" || src.GetBindConfiguration("/") != bind {
+		t.Fatalf("Rewriting should not change the identifier or cache path")
+	}
+
+	SetURLRewriter(nil)
+	if fetch, _ := src.getFetchSource(); fetch != src {
+		t.Fatalf("Source should be fetched directly without a hook")
+	}
+}
+
+func TestRewrittenDownload(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte("nano"))
+	}))
+	defer server.Close()
+
+	SetURLRewriter(presign)
+	defer SetURLRewriter(nil)
+
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src, err := NewSimple(server.URL+"/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.download(filepath.Join(tmp, src.File)); err != nil {
+		t.Fatalf("Failed to download source: %v", err)
+	}
+	if query != "X-Amz-Expires=300&X-Amz-Signature=abc" {
+		t.Fatalf("Download did not use the rewritten URL: %s", query)
+	}
+	if src.GetEffectiveURL() != src.URI {
+		t.Fatalf("Rewritten URL should not be recorded: %s", src.GetEffectiveURL())
+	}
+}
@@ -0,0 +1,213 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// S3DefaultRegion is used for buckets when no region is configured
+	S3DefaultRegion = "us-east-1"
+
+	// s3EmptyPayload is the sha256sum of the empty body of GET and HEAD
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config configures access to sources in S3, or S3 compatible object
+// storage, named by s3://bucket/key URIs.
+type S3Config struct {
+	Region       string `toml:"region"`        // Region of the buckets
+	Endpoint     string `toml:"endpoint"`      // Endpoint of an S3 compatible service, i.e. https://minio.internal:9000
+	PathStyle    bool   `toml:"path_style"`    // Name the bucket in the path, rather than the host
+	AccessKey    string `toml:"access_key"`    // Access key ID, defaulting to AWS_ACCESS_KEY_ID
+	SecretKey    string `toml:"secret_key"`    // Secret access key, defaulting to AWS_SECRET_ACCESS_KEY
+	SessionToken string `toml:"session_token"` // Session token, defaulting to AWS_SESSION_TOKEN
+}
+
+// S3 is the configuration used to fetch s3:// sources
+var S3 S3Config
+
+// s3Now is used to timestamp signed requests. Overridden in tests.
+var s3Now = time.Now
+
+// SetS3Config will validate and set the configuration for s3:// sources
+func SetS3Config(config *S3Config) error {
+	if config == nil {
+		S3 = S3Config{}
+		return nil
+	}
+	if config.Endpoint != "" {
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid S3 endpoint: %s", config.Endpoint)
+		}
+	}
+	S3 = *config
+	return nil
+}
+
+// getRegion will return the configured region, or the default
+func (c *S3Config) getRegion() string {
+	if c.Region == "" {
+		return S3DefaultRegion
+	}
+	return c.Region
+}
+
+// getCredentials will return the configured credentials, falling back to
+// those of the environment. Requests are unsigned without an access key.
+func (c *S3Config) getCredentials() (string, string, string) {
+	if c.AccessKey != "" {
+		return c.AccessKey, c.SecretKey, c.SessionToken
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// getObjectURL will return the HTTP URL of the object in the bucket
+func (c *S3Config) getObjectURL(bucket, key string) string {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.getRegion())
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	u, err := url.Parse(endpoint)
+	if c.PathStyle || err != nil {
+		return endpoint + "/" + bucket + s3EscapePath(key)
+	}
+	return fmt.Sprintf("%s://%s.%s%s", u.Scheme, bucket, u.Host, s3EscapePath(key))
+}
+
+// s3EscapePath will escape the path as in the canonical request, encoding
+// all but the unreserved characters of each segment.
+func s3EscapePath(path string) string {
+	var ret strings.Builder
+	for _, b := range []byte(path) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9':
+			ret.WriteByte(b)
+		case b == '-', b == '_', b == '.', b == '~', b == '/':
+			ret.WriteByte(b)
+		default:
+			fmt.Fprintf(&ret, "%%%02X", b)
+		}
+	}
+	return ret.String()
+}
+
+// hmacSHA256 will return the HMAC of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3Request will return the headers that sign the request with AWS
+// Signature Version 4, including the given headers in the signature.
+func signS3Request(method string, u *url.URL, headers map[string]string, region, accessKey, secretKey string, now time.Time) map[string]string {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	signed := map[string]string{"host": u.Host}
+	for key, value := range headers {
+		signed[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+	signed["x-amz-content-sha256"] = s3EmptyPayload
+	signed["x-amz-date"] = amzDate
+
+	var keys []string
+	for key := range signed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalHeaders strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", key, signed[key])
+	}
+	signedHeaders := strings.Join(keys, ";")
+
+	canonical := strings.Join([]string{
+		method,
+		s3EscapePath(u.Path),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyPayload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	delete(signed, "host")
+	signed["Authorization"] = fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature)
+	return signed
+}
+
+// getS3Source will return the source to download the object in S3 from
+// over HTTP, which signs each of its requests.
+func (s *SimpleSource) getS3Source() (*SimpleSource, error) {
+	if s.url.Host == "" || s.url.Path == "" || s.url.Path == "/" {
+		return nil, fmt.Errorf("S3 source must name a bucket and key: %s", s.URI)
+	}
+	fetch, err := NewSimple(S3.getObjectURL(s.url.Host, s.url.Path), "", s.legacy)
+	if err != nil {
+		return nil, err
+	}
+	fetch.headers = s.headers
+	fetch.progress = s.progress
+	fetch.signS3 = true
+	return fetch, nil
+}
+
+// requestHeaders will return the headers to send with a request of the
+// given method, signing it for S3 when needed.
+func (s *SimpleSource) requestHeaders(method string) map[string]string {
+	headers := s.getHeaders()
+	if !s.signS3 {
+		return headers
+	}
+	accessKey, secretKey, token := S3.getCredentials()
+	if accessKey == "" {
+		return headers
+	}
+	var extra map[string]string
+	if token != "" {
+		extra = map[string]string{"x-amz-security-token": token}
+	}
+	for key, value := range signS3Request(method, s.url, extra, S3.getRegion(), accessKey, secretKey, s3Now()) {
+		headers[key] = value
+	}
+	return headers
+}
@@ -0,0 +1,74 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A SchemeFactory creates a Source for a URI using a registered scheme,
+// with the same arguments as New.
+type SchemeFactory func(uri, validator string, legacy bool) (Source, error)
+
+var (
+	// ErrSchemeRegistered is returned when a scheme already has a factory
+	ErrSchemeRegistered = errors.New("Source scheme is already registered")
+
+	schemeLock      sync.RWMutex
+	schemeFactories = make(map[string]SchemeFactory)
+)
+
+// RegisterScheme will register a factory for a custom URI scheme, such as
+// "ipfs" or "s3", allowing downstream users to add their own source types.
+// Registered schemes take precedence over the built-in implementations.
+//
+// The returned Source must satisfy the contract documented on the Source
+// interface.
+func RegisterScheme(scheme string, factory SchemeFactory) error {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if scheme == "" || factory == nil {
+		return errors.New("A scheme and factory must be provided")
+	}
+	schemeLock.Lock()
+	defer schemeLock.Unlock()
+	if _, ok := schemeFactories[scheme]; ok {
+		return ErrSchemeRegistered
+	}
+	schemeFactories[scheme] = factory
+	return nil
+}
+
+// UnregisterScheme will remove the factory for the given scheme, if any
+func UnregisterScheme(scheme string) {
+	schemeLock.Lock()
+	defer schemeLock.Unlock()
+	delete(schemeFactories, strings.ToLower(strings.TrimSpace(scheme)))
+}
+
+// getSchemeFactory will return the registered factory for the URI, if any
+func getSchemeFactory(uri string) SchemeFactory {
+	urlObj, err := url.Parse(uri)
+	if err != nil || urlObj.Scheme == "" {
+		return nil
+	}
+	schemeLock.RLock()
+	defer schemeLock.RUnlock()
+	return schemeFactories[strings.ToLower(urlObj.Scheme)]
+}
@@ -0,0 +1,73 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"testing"
+)
+
+// schemeSource is a trivial source for a custom scheme
+type schemeSource struct {
+	uri     string
+	fetched bool
+}
+
+func (s *schemeSource) IsFetched() bool { return s.fetched }
+func (s *schemeSource) Fetch() error {
+	s.fetched = true
+	return nil
+}
+func (s *schemeSource) GetIdentifier() string { return s.uri }
+func (s *schemeSource) GetBindConfiguration(rootfs string) BindConfiguration {
+	return BindConfiguration{BindSource: "/cache/demo", BindTarget: rootfs + "/demo"}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	factory := func(uri, validator string, legacy bool) (Source, error) {
+		return &schemeSource{uri: uri}, nil
+	}
+	if err := RegisterScheme("IPFS", factory); err != nil {
+		t.Fatalf("Failed to register scheme: %v", err)
+	}
+	defer UnregisterScheme("ipfs")
+
+	if err := RegisterScheme("ipfs", factory); err != ErrSchemeRegistered {
+		t.Fatalf("Duplicate registration should fail: %v", err)
+	}
+
+	src, err := New("ipfs://QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create custom source: %v", err)
+	}
+	custom, ok := src.(*schemeSource)
+	if !ok {
+		t.Fatalf("Registered factory was not used: %T", src)
+	}
+	if custom.IsFetched() {
+		t.Fatalf("Source should not be fetched yet")
+	}
+	if err := src.Fetch(); err != nil || !src.IsFetched() {
+		t.Fatalf("Failed to fetch through custom source: %v", err)
+	}
+
+	// Built-in schemes remain unaffected
+	if src, err := New("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "", false); err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	} else if _, ok := src.(*SimpleSource); !ok {
+		t.Fatalf("Expected a SimpleSource: %T", src)
+	}
+}
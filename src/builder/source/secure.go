@@ -0,0 +1,81 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// RequireSecureSchemes controls whether sources may be fetched over
+	// plaintext schemes, such as http and ftp, which allow tampering.
+	RequireSecureSchemes = false
+
+	// InsecureHosts may still serve sources over plaintext schemes while
+	// RequireSecureSchemes is set, for legacy hosts without TLS.
+	InsecureHosts []string
+
+	// insecureSchemes are the schemes that offer no transport security
+	insecureSchemes = map[string]bool{
+		"http": true,
+		"ftp":  true,
+		"git":  true,
+		"svn":  true,
+		"bzr":  true,
+	}
+)
+
+// An InsecureSchemeError is returned for sources using a plaintext scheme
+// while RequireSecureSchemes is set.
+type InsecureSchemeError struct {
+	URI string
+}
+
+func (e *InsecureSchemeError) Error() string {
+	return fmt.Sprintf("Source uses an insecure scheme, and secure schemes are required: %s", e.URI)
+}
+
+// isInsecureHostAllowed will determine if the host is allowed to serve
+// plaintext sources, with or without the port.
+func isInsecureHostAllowed(u *url.URL) bool {
+	for _, host := range InsecureHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSecureScheme will return an InsecureSchemeError if the URI uses a
+// plaintext scheme, unless the policy is disabled or its host is allowed.
+func CheckSecureScheme(uri string) error {
+	if !RequireSecureSchemes {
+		return nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if !insecureSchemes[strings.ToLower(u.Scheme)] || isInsecureHostAllowed(u) {
+		return nil
+	}
+	// Never leak credentials in the error
+	u.User = nil
+	return &InsecureSchemeError{URI: u.String()}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HostKeyStrict only accepts hosts already in the known hosts
+	HostKeyStrict = "strict"
+
+	// HostKeyAcceptNew trusts the key of a new host on first use, but
+	// still refuses a changed key
+	HostKeyAcceptNew = "accept-new"
+
+	// HostKeyInsecure accepts any host key, and should only be used on a
+	// trusted network
+	HostKeyInsecure = "insecure"
+)
+
+// SFTPConfig configures the SSH connection used to fetch sftp:// sources.
+// Only key authentication is supported, as fetches are never interactive.
+type SFTPConfig struct {
+	IdentityFile  string `toml:"identity_file"`   // Private key to authenticate with, if not the default
+	KnownHosts    string `toml:"known_hosts"`     // Known hosts file, if not the default
+	HostKeyPolicy string `toml:"host_key_policy"` // strict, accept-new or insecure
+}
+
+// SFTP is the configuration used to fetch sftp:// sources
+var SFTP = SFTPConfig{HostKeyPolicy: HostKeyStrict}
+
+// SetSFTPConfig will validate and set the configuration for sftp:// sources
+func SetSFTPConfig(config *SFTPConfig) error {
+	if config == nil {
+		SFTP = SFTPConfig{HostKeyPolicy: HostKeyStrict}
+		return nil
+	}
+	c := *config
+	c.HostKeyPolicy = strings.ToLower(strings.TrimSpace(c.HostKeyPolicy))
+	switch c.HostKeyPolicy {
+	case "":
+		c.HostKeyPolicy = HostKeyStrict
+	case HostKeyStrict, HostKeyAcceptNew, HostKeyInsecure:
+	default:
+		return fmt.Errorf("Unknown host key policy: %s", config.HostKeyPolicy)
+	}
+	SFTP = c
+	return nil
+}
+
+// getSSHOptions will return the options passed to sftp to apply the config
+func (c *SFTPConfig) getSSHOptions() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	switch c.HostKeyPolicy {
+	case HostKeyAcceptNew:
+		args = append(args, "-o", "StrictHostKeyChecking=accept-new")
+	case HostKeyInsecure:
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	default:
+		args = append(args, "-o", "StrictHostKeyChecking=yes")
+	}
+	if c.KnownHosts != "" && c.HostKeyPolicy != HostKeyInsecure {
+		args = append(args, "-o", "UserKnownHostsFile="+c.KnownHosts)
+	}
+	if c.IdentityFile != "" {
+		args = append(args, "-i", c.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	return args
+}
+
+// quoteSFTP will quote the path for an sftp batch file
+func quoteSFTP(path string) string {
+	path = strings.Replace(path, "\\", "\\\\", -1)
+	path = strings.Replace(path, "\"", "\\\"", -1)
+	return "\"" + path + "\""
+}
+
+// getSFTPRemotePath will return the remote path of the source, where a
+// leading /~/ is relative to the home directory of the user.
+func (s *SimpleSource) getSFTPRemotePath() string {
+	if strings.HasPrefix(s.url.Path, "/~/") {
+		return s.url.Path[len("/~/"):]
+	}
+	return s.url.Path
+}
+
+// checkSFTPURL will refuse a user or host that ssh would read as an option,
+// i.e. "-oProxyCommand=...", as that would run commands on the build host.
+func checkSFTPURL(u *url.URL) error {
+	if u.User != nil && strings.HasPrefix(u.User.Username(), "-") {
+		return fmt.Errorf("Invalid user in SFTP source URL: %s", u.User.Username())
+	}
+	if host := u.Hostname(); host == "" || strings.HasPrefix(host, "-") {
+		return fmt.Errorf("Invalid host in SFTP source URL: %s", host)
+	}
+	return nil
+}
+
+// getSFTPArgs will return the arguments to sftp to connect to the host of
+// the source, reading commands from stdin.
+func (s *SimpleSource) getSFTPArgs() []string {
+	timeout := GetNetworkPolicy(NetworkDownload).ConnectTimeout
+	args := []string{"-q", "-b", "-"}
+	args = append(args, SFTP.getSSHOptions()...)
+	args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", int(timeout/time.Second)))
+	if port := s.url.Port(); port != "" {
+		args = append(args, "-P", port)
+	}
+	host := s.url.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if s.url.User != nil && s.url.User.Username() != "" {
+		host = s.url.User.Username() + "@" + host
+	}
+	// Nothing after the options may be read as one
+	return append(args, "--", host)
+}
+
+// downloadSFTP will fetch the source over sftp, resuming any partial
+// download left by an interrupted fetch.
+func (s *SimpleSource) downloadSFTP(destination string) error {
+	discardHashCheckpoint(destination)
+	get := "get"
+	if offset := getResumeOffset(destination); offset > 0 {
+		log.WithFields(log.Fields{
+			"uri":    s.URI,
+			"offset": offset,
+		}).Info("Resuming SFTP download")
+		get = "reget"
+	} else {
+		os.Remove(destination)
+	}
+
+	cmd := exec.Command("sftp", s.getSFTPArgs()...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s %s %s\n", get, quoteSFTP(s.getSFTPRemotePath()), quoteSFTP(destination)))
+	log.WithFields(log.Fields{
+		"uri": s.URI,
+	}).Info("Fetching source over SFTP")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to fetch %s over SFTP: %v: %s", s.URI, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getRemoteSizeSFTP will use the sftp listing to find the size
+func (s *SimpleSource) getRemoteSizeSFTP() (int64, error) {
+	cmd := exec.Command("sftp", s.getSFTPArgs()...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("ls -ln %s\n", quoteSFTP(s.getSFTPRemotePath())))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return -1, fmt.Errorf("Failed to list %s over SFTP: %v: %s", s.URI, err, strings.TrimSpace(string(out)))
+	}
+	return parseSFTPListing(string(out))
+}
+
+// parseSFTPListing will find the size of the single regular file in the
+// output of ls -ln, ignoring the echoed command.
+func parseSFTPListing(listing string) (int64, error) {
+	var sizes []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		sizes = append(sizes, fields[4])
+	}
+	if len(sizes) != 1 {
+		return -1, ErrUnknownSize
+	}
+	size, err := strconv.ParseInt(sizes[0], 10, 64)
+	if err != nil {
+		return -1, ErrUnknownSize
+	}
+	return size, nil
+}
@@ -0,0 +1,142 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSFTPArgs(t *testing.T) {
+	defer SetSFTPConfig(nil)
+
+	src, err := New("sftp://build@files.internal:2222/~/tarballs/blob-1.0.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	simple := src.(*SimpleSource)
+	if err := simple.Validate(); err != nil {
+		t.Fatalf("SFTP source should be valid: %v", err)
+	}
+	if path := simple.getSFTPRemotePath(); path != "tarballs/blob-1.0.tar.xz" {
+		t.Fatalf("Path should be relative to the home directory: %s", path)
+	}
+
+	// Host keys are checked strictly by default
+	args := strings.Join(simple.getSFTPArgs(), " ")
+	if !strings.Contains(args, "-o BatchMode=yes") || !strings.Contains(args, "-o StrictHostKeyChecking=yes") {
+		t.Fatalf("Wrong default arguments: %s", args)
+	}
+	if !strings.Contains(args, "-P 2222") || !strings.HasSuffix(args, " -- build@files.internal") {
+		t.Fatalf("Wrong host arguments: %s", args)
+	}
+
+	if err := SetSFTPConfig(&SFTPConfig{HostKeyPolicy: "trusting"}); err == nil {
+		t.Fatalf("Unknown host key policy should be rejected")
+	}
+	err = SetSFTPConfig(&SFTPConfig{
+		IdentityFile:  "/etc/solbuild/id_ed25519",
+		KnownHosts:    "/etc/solbuild/known_hosts",
+		HostKeyPolicy: "Accept-New",
+	})
+	if err != nil {
+		t.Fatalf("Failed to set SFTP configuration: %v", err)
+	}
+	args = strings.Join(simple.getSFTPArgs(), " ")
+	for _, want := range []string{
+		"-o StrictHostKeyChecking=accept-new",
+		"-o UserKnownHostsFile=/etc/solbuild/known_hosts",
+		"-i /etc/solbuild/id_ed25519 -o IdentitiesOnly=yes",
+	} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("Missing '%s' from arguments: %s", want, args)
+		}
+	}
+
+	// ssh must never see the user or host as an option
+	for _, uri := range []string{
+		"sftp://-oProxyCommand=touch%20%2Ftmp%2Fpwn@files.internal/blob-1.0.tar.xz",
+		"sftp://build@-oProxyCommand=true/blob-1.0.tar.xz",
+	} {
+		if _, err := New(uri, nanoSHA256, false); err == nil {
+			t.Fatalf("Hostile SFTP source should be rejected: %s", uri)
+		}
+	}
+
+	if quoted := quoteSFTP(`/srv/a "b"\c`); quoted != `"/srv/a \"b\"\\c"` {
+		t.Fatalf("Wrong quoting: %s", quoted)
+	}
+}
+
+func TestSFTPListing(t *testing.T) {
+	listing := "sftp> ls -ln \"tarballs/blob-1.0.tar.xz\"\n" +
+		"-rw-r--r--    1 1000     1000      1048576 Jan  1 00:00 tarballs/blob-1.0.tar.xz\n"
+	if size, err := parseSFTPListing(listing); err != nil || size != 1048576 {
+		t.Fatalf("Wrong size from listing: %d (%v)", size, err)
+	}
+	if _, err := parseSFTPListing("drwxr-xr-x    2 1000     1000         4096 Jan  1 00:00 tarballs\n"); err != ErrUnknownSize {
+		t.Fatalf("Directories should have no size, got: %v", err)
+	}
+}
+
+func TestFetchSFTP(t *testing.T) {
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	// Stand in for sftp, serving files beneath the remote directory
+	remote := filepath.Join(tmp, "remote")
+	if err := os.MkdirAll(filepath.Join(remote, "srv"), 00755); err != nil {
+		t.Fatalf("Failed to create remote directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(remote, "srv", "nano 2.7.5.tar.xz"), []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write remote file: %v", err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if err := os.MkdirAll(bin, 00755); err != nil {
+		t.Fatalf("Failed to create bin directory: %v", err)
+	}
+	script := "#!/bin/sh\nread -r line\neval \"set -- $line\"\nexec cp \"" + remote + "$2\" \"$3\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "sftp"), []byte(script), 00755); err != nil {
+		t.Fatalf("Failed to write sftp script: %v", err)
+	}
+	os.Setenv("PATH", bin+":"+oldPath)
+
+	src, err := NewSimple("sftp://files.internal/srv/nano%202.7.5.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source over SFTP: %v", err)
+	}
+	if !src.IsFetched() {
+		t.Fatalf("SFTP source was not cached")
+	}
+
+	missing, err := NewSimple("sftp://files.internal/srv/missing.tar.xz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := missing.Fetch(); err == nil || !strings.Contains(err.Error(), "over SFTP") {
+		t.Fatalf("Expected SFTP failure, got: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffixes may be given in place of the URI of a detached
+// signature, and are appended to the URI of the source itself.
+var SignatureSuffixes = []string{".sig", ".asc"}
+
+// A SignatureError is returned when a source could not be verified against
+// its detached signature.
+type SignatureError struct {
+	URI string // Declared URI of the source
+	Err error  // Underlying error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("Signature verification failed for %s: %v", e.URI, e.Err)
+}
+
+// SetSignature will require the source to match the detached signature at
+// the given URI, made by a key within the keyring. The URI may instead be
+// one of the SignatureSuffixes.
+func (s *SimpleSource) SetSignature(uri, keyring string) {
+	for _, suffix := range SignatureSuffixes {
+		if uri == suffix {
+			uri = s.URI + suffix
+			break
+		}
+	}
+	s.signature = uri
+	s.keyring = keyring
+}
+
+// GetSignature will return the URI of the detached signature, if any
+func (s *SimpleSource) GetSignature() string {
+	return s.signature
+}
+
+// verifySignature will fetch the detached signature of the source, and
+// verify the download at path against it. Only keys in the keyring of the
+// source are trusted.
+func (s *SimpleSource) verifySignature(path string) error {
+	if s.signature == "" {
+		return nil
+	}
+	if s.keyring == "" {
+		return &SignatureError{URI: s.URI, Err: fmt.Errorf("No keyring to verify %s", s.signature)}
+	}
+	sig, err := NewSimple(s.signature, "", false)
+	if err != nil {
+		return &SignatureError{URI: s.URI, Err: err}
+	}
+
+	// Never resume a stale signature
+	sigPath := path + ".sig"
+	os.Remove(sigPath)
+	defer os.Remove(sigPath)
+	if err := sig.downloadUpstream(sigPath); err != nil {
+		return &SignatureError{URI: s.URI, Err: fmt.Errorf("Failed to fetch %s: %v", s.signature, err)}
+	}
+	if err := checkSignature(path, sigPath, s.keyring); err != nil {
+		return &SignatureError{URI: s.URI, Err: err}
+	}
+	log.WithFields(log.Fields{
+		"source":    s.File,
+		"signature": s.signature,
+	}).Info("Verified source signature")
+	return nil
+}
+
+// checkSignature will verify the detached signature of the file at path
+// with gpgv, which trusts nothing beyond the given keyring.
+func checkSignature(path, sigPath, keyring string) error {
+	keyring, err := filepath.Abs(keyring)
+	if err != nil {
+		return err
+	}
+	if !PathExists(keyring) {
+		return fmt.Errorf("Keyring does not exist: %s", keyring)
+	}
+	out, err := exec.Command("gpgv", "--keyring", keyring, sigPath, path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
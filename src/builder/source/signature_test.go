@@ -0,0 +1,128 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKeyring will create a signing key, returning a keyring holding it
+// and a function to sign files with it.
+func newTestKeyring(t *testing.T, dir, name string) (string, func(path string) []byte) {
+	home := filepath.Join(dir, name)
+	if err := os.MkdirAll(home, 00700); err != nil {
+		t.Fatalf("Failed to create GnuPG home: %v", err)
+	}
+	gpg := func(args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--passphrase", ""}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %v failed: %v", args, err)
+		}
+		return out
+	}
+	gpg("--quick-gen-key", name+"@example.com", "default", "sign", "never")
+	keyring := filepath.Join(dir, name+".gpg")
+	if err := ioutil.WriteFile(keyring, gpg("--export"), 00644); err != nil {
+		t.Fatalf("Failed to write keyring: %v", err)
+	}
+	return keyring, func(path string) []byte {
+		return gpg("--detach-sign", "--armor", "--output", "-", path)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv is not installed")
+	}
+
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	keyring, sign := newTestKeyring(t, tmp, "upstream")
+	otherKeyring, _ := newTestKeyring(t, tmp, "other")
+	tarball := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(tarball, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+	sigServer := newMockFTPServer(t, "nano-2.7.5.tar.xz.asc", sign(tarball), true)
+	defer sigServer.listener.Close()
+
+	tests := []struct {
+		keyring string
+		valid   bool
+	}{
+		{keyring, true},
+		{otherKeyring, false},
+		{filepath.Join(tmp, "missing.gpg"), false},
+		{"", false},
+	}
+	for i, test := range tests {
+		SetSourceDir(filepath.Join(tmp, "sources", fmt.Sprintf("%d", i)))
+		if err := EnsureSourceDir(); err != nil {
+			t.Fatalf("Failed to create source directory: %v", err)
+		}
+		src, err := NewSimple(server.URL(), nanoSHA256, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		src.SetSignature(sigServer.URL(), test.keyring)
+
+		err = src.Fetch()
+		if test.valid {
+			if err != nil {
+				t.Fatalf("Valid signature rejected: %v", err)
+			}
+			if prov, err := src.GetProvenance(); err != nil || prov.Signature != sigServer.URL() {
+				t.Fatalf("Signature was not recorded: %v", err)
+			}
+			continue
+		}
+		if _, ok := err.(*SignatureError); !ok {
+			t.Fatalf("Expected a signature error with keyring %q, got: %v", test.keyring, err)
+		}
+		if src.IsFetched() {
+			t.Fatalf("Unverified source was cached with keyring %q", test.keyring)
+		}
+	}
+}
+
+func TestSetSignature(t *testing.T) {
+	src, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	src.SetSignature(".asc", "/etc/solbuild/keyring.gpg")
+	if sig := src.GetSignature(); sig != src.URI+".asc" {
+		t.Fatalf("Suffix should be appended to the source URI: %s", sig)
+	}
+	src.SetSignature("https://example.com/nano.sig", "/etc/solbuild/keyring.gpg")
+	if sig := src.GetSignature(); sig != "https://example.com/nano.sig" {
+		t.Fatalf("Wrong signature URI: %s", sig)
+	}
+}
@@ -17,32 +17,55 @@
 package source
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	curl "github.com/andelf/go-curl"
-	"github.com/cheggaaa/pb"
-	"github.com/jlaffaye/ftp"
 	"io"
-	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
+var (
+	// MaxRedirects controls how redirects are handled when downloading
+	// sources. A negative value will follow all redirects, 0 will forbid
+	// redirects entirely, and any other value caps the number followed.
+	MaxRedirects = -1
+
+	// ErrRedirectForbidden is returned when a server attempts to redirect
+	// us while redirects are disabled.
+	ErrRedirectForbidden = errors.New("Source server attempted a redirect, but redirects are disabled")
+)
+
+// ValidatorSeparator separates the validators of a source that accepts more
+// than one digest, i.e. while upstream has re-released a tarball.
+const ValidatorSeparator = ","
+
 // A SimpleSource is a tarball or other source for a package
 type SimpleSource struct {
 	URI  string
 	File string // Basename of the file
 
-	legacy    bool   // If this is ypkg or not
-	validator string // Validation key for this source
-
-	url *url.URL
+	legacy     bool              // If this is ypkg or not
+	validator  string            // Validation key for this source
+	validators []string          // All acceptable validation keys, in order of preference
+	algorithms map[string]string // Digest algorithm of each validator
+
+	url          *url.URL
+	effectiveURL string            // Final URL after following any redirects
+	mirror       string            // Mirror or archive the source was fetched from, if any
+	status       int               // HTTP status of the final response
+	headers      map[string]string // Custom headers for this source only
+	progress     ProgressFunc      // Receives download progress, if set
+	alternates   []string          // Alternate URIs, tried in order when this one fails
+	signature    string            // URI of the detached signature, if any
+	keyring      string            // Keyring trusted to sign the source
+	signS3       bool              // Sign requests for S3, as the URL is that of an object
+	guessedName  bool              // File is only the last path component of a query URL
+	disposition  string            // File name given by the server, if any
 }
 
 // NewSimple will create a new source instance
@@ -52,21 +75,87 @@ func NewSimple(uri, validator string, legacy bool) (*SimpleSource, error) {
 	if err != nil {
 		return nil, err
 	}
+	// A fragment renames the file, and is never sent upstream
+	rename := uriObj.Fragment
+	if i := strings.Index(uri, "#"); i >= 0 {
+		uri = uri[:i]
+		uriObj.Fragment = ""
+		uriObj.RawFragment = ""
+	}
 	ret := &SimpleSource{
-		URI:       uri,
-		File:      filepath.Base(uriObj.Path),
-		legacy:    legacy,
-		validator: validator,
-		url:       uriObj,
+		URI:        uri,
+		File:       filepath.Base(uriObj.Path),
+		legacy:     legacy,
+		algorithms: make(map[string]string),
+		url:        uriObj,
+	}
+	// Torrents are named for the file they share
+	if name, ok := getTorrentName(uriObj); ok {
+		ret.File = name
+	}
+	if name, ok := getIPFSName(uriObj); ok {
+		ret.File = name
+	}
+	// Download scripts may name the file in the query, or only when fetched
+	if name, ok := getQueryName(uriObj); ok {
+		ret.File = name
+	} else if isDownloadQuery(uriObj) {
+		ret.guessedName = true
+	}
+	if rename != "" {
+		if rename != filepath.Base(rename) || rename == "." || rename == ".." {
+			return nil, fmt.Errorf("Invalid file name in source URL: %s", rename)
+		}
+		ret.File = rename
+		ret.guessedName = false
+	}
+	for _, v := range splitValidators(validator) {
+		algorithm, digest, err := ParseValidator(v, legacy)
+		if err != nil {
+			return nil, err
+		}
+		ret.validators = append(ret.validators, digest)
+		ret.algorithms[digest] = algorithm
+	}
+	if uriObj.Scheme == "sftp" {
+		if err := checkSFTPURL(uriObj); err != nil {
+			return nil, err
+		}
+	}
+	if len(ret.validators) > 0 {
+		ret.validator = ret.validators[0]
+	} else if ret.isContentAddressed() {
+		ret.validator = ret.getIPFSKey()
 	}
 	return ret, nil
 }
 
+// splitValidators will return each of the acceptable validators
+func splitValidators(validator string) []string {
+	var validators []string
+	for _, v := range strings.Split(validator, ValidatorSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			validators = append(validators, v)
+		}
+	}
+	return validators
+}
+
 // GetIdentifier will return the URI associated with this source.
 func (s *SimpleSource) GetIdentifier() string {
 	return s.URI
 }
 
+// GetEffectiveURL will return the final URL the source was downloaded from,
+// after following any redirects. If the source hasn't been downloaded in
+// this session, the declared URI is returned.
+func (s *SimpleSource) GetEffectiveURL() string {
+	if s.effectiveURL == "" {
+		return s.URI
+	}
+	return s.effectiveURL
+}
+
 // GetBindConfiguration will return the pair for binding our tarballs.
 func (s *SimpleSource) GetBindConfiguration(rootfs string) BindConfiguration {
 	return BindConfiguration{
@@ -82,108 +171,431 @@ func (s *SimpleSource) GetPath(hash string) string {
 
 // GetSHA1Sum will return the sha1sum for the given path
 func (s *SimpleSource) GetSHA1Sum(path string) (string, error) {
-	inp, err := ioutil.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	hash := sha1.New()
-	hash.Write(inp)
-	sum := hash.Sum(nil)
-	return hex.EncodeToString(sum), nil
+	return getDigest(path, ValidatorSHA1)
 }
 
-// GetSHA256Sum will return the sha1sum for the given path
+// GetSHA256Sum will return the sha256sum for the given path
 func (s *SimpleSource) GetSHA256Sum(path string) (string, error) {
-	inp, err := ioutil.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	hash := sha256.New()
-	hash.Write(inp)
-	sum := hash.Sum(nil)
-	return hex.EncodeToString(sum), nil
+	return getDigest(path, ValidatorSHA256)
 }
 
-// IsFetched will determine if the source is already present
+// IsFetched will determine if the source is already present, under any of
+// the acceptable validators.
 func (s *SimpleSource) IsFetched() bool {
-	return PathExists(s.GetPath(s.validator))
+	if s.isContentAddressed() {
+		return PathExists(s.GetPath(s.validator))
+	}
+	for _, v := range s.validators {
+		if s.guessedName && !PathExists(s.GetPath(v)) {
+			if name, ok := s.findCachedName(v); ok {
+				s.File = name
+			}
+		}
+		if PathExists(s.GetPath(v)) {
+			s.validator = v
+			markUsed(s.GetPath(v))
+			return true
+		}
+	}
+	if !s.legacy {
+		return false
+	}
+	// Content may still be cached under the sha256sum
+	healed, err := s.healLegacyLink(SourceDir)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"source": s.File,
+		}).Warning("Failed to restore legacy source link")
+	}
+	return healed
 }
 
-// download will proxy the download to the correct scheme handler
+// download will fetch the source from upstream, falling back to the
+// archive by hash when upstream is unavailable, if an archive is set.
 func (s *SimpleSource) download(destination string) error {
+	err := s.downloadUpstream(destination)
+	if err == nil || ArchiveURL == "" {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uri":   s.URI,
+		"error": err,
+	}).Warning("Failed to fetch source, falling back to the archive")
+	if archiveErr := s.downloadArchive(destination); archiveErr != nil {
+		log.WithFields(log.Fields{
+			"uri":   s.URI,
+			"error": archiveErr,
+		}).Error("Failed to fetch source from the archive")
+		return err
+	}
+	return nil
+}
+
+// downloadUpstream will fetch the source from the configured mirror, if
+// any, falling back to the original URI when the mirror fails.
+func (s *SimpleSource) downloadUpstream(destination string) error {
+	mirrorURI, ok := getMirrorURL(s.URI)
+	if !ok {
+		return s.downloadDirect(destination)
+	}
+	mirror, err := NewSimple(mirrorURI, s.formatValidators(), s.legacy)
+	if err == nil {
+		mirror.progress = s.progress
+		log.WithFields(log.Fields{
+			"uri":    s.URI,
+			"mirror": mirrorURI,
+		}).Debug("Fetching source from mirror")
+		if err = mirror.downloadDirect(destination); err == nil {
+			s.effectiveURL = mirror.GetEffectiveURL()
+			s.mirror = mirrorURI
+			s.status = mirror.status
+			s.disposition = mirror.disposition
+			return nil
+		}
+	}
+	log.WithFields(log.Fields{
+		"mirror": mirrorURI,
+		"error":  err,
+	}).Warning("Failed to fetch source from mirror, falling back to upstream")
+
+	// Never resume a partial download from another server
+	os.Remove(destination)
+	discardHashCheckpoint(destination)
+	return s.downloadDirect(destination)
+}
+
+// downloadDirect will proxy the download to the correct scheme handler,
+// using the URL from the pre-fetch hook if one is set.
+func (s *SimpleSource) downloadDirect(destination string) error {
+	fetch, err := s.getFetchSource()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uri":   s.URI,
+			"error": err,
+		}).Error("Pre-fetch hook failed to rewrite source URL")
+		return err
+	}
+	if fetch != s {
+		// Never record the rewritten URL, it may hold credentials
+		log.WithFields(log.Fields{
+			"uri": s.URI,
+		}).Debug("Fetching source from rewritten URL")
+	}
+	defer func() {
+		s.status = fetch.status
+		s.disposition = fetch.disposition
+	}()
+
+	if fetch.isTorrent() {
+		return fetch.downloadTorrent(destination)
+	}
+
 	// Fix up the http client
-	switch s.url.Scheme {
+	switch fetch.url.Scheme {
 	case "ftp":
-		return s.downloadFTP(destination)
+		return fetch.downloadFTP(destination)
+	case "file":
+		return fetch.downloadFile(destination)
+	case "sftp":
+		return fetch.downloadSFTP(destination)
+	case "ipfs":
+		return fetch.downloadIPFS(destination)
 	default:
-		return s.downloadCurl(destination)
+		return fetch.downloadHTTP(destination)
 	}
 }
 
-// downloadCURL utilises CURL to do all downloads
-func (s *SimpleSource) downloadCurl(destination string) error {
-	hnd := curl.EasyInit()
-	defer hnd.Cleanup()
+// setRedirectPolicy will configure the curl handle to follow redirects
+// according to MaxRedirects
+func setRedirectPolicy(hnd *curl.CURL) {
+	if MaxRedirects == 0 {
+		hnd.Setopt(curl.OPT_FOLLOWLOCATION, 0)
+		return
+	}
+	hnd.Setopt(curl.OPT_FOLLOWLOCATION, 1)
+	if MaxRedirects > 0 {
+		hnd.Setopt(curl.OPT_MAXREDIRS, MaxRedirects)
+	}
+}
+
+// isRedirect will determine whether the current response is a redirect
+func isRedirect(hnd *curl.CURL) bool {
+	info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE)
+	if err != nil {
+		return false
+	}
+	code, ok := info.(int)
+	return ok && code >= 300 && code < 400
+}
+
+// getRedirectURL will return where the last response redirected us to, if
+// it was a redirect at all.
+func getRedirectURL(hnd *curl.CURL) (*url.URL, bool) {
+	if !isRedirect(hnd) {
+		return nil, false
+	}
+	info, err := hnd.Getinfo(curl.INFO_REDIRECT_URL)
+	if err != nil {
+		return nil, false
+	}
+	location, ok := info.(string)
+	if !ok || location == "" {
+		return nil, false
+	}
+	u, err := url.Parse(location)
+	return u, err == nil
+}
+
+// isSameOrigin will determine whether the URL has the scheme and host of
+// the source, and so may be sent its custom headers.
+func (s *SimpleSource) isSameOrigin(u *url.URL) bool {
+	return u.Scheme == s.url.Scheme && strings.EqualFold(u.Host, s.url.Host)
+}
 
+// performRequest will perform the request for the source on the handle,
+// passing the body of the final response to write, if set. The custom
+// headers are only ever sent to the host of the source: libcurl resends
+// them to whichever host a redirect points at, so when there are any,
+// redirects are followed by hand and the headers dropped once the scheme
+// or host changes.
+func (s *SimpleSource) performRequest(hnd *curl.CURL, headers map[string]string, write func(data []byte) bool) error {
+	manual := len(headers) > 0 && MaxRedirects != 0
+	if write != nil {
+		hnd.Setopt(curl.OPT_WRITEFUNCTION, func(data []byte, udata interface{}) bool {
+			// The body of a redirect we follow is never the source
+			if manual && isRedirect(hnd) {
+				return true
+			}
+			return write(data)
+		})
+	}
 	hnd.Setopt(curl.OPT_URL, s.URI)
-	hnd.Setopt(curl.OPT_FOLLOWLOCATION, 1)
+	if len(headers) > 0 {
+		hnd.Setopt(curl.OPT_HTTPHEADER, formatHeaders(headers, false))
+	}
+	if !manual {
+		setRedirectPolicy(hnd)
+		return hnd.Perform()
+	}
+
+	hnd.Setopt(curl.OPT_FOLLOWLOCATION, 0)
+	for redirects := 0; ; redirects++ {
+		if err := hnd.Perform(); err != nil {
+			return err
+		}
+		next, ok := getRedirectURL(hnd)
+		if !ok {
+			return nil
+		}
+		if MaxRedirects > 0 && redirects >= MaxRedirects {
+			return curl.E_TOO_MANY_REDIRECTS
+		}
+		if !s.isSameOrigin(next) {
+			log.WithFields(log.Fields{
+				"uri":      s.URI,
+				"location": next.Host,
+			}).Debug("Dropping custom headers on redirect to another host")
+			hnd.Setopt(curl.OPT_HTTPHEADER, nil)
+		}
+		hnd.Setopt(curl.OPT_URL, next.String())
+	}
+}
+
+// checkRedirect will ensure that we weren't handed a redirect when they
+// have been disabled.
+func checkRedirect(hnd *curl.CURL) error {
+	if MaxRedirects != 0 {
+		return nil
+	}
+	info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE)
+	if err != nil {
+		return err
+	}
+	if code, ok := info.(int); ok && code >= 300 && code < 400 {
+		return ErrRedirectForbidden
+	}
+	return nil
+}
+
+// downloadCURL utilises CURL to do all downloads, resuming any partial
+// download left by an interrupted fetch with a range request. The file is
+// hashed as it is written, so it needn't be read again to verify it.
+func (s *SimpleSource) downloadCurl(destination string) error {
+	offset := getResumeOffset(destination)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	hasher := newStagedHash()
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		log.WithFields(log.Fields{
+			"uri":    s.URI,
+			"offset": offset,
+		}).Info("Resuming HTTP download")
+
+		// Continue the running hash, if checkpointed
+		var err error
+		if hasher, err = loadHashCheckpoint(destination); err != nil {
+			log.WithFields(log.Fields{
+				"uri":   s.URI,
+				"error": err,
+			}).Debug("No usable hash checkpoint, will hash the whole file")
+		}
+	}
+	discardHashCheckpoint(destination)
 
-	out, err := os.Create(destination)
+	out, err := os.OpenFile(destination, flags, 00644)
 	if err != nil {
 		return err
 	}
+	defer out.Close()
+	defer func() {
+		if hasher != nil {
+			saveHashCheckpoint(destination, hasher)
+		}
+	}()
+	withHash := func() io.Writer {
+		if hasher == nil {
+			return out
+		}
+		return io.MultiWriter(out, hasher)
+	}
 
-	pbar := pb.New64(0).Prefix(filepath.Base(destination))
-	pbar.Set(0)
-	pbar.SetUnits(pb.U_BYTES)
-	pbar.SetMaxWidth(80)
-	pbar.ShowSpeed = true
+	err = s.downloadCurlTo(withHash(), filepath.Base(destination), offset)
+	if err != errResumeUnsupported {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uri": s.URI,
+	}).Warning("Server cannot resume download, starting over")
+	if err := out.Truncate(0); err != nil {
+		return err
+	}
+	hasher = newStagedHash()
+	return s.downloadCurlTo(withHash(), filepath.Base(destination), 0)
+}
+
+// downloadCurlTo will download the source with CURL, writing it to out and
+// naming it in the progress bar. A non-zero offset requests only the rest
+// of the file from that point.
+func (s *SimpleSource) downloadCurlTo(out io.Writer, name string, offset int64) error {
+	hnd, release := acquireHandle(s.url)
+	defer release()
+
+	if offset > 0 {
+		hnd.Setopt(curl.OPT_RESUME_FROM_LARGE, offset)
+	}
 
-	writer := func(data []byte, udata interface{}) bool {
+	custom := s.requestHeaders("GET")
+	if len(custom) > 0 {
+		log.WithFields(log.Fields{
+			"uri":     s.URI,
+			"headers": strings.Join(formatHeaders(custom, true), ", "),
+		}).Debug("Using custom headers for source")
+	}
+
+	pbar := newProgressBar(name, 0)
+
+	// Error pages must never be appended to a partial download
+	checked, discard := false, false
+	writer := func(data []byte) bool {
+		if !checked {
+			checked = true
+			if info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE); err == nil {
+				code, ok := info.(int)
+				discard = ok && code >= 400
+			}
+		}
+		if discard {
+			return true
+		}
+		throttle(len(data))
 		if _, err := out.Write(data); err != nil {
 			return false
 		}
 		return true
 	}
 	progress := func(total, now, utotal, unow float64, udata interface{}) bool {
-		pbar.Total = int64(total)
-		pbar.Set64(int64(now))
-		pbar.Update()
+		pbar.Set(offset+int64(now), offset+int64(total))
+		if total > 0 {
+			s.reportProgress(offset+int64(now), offset+int64(total))
+		} else {
+			s.reportProgress(offset+int64(now), -1)
+		}
+		return true
+	}
+
+	// Keep the response headers, i.e. for Retry-After
+	var headers []string
+	headerFunc := func(data []byte, udata interface{}) bool {
+		headers = append(headers, string(data))
 		return true
 	}
 
-	hnd.Setopt(curl.OPT_WRITEFUNCTION, writer)
+	hnd.Setopt(curl.OPT_HEADERFUNCTION, headerFunc)
 	hnd.Setopt(curl.OPT_NOPROGRESS, false)
 	hnd.Setopt(curl.OPT_PROGRESSFUNCTION, progress)
-	// Enforce internal 300 second connect timeout in libcurl
-	hnd.Setopt(curl.OPT_CONNECTTIMEOUT, 0)
+	GetNetworkPolicy(NetworkDownload).setCurlOptions(hnd)
+	setProxyOptions(hnd, s.url)
+	if err := setResolveOptions(hnd, s.url); err != nil {
+		return err
+	}
 	hnd.Setopt(curl.OPT_USERAGENT, fmt.Sprintf("solbuild 1.3.0"))
 
 	pbar.Start()
-	defer func() {
-		pbar.Update()
-		pbar.Finish()
-	}()
-
-	return hnd.Perform()
-}
-
-// downloadFTP will fetch a file over ftp using anonymous credentials
-func (s *SimpleSource) downloadFTP(destination string) error {
-	hostAddr := s.url.Host
-	// Assign a port if not set
-	if !strings.Contains(hostAddr, ":") {
-		hostAddr += ":21"
+	defer pbar.Finish()
+
+	if err := s.performRequest(hnd, custom, writer); err != nil {
+		// The server replied with the whole file, rather than the rest
+		if info, infoErr := hnd.Getinfo(curl.INFO_RESPONSE_CODE); offset > 0 && infoErr == nil {
+			if code, ok := info.(int); ok && code == http.StatusOK {
+				return errResumeUnsupported
+			}
+		}
+		if MaxRedirects > 0 {
+			return fmt.Errorf("%v (redirects are capped at %d)", err, MaxRedirects)
+		}
+		return err
 	}
-	client, err := ftp.DialTimeout(hostAddr, time.Minute*2)
-	if err != nil {
+	if err := checkRedirect(hnd); err != nil {
 		return err
 	}
-	defer client.Quit()
+	if info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE); err == nil {
+		code, ok := info.(int)
+		if ok {
+			s.status = code
+		}
+		if ok && offset > 0 && code == http.StatusRequestedRangeNotSatisfiable {
+			// Nothing is left to fetch, verification catches a bad partial
+			return nil
+		}
+		if ok && code == http.StatusTooManyRequests {
+			return &RateLimitError{URI: s.URI, Wait: getRetryAfter(headers)}
+		} else if ok && code >= 400 {
+			return &HTTPStatusError{URI: s.URI, Code: code}
+		}
+	}
 
-	// Get the relevant credentials
+	// Record where we actually ended up, i.e. for mirror redirectors
+	if info, err := hnd.Getinfo(curl.INFO_EFFECTIVE_URL); err == nil {
+		if effective, ok := info.(string); ok && effective != "" {
+			s.effectiveURL = effective
+		}
+	}
+	if name := getDispositionName(headers); name != "" {
+		s.disposition = name
+	}
+	return nil
+}
+
+// ftpCredentials will return the credentials to use for FTP logins, which
+// are anonymous unless specified in the URI or the credentials of the host.
+func (s *SimpleSource) ftpCredentials() (string, string) {
 	username := "anonymous"
 	password := "anonymous"
+	if creds, ok := s.getCredentials(); ok && creds.Username != "" {
+		username = creds.Username
+		password = creds.Password
+	}
 	if s.url.User != nil {
 		username = s.url.User.Username()
 		if pwd, set := s.url.User.Password(); set {
@@ -192,87 +604,268 @@ func (s *SimpleSource) downloadFTP(destination string) error {
 			password = ""
 		}
 	}
+	return username, password
+}
+
+// loginFTP will connect to the FTP server of the source and log in, using
+// the data connection mode set by FTPMode and the timeouts of the network
+// policy for the operation.
+func (s *SimpleSource) loginFTP(op string) (*ftpConn, error) {
+	hostAddr := s.url.Host
+	// Assign a port if not set
+	if !strings.Contains(hostAddr, ":") {
+		hostAddr += ":21"
+	}
+	hostAddr, err := resolveAddr(hostAddr)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dialFTP(hostAddr, GetNetworkPolicy(op).ConnectTimeout, FTPMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the relevant credentials
+	username, password := s.ftpCredentials()
 
 	// Login to the server
 	log.WithFields(log.Fields{
 		"username": username,
+		"mode":     FTPMode,
 	}).Info("Logging into FTP server")
 	if err := client.Login(username, password); err != nil {
+		client.Quit()
+		return nil, err
+	}
+	return client, nil
+}
+
+// downloadFTP will fetch a file over ftp using anonymous credentials, using
+// the data connection mode set by FTPMode. When an FTP proxy is configured,
+// the download goes through CURL instead, as the proxy speaks HTTP.
+func (s *SimpleSource) downloadFTP(destination string) error {
+	if getProxy(s.url) != "" {
+		return s.downloadCurl(destination)
+	}
+	client, err := s.loginFTP(NetworkDownload)
+	if err != nil {
 		return err
 	}
+	defer client.Quit()
 
-	// Try to list the file
+	// Find the size of the file
 	toFetch := s.url.Path
 	log.WithFields(log.Fields{
 		"path": toFetch,
 	}).Info("Getting remote file information")
-	entries, err := client.List(toFetch)
+	fileLen, err := client.Size(toFetch)
 	if err != nil {
 		return err
 	}
 
-	// Assert *1* file
-	if len(entries) != 1 {
-		return fmt.Errorf("FTP expected 1 file, found %d files", len(entries))
-	}
-
-	// Try to RETR the file
-	fileLen := entries[0].Size
-	resp, err := client.Retr(toFetch)
+	// Try to RETR the file, resuming any partial download
+	resp, offset, err := s.retrFTP(client, toFetch, destination, fileLen)
 	if err != nil {
 		return err
 	}
+	if resp == nil {
+		// Partial download is already complete
+		return nil
+	}
 	defer resp.Close()
 
-	// Set the output
-	out, err := os.Create(destination)
+	// Set the output, appending when resuming
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	// Continue the running hash when resuming, if checkpointed
+	hasher := newStagedHash()
+	if offset > 0 {
+		if hasher, err = loadHashCheckpoint(destination); err != nil {
+			log.WithFields(log.Fields{
+				"path":  toFetch,
+				"error": err,
+			}).Debug("No usable hash checkpoint, will hash the whole file")
+			discardHashCheckpoint(destination)
+		}
+	}
+
+	out, err := os.OpenFile(destination, flags, 00644)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
+	var writer io.Writer = out
+	if hasher != nil {
+		writer = io.MultiWriter(out, hasher)
+		defer saveHashCheckpoint(destination, hasher)
+	}
 
 	// Set up the progressbar & hooks
-	pbar := pb.New64(int64(fileLen)).Prefix(filepath.Base(destination))
-	pbar.SetUnits(pb.U_BYTES)
-	pbar.SetMaxWidth(80)
-	pbar.ShowSpeed = true
-	reader := pbar.NewProxyReader(resp)
+	pbar := newProgressBar(filepath.Base(destination), int64(fileLen))
+	reader := &progressReader{
+		Reader: &throttledReader{resp},
+		done:   int64(offset),
+		total:  int64(fileLen),
+		fn: func(done, total int64) {
+			pbar.Set(done, total)
+			s.reportProgress(done, total)
+		},
+	}
 	pbar.Start()
-	defer func() {
-		pbar.Update()
-		pbar.Finish()
-	}()
+	pbar.Set(int64(offset), int64(fileLen))
+	defer pbar.Finish()
 
 	// Now actually download it
-	if _, err := io.Copy(out, reader); err != nil {
+	if _, err := io.Copy(writer, reader); err != nil {
 		return err
 	}
 	return nil
 }
 
+// retrFTP will begin retrieving the file, using REST to resume from the
+// end of any partial download at the destination. If the server does not
+// support REST, the download starts over. The offset at which the returned
+// response begins is also returned.
+func (s *SimpleSource) retrFTP(client *ftpConn, path, destination string, fileLen uint64) (io.ReadCloser, uint64, error) {
+	partial := uint64(getResumeOffset(destination))
+	if partial > 0 && partial == fileLen {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Debug("Partial download is already complete")
+		return nil, partial, nil
+	}
+	if partial > 0 && partial < fileLen {
+		resp, err := client.Retr(path, partial)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"path":   path,
+				"offset": partial,
+			}).Info("Resuming FTP download")
+			return resp, partial, nil
+		}
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Warning("FTP server cannot resume download, starting over")
+	}
+	resp, err := client.Retr(path, 0)
+	return resp, 0, err
+}
+
+// verify will ensure the downloaded file matches one of the validators, if
+// set, selecting the matched validator for the source. Without a path, only
+// sha256 validators can be matched.
+func (s *SimpleSource) verify(path, sha256sum string) error {
+	if len(s.validators) == 0 {
+		return nil
+	}
+	// Only hash the file again for algorithms other than sha256, all of
+	// them in a single pass
+	sums := map[string]string{ValidatorSHA256: sha256sum}
+	var others []string
+	for _, v := range s.validators {
+		if algorithm := s.algorithms[v]; algorithm != ValidatorSHA256 {
+			others = append(others, algorithm)
+		}
+	}
+	if len(others) > 0 && path != "" {
+		digests, err := GetDigests(path, others...)
+		if err != nil {
+			return err
+		}
+		for algorithm, sum := range digests {
+			sums[algorithm] = sum
+		}
+	}
+	var got []string
+	for _, v := range s.validators {
+		sum, ok := sums[s.algorithms[v]]
+		if !ok {
+			continue
+		}
+		if sum == v {
+			s.validator = v
+			return nil
+		}
+		got = append(got, sum)
+	}
+	return fmt.Errorf("Checksum mismatch for %s: expected %s, got %s", s.File, strings.Join(s.validators, " or "), strings.Join(got, " or "))
+}
+
+// fetchVerified will download the source to destination with the given
+// download function, returning the sha256sum once it passes verification.
+func (s *SimpleSource) fetchVerified(destination string, download func(string) error) (string, error) {
+	if err := download(destination); err != nil {
+		return "", err
+	}
+
+	hash, err := s.getStagedSHA256(destination)
+	if err != nil {
+		return "", err
+	}
+
+	// Catch corrupt downloads, i.e. from a bad resume
+	if err := s.verify(destination, hash); err != nil {
+		os.Remove(destination)
+		return "", err
+	}
+	return hash, nil
+}
+
 // Fetch will download the given source and cache it locally
 func (s *SimpleSource) Fetch() error {
+	if err := checkOffline(s.URI); err != nil {
+		return err
+	}
+	if err := s.checkIPFS(); err != nil {
+		return err
+	}
+
 	// Now go and download it
 	log.WithFields(log.Fields{
 		"uri": s.URI,
 	}).Debug("Downloading source")
 
-	destPath := filepath.Join(SourceStagingDir, s.File)
+	// Staging is created by EnsureSourceDir
+	destPath := filepath.Join(GetStagingDir(), s.File)
 
-	// Check staging is available
-	if !PathExists(SourceStagingDir) {
-		if err := os.MkdirAll(SourceStagingDir, 00755); err != nil {
-			return err
-		}
+	// Grab the file, retrying transient failures, then falling back to any
+	// alternates
+	hash, err := s.fetchRetrying(destPath)
+	if err != nil && len(s.alternates) > 0 {
+		hash, err = s.fetchAlternates(destPath, err)
+	}
+	if err != nil {
+		return err
 	}
 
-	// Grab the file
-	if err := s.download(destPath); err != nil {
+	// Cache the source under the name the server gave it
+	if destPath, err = s.applyDispositionName(destPath); err != nil {
+		os.Remove(destPath)
 		return err
 	}
 
-	hash, err := s.GetSHA256Sum(destPath)
-	if err != nil {
+	// Refuse sources that upstream did not sign
+	if err := s.verifySignature(destPath); err != nil {
+		os.Remove(destPath)
+		return err
+	}
+
+	// Legacy sources are found by the sha1sum of the upstream content
+	var sha1sum string
+	if s.legacy {
+		if sha1sum, err = s.GetSHA1Sum(destPath); err != nil {
+			return err
+		}
+	}
+
+	// Normalize the source, caching it under the new hash
+	upstream := hash
+	if hash, err = s.applyTransform(destPath, hash); err != nil {
+		os.Remove(destPath)
 		return err
 	}
 
@@ -284,21 +877,42 @@ func (s *SimpleSource) Fetch() error {
 		}
 	}
 	// Move from staging into hash based directory
-	dest := filepath.Join(tgtDir, s.File)
-	if err := os.Rename(destPath, dest); err != nil {
+	if err := storeSource(destPath, tgtDir, s.File); err != nil {
 		return err
 	}
+	if err := writeProvenance(filepath.Join(tgtDir, s.File), s.newProvenance(hash, upstream)); err != nil {
+		log.WithFields(log.Fields{
+			"source": s.File,
+			"error":  err,
+		}).Warning("Failed to record source provenance")
+	}
+	// Transformed sources are still found by their upstream hash
+	if upstream != hash {
+		if err := linkHash(upstream, hash); err != nil {
+			return err
+		}
+	}
 	// If the file has a sha1sum set, symlink it to the sha256sum because
 	// it's a legacy archive (pspec.xml)
 	if s.legacy {
-		sha, err := s.GetSHA1Sum(dest)
-		if err != nil {
+		if err := linkHash(sha1sum, hash); err != nil {
 			return err
 		}
-		tgtLink := filepath.Join(SourceDir, sha)
-		if err := os.Symlink(hash, tgtLink); err != nil {
+	}
+	// Stronger digests than sha256 are found through a link, too
+	if algorithm, ok := s.algorithms[s.validator]; ok && algorithm != ValidatorSHA1 && algorithm != ValidatorSHA256 {
+		if err := linkHash(s.validator, hash); err != nil {
 			return err
 		}
 	}
+	// As is content trusted by its CID
+	if s.isContentAddressed() {
+		if err := linkHash(s.validator, hash); err != nil {
+			return err
+		}
+	}
+	if MaxCachedVersions > 0 {
+		s.evictOldVersions(hash)
+	}
 	return nil
 }
@@ -0,0 +1,170 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mirror/nano-2.7.5.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dist/nano-2.7.5.tar.xz", http.StatusFound)
+	})
+	mux.HandleFunc("/dist/nano-2.7.5.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("nano"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src, err := NewSimple(server.URL+"/mirror/nano-2.7.5.tar.xz", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.GetEffectiveURL() != src.URI {
+		t.Fatalf("Effective URL should default to the URI: %v", src.GetEffectiveURL())
+	}
+	if err := src.download(filepath.Join(tmp, src.File)); err != nil {
+		t.Fatalf("Failed to download source: %v", err)
+	}
+	if src.GetEffectiveURL() != server.URL+"/dist/nano-2.7.5.tar.xz" {
+		t.Fatalf("Wrong effective URL: %v", src.GetEffectiveURL())
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/one", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/two", http.StatusFound)
+	})
+	mux.HandleFunc("/two", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/nano-2.7.5.tar.xz", http.StatusFound)
+	})
+	mux.HandleFunc("/nano-2.7.5.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("nano"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() {
+		MaxRedirects = -1
+	}()
+
+	src, err := NewSimple(server.URL+"/one", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	dest := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+
+	// Default, follow everything
+	MaxRedirects = -1
+	if err := src.download(dest); err != nil {
+		t.Fatalf("Default policy should follow redirects: %v", err)
+	}
+
+	// Capped below the length of the chain
+	MaxRedirects = 1
+	if err := src.download(dest); err == nil {
+		t.Fatal("Capped policy should fail on too many redirects")
+	}
+	MaxRedirects = 2
+	if err := src.download(dest); err != nil {
+		t.Fatalf("Capped policy should follow redirects within the cap: %v", err)
+	}
+
+	// Disabled
+	MaxRedirects = 0
+	if err := src.download(dest); err != ErrRedirectForbidden {
+		t.Fatalf("Disabled policy should forbid redirects: %v", err)
+	}
+}
+
+func TestMultipleValidators(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-source")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(path, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	repacked := "0000000000000000000000000000000000000000000000000000000000000000"
+	actual := "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+
+	src, err := NewSimple("https://example.com/nano-2.7.5.tar.xz", repacked+", "+actual, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.validator != repacked || len(src.validators) != 2 {
+		t.Fatalf("Wrong validators: %s %v", src.validator, src.validators)
+	}
+	if err := src.verify(path, actual); err != nil {
+		t.Fatalf("Source matching the second validator was rejected: %v", err)
+	}
+	if bind := src.GetBindConfiguration("/"); bind.BindSource != filepath.Join(SourceDir, actual, src.File) {
+		t.Fatalf("Source should be cached under the matched hash: %s", bind.BindSource)
+	}
+
+	none, err := NewSimple("https://example.com/nano-2.7.5.tar.xz", repacked+","+strings.Repeat("1", 64), false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := none.verify(path, actual); err == nil {
+		t.Fatalf("Source matching no validator should be rejected")
+	}
+}
+
+func TestRenameFragment(t *testing.T) {
+	src, err := NewSimple("https://github.com/example/nano/archive/v2.7.5.tar.gz#nano-2.7.5.tar.gz", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if src.File != "nano-2.7.5.tar.gz" {
+		t.Fatalf("Source should be renamed by the fragment: %s", src.File)
+	}
+	if src.URI != "https://github.com/example/nano/archive/v2.7.5.tar.gz" || src.url.Fragment != "" {
+		t.Fatalf("Fragment should not be fetched: %s", src.URI)
+	}
+	if bind := src.GetBindConfiguration("/"); filepath.Base(bind.BindTarget) != "nano-2.7.5.tar.gz" {
+		t.Fatalf("Source should be bound under its new name: %s", bind.BindTarget)
+	}
+
+	for _, name := range []string{"../nano.tar.gz", "sub/nano.tar.gz", ".."} {
+		if _, err := NewSimple("https://example.com/v2.7.5.tar.gz#"+name, nanoSHA256, false); err == nil {
+			t.Fatalf("Rename to %s should be rejected", name)
+		}
+	}
+}
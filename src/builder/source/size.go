@@ -0,0 +1,158 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	curl "github.com/andelf/go-curl"
+)
+
+// ErrUnknownSize is returned when the remote size of a source cannot be
+// determined ahead of fetching it.
+var ErrUnknownSize = errors.New("Unknown source size")
+
+// A SizedSource is able to report the size of the remote source prior to
+// fetching it, enabling space requirements to be estimated.
+type SizedSource interface {
+	Source
+
+	// GetRemoteSize will return the size of the source in bytes, without
+	// fetching it.
+	GetRemoteSize() (int64, error)
+}
+
+// GetRemoteSize will determine the remote size of the source using either
+// a HEAD request or the FTP listing.
+func (s *SimpleSource) GetRemoteSize() (int64, error) {
+	if err := checkOffline(s.URI); err != nil {
+		return -1, err
+	}
+	// Torrents only know their size once the metadata is fetched
+	if s.isTorrent() {
+		return -1, ErrUnknownSize
+	}
+	switch s.url.Scheme {
+	case "ftp":
+		if getProxy(s.url) != "" {
+			return s.getRemoteSizeCurl()
+		}
+		return s.getRemoteSizeFTP()
+	case "file":
+		_, st, err := s.statLocal()
+		if err != nil {
+			return -1, err
+		}
+		return st.Size(), nil
+	case "s3":
+		fetch, err := s.getS3Source()
+		if err != nil {
+			return -1, err
+		}
+		return fetch.getRemoteSizeCurl()
+	case "sftp":
+		return s.getRemoteSizeSFTP()
+	case "ipfs":
+		if IPFSDaemon {
+			return -1, ErrUnknownSize
+		}
+		fetch, err := s.getIPFSSource()
+		if err != nil {
+			return -1, err
+		}
+		return fetch.getRemoteSizeCurl()
+	}
+	return s.getRemoteSizeCurl()
+}
+
+// getRemoteSizeCurl will issue a HEAD request for the source
+func (s *SimpleSource) getRemoteSizeCurl() (int64, error) {
+	status, size, err := s.headRequest()
+	if err != nil {
+		return -1, err
+	}
+	if size < 0 || status >= 400 {
+		return -1, ErrUnknownSize
+	}
+	return size, nil
+}
+
+// headRequest will issue a HEAD request for the source, returning the
+// final status code and advertised size, or -1 if the size is unknown.
+func (s *SimpleSource) headRequest() (int, int64, error) {
+	status, size, _, err := s.headRequestHeaders()
+	return status, size, err
+}
+
+// headRequestHeaders will issue a HEAD request for the source, as with
+// headRequest, also returning the raw response headers.
+func (s *SimpleSource) headRequestHeaders() (int, int64, []string, error) {
+	hnd, release := acquireHandle(s.url)
+	defer release()
+
+	hnd.Setopt(curl.OPT_NOBODY, true)
+	GetNetworkPolicy(NetworkMetadata).setCurlOptions(hnd)
+	setProxyOptions(hnd, s.url)
+	if err := setResolveOptions(hnd, s.url); err != nil {
+		return 0, -1, nil, err
+	}
+	hnd.Setopt(curl.OPT_USERAGENT, fmt.Sprintf("solbuild 1.3.0"))
+
+	// Keep the response headers, i.e. for Accept-Ranges
+	var headers []string
+	hnd.Setopt(curl.OPT_HEADERFUNCTION, func(data []byte, udata interface{}) bool {
+		headers = append(headers, string(data))
+		return true
+	})
+
+	if err := s.performRequest(hnd, s.requestHeaders("HEAD"), nil); err != nil {
+		return 0, -1, nil, err
+	}
+	if err := checkRedirect(hnd); err != nil {
+		return 0, -1, nil, err
+	}
+	status := 0
+	if info, err := hnd.Getinfo(curl.INFO_RESPONSE_CODE); err == nil {
+		if code, ok := info.(int); ok {
+			status = code
+		}
+	}
+	info, err := hnd.Getinfo(curl.INFO_CONTENT_LENGTH_DOWNLOAD)
+	if err != nil {
+		return status, -1, nil, err
+	}
+	if size, ok := info.(float64); ok && size >= 0 {
+		return status, int64(size), headers, nil
+	}
+	return status, -1, headers, nil
+}
+
+// getRemoteSizeFTP will ask the FTP server for the size with SIZE, using
+// the data connection mode set by FTPMode like downloads do
+func (s *SimpleSource) getRemoteSizeFTP() (int64, error) {
+	client, err := s.loginFTP(NetworkMetadata)
+	if err != nil {
+		return -1, err
+	}
+	defer client.Quit()
+
+	size, err := client.Size(s.url.Path)
+	if err != nil {
+		return -1, err
+	}
+	return int64(size), nil
+}
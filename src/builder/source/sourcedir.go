@@ -0,0 +1,108 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"syscall"
+)
+
+const (
+	// SourceDirMode is the mode the source cache directories must have
+	SourceDirMode os.FileMode = 00755
+
+	// accessWrite is W_OK for access(2)
+	accessWrite = 0x2
+)
+
+// A SourceDirError is returned when a cache directory cannot be created,
+// or its permissions cannot be repaired.
+type SourceDirError struct {
+	Path string // Path of the directory
+	Op   string // What was being attempted, i.e. "chmod"
+	Err  error  // Underlying error
+}
+
+func (e *SourceDirError) Error() string {
+	return fmt.Sprintf("Cannot %s source directory %s: %v", e.Op, e.Path, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *SourceDirError) Unwrap() error {
+	return e.Err
+}
+
+// EnsureSourceDir will ensure that the source cache and staging directories
+// exist with the expected mode, and are owned by the current user. Existing
+// directories with the wrong mode or owner are repaired where possible.
+// This is safe to call more than once, and should be called once before
+// fetching any sources.
+func EnsureSourceDir() error {
+	for _, dir := range []string{SourceDir, GetStagingDir()} {
+		if err := ensureDir(dir, SourceDirMode, os.Geteuid(), os.Getegid()); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Source directory is unusable")
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDir will create the directory if needed, then repair its mode and
+// ownership, failing if it still cannot be written to.
+func ensureDir(path string, mode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(path, mode); err != nil {
+		return &SourceDirError{Path: path, Op: "create", Err: err}
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return &SourceDirError{Path: path, Op: "stat", Err: err}
+	}
+	if !st.IsDir() {
+		return &SourceDirError{Path: path, Op: "use", Err: syscall.ENOTDIR}
+	}
+
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok && (int(sys.Uid) != uid || int(sys.Gid) != gid) {
+		log.WithFields(log.Fields{
+			"dir": path,
+			"uid": sys.Uid,
+			"gid": sys.Gid,
+		}).Warning("Repairing ownership of source directory")
+		if err := os.Chown(path, uid, gid); err != nil {
+			return &SourceDirError{Path: path, Op: "chown", Err: err}
+		}
+	}
+	// MkdirAll is subject to the umask, and won't touch existing directories
+	if st.Mode().Perm() != mode {
+		log.WithFields(log.Fields{
+			"dir":  path,
+			"mode": fmt.Sprintf("%04o", st.Mode().Perm()),
+		}).Warning("Repairing mode of source directory")
+		if err := os.Chmod(path, mode); err != nil {
+			return &SourceDirError{Path: path, Op: "chmod", Err: err}
+		}
+	}
+
+	// Catch anything else preventing writes, i.e. a read-only mount
+	if err := syscall.Access(path, accessWrite); err != nil {
+		return &SourceDirError{Path: path, Op: "write to", Err: err}
+	}
+	return nil
+}
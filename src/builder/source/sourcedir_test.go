@@ -0,0 +1,72 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureSourceDir(t *testing.T) {
+	_, restore := withTempSourceDir(t)
+	defer restore()
+	for _, dir := range []string{SourceDir, SourceStagingDir} {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() || st.Mode().Perm() != SourceDirMode {
+			t.Fatalf("Source directory %s not created correctly: %v", dir, err)
+		}
+	}
+
+	// Repair a cache left behind with the wrong mode
+	if err := os.Chmod(SourceStagingDir, 00500); err != nil {
+		t.Fatalf("Failed to break staging directory: %v", err)
+	}
+	if err := os.Chmod(SourceDir, 00700); err != nil {
+		t.Fatalf("Failed to break source directory: %v", err)
+	}
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to repair source directories: %v", err)
+	}
+	for _, dir := range []string{SourceDir, SourceStagingDir} {
+		if st, err := os.Stat(dir); err != nil || st.Mode().Perm() != SourceDirMode {
+			t.Fatalf("Mode of %s was not repaired: %v", dir, st.Mode())
+		}
+	}
+
+	// Idempotent once in place
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to ensure existing source directories: %v", err)
+	}
+}
+
+func TestEnsureSourceDirFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-sourcedir")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "sources")
+	if err := ioutil.WriteFile(path, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	err = ensureDir(path, SourceDirMode, os.Geteuid(), os.Getegid())
+	if _, ok := err.(*SourceDirError); !ok {
+		t.Fatalf("Expected a SourceDirError, got: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrStreamLegacy is returned when streaming a legacy source, as these
+	// are validated by sha1sum.
+	ErrStreamLegacy = errors.New("Legacy sources cannot be streamed")
+
+	// ErrStreamDigest is returned when streaming a source without a sha256
+	// validator, as only the sha256sum is computed while streaming.
+	ErrStreamDigest = errors.New("Streamed sources require a sha256 validator")
+
+	// ErrStreamSFTP is returned when streaming an sftp:// source, as these
+	// are only fetched to a file.
+	ErrStreamSFTP = errors.New("SFTP sources cannot be streamed")
+
+	// ErrStreamTorrent is returned when streaming a torrent, as pieces are
+	// fetched out of order.
+	ErrStreamTorrent = errors.New("Torrent sources cannot be streamed")
+
+	// errStreamAborted is seen by the download when extraction fails first
+	errStreamAborted = errors.New("Streamed extraction was aborted")
+)
+
+// ExtractStream will extract the archive read from r into dest, detecting
+// the format from the given file name. Extraction is staged alongside dest,
+// and only moved into place once every byte of the stream has been read and
+// verify accepts its sha256sum, so nothing is left behind on a mismatch.
+// The destination must not exist, or be an empty directory.
+func ExtractStream(r io.Reader, name, dest string, verify func(sha256sum string) error) error {
+	staging, err := ioutil.TempDir(filepath.Dir(dest), "."+filepath.Base(dest)+".")
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.RemoveAll(staging)
+		}
+	}()
+
+	hash := sha256.New()
+	tee := io.TeeReader(r, hash)
+	walk := func(fn func(e *archiveEntry) error) error {
+		return walkStream(name, tee, fn)
+	}
+	var created []string
+	if err := extractTo(walk, staging, &created); err != nil {
+		return err
+	}
+	// Trailing padding is still part of the verified content
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return err
+	}
+	if err := verify(hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return err
+	}
+
+	// An empty destination may be replaced
+	if PathExists(dest) {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(staging, 00755); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// streamFTP will write the whole file from the FTP server to w
+func (s *SimpleSource) streamFTP(w io.Writer) error {
+	client, err := s.loginFTP(NetworkDownload)
+	if err != nil {
+		return err
+	}
+	defer client.Quit()
+
+	resp, err := client.Retr(s.url.Path, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	_, err = io.Copy(w, &throttledReader{resp})
+	return err
+}
+
+// downloadStream will write the source to w as it's downloaded, using the
+// URL from the pre-fetch hook if one is set. Mirrors and the archive are
+// not used, as a stream cannot be restarted part way through.
+func (s *SimpleSource) downloadStream(w io.Writer) error {
+	fetch, err := s.getFetchSource()
+	if err != nil {
+		return err
+	}
+	defer func() { s.status = fetch.status }()
+
+	if fetch.isTorrent() {
+		return ErrStreamTorrent
+	}
+	switch fetch.url.Scheme {
+	case "ftp":
+		if getProxy(fetch.url) != "" {
+			return fetch.downloadCurlTo(w, s.File, 0)
+		}
+		return fetch.streamFTP(w)
+	case "file":
+		return fetch.streamFile(w)
+	case "sftp":
+		return ErrStreamSFTP
+	case "ipfs":
+		return fetch.streamIPFS(w)
+	default:
+		return fetch.downloadCurlTo(w, s.File, 0)
+	}
+}
+
+// FetchExtract will stream the source directly into extraction at dest,
+// without writing the archive to disk or caching it. This suits huge
+// sources that are only used once, while Fetch remains the default. The
+// extracted tree is only committed once the download matches one of the
+// validators.
+func (s *SimpleSource) FetchExtract(dest string) error {
+	if s.legacy {
+		return ErrStreamLegacy
+	}
+	if len(s.validators) == 0 {
+		return ErrMissingValidator
+	}
+	if !s.hasValidator(ValidatorSHA256) {
+		return ErrStreamDigest
+	}
+	if err := checkOffline(s.URI); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.downloadStream(pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := ExtractStream(pr, s.File, dest, func(sum string) error {
+		return s.verify("", sum)
+	})
+	// Unblock the download if extraction stopped early
+	pr.CloseWithError(errStreamAborted)
+	if downloadErr := <-done; downloadErr != nil && downloadErr != errStreamAborted && err == nil {
+		err = downloadErr
+	}
+	return err
+}
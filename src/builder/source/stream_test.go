@@ -0,0 +1,104 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchExtract(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-stream")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := filepath.Join(tmp, "nano-2.7.5.tar.gz")
+	writeTestTarball(t, archive, []testMember{
+		{name: "nano-2.7.5/README", body: "nano"},
+		{name: "nano-2.7.5/src/nano.c", body: "int main;"},
+	})
+	contents, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read tarball: %v", err)
+	}
+	sum := sha256.Sum256(contents)
+	reference := filepath.Join(tmp, "reference")
+	if err := ExtractTo(archive, reference); err != nil {
+		t.Fatalf("Failed to extract archive: %v", err)
+	}
+
+	for _, validator := range []string{hex.EncodeToString(sum[:]), nanoSHA256} {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.gz", contents, true)
+		defer server.listener.Close()
+
+		src, err := NewSimple(server.URL(), validator, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		dest := filepath.Join(tmp, validator)
+		err = src.FetchExtract(dest)
+		if validator == nanoSHA256 {
+			if err == nil {
+				t.Fatalf("Mismatched stream should fail to extract")
+			}
+			if PathExists(dest) {
+				t.Fatalf("Mismatched stream left its destination behind")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to stream extraction: %v", err)
+		}
+		for _, member := range []string{"nano-2.7.5/README", "nano-2.7.5/src/nano.c"} {
+			want, err := ioutil.ReadFile(filepath.Join(reference, member))
+			if err != nil {
+				t.Fatalf("Failed to read reference member: %v", err)
+			}
+			if b, err := ioutil.ReadFile(filepath.Join(dest, member)); err != nil || string(b) != string(want) {
+				t.Fatalf("Streamed %s differs from extraction: %q %v", member, b, err)
+			}
+		}
+	}
+
+	// Nothing staged may be left over, even on failure
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatalf("Failed to read temporary directory: %v", err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Fatalf("Staging directory left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestFetchExtractLegacy(t *testing.T) {
+	src, err := NewSimple("https://example.com/nano-2.7.5.tar.gz", "", true)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.FetchExtract(filepath.Join(os.TempDir(), "solbuild-legacy")); err != ErrStreamLegacy {
+		t.Fatalf("Expected legacy sources to be refused, got: %v", err)
+	}
+}
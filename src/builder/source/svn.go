@@ -0,0 +1,153 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A SvnSource is a subversion repository referenced by the `ypkg` build
+// spec as `svn|` URI, pinned to a revision. As the revision never changes,
+// each checkout is stored once under a directory named for the hash of the
+// URI and revision within SourceDir, much as tarballs are.
+type SvnSource struct {
+	URI      string
+	Revision string
+	BaseName string
+}
+
+// NewSvn will create a new SvnSource for the given URI & revision
+func NewSvn(uri, revision string) (*SvnSource, error) {
+	if err := checkRepositoryURI(uri); err != nil {
+		return nil, err
+	}
+	urlObj, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &SvnSource{
+		URI:      uri,
+		Revision: revision,
+		BaseName: filepath.Base(urlObj.Path),
+	}, nil
+}
+
+// getHash will return the hash identifying the checkout in the cache
+func (s *SvnSource) getHash() string {
+	sum := sha256.Sum256([]byte(s.URI + "@" + s.Revision))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetPath will return the path of the checkout within the cache
+func (s *SvnSource) GetPath() string {
+	return filepath.Join(SourceDir, s.getHash(), s.BaseName)
+}
+
+// Fetch will check out the revision into a staging directory beside the
+// cached path, only moving it into place once complete.
+func (s *SvnSource) Fetch() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := checkOffline(s.URI); err != nil {
+		return err
+	}
+	path := s.GetPath()
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(path), "."+s.BaseName+".")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	log.WithFields(log.Fields{
+		"uri":      s.URI,
+		"revision": s.Revision,
+	}).Debug("Checking out subversion source")
+
+	// Peg the revision too, in case the path has since moved
+	target := filepath.Join(staging, s.BaseName)
+	args := []string{"checkout", "--quiet", "--non-interactive", "-r", s.Revision, "--", s.URI + "@" + s.Revision, target}
+	if err := commands.ExecStdoutArgs("svn", args); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"uri":   s.URI,
+		}).Error("Failed to check out subversion source")
+		return err
+	}
+	return os.Rename(target, path)
+}
+
+// IsFetched will determine whether the revision is already checked out
+func (s *SvnSource) IsFetched() bool {
+	return PathExists(s.GetPath())
+}
+
+// GetBindConfiguration will return a config that enables bind mounting
+// the checkout into the container, as with SimpleSource.
+func (s *SvnSource) GetBindConfiguration(rootfs string) BindConfiguration {
+	return BindConfiguration{
+		BindSource: s.GetPath(),
+		BindTarget: filepath.Join(rootfs, s.BaseName),
+	}
+}
+
+// GetIdentifier will return a human readable string to represent this
+// subversion source in the event of errors.
+func (s *SvnSource) GetIdentifier() string {
+	return fmt.Sprintf("%s@%s", s.URI, s.Revision)
+}
+
+// GetValidator will return the revision that will be checked out
+func (s *SvnSource) GetValidator() (string, string) {
+	return "revision", s.Revision
+}
+
+// GetCanonicalPath will return the path of the checkout
+func (s *SvnSource) GetCanonicalPath() (string, bool, error) {
+	resolved, err := filepath.EvalSymlinks(s.GetPath())
+	if err != nil {
+		return "", false, err
+	}
+	return resolved, false, nil
+}
+
+// Validate will ensure the subversion source has a URL and is pinned to a
+// numeric revision.
+func (s *SvnSource) Validate() error {
+	if s.Revision == "" {
+		return ErrMissingValidator
+	}
+	if rev, err := strconv.Atoi(s.Revision); err != nil || rev < 1 {
+		return fmt.Errorf("Invalid subversion revision: '%s'", s.Revision)
+	}
+	if u, err := url.Parse(s.URI); err != nil || u.Scheme == "" {
+		return fmt.Errorf("%v: '%s'", ErrUnsupportedScheme, s.URI)
+	}
+	return CheckSecureScheme(s.URI)
+}
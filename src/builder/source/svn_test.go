@@ -0,0 +1,62 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSvn(t *testing.T) {
+	src, err := New("svn|https://svn.code.sf.net/p/netpbm/code/stable", "3471", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	svn, ok := src.(*SvnSource)
+	if !ok {
+		t.Fatalf("Expected a subversion source, got %T", src)
+	}
+	if err := svn.Validate(); err != nil {
+		t.Fatalf("Source should be valid: %v", err)
+	}
+
+	bind := svn.GetBindConfiguration("/home/build/YPKG/sources")
+	if filepath.Dir(filepath.Dir(bind.BindSource)) != SourceDir || filepath.Base(bind.BindSource) != "stable" {
+		t.Fatalf("Checkout should be cached beneath a hash directory: %s", bind.BindSource)
+	}
+	if bind.BindTarget != "/home/build/YPKG/sources/stable" {
+		t.Fatalf("Wrong bind target: %s", bind.BindTarget)
+	}
+
+	// Each revision is checked out separately
+	other, _ := NewSvn(svn.URI, "3472")
+	if other.GetPath() == svn.GetPath() {
+		t.Fatalf("Revisions should not share a checkout")
+	}
+
+	// svn must never see the URI as an option
+	if _, err := New("svn|--config-option=config:tunnels:ssh=touch /tmp/pwn", "3471", false); err == nil {
+		t.Fatalf("URI starting with a dash should be rejected")
+	}
+
+	for _, revision := range []string{"HEAD", "0", "r3471"} {
+		svn.Revision = revision
+		if err := svn.Validate(); err == nil {
+			t.Fatalf("Revision should be rejected: %s", revision)
+		}
+	}
+}
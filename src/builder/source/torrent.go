@@ -0,0 +1,160 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TorrentSuffix marks the URI of a .torrent file as a torrent source
+const TorrentSuffix = ".torrent"
+
+var (
+	// seeders are the aria2c processes seeding fetched torrents
+	seeders    []*exec.Cmd
+	seederLock sync.Mutex
+)
+
+// getTorrentName will return the name of the file shared by the torrent,
+// from the dn of a magnet link or the name of a .torrent file, and whether
+// the URL is that of a torrent at all.
+func getTorrentName(u *url.URL) (string, bool) {
+	if u.Scheme == "magnet" {
+		return filepath.Base(u.Query().Get("dn")), true
+	}
+	if strings.HasSuffix(u.Path, TorrentSuffix) {
+		return strings.TrimSuffix(filepath.Base(u.Path), TorrentSuffix), true
+	}
+	return "", false
+}
+
+// isTorrent will determine whether the source is fetched over BitTorrent
+func (s *SimpleSource) isTorrent() bool {
+	_, ok := getTorrentName(s.url)
+	return ok
+}
+
+// getTorrentArgs will return the arguments to aria2c to fetch or seed the
+// file of the torrent within dir, named for the source.
+func (s *SimpleSource) getTorrentArgs(dir string) []string {
+	policy := GetNetworkPolicy(NetworkDownload)
+	args := []string{
+		"--dir=" + dir,
+		"--index-out=1=" + s.File,
+		"--follow-torrent=mem",
+		"--summary-interval=0",
+		"--console-log-level=warn",
+		fmt.Sprintf("--connect-timeout=%d", int(policy.ConnectTimeout/time.Second)),
+	}
+	if policy.TransferTimeout > 0 {
+		args = append(args, fmt.Sprintf("--bt-stop-timeout=%d", int(policy.TransferTimeout/time.Second)))
+	}
+	if LimitRate > 0 {
+		args = append(args, fmt.Sprintf("--max-overall-download-limit=%d", LimitRate))
+	}
+	return args
+}
+
+// downloadTorrent will fetch the file of the torrent with aria2c, into a
+// directory beside the destination so that it may be resumed. The torrent
+// must share exactly one file.
+func (s *SimpleSource) downloadTorrent(destination string) error {
+	dir := destination + ".parts"
+	if ForceFreshDownloads {
+		os.RemoveAll(dir)
+	}
+	os.Remove(destination)
+	discardHashCheckpoint(destination)
+
+	log.WithFields(log.Fields{
+		"uri": s.URI,
+	}).Info("Fetching source over BitTorrent")
+	args := append(s.getTorrentArgs(dir), "--seed-time=0", s.URI)
+	if out, err := exec.Command("aria2c", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to fetch %s over BitTorrent: %v: %s", s.URI, err, strings.TrimSpace(string(out)))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != s.File {
+			os.RemoveAll(dir)
+			return fmt.Errorf("Torrent must share a single file: %s", s.URI)
+		}
+	}
+	if err := os.Rename(filepath.Join(dir, s.File), destination); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Seed will seed the cached file of a torrent source in the background,
+// until StopSeeding is called. Other sources are ignored.
+func (s *SimpleSource) Seed() error {
+	if !s.isTorrent() || !s.IsFetched() {
+		return nil
+	}
+	args := append(s.getTorrentArgs(filepath.Dir(s.GetPath(s.validator))), "--check-integrity=true", "--seed-ratio=0.0", s.URI)
+	cmd := exec.Command("aria2c", args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uri": s.URI,
+	}).Info("Seeding source")
+
+	seederLock.Lock()
+	defer seederLock.Unlock()
+	seeders = append(seeders, cmd)
+	return nil
+}
+
+// StopSeeding will stop seeding all torrent sources
+func StopSeeding() {
+	seederLock.Lock()
+	defer seederLock.Unlock()
+	for _, cmd := range seeders {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	seeders = nil
+}
+
+// checkRemoteTorrent will ensure the .torrent file is available, as the
+// size of the source itself is unknown. Magnet links cannot be checked
+// without joining the swarm.
+func (s *SimpleSource) checkRemoteTorrent() (int, int64, error) {
+	if s.url.Scheme == "magnet" {
+		return 0, -1, nil
+	}
+	status, _, err := s.headRequest()
+	if err == nil && status >= 400 {
+		err = fmt.Errorf("Unexpected status code: %d", status)
+	}
+	return status, -1, err
+}
@@ -0,0 +1,124 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const nanoMagnet = "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=nano-2.7.5.tar.xz"
+
+// fakeAria2c stands in for aria2c, writing "nano" to the indexed file, or
+// seeding until killed.
+const fakeAria2c = `#!/bin/sh
+for arg; do
+	case "$arg" in
+		--dir=*) dir="${arg#--dir=}" ;;
+		--index-out=1=*) name="${arg#--index-out=1=}" ;;
+		--seed-ratio=*) exec sleep 60 ;;
+	esac
+done
+mkdir -p "$dir"
+printf nano > "$dir/$name"
+if [ -n "$TORRENT_EXTRA" ]; then
+	printf extra > "$dir/README"
+fi
+`
+
+func TestTorrentSource(t *testing.T) {
+	src, err := NewSimple(nanoMagnet, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !src.isTorrent() || src.File != "nano-2.7.5.tar.xz" {
+		t.Fatalf("Magnet link should be a torrent named by dn: %s", src.File)
+	}
+	if err := src.Validate(); err != nil {
+		t.Fatalf("Magnet source should be valid: %v", err)
+	}
+	if _, err := src.GetRemoteSize(); err != ErrUnknownSize {
+		t.Fatalf("Torrent size should be unknown, got: %v", err)
+	}
+
+	src, err = NewSimple("https://example.com/torrents/nano-2.7.5.tar.xz.torrent", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if !src.isTorrent() || src.File != "nano-2.7.5.tar.xz" {
+		t.Fatalf(".torrent URI should be a torrent named for its file: %s", src.File)
+	}
+
+	unnamed, err := NewSimple("magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a", nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := unnamed.Validate(); err == nil {
+		t.Fatalf("Magnet link without a name should be rejected")
+	}
+}
+
+func TestFetchTorrent(t *testing.T) {
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	defer StopSeeding()
+
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+	bin := filepath.Join(tmp, "bin")
+	if err := os.MkdirAll(bin, 00755); err != nil {
+		t.Fatalf("Failed to create bin directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "aria2c"), []byte(fakeAria2c), 00755); err != nil {
+		t.Fatalf("Failed to write aria2c script: %v", err)
+	}
+	os.Setenv("PATH", bin+":"+oldPath)
+
+	// Torrents sharing more than the one file are refused
+	os.Setenv("TORRENT_EXTRA", "1")
+	src, err := NewSimple(nanoMagnet, nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err == nil {
+		t.Fatalf("Torrent with several files should be rejected")
+	}
+	os.Unsetenv("TORRENT_EXTRA")
+
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch torrent: %v", err)
+	}
+	if !src.IsFetched() {
+		t.Fatalf("Torrent was not cached")
+	}
+	if PathExists(filepath.Join(GetStagingDir(), src.File+".parts")) {
+		t.Fatalf("Torrent download directory was left behind")
+	}
+
+	if err := src.Seed(); err != nil {
+		t.Fatalf("Failed to seed torrent: %v", err)
+	}
+	if len(seeders) != 1 {
+		t.Fatalf("Torrent should be seeded")
+	}
+	StopSeeding()
+	if len(seeders) != 0 {
+		t.Fatalf("Seeding should have stopped")
+	}
+}
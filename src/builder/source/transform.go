@@ -0,0 +1,99 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A SourceTransform is called with the URI of each freshly fetched and
+// verified source, and the path of the staged download. It may write a
+// normalized replacement, such as a recompressed tarball, returning its
+// path, or return the staged path to keep the source as it is. The source
+// keeps its file name either way.
+type SourceTransform func(uri, staged string) (string, error)
+
+var (
+	transformLock   sync.RWMutex
+	sourceTransform SourceTransform
+)
+
+// SetSourceTransform will install the post-fetch hook used to normalize
+// sources before they are cached. Transformed sources are cached under the
+// sha256sum of the replacement, with the upstream hash linked to it so that
+// the source is still found by its validator. Passing nil removes the hook.
+func SetSourceTransform(transform SourceTransform) {
+	transformLock.Lock()
+	defer transformLock.Unlock()
+	sourceTransform = transform
+}
+
+// getSourceTransform will return the post-fetch hook, if any
+func getSourceTransform() SourceTransform {
+	transformLock.RLock()
+	defer transformLock.RUnlock()
+	return sourceTransform
+}
+
+// applyTransform will run the post-fetch hook over the staged download,
+// replacing it with the normalized file. The sha256sum of the content to
+// cache is returned.
+func (s *SimpleSource) applyTransform(staged, hash string) (string, error) {
+	transform := getSourceTransform()
+	if transform == nil {
+		return hash, nil
+	}
+	replacement, err := transform(s.URI, staged)
+	if err != nil {
+		return "", err
+	}
+	if replacement == "" || replacement == staged {
+		return hash, nil
+	}
+	if err := moveFile(replacement, staged); err != nil {
+		return "", err
+	}
+	normalized, err := s.GetSHA256Sum(staged)
+	if err != nil {
+		return "", err
+	}
+	log.WithFields(log.Fields{
+		"source":     s.File,
+		"upstream":   hash,
+		"normalized": normalized,
+	}).Info("Normalized fetched source")
+	return normalized, nil
+}
+
+// linkHash will point the hash within the cache at the directory of
+// another hash, replacing any existing link. A real directory for the hash
+// already holds the content, and is left alone.
+func linkHash(hash, target string) error {
+	link := filepath.Join(SourceDir, hash)
+	if PathExists(link) && !isSymlink(link) {
+		return nil
+	}
+	if isSymlink(link) {
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, link)
+}
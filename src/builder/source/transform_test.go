@@ -0,0 +1,92 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceTransform(t *testing.T) {
+	defer SetSourceTransform(nil)
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+
+	var transformed []string
+	SetSourceTransform(func(uri, staged string) (string, error) {
+		transformed = append(transformed, uri)
+		b, err := ioutil.ReadFile(staged)
+		if err != nil {
+			return "", err
+		}
+		normalized := staged + ".normalized"
+		return normalized, ioutil.WriteFile(normalized, bytes.ToUpper(b), 00644)
+	})
+
+	src, err := NewSimple(server.URL(), nanoSHA256, false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	if len(transformed) != 1 || transformed[0] != server.URL() {
+		t.Fatalf("Transform was not called with the source: %v", transformed)
+	}
+
+	// Cached under the digest of the normalized content
+	normalized := "3929a2d0db4d8e6375b657dcf56d69a6c6f7476e5e8b7f6453a598b02fe40d1d"
+	if b, err := ioutil.ReadFile(filepath.Join(SourceDir, normalized, src.File)); err != nil || string(b) != "NANO" {
+		t.Fatalf("Normalized source was not cached: %s %v", b, err)
+	}
+	// Still found by the upstream hash
+	if !isSymlink(filepath.Join(SourceDir, nanoSHA256)) {
+		t.Fatalf("Upstream hash should link to the normalized source")
+	}
+	if !src.IsFetched() {
+		t.Fatalf("Transformed source should be fetched")
+	}
+	resolved, _, err := src.GetCanonicalPath()
+	if err != nil {
+		t.Fatalf("Failed to resolve source: %v", err)
+	}
+	if hash := filepath.Base(filepath.Dir(resolved)); hash != normalized {
+		t.Fatalf("Source should resolve to the normalized digest, got %s", hash)
+	}
+
+	// Keeping the staged file leaves the source untouched
+	SetSourceTransform(func(uri, staged string) (string, error) {
+		return staged, nil
+	})
+	os.RemoveAll(SourceDir)
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := src.Fetch(); err != nil {
+		t.Fatalf("Failed to fetch source: %v", err)
+	}
+	if isSymlink(filepath.Join(SourceDir, nanoSHA256)) || PathExists(filepath.Join(SourceDir, normalized)) {
+		t.Fatalf("Unchanged source should be cached under its upstream hash")
+	}
+}
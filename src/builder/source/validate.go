@@ -0,0 +1,218 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// ErrSizeMismatch is returned when the remote size of a source does
+// not match the size declared for it.
+var ErrSizeMismatch = errors.New("Remote size does not match the expected size")
+
+// A CheckableSource is able to verify that the remote source is reachable,
+// without fetching it.
+type CheckableSource interface {
+	Source
+
+	// CheckRemote will return the status code and advertised size of the
+	// remote source. The size is -1 when unknown, and the status code is 0
+	// for protocols without one.
+	CheckRemote() (int, int64, error)
+
+	// GetHost will return the host serving this source.
+	GetHost() string
+}
+
+// A ValidationResult records the outcome of validating a single source.
+type ValidationResult struct {
+	Source  Source
+	Status  int   // Status code returned by the server, if any
+	Size    int64 // Advertised size, or -1 if unknown
+	Skipped bool  // Source type doesn't support validation
+	Err     error
+}
+
+// CheckRemote will issue a HEAD request, or list the file over FTP, to
+// ensure the source is available.
+func (s *SimpleSource) CheckRemote() (int, int64, error) {
+	if err := checkOffline(s.URI); err != nil {
+		return 0, -1, err
+	}
+	if s.isTorrent() {
+		return s.checkRemoteTorrent()
+	}
+	if s.url.Scheme == "file" {
+		_, st, err := s.statLocal()
+		if err != nil {
+			return 0, -1, err
+		}
+		return 0, st.Size(), nil
+	}
+	if s.url.Scheme == "ftp" && getProxy(s.url) == "" {
+		size, err := s.getRemoteSizeFTP()
+		if err == ErrUnknownSize {
+			return 0, -1, fmt.Errorf("File not found: %s", s.url.Path)
+		}
+		return 0, size, err
+	}
+	if s.url.Scheme == "ipfs" {
+		if IPFSDaemon {
+			// Content is only found by asking the swarm
+			return 0, -1, nil
+		}
+		fetch, err := s.getIPFSSource()
+		if err != nil {
+			return 0, -1, err
+		}
+		return fetch.CheckRemote()
+	}
+	if s.url.Scheme == "sftp" {
+		size, err := s.getRemoteSizeSFTP()
+		return 0, size, err
+	}
+	if s.url.Scheme == "s3" {
+		fetch, err := s.getS3Source()
+		if err != nil {
+			return 0, -1, err
+		}
+		return fetch.CheckRemote()
+	}
+	status, size, err := s.headRequest()
+	if err != nil {
+		return status, -1, err
+	}
+	if status >= 400 {
+		return status, size, fmt.Errorf("Unexpected status code: %d", status)
+	}
+	return status, size, nil
+}
+
+// GetHost will return the host portion of the source URL
+func (s *SimpleSource) GetHost() string {
+	return s.url.Host
+}
+
+// ValidateSources will check that each of the given sources is reachable
+// without downloading them. Checks run concurrently, with no more than
+// the HostConcurrency of the metadata policy made to a single host at once.
+//
+// Sizes may contain the expected size of sources, keyed by identifier,
+// and any mismatch with the advertised size is reported as ErrSizeMismatch.
+func ValidateSources(sources []Source, sizes map[string]int64) []ValidationResult {
+	results := make([]ValidationResult, len(sources))
+	hosts := make(map[string]chan bool)
+	limit := GetNetworkPolicy(NetworkMetadata).Concurrency()
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		results[i] = ValidationResult{Source: src, Size: -1}
+		checkable, ok := src.(CheckableSource)
+		if !ok {
+			results[i].Skipped = true
+			continue
+		}
+		host := checkable.GetHost()
+		if _, ok := hosts[host]; !ok {
+			hosts[host] = make(chan bool, limit)
+		}
+
+		wg.Add(1)
+		go func(result *ValidationResult, src CheckableSource, sem chan bool) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+
+			result.Status, result.Size, result.Err = src.CheckRemote()
+			if result.Err != nil || result.Size < 0 {
+				return
+			}
+			if expected, ok := sizes[src.GetIdentifier()]; ok && expected != result.Size {
+				result.Err = ErrSizeMismatch
+			}
+		}(&results[i], checkable, hosts[host])
+	}
+	wg.Wait()
+	return results
+}
+
+var (
+	// ErrMissingValidator is returned when a source has no hash or ref
+	ErrMissingValidator = errors.New("Source has no validator")
+
+	// ErrUnsupportedScheme is returned for URLs no source type can fetch
+	ErrUnsupportedScheme = errors.New("Unsupported source scheme")
+)
+
+// A DeclaredSource is able to check its own declaration is consistent,
+// without touching the network or the cache.
+type DeclaredSource interface {
+	Source
+
+	// Validate will return an error if the source can never be fetched
+	// as declared.
+	Validate() error
+}
+
+// Validate will ensure the source has a fetchable URL and well formed
+// hashes, i.e. sha256sum or stronger for package.yml, and sha1sum for legacy
+// sources.
+func (s *SimpleSource) Validate() error {
+	switch s.url.Scheme {
+	case "http", "https", "ftp", "file", "s3", "sftp", "magnet", "ipfs":
+	default:
+		return fmt.Errorf("%v: '%s'", ErrUnsupportedScheme, s.url.Scheme)
+	}
+	if err := CheckSecureScheme(s.URI); err != nil {
+		return err
+	}
+	if s.File == "" || s.File == "." || s.File == "/" {
+		return fmt.Errorf("Source URL has no file name: %s", s.URI)
+	}
+	if s.isContentAddressed() {
+		return s.checkIPFS()
+	}
+	if len(s.validators) == 0 {
+		return ErrMissingValidator
+	}
+	for _, v := range s.validators {
+		algorithm := s.algorithms[v]
+		if _, err := hex.DecodeString(v); err != nil || len(v) != validatorHashes[algorithm]().Size()*2 {
+			return fmt.Errorf("Invalid hash for source: '%s'", v)
+		}
+		// Only legacy sources may still use sha1sums
+		if algorithm == ValidatorSHA1 && !s.legacy {
+			return fmt.Errorf("Source must use sha256 or a stronger digest: '%s'", v)
+		}
+	}
+	return nil
+}
+
+// Validate will ensure the git source has a URL and a ref to check out
+func (g *GitSource) Validate() error {
+	if g.Ref == "" {
+		return ErrMissingValidator
+	}
+	if u, err := url.Parse(g.URI); err != nil || u.Scheme == "" {
+		return fmt.Errorf("%v: '%s'", ErrUnsupportedScheme, g.URI)
+	}
+	return CheckSecureScheme(g.URI)
+}
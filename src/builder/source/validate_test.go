@@ -0,0 +1,95 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateSources(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4")
+		w.Write([]byte("nano"))
+	})
+	mux.HandleFunc("/missing.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/broken.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/moved.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok.tar.xz", http.StatusFound)
+	})
+	mux.HandleFunc("/mismatch.tar.xz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4")
+		w.Write([]byte("nano"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		fail   bool
+	}{
+		{"/ok.tar.xz", 200, false},
+		{"/missing.tar.xz", 404, true},
+		{"/broken.tar.xz", 500, true},
+		{"/moved.tar.xz", 200, false},
+		{"/mismatch.tar.xz", 0, true},
+	}
+
+	var sources []Source
+	for _, test := range tests {
+		src, err := NewSimple(server.URL+test.path, "", false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		sources = append(sources, src)
+	}
+	git, err := NewGit("https://github.com/solus-project/solbuild.git", "v1.3.0")
+	if err != nil {
+		t.Fatalf("Failed to create git source: %v", err)
+	}
+	sources = append(sources, git)
+
+	sizes := map[string]int64{
+		server.URL + "/mismatch.tar.xz": 1024,
+	}
+	results := ValidateSources(sources, sizes)
+	if len(results) != len(sources) {
+		t.Fatalf("Expected %d results, got %d", len(sources), len(results))
+	}
+	for i, test := range tests {
+		result := results[i]
+		if test.fail != (result.Err != nil) {
+			t.Fatalf("Unexpected result for %s: %v", test.path, result.Err)
+		}
+		if test.status != 0 && result.Status != test.status {
+			t.Fatalf("Wrong status for %s: %d", test.path, result.Status)
+		}
+	}
+	if results[4].Err != ErrSizeMismatch {
+		t.Fatalf("Expected size mismatch, got: %v", results[4].Err)
+	}
+	if !results[len(results)-1].Skipped {
+		t.Fatalf("Git source should not be validated")
+	}
+}
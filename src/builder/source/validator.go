@@ -0,0 +1,147 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// The digest algorithms supported by validators. Sources are always cached
+// by their sha256sum, and found by any other digest through a link.
+const (
+	ValidatorSHA1   = "sha1"
+	ValidatorSHA256 = "sha256"
+	ValidatorSHA384 = "sha384"
+	ValidatorSHA512 = "sha512"
+)
+
+// validatorHashes will create the hash for each supported algorithm
+var validatorHashes = map[string]func() hash.Hash{
+	ValidatorSHA1:   sha1.New,
+	ValidatorSHA256: sha256.New,
+	ValidatorSHA384: sha512.New384,
+	ValidatorSHA512: sha512.New,
+}
+
+// ParseValidator will return the algorithm and lower case digest of the
+// validator, which is either in the "algo:hex" form, or a bare digest. The
+// algorithm of a bare digest is implied by its length, with sha1sums only
+// implied for legacy sources.
+func ParseValidator(validator string, legacy bool) (string, string, error) {
+	validator = strings.ToLower(strings.TrimSpace(validator))
+	i := strings.Index(validator, ":")
+	if i < 0 {
+		return getValidatorAlgorithm(validator, legacy), validator, nil
+	}
+	algorithm, digest := validator[:i], validator[i+1:]
+	newHash, ok := validatorHashes[algorithm]
+	if !ok {
+		return "", "", fmt.Errorf("Unsupported validator algorithm: %s", algorithm)
+	}
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != newHash().Size()*2 {
+		return "", "", fmt.Errorf("Invalid %s digest: '%s'", algorithm, digest)
+	}
+	return algorithm, digest, nil
+}
+
+// getValidatorAlgorithm will return the algorithm implied by the length of
+// a bare digest, falling back to the default for the source type.
+func getValidatorAlgorithm(digest string, legacy bool) string {
+	switch len(digest) {
+	case sha512.Size * 2:
+		return ValidatorSHA512
+	case sha512.Size384 * 2:
+		return ValidatorSHA384
+	case sha256.Size * 2:
+		return ValidatorSHA256
+	}
+	if legacy {
+		return ValidatorSHA1
+	}
+	return ValidatorSHA256
+}
+
+// hashBufferSize is the most of a file held in memory while hashing it
+const hashBufferSize = 128 * 1024
+
+// GetDigests will return the hex digest of the file at path for each of
+// the algorithms, reading the file only once.
+func GetDigests(path string, algorithms ...string) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if _, ok := hashes[algorithm]; ok {
+			continue
+		}
+		newHash, ok := validatorHashes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported validator algorithm: %s", algorithm)
+		}
+		hashes[algorithm] = newHash()
+		writers = append(writers, hashes[algorithm])
+	}
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), fi, make([]byte, hashBufferSize)); err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, nil
+}
+
+// getDigest will return the hex digest of the file at path
+func getDigest(path, algorithm string) (string, error) {
+	digests, err := GetDigests(path, algorithm)
+	if err != nil {
+		return "", err
+	}
+	return digests[algorithm], nil
+}
+
+// formatValidators will return the validators of the source in the
+// "algo:hex" form, so that they may be passed to New unchanged.
+func (s *SimpleSource) formatValidators() string {
+	var ret []string
+	for _, v := range s.validators {
+		ret = append(ret, s.algorithms[v]+":"+v)
+	}
+	return strings.Join(ret, ValidatorSeparator)
+}
+
+// hasValidator will determine if the source has a validator for algorithm
+func (s *SimpleSource) hasValidator(algorithm string) bool {
+	for _, v := range s.validators {
+		if s.algorithms[v] == algorithm {
+			return true
+		}
+	}
+	return false
+}
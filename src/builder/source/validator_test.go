@@ -0,0 +1,169 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	nanoSHA384 = "e2b9b3a6cba4b35343824f47ed9e059b35dbbd5c1c933a69b9eeeabd4fd4857706d220cfed8107845711254a05c4d7d5"
+	nanoSHA512 = "9e2fc8de0ec3efcdbeb1b43ea4185ddf018cda71aff0e6af42c84a94cebdfea45f82071dd72e12c0c4954529002ccdaa4bb5387262dbb84f16f05d4498870551"
+)
+
+func TestParseValidator(t *testing.T) {
+	tests := []struct {
+		validator string
+		legacy    bool
+		algorithm string
+		digest    string
+	}{
+		{nanoSHA256, false, ValidatorSHA256, nanoSHA256},
+		{"SHA256:" + strings.ToUpper(nanoSHA256), false, ValidatorSHA256, nanoSHA256},
+		{nanoSHA384, false, ValidatorSHA384, nanoSHA384},
+		{"sha512:" + nanoSHA512, false, ValidatorSHA512, nanoSHA512},
+		{nanoSHA512, true, ValidatorSHA512, nanoSHA512},
+		{"e6efbd8aed7a6a63e6ec49365245a32bdc913b43", true, ValidatorSHA1, "e6efbd8aed7a6a63e6ec49365245a32bdc913b43"},
+		{"e6efbd8aed7a6a63e6ec49365245a32bdc913b43", false, ValidatorSHA256, "e6efbd8aed7a6a63e6ec49365245a32bdc913b43"},
+	}
+	for _, test := range tests {
+		algorithm, digest, err := ParseValidator(test.validator, test.legacy)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.validator, err)
+		}
+		if algorithm != test.algorithm || digest != test.digest {
+			t.Fatalf("Wrong validator for %s: %s:%s", test.validator, algorithm, digest)
+		}
+	}
+
+	for _, bad := range []string{"md5:" + nanoSHA256, "sha512:" + nanoSHA256, "sha256:nano"} {
+		if _, _, err := ParseValidator(bad, false); err == nil {
+			t.Fatalf("Invalid validator should be rejected: %s", bad)
+		}
+		if _, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", bad, false); err == nil {
+			t.Fatalf("Source with an invalid validator should be rejected: %s", bad)
+		}
+	}
+}
+
+func TestFetchStrongValidators(t *testing.T) {
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer server.listener.Close()
+
+	for _, validator := range []string{"sha512:" + nanoSHA512, nanoSHA384} {
+		SetSourceDir(filepath.Join(tmp, "sources", validator[:6]))
+		if err := EnsureSourceDir(); err != nil {
+			t.Fatalf("Failed to create source directory: %v", err)
+		}
+		src, err := NewSimple(server.URL(), validator, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		if err := src.Validate(); err != nil {
+			t.Fatalf("Source should be valid: %v", err)
+		}
+		if err := src.Fetch(); err != nil {
+			t.Fatalf("Failed to fetch source with %s: %v", validator, err)
+		}
+
+		// The cache layout is unchanged, keyed by sha256sum
+		if !PathExists(filepath.Join(SourceDir, nanoSHA256, src.File)) {
+			t.Fatalf("Source was not cached by its sha256sum")
+		}
+		cached, err := NewSimple(server.URL(), validator, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		if !cached.IsFetched() {
+			t.Fatalf("Source should be found by %s", validator)
+		}
+		algorithm, digest := cached.GetValidator()
+		if !strings.HasSuffix(validator, digest) || !strings.HasPrefix(algorithm, "sha") || algorithm == ValidatorSHA256 {
+			t.Fatalf("Wrong validator: %s:%s", algorithm, digest)
+		}
+	}
+
+	// Mismatched strong digests are still rejected
+	SetSourceDir(filepath.Join(tmp, "sources", "bad"))
+	if err := EnsureSourceDir(); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	bad, err := NewSimple(server.URL(), "sha512:"+strings.Repeat("0", 128), false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := bad.Fetch(); err == nil || !strings.Contains(err.Error(), nanoSHA512) {
+		t.Fatalf("Expected a sha512 mismatch, got: %v", err)
+	}
+}
+
+func TestValidateLegacyDigest(t *testing.T) {
+	src, err := NewSimple("https://www.nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "sha1:e6efbd8aed7a6a63e6ec49365245a32bdc913b43", false)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	if err := src.Validate(); err == nil {
+		t.Fatalf("sha1sums should only be accepted for legacy sources")
+	}
+}
+
+func TestGetDigests(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-validator")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "nano-2.7.5.tar.xz")
+	if err := ioutil.WriteFile(path, []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	digests, err := GetDigests(path, ValidatorSHA256, ValidatorSHA384, ValidatorSHA512, ValidatorSHA256)
+	if err != nil {
+		t.Fatalf("Failed to hash file: %v", err)
+	}
+	if len(digests) != 3 || digests[ValidatorSHA256] != nanoSHA256 || digests[ValidatorSHA384] != nanoSHA384 || digests[ValidatorSHA512] != nanoSHA512 {
+		t.Fatalf("Wrong digests: %v", digests)
+	}
+	if _, err := GetDigests(path, "md5"); err == nil {
+		t.Fatalf("Unsupported algorithm should be rejected")
+	}
+
+	// Files larger than the buffer are hashed in full
+	big := strings.Repeat("nano", hashBufferSize)
+	if err := ioutil.WriteFile(path, []byte(big), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	src := &SimpleSource{}
+	sum, err := src.GetSHA256Sum(path)
+	if err != nil {
+		t.Fatalf("Failed to hash file: %v", err)
+	}
+	expected := sha256.Sum256([]byte(big))
+	if sum != hex.EncodeToString(expected[:]) {
+		t.Fatalf("Wrong digest for large file: %s", sum)
+	}
+}
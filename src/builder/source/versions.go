@@ -0,0 +1,166 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// MaxCachedVersions is the number of cached files sharing the same name
+// to keep, evicting the least recently used beyond it. 0 keeps them all.
+var MaxCachedVersions = 0
+
+// A cachedVersion is one cached file sharing the name of a source
+type cachedVersion struct {
+	hash     string
+	path     string
+	lastUsed time.Time
+}
+
+// getLastUsed will return when the cached file was last used. Cache hits
+// update the access time, but not the modification time, so whichever is
+// newer is used.
+func getLastUsed(fi os.FileInfo) time.Time {
+	used := fi.ModTime()
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if atime := time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)); atime.After(used) {
+			used = atime
+		}
+	}
+	return used
+}
+
+// markUsed will record a cache hit on the file, for eviction and pruning
+func markUsed(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	os.Chtimes(path, time.Now(), fi.ModTime())
+}
+
+// getCachedVersions will find each real hash directory holding a file
+// with the given name, least recently used first.
+func getCachedVersions(sourceDir, file string) ([]cachedVersion, error) {
+	candidates, err := filepath.Glob(filepath.Join(sourceDir, "*", file))
+	if err != nil {
+		return nil, err
+	}
+	var versions []cachedVersion
+	for _, path := range candidates {
+		hashDir := filepath.Dir(path)
+		// Legacy links are removed along with their target
+		if isSymlink(hashDir) {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		versions = append(versions, cachedVersion{
+			hash:     filepath.Base(hashDir),
+			path:     path,
+			lastUsed: getLastUsed(fi),
+		})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].lastUsed.Before(versions[j].lastUsed)
+	})
+	return versions, nil
+}
+
+// removeHashLinks will remove all symlinks within the cache pointing at
+// the hash directory, i.e. legacy sha1sum links.
+func removeHashLinks(sourceDir, hash string) error {
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink != os.ModeSymlink {
+			continue
+		}
+		link := filepath.Join(sourceDir, entry.Name())
+		if target, err := os.Readlink(link); err == nil && filepath.Base(target) == hash {
+			if err := os.Remove(link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evictVersions will remove the least recently used files with the given
+// name beyond keep, never removing the hash that was just fetched. Hash
+// directories left empty are removed along with any links to them.
+func evictVersions(sourceDir, file, current string, keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, nil
+	}
+	versions, err := getCachedVersions(sourceDir, file)
+	if err != nil {
+		return nil, err
+	}
+	var evicted []string
+	for i := 0; len(versions)-len(evicted) > keep && i < len(versions); i++ {
+		v := versions[i]
+		if v.hash == current {
+			continue
+		}
+		if err := os.Remove(v.path); err != nil {
+			return evicted, err
+		}
+		os.Remove(getProvenancePath(v.path))
+		evicted = append(evicted, v.hash)
+		hashDir := filepath.Dir(v.path)
+		if remaining, err := ioutil.ReadDir(hashDir); err != nil || len(remaining) > 0 {
+			continue
+		}
+		if err := os.Remove(hashDir); err != nil {
+			return evicted, err
+		}
+		if err := removeHashLinks(sourceDir, v.hash); err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
+// evictOldVersions will enforce MaxCachedVersions for the source, after
+// it has been cached under the given hash. Failures are only logged as
+// the fetch itself succeeded.
+func (s *SimpleSource) evictOldVersions(hash string) {
+	evicted, err := evictVersions(SourceDir, s.File, hash, MaxCachedVersions)
+	for _, old := range evicted {
+		log.WithFields(log.Fields{
+			"source": s.File,
+			"hash":   old,
+		}).Info("Evicted old cached version of source")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"source": s.File,
+			"error":  err,
+		}).Warning("Failed to evict old cached versions")
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaxCachedVersions(t *testing.T) {
+	defer func() {
+		MaxCachedVersions = 0
+	}()
+
+	_, restore := withTempSourceDir(t)
+	defer restore()
+	MaxCachedVersions = 2
+
+	versions := []struct {
+		contents string
+		hash     string
+	}{
+		{"nano", nanoSHA256},
+		{"NANO", "3929a2d0db4d8e6375b657dcf56d69a6c6f7476e5e8b7f6453a598b02fe40d1d"},
+		{"nano2", "51303d8385c59a09090c30a88144f572642ae6bbdcb23adcab3a0c77c7f55a81"},
+	}
+	for i, v := range versions {
+		server := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte(v.contents), true)
+		src, err := NewSimple(server.URL(), v.hash, false)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		if err := src.Fetch(); err != nil {
+			t.Fatalf("Failed to fetch version %d: %v", i, err)
+		}
+		server.listener.Close()
+		// Ensure the versions were last used in order
+		used := time.Now().Add(time.Duration(i-len(versions)) * time.Hour)
+		if err := os.Chtimes(src.GetPath(v.hash), used, used); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+	}
+
+	if PathExists(filepath.Join(SourceDir, versions[0].hash)) {
+		t.Fatalf("Oldest version should have been evicted")
+	}
+	for _, v := range versions[1:] {
+		if !PathExists(filepath.Join(SourceDir, v.hash, "nano-2.7.5.tar.xz")) {
+			t.Fatalf("Version %s should be kept", v.contents)
+		}
+	}
+}
+
+func TestEvictVersions(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-versions")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	now := time.Now()
+	hashes := []string{"aaaa", "bbbb", "cccc", "dddd"}
+	for i, hash := range hashes {
+		writeCacheFile(t, filepath.Join(tmp, hash), "nano.tar.xz", hash)
+		used := now.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(filepath.Join(tmp, hash, "nano.tar.xz"), used, used)
+	}
+	// Shared content under another name must survive eviction
+	writeCacheFile(t, filepath.Join(tmp, "bbbb"), "nano-copy.tar.xz", "bbbb")
+	writeCacheFile(t, filepath.Join(tmp, "eeee"), "vim.tar.xz", "vim")
+	for link, hash := range map[string]string{"1111": "aaaa", "2222": "bbbb", "3333": "cccc"} {
+		if err := os.Symlink(hash, filepath.Join(tmp, link)); err != nil {
+			t.Fatalf("Failed to create legacy link: %v", err)
+		}
+	}
+
+	// The oldest version is the current one, so must be kept
+	evicted, err := evictVersions(tmp, "nano.tar.xz", "aaaa", 2)
+	if err != nil {
+		t.Fatalf("Failed to evict versions: %v", err)
+	}
+	if len(evicted) != 2 || evicted[0] != "bbbb" || evicted[1] != "cccc" {
+		t.Fatalf("Wrong versions evicted: %v", evicted)
+	}
+	for _, path := range []string{"aaaa/nano.tar.xz", "dddd/nano.tar.xz", "bbbb/nano-copy.tar.xz", "eeee/vim.tar.xz", "1111", "2222"} {
+		if !PathExists(filepath.Join(tmp, path)) {
+			t.Fatalf("%s should not have been evicted", path)
+		}
+	}
+	for _, path := range []string{"bbbb/nano.tar.xz", "cccc", "3333"} {
+		if _, err := os.Lstat(filepath.Join(tmp, path)); !os.IsNotExist(err) {
+			t.Fatalf("%s should have been evicted", path)
+		}
+	}
+
+	// Unlimited keeps everything
+	if evicted, err := evictVersions(tmp, "nano.tar.xz", "", 0); err != nil || len(evicted) != 0 {
+		t.Fatalf("Should not evict without a limit: %v %v", evicted, err)
+	}
+}
@@ -0,0 +1,149 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A ManifestEntry is a single source to warm the cache with
+type ManifestEntry struct {
+	URL       string
+	Validator string
+	Legacy    bool // Validator is a sha1sum, as in pspec.xml
+}
+
+// A WarmResult records how the cache was warmed from a manifest
+type WarmResult struct {
+	Fetched int // Sources downloaded into the cache
+	Present int // Sources that were already cached
+	Failed  int // Sources that could not be fetched
+}
+
+// ReadManifest will parse a cache warming manifest. Each line holds the
+// URL of a source followed by its validator, and optionally the word
+// "legacy" for sha1sum validators. Blank lines and those beginning with
+// a '#' are ignored.
+func ReadManifest(manifestPath string) ([]ManifestEntry, error) {
+	fi, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(fi)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2:
+			entries = append(entries, ManifestEntry{URL: fields[0], Validator: fields[1]})
+		case len(fields) == 3 && fields[2] == "legacy":
+			entries = append(entries, ManifestEntry{URL: fields[0], Validator: fields[1], Legacy: true})
+		default:
+			return nil, fmt.Errorf("Invalid manifest entry on line %d of %s", lineno, manifestPath)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WarmCache will fetch every source listed in the manifest that isn't
+// already cached, without needing the recipes that use them.
+func WarmCache(manifestPath string) error {
+	entries, err := ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	result, err := warmEntries(entries)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"fetched": result.Fetched,
+		"present": result.Present,
+		"failed":  result.Failed,
+	}).Info("Warmed source cache")
+	if result.Failed > 0 {
+		return fmt.Errorf("Failed to fetch %d of %d sources", result.Failed, len(entries))
+	}
+	return nil
+}
+
+// warmEntries will fetch the missing sources concurrently, with no more
+// than the HostConcurrency of the download policy fetched from a single
+// host at once.
+func warmEntries(entries []ManifestEntry) (WarmResult, error) {
+	var result WarmResult
+	var sources []*SimpleSource
+	for _, entry := range entries {
+		src, err := NewSimple(entry.URL, entry.Validator, entry.Legacy)
+		if err != nil {
+			return result, err
+		}
+		if src.IsFetched() {
+			result.Present++
+			continue
+		}
+		sources = append(sources, src)
+	}
+
+	hosts := make(map[string]chan bool)
+	limit := GetNetworkPolicy(NetworkDownload).Concurrency()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		host := src.GetHost()
+		if _, ok := hosts[host]; !ok {
+			hosts[host] = make(chan bool, limit)
+		}
+
+		wg.Add(1)
+		go func(src *SimpleSource, sem chan bool) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+
+			err := src.Fetch()
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"uri":   src.URI,
+					"error": err,
+				}).Error("Failed to fetch source")
+				result.Failed++
+				return
+			}
+			result.Fetched++
+		}(src, hosts[host])
+	}
+	wg.Wait()
+	return result, nil
+}
@@ -0,0 +1,138 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withTempSourceDir will point the source cache at a new temporary directory,
+// returning that directory and a function to restore the previous cache.
+func withTempSourceDir(t *testing.T) (string, func()) {
+	oldSourceDir := SourceDir
+	tmp, err := ioutil.TempDir("", "solbuild-sources")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	SetSourceDir(filepath.Join(tmp, "sources"))
+	if err := EnsureSourceDir(); err != nil {
+		SetSourceDir(oldSourceDir)
+		os.RemoveAll(tmp)
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	return tmp, func() {
+		SetSourceDir(oldSourceDir)
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-warm")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest := filepath.Join(tmp, "sources.txt")
+	contents := `# Sources for the CI cache
+https://example.com/nano-2.7.5.tar.xz f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762
+
+https://example.com/nano-2.7.4.tar.xz e6efbd8aed7a6a63e6ec49365245a32bdc913b43 legacy
+`
+	if err := ioutil.WriteFile(manifest, []byte(contents), 00644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	entries, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Legacy || !entries[1].Legacy {
+		t.Fatalf("Legacy flag not parsed: %v", entries)
+	}
+	if entries[1].Validator != "e6efbd8aed7a6a63e6ec49365245a32bdc913b43" {
+		t.Fatalf("Wrong validator: %s", entries[1].Validator)
+	}
+
+	for _, invalid := range []string{"https://example.com/nano.tar.xz", "https://example.com/nano.tar.xz abc sha1"} {
+		if err := ioutil.WriteFile(manifest, []byte(invalid), 00644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		if _, err := ReadManifest(manifest); err == nil {
+			t.Fatalf("Invalid manifest should be rejected: %s", invalid)
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	tmp, restore := withTempSourceDir(t)
+	defer restore()
+
+	cached := newMockFTPServer(t, "nano-2.7.4.tar.xz", []byte("nano"), true)
+	defer cached.listener.Close()
+	missing := newMockFTPServer(t, "nano-2.7.5.tar.xz", []byte("nano"), true)
+	defer missing.listener.Close()
+
+	// Pre-populate the cache with the first source
+	hash := "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+	if err := os.MkdirAll(filepath.Join(SourceDir, hash), 00755); err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(SourceDir, hash, "nano-2.7.4.tar.xz"), []byte("nano"), 00644); err != nil {
+		t.Fatalf("Failed to write cached source: %v", err)
+	}
+
+	manifest := filepath.Join(tmp, "sources.txt")
+	contents := fmt.Sprintf("%s %s\n%s %s\n", cached.URL(), hash, missing.URL(), hash)
+	if err := ioutil.WriteFile(manifest, []byte(contents), 00644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	entries, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	result, err := warmEntries(entries)
+	if err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if result.Fetched != 1 || result.Present != 1 || result.Failed != 0 {
+		t.Fatalf("Expected 1 fetched and 1 present, got %+v", result)
+	}
+	if len(cached.modes) != 0 {
+		t.Fatalf("Cached source should not be downloaded")
+	}
+	if len(missing.modes) != 1 {
+		t.Fatalf("Missing source was not downloaded")
+	}
+	if !PathExists(filepath.Join(SourceDir, hash, "nano-2.7.5.tar.xz")) {
+		t.Fatalf("Missing source was not cached")
+	}
+
+	// Everything is now cached
+	if err := WarmCache(manifest); err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if len(missing.modes) != 1 {
+		t.Fatalf("Cached source should not be downloaded again")
+	}
+}
@@ -0,0 +1,104 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"reflect"
+)
+
+// SourceRecordSuffix is appended to the overlay base directory to form the
+// path recording the sources of the last successful build. This lives
+// outside of the base directory as that is removed before each build.
+const SourceRecordSuffix = ".sources"
+
+// A recordedSource is a single source of a successful build
+type recordedSource struct {
+	Identifier string `json:"identifier"`
+	Validator  string `json:"validator"`
+	CachePath  string `json:"cache_path"`
+}
+
+// getSourceRecordPath will return where the source record is kept
+func (o *Overlay) getSourceRecordPath() string {
+	return o.BaseDir + SourceRecordSuffix
+}
+
+// getRecordedSources will describe the current sources, without checking
+// whether they have been fetched.
+func (p *Package) getRecordedSources() []recordedSource {
+	var sources []recordedSource
+	for _, src := range p.Sources {
+		record := recordedSource{
+			Identifier: src.GetIdentifier(),
+			CachePath:  src.GetBindConfiguration("").BindSource,
+		}
+		if v, ok := src.(source.ValidatedSource); ok {
+			_, record.Validator = v.GetValidator()
+		}
+		sources = append(sources, record)
+	}
+	return sources
+}
+
+// SourcesUnchanged will determine if the sources are identical to those of
+// the last successful build, and are all still cached. When they are, the
+// sources need not be verified again.
+func (p *Package) SourcesUnchanged(o *Overlay) bool {
+	b, err := ioutil.ReadFile(o.getSourceRecordPath())
+	if err != nil {
+		return false
+	}
+	var recorded []recordedSource
+	if err := json.Unmarshal(b, &recorded); err != nil {
+		return false
+	}
+	current := p.getRecordedSources()
+	if !reflect.DeepEqual(recorded, current) {
+		return false
+	}
+	for _, src := range current {
+		if _, err := os.Stat(src.CachePath); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordSources will store the sources of a successful build, for
+// comparison with the next build.
+func (p *Package) RecordSources(o *Overlay) error {
+	b, err := json.Marshal(p.getRecordedSources())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(o.getSourceRecordPath(), b, 00644)
+}
+
+// PrepareSources will fetch and verify the sources, unless they're known to
+// be unchanged since the last successful build.
+func (p *Package) PrepareSources(o *Overlay) error {
+	if p.SourcesUnchanged(o) {
+		log.Info("Sources unchanged since the last build, skipping verification")
+		return nil
+	}
+	return p.FetchSources(o)
+}
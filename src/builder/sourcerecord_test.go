@@ -0,0 +1,91 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkedSource counts how often the source is verified
+type checkedSource struct {
+	fetchableSource
+	checks int
+}
+
+func (c *checkedSource) IsFetched() bool {
+	c.checks++
+	return c.fetchableSource.IsFetched()
+}
+
+func TestSourcesUnchanged(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-sourcerecord")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := &checkedSource{fetchableSource: fetchableSource{path: filepath.Join(tmp, "nano.tar.xz")}}
+	pkg := &Package{Name: "nano", Sources: []source.Source{src}}
+	overlay := &Overlay{Back: &BackingImage{Name: "main-x86_64"}, BaseDir: filepath.Join(tmp, "nano")}
+
+	// No prior record, so the sources are fetched
+	if err := pkg.PrepareSources(overlay); err != nil {
+		t.Fatalf("Failed to prepare sources: %v", err)
+	}
+	if src.checks != 1 || !src.fetched {
+		t.Fatalf("Sources should be fetched without a record")
+	}
+	if err := pkg.RecordSources(overlay); err != nil {
+		t.Fatalf("Failed to record sources: %v", err)
+	}
+
+	// Unchanged sources are not verified again
+	if err := pkg.PrepareSources(overlay); err != nil {
+		t.Fatalf("Failed to prepare sources: %v", err)
+	}
+	if src.checks != 1 {
+		t.Fatalf("Unchanged sources should not be verified again")
+	}
+
+	// A new source requires the full setup
+	extra := &checkedSource{fetchableSource: fetchableSource{path: filepath.Join(tmp, "patches.tar.xz")}}
+	pkg.Sources = append(pkg.Sources, extra)
+	if pkg.SourcesUnchanged(overlay) {
+		t.Fatalf("Added source should be detected")
+	}
+	if err := pkg.PrepareSources(overlay); err != nil {
+		t.Fatalf("Failed to prepare sources: %v", err)
+	}
+	if src.checks != 2 || extra.checks != 1 {
+		t.Fatalf("Changed sources should be verified")
+	}
+	if err := pkg.RecordSources(overlay); err != nil {
+		t.Fatalf("Failed to record sources: %v", err)
+	}
+
+	// Missing cache entries also require the full setup
+	if err := os.Remove(extra.path); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if pkg.SourcesUnchanged(overlay) {
+		t.Fatalf("Uncached source should be detected")
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ChrootTempDir is where the configured temporary directory is made
+	// available within the chroot.
+	ChrootTempDir = "/tmp"
+)
+
+// TempDir is a host directory used for intermediate files, instead of the
+// default temporary locations. This is used for staging downloads, and is
+// bind mounted into the chroot as /tmp. When empty, defaults are used.
+var TempDir string
+
+// ValidateTempDir will ensure the temporary directory exists, is a directory
+// and is writable by us, creating it if needed.
+func ValidateTempDir(dir string) error {
+	if !PathExists(dir) {
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			return err
+		}
+	}
+	st, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("Temporary directory is not a directory: %v", dir)
+	}
+	fi, err := ioutil.TempFile(dir, ".solbuild-check")
+	if err != nil {
+		return fmt.Errorf("Temporary directory is not writable: %v", err)
+	}
+	fi.Close()
+	return os.Remove(fi.Name())
+}
+
+// SetTempDir will validate and set the temporary directory used by the
+// builder and source fetching.
+func SetTempDir(dir string) error {
+	dir = strings.TrimSpace(dir)
+	if dir != "" {
+		if err := ValidateTempDir(dir); err != nil {
+			return err
+		}
+	}
+	TempDir = dir
+	source.TempDir = dir
+	return nil
+}
+
+// getTempBindConfiguration will return the bind mount for the temporary
+// directory, unique to this overlay so builds don't collide.
+func (p *Package) getTempBindConfiguration(o *Overlay) source.BindConfiguration {
+	subdir := strings.TrimPrefix(o.BaseDir, OverlayRootDir)
+	return source.BindConfiguration{
+		BindSource: filepath.Join(TempDir, "solbuild", subdir),
+		BindTarget: filepath.Join(o.MountPoint, ChrootTempDir[1:]),
+	}
+}
+
+// BindTempDir will make the configured temporary directory available as
+// /tmp within the chroot, if one is set.
+func (p *Package) BindTempDir(o *Overlay) error {
+	if TempDir == "" {
+		return nil
+	}
+	bindConfig := p.getTempBindConfiguration(o)
+
+	for _, dir := range []string{bindConfig.BindSource, bindConfig.BindTarget} {
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			return err
+		}
+	}
+	// Same permissions as a normal /tmp
+	if err := os.Chmod(bindConfig.BindSource, 01777); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dir": bindConfig.BindSource,
+	}).Debug("Exposing temporary directory to build")
+
+	if err := disk.GetMountManager().BindMount(bindConfig.BindSource, bindConfig.BindTarget); err != nil {
+		log.WithFields(log.Fields{
+			"target": bindConfig.BindTarget,
+			"error":  err,
+		}).Error("Failed to bind mount temporary directory")
+		return err
+	}
+	o.ExtraMounts = append(o.ExtraMounts, bindConfig.BindTarget)
+	return nil
+}
@@ -0,0 +1,78 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTempDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-tempdir")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer SetTempDir("")
+
+	if err := SetTempDir(filepath.Join(tmp, "missing", "dir")); err != nil {
+		t.Fatalf("Temporary directory should be created: %v", err)
+	}
+	notDir := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(notDir, nil, 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := SetTempDir(notDir); err == nil {
+		t.Fatal("Should not accept a file as the temporary directory")
+	}
+
+	if err := SetTempDir(tmp); err != nil {
+		t.Fatalf("Failed to set temporary directory: %v", err)
+	}
+	if !strings.HasPrefix(source.GetStagingDir(), tmp) {
+		t.Fatalf("Downloads should be staged in the temporary directory: %v", source.GetStagingDir())
+	}
+
+	pkg := &Package{Name: "nano", Type: PackageTypeYpkg}
+	profile := &Profile{Name: "unstable-x86_64", Image: "unstable-x86_64"}
+	overlay := NewOverlay(profile, NewBackingImage(profile.Image), pkg)
+
+	found := false
+	for _, bind := range pkg.GetBindConfigurations(overlay, nil) {
+		if bind.BindTarget != filepath.Join(overlay.MountPoint, "tmp") {
+			continue
+		}
+		found = true
+		if !strings.HasPrefix(bind.BindSource, tmp) {
+			t.Fatalf("Chroot /tmp should use the temporary directory: %v", bind.BindSource)
+		}
+	}
+	if !found {
+		t.Fatal("Temporary directory missing from bind configuration")
+	}
+
+	if err := SetTempDir(""); err != nil {
+		t.Fatalf("Failed to reset temporary directory: %v", err)
+	}
+	if source.GetStagingDir() != source.SourceStagingDir {
+		t.Fatalf("Default staging directory not restored: %v", source.GetStagingDir())
+	}
+}
@@ -0,0 +1,102 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// UpperLayerLargest is the number of largest files reported from the
+// upper layer after a build.
+var UpperLayerLargest = 10
+
+// An UpperFile is a single file written into the overlay upper layer
+type UpperFile struct {
+	Path string // Path within the build root
+	Size int64  // Size in bytes
+}
+
+// An UpperLayerReport describes the data a build wrote into the overlay
+// upper layer, to help find accidental large writes such as core dumps.
+type UpperLayerReport struct {
+	TotalBytes int64       // Bytes of all regular files in the upper layer
+	Files      int         // Number of regular files in the upper layer
+	Largest    []UpperFile // The largest files, largest first
+}
+
+// getUpperLayerReport will walk the upper directory to find its size,
+// along with the given number of largest files.
+func getUpperLayerReport(upperDir string, largest int) (*UpperLayerReport, error) {
+	report := &UpperLayerReport{}
+	var files []UpperFile
+
+	err := filepath.Walk(upperDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Whiteouts and other special files use no space of note
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(upperDir, path)
+		if err != nil {
+			return err
+		}
+		report.Files++
+		report.TotalBytes += fi.Size()
+		files = append(files, UpperFile{Path: "/" + rel, Size: fi.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	if len(files) > largest {
+		files = files[:largest]
+	}
+	report.Largest = files
+	return report, nil
+}
+
+// ReportUpperLayer will record the size of the overlay upper layer as left
+// by the build within UpperLayer, logging the largest files within it.
+func (p *Package) ReportUpperLayer(o *Overlay) error {
+	report, err := getUpperLayerReport(o.UpperDir, UpperLayerLargest)
+	if err != nil {
+		return err
+	}
+	p.UpperLayer = report
+	ActiveMetrics.Observe(MetricUpperLayerBytes, getMetricLabels(p, o), float64(report.TotalBytes))
+
+	log.WithFields(log.Fields{
+		"bytes": report.TotalBytes,
+		"files": report.Files,
+	}).Info("Build wrote to the overlay upper layer")
+	for _, file := range report.Largest {
+		log.WithFields(log.Fields{
+			"path": file.Path,
+			"size": file.Size,
+		}).Debug("Large file in the overlay upper layer")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportUpperLayer(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-upper")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	files := map[string]int{
+		"home/build/core":          4096,
+		"home/build/.cache/ccache": 1024,
+		"etc/hostname":             8,
+	}
+	for path, size := range files {
+		path = filepath.Join(tmp, path)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, make([]byte, size), 00644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	// Symlinks use no space of note
+	if err := os.Symlink("core", filepath.Join(tmp, "home/build/core.link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	oldLargest := UpperLayerLargest
+	UpperLayerLargest = 2
+	defer func() { UpperLayerLargest = oldLargest }()
+
+	registry := NewMetricsRegistry()
+	SetMetrics(registry)
+	defer SetMetrics(nil)
+
+	p := &Package{Name: "nano"}
+	if err := p.ReportUpperLayer(&Overlay{UpperDir: tmp}); err != nil {
+		t.Fatalf("Failed to report upper layer: %v", err)
+	}
+	report := p.UpperLayer
+	if report.TotalBytes != 4096+1024+8 || report.Files != 3 {
+		t.Fatalf("Wrong upper layer size: %+v", report)
+	}
+	if len(report.Largest) != 2 || report.Largest[0].Path != "/home/build/core" || report.Largest[1].Size != 1024 {
+		t.Fatalf("Wrong largest files: %v", report.Largest)
+	}
+	if count, sum := registry.GetObservations(MetricUpperLayerBytes, map[string]string{"package": "nano"}); count != 1 || sum != 4096+1024+8 {
+		t.Fatalf("Upper layer size not observed: %d %v", count, sum)
+	}
+}
@@ -0,0 +1,315 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"bufio"
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// UserNamespaceEnv is set in the environment of a solbuild process that
+	// has been re-executed within a user namespace.
+	UserNamespaceEnv = "SOLBUILD_USERNS"
+
+	// userNamespaceSyncFD is inherited by a re-executed process, which must
+	// read from it before relying on being root, as the parent only writes
+	// to it once the ID mappings of the namespace are in place.
+	userNamespaceSyncFD = 3
+
+	// minSubordinateIDs is the fewest subordinate IDs the user must have
+	// delegated, so that the build user (1000) and the system accounts of
+	// the image exist within the namespace.
+	minSubordinateIDs = 65536
+
+	// accessWrite is W_OK for access(2)
+	accessWrite = 0x2
+)
+
+var (
+	// ErrNoUserNamespaces is returned when the kernel will not permit us
+	// to create a user namespace as an unprivileged user.
+	ErrNoUserNamespaces = errors.New("Unprivileged user namespaces are not available")
+
+	// userNamespaceKnobs are the sysctls which may disable unprivileged
+	// user namespaces, depending on the distribution.
+	userNamespaceKnobs = []string{
+		"/proc/sys/kernel/unprivileged_userns_clone",
+		"/proc/sys/user/max_user_namespaces",
+	}
+
+	// SubordinateUIDFile and SubordinateGIDFile list the ID ranges delegated
+	// to each user, see subuid(5) and subgid(5).
+	SubordinateUIDFile = "/etc/subuid"
+	SubordinateGIDFile = "/etc/subgid"
+)
+
+// UserNamespacesSupported will determine whether the kernel allows the
+// creation of user namespaces by unprivileged users.
+func UserNamespacesSupported() bool {
+	if !PathExists("/proc/self/ns/user") {
+		return false
+	}
+	for _, knob := range userNamespaceKnobs {
+		contents, err := ioutil.ReadFile(knob)
+		if err != nil {
+			continue
+		}
+		if val, err := strconv.Atoi(strings.TrimSpace(string(contents))); err == nil && val < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// InUserNamespace will determine whether we've been re-executed within
+// a user namespace.
+func InUserNamespace() bool {
+	return os.Getenv(UserNamespaceEnv) == "1"
+}
+
+// A subordinateRange is a range of host IDs delegated to a user
+type subordinateRange struct {
+	start int
+	count int
+}
+
+// parseSubordinateRange will find the first range in a subuid(5) or
+// subgid(5) listing that belongs to the named owner, or their ID, and is
+// large enough to host a build.
+func parseSubordinateRange(r io.Reader, name string, id int) (subordinateRange, bool) {
+	owner := strconv.Itoa(id)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != owner) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count < minSubordinateIDs {
+			continue
+		}
+		return subordinateRange{start: start, count: count}, true
+	}
+	return subordinateRange{}, false
+}
+
+// getSubordinateRange will return the subordinate IDs delegated to the
+// owner in the given file.
+func getSubordinateRange(path, name string, id int) (subordinateRange, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return subordinateRange{}, err
+	}
+	defer fi.Close()
+	sub, ok := parseSubordinateRange(fi, name, id)
+	if !ok {
+		return subordinateRange{}, fmt.Errorf("%s must delegate at least %d IDs to %s", path, minSubordinateIDs, name)
+	}
+	return sub, nil
+}
+
+// getIDMapArgs will return the newuidmap(1) or newgidmap(1) arguments to
+// map the ID to root within the namespace of pid, and the subordinate IDs
+// to every ID above it.
+func getIDMapArgs(pid, id int, sub subordinateRange) []string {
+	return []string{
+		strconv.Itoa(pid),
+		"0", strconv.Itoa(id), "1",
+		"1", strconv.Itoa(sub.start), strconv.Itoa(sub.count),
+	}
+}
+
+// A userNamespaceMapping describes the IDs of a new user namespace. The
+// invoking user becomes root, so that files created by "root" in the build
+// are owned by the user on the host, while the other users of the build
+// are backed by the subordinate IDs of the user.
+type userNamespaceMapping struct {
+	uid    int
+	gid    int
+	subUID subordinateRange
+	subGID subordinateRange
+}
+
+// getUserNamespaceMapping will determine the mapping for the invoking user,
+// returning an error if the host is not set up to provide one.
+func getUserNamespaceMapping() (*userNamespaceMapping, error) {
+	for _, tool := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s is required to map subordinate IDs", tool)
+		}
+	}
+	m := &userNamespaceMapping{
+		uid: os.Getuid(),
+		gid: os.Getgid(),
+	}
+	usr, err := user.LookupId(strconv.Itoa(m.uid))
+	if err != nil {
+		return nil, err
+	}
+	grp, err := user.LookupGroupId(strconv.Itoa(m.gid))
+	if err != nil {
+		return nil, err
+	}
+	if m.subUID, err = getSubordinateRange(SubordinateUIDFile, usr.Username, m.uid); err != nil {
+		return nil, err
+	}
+	if m.subGID, err = getSubordinateRange(SubordinateGIDFile, grp.Name, m.gid); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Apply will write the ID mappings of the user namespace of pid
+func (m *userNamespaceMapping) Apply(pid int) error {
+	if err := commands.ExecStdoutArgs("newuidmap", getIDMapArgs(pid, m.uid, m.subUID)); err != nil {
+		return fmt.Errorf("Failed to map user IDs: %v", err)
+	}
+	if err := commands.ExecStdoutArgs("newgidmap", getIDMapArgs(pid, m.gid, m.subGID)); err != nil {
+		return fmt.Errorf("Failed to map group IDs: %v", err)
+	}
+	return nil
+}
+
+// checkWritableDirs will ensure the user may write to each of the dirs, or
+// the closest parent that exists, as root within a user namespace has no
+// more access to the host filesystem than the user does.
+func checkWritableDirs(dirs []string) error {
+	for _, dir := range dirs {
+		path := dir
+		for !PathExists(path) && path != filepath.Dir(path) {
+			path = filepath.Dir(path)
+		}
+		if err := syscall.Access(path, accessWrite); err != nil {
+			return fmt.Errorf("%s must be writable by the user: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// getUserNamespaceDirs will return the caches that a build writes to
+func getUserNamespaceDirs() []string {
+	return []string{
+		OverlayRootDir,
+		PackageCacheDirectory,
+		CcacheDirectory,
+		LegacyCcacheDirectory,
+		source.SourceDir,
+	}
+}
+
+// userNamespaceCommand will return a command that runs within a new user
+// and mount namespace. The namespace has no ID mappings until they are
+// applied once the command has started.
+func userNamespaceCommand(name string, args ...string) *exec.Cmd {
+	c := exec.Command(name, args...)
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+	}
+	c.Env = append(os.Environ(), UserNamespaceEnv+"=1")
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c
+}
+
+// startInUserNamespace will start the command made by userNamespaceCommand,
+// and release it through userNamespaceSyncFD once its IDs are mapped.
+func startInUserNamespace(c *exec.Cmd, mapping *userNamespaceMapping) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	// Closing without a write tells the child to give up
+	defer w.Close()
+
+	c.ExtraFiles = []*os.File{r}
+	err = c.Start()
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if err := mapping.Apply(c.Process.Pid); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return err
+	}
+	_, err = w.Write([]byte("\n"))
+	return err
+}
+
+// AwaitUserNamespace will block a re-executed process until the parent has
+// mapped its IDs, after which it is root within the namespace. This does
+// nothing outside of a user namespace.
+func AwaitUserNamespace() error {
+	if !InUserNamespace() {
+		return nil
+	}
+	sync := os.NewFile(userNamespaceSyncFD, "userns-sync")
+	defer sync.Close()
+	if _, err := sync.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("Failed to enter user namespace: %v", err)
+	}
+	return nil
+}
+
+// ReexecInUserNamespace will run solbuild again with the given arguments,
+// within a new user namespace. This permits the overlay mounts and chroot
+// to be used without root privileges on kernels that allow it, provided
+// the user has subordinate IDs and may write to the solbuild caches.
+//
+// When the child fails, an *exec.ExitError is returned so that the caller
+// may exit with the same status.
+func ReexecInUserNamespace(args []string) error {
+	if InUserNamespace() || !UserNamespacesSupported() {
+		return ErrNoUserNamespaces
+	}
+	mapping, err := getUserNamespaceMapping()
+	if err != nil {
+		return err
+	}
+	if err := checkWritableDirs(getUserNamespaceDirs()); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"uid":    mapping.uid,
+		"subuid": mapping.subUID.start,
+	}).Debug("Entering user namespace")
+
+	c := userNamespaceCommand("/proc/self/exe", args...)
+	if err := startInUserNamespace(c, mapping); err != nil {
+		return err
+	}
+	return c.Wait()
+}
@@ -0,0 +1,144 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSubordinateRange(t *testing.T) {
+	listing := strings.Join([]string{
+		"root:100000:65536",
+		"ikey:165536:1000",
+		"ikey:231072:65536",
+		"1001:296608:65536",
+		"broken:x:65536",
+	}, "\n")
+
+	tests := []struct {
+		name  string
+		id    int
+		found bool
+		start int
+	}{
+		// Ranges too small to map the build user are skipped
+		{"ikey", 1000, true, 231072},
+		// Owners may also be listed by ID
+		{"someone", 1001, true, 296608},
+		{"broken", 1002, false, 0},
+		{"nobody", 65534, false, 0},
+	}
+	for _, test := range tests {
+		sub, found := parseSubordinateRange(strings.NewReader(listing), test.name, test.id)
+		if found != test.found || sub.start != test.start {
+			t.Fatalf("Wrong range for %s: %v %v", test.name, sub, found)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "solbuild-subuid")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "subuid")
+	if err := ioutil.WriteFile(path, []byte(listing), 00644); err != nil {
+		t.Fatalf("Failed to write subuid: %v", err)
+	}
+	if _, err := getSubordinateRange(path, "nobody", 65534); err == nil || !strings.Contains(err.Error(), "nobody") {
+		t.Fatalf("Missing range should name the user: %v", err)
+	}
+}
+
+func TestIDMapArgs(t *testing.T) {
+	args := getIDMapArgs(42, 1000, subordinateRange{start: 100000, count: 65536})
+	want := []string{"42", "0", "1000", "1", "1", "100000", "65536"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("User should be root, backed by subordinate IDs: %v", args)
+	}
+}
+
+func TestCheckWritableDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-writable")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Missing caches are created beneath the closest parent
+	if err := checkWritableDirs([]string{filepath.Join(tmp, "sources", "git")}); err != nil {
+		t.Fatalf("Writable parent should be accepted: %v", err)
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("Root may write anywhere")
+	}
+	if err := checkWritableDirs([]string{"/proc/sys/sources"}); err == nil {
+		t.Fatalf("Read-only directory should be refused")
+	}
+}
+
+func TestUserNamespaceOwnership(t *testing.T) {
+	if !UserNamespacesSupported() {
+		t.Skip("User namespaces are not available")
+	}
+	mapping, err := getUserNamespaceMapping()
+	if err != nil {
+		t.Skipf("Cannot map user namespace: %v", err)
+	}
+	tmp, err := ioutil.TempDir("", "solbuild-userns")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Write an "artifact" as root within the namespace, and chown another
+	// to the build user as ccache is
+	artifact := filepath.Join(tmp, "nano-2.7.5-70-1-x86_64.eopkg")
+	ccache := filepath.Join(tmp, "ccache")
+	script := `read _ <&3 && [ "$(id -u)" = 0 ] && touch "$1" "$2" && chown 1000:1000 "$2"`
+	c := userNamespaceCommand("/bin/sh", "-c", script, "sh", artifact, ccache)
+	c.Stdin, c.Stdout, c.Stderr = nil, nil, nil
+	if err := startInUserNamespace(c, mapping); err != nil {
+		t.Skipf("Cannot create user namespace: %v", err)
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatalf("Failed to run as root in the namespace: %v", err)
+	}
+
+	st, err := os.Stat(artifact)
+	if err != nil {
+		t.Fatalf("Artifact missing: %v", err)
+	}
+	sys := st.Sys().(*syscall.Stat_t)
+	if int(sys.Uid) != os.Getuid() || int(sys.Gid) != os.Getgid() {
+		t.Fatalf("Artifact should be owned by the invoking user: %d:%d", sys.Uid, sys.Gid)
+	}
+
+	st, err = os.Stat(ccache)
+	if err != nil {
+		t.Fatalf("ccache missing: %v", err)
+	}
+	sys = st.Sys().(*syscall.Stat_t)
+	if int(sys.Uid) != mapping.subUID.start+999 || int(sys.Gid) != mapping.subGID.start+999 {
+		t.Fatalf("Build user should be a subordinate ID: %d:%d", sys.Uid, sys.Gid)
+	}
+}
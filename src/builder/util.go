@@ -66,7 +66,9 @@ func (p *Package) ActivateRoot(overlay *Overlay) error {
 	if err := overlay.MountVFS(); err != nil {
 		return err
 	}
-	return nil
+
+	// Keep scratch writes out of the upper layer
+	return overlay.MountScratch()
 }
 
 // DeactivateRoot will tear down the previously activated root
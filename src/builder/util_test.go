@@ -0,0 +1,30 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSaneEnvironment(t *testing.T) {
+	// Compression settings would change the packages produced
+	env := strings.Join(SaneEnvironment(BuildUser, BuildUserHome), "\n")
+	if strings.Contains(env, "XZ_DEFAULTS") || strings.Contains(env, "ZSTD_NBTHREADS") {
+		t.Fatalf("Build environment should not set xz or zstd threads: %v", env)
+	}
+}
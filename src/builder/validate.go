@@ -0,0 +1,129 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrMissingName is returned when the package has no name
+	ErrMissingName = errors.New("Package has no name")
+
+	// ErrMissingVersion is returned when the package has no version
+	ErrMissingVersion = errors.New("Package has no version")
+)
+
+// A SourceError is a problem with one of the package sources
+type SourceError struct {
+	Source string // Identifier of the source
+	Err    error  // Underlying error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("Invalid source %s: %v", e.Source, e.Err)
+}
+
+// Unwrap will return the underlying error
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// A SourceCollisionError is returned when several sources would be placed
+// at the same path within the build root.
+type SourceCollisionError struct {
+	File    string   // File name within the source directory
+	Sources []string // Identifiers of the colliding sources
+}
+
+func (e *SourceCollisionError) Error() string {
+	return fmt.Sprintf("Sources share the file name %s: %s", e.File, strings.Join(e.Sources, ", "))
+}
+
+// An InvalidPackageError holds every problem found by Validate
+type InvalidPackageError struct {
+	Package string
+	Errors  []error
+}
+
+func (e *InvalidPackageError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("Package %s is invalid: %s", e.Package, strings.Join(msgs, "; "))
+}
+
+// Validate will check the package and its sources are consistent, before
+// anything is fetched or built, returning every problem found.
+func (p *Package) Validate() []error {
+	var errs []error
+	if strings.TrimSpace(p.Name) == "" {
+		errs = append(errs, ErrMissingName)
+	}
+	if strings.TrimSpace(p.Version) == "" {
+		errs = append(errs, ErrMissingVersion)
+	}
+	if p.Release < 0 {
+		errs = append(errs, fmt.Errorf("Invalid release: %d", p.Release))
+	}
+
+	// Sources are bound into the same directory, so names must be unique
+	files := make(map[string][]string)
+	for _, src := range p.Sources {
+		if declared, ok := src.(source.DeclaredSource); ok {
+			if err := declared.Validate(); err != nil {
+				errs = append(errs, &SourceError{Source: src.GetIdentifier(), Err: err})
+			}
+		}
+		file := filepath.Base(src.GetBindConfiguration("/").BindTarget)
+		files[file] = append(files[file], src.GetIdentifier())
+	}
+	var names []string
+	for file, sources := range files {
+		if len(sources) > 1 {
+			names = append(names, file)
+		}
+	}
+	sort.Strings(names)
+	for _, file := range names {
+		errs = append(errs, &SourceCollisionError{File: file, Sources: files[file]})
+	}
+	return errs
+}
+
+// CheckValid will log every problem found by Validate, returning an
+// InvalidPackageError if there were any.
+func (p *Package) CheckValid() error {
+	errs := p.Validate()
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs {
+		log.WithFields(log.Fields{
+			"package": p.Name,
+			"error":   err,
+		}).Error("Package is invalid")
+	}
+	return &InvalidPackageError{Package: p.Name, Errors: errs}
+}
@@ -0,0 +1,98 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"builder/source"
+	"errors"
+	"testing"
+)
+
+const testSHA256 = "f7a5936c485e5b92df267d9c20243b07a7aa2ba25ade3b0bcae88eec83168762"
+
+func newValidateTestPackage(t *testing.T, name, version string, release int, sources ...[2]string) *Package {
+	pkg := &Package{Name: name, Version: version, Release: release, Type: PackageTypeYpkg}
+	for _, s := range sources {
+		src, err := source.New(s[0], s[1], false)
+		if err != nil {
+			t.Fatalf("Failed to create source %s: %v", s[0], err)
+		}
+		pkg.Sources = append(pkg.Sources, src)
+	}
+	return pkg
+}
+
+func TestPackageValidate(t *testing.T) {
+	nano := [2]string{"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", testSHA256}
+
+	pkg := newValidateTestPackage(t, "nano", "2.7.5", 1, nano, [2]string{"git|https://git.savannah.gnu.org/git/nano.git", "v2.7.5"})
+	if errs := pkg.Validate(); len(errs) != 0 {
+		t.Fatalf("Valid package reported problems: %v", errs)
+	}
+	if err := pkg.CheckValid(); err != nil {
+		t.Fatalf("Valid package failed the check: %v", err)
+	}
+
+	tests := []struct {
+		pkg    *Package
+		errors []error
+	}{
+		{newValidateTestPackage(t, "", "2.7.5", 1, nano), []error{ErrMissingName}},
+		{newValidateTestPackage(t, "nano", " ", 1, nano), []error{ErrMissingVersion}},
+		{newValidateTestPackage(t, "nano", "2.7.5", -1, nano), []error{errors.New("Invalid release: -1")}},
+		{
+			newValidateTestPackage(t, "nano", "2.7.5", 1, [2]string{"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", ""}),
+			[]error{&SourceError{Source: nano[0], Err: source.ErrMissingValidator}},
+		},
+		{
+			newValidateTestPackage(t, "nano", "2.7.5", 1, [2]string{"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", "abc"}),
+			[]error{errors.New("Invalid source " + nano[0] + ": Invalid hash for source: 'abc'")},
+		},
+		{
+			newValidateTestPackage(t, "nano", "2.7.5", 1, [2]string{"rsync://nano-editor.org/nano-2.7.5.tar.xz", testSHA256}),
+			[]error{errors.New("Invalid source rsync://nano-editor.org/nano-2.7.5.tar.xz: Unsupported source scheme: 'rsync'")},
+		},
+		{
+			newValidateTestPackage(t, "nano", "2.7.5", 1, [2]string{"git|https://git.savannah.gnu.org/git/nano.git", ""}),
+			[]error{&SourceError{Source: "https://git.savannah.gnu.org/git/nano.git#", Err: source.ErrMissingValidator}},
+		},
+		{
+			newValidateTestPackage(t, "nano", "2.7.5", 1, nano, [2]string{"https://mirror.example.com/nano/nano-2.7.5.tar.xz", testSHA256}),
+			[]error{&SourceCollisionError{File: "nano-2.7.5.tar.xz", Sources: []string{nano[0], "https://mirror.example.com/nano/nano-2.7.5.tar.xz"}}},
+		},
+		// All problems are reported at once
+		{
+			newValidateTestPackage(t, "", "", 1, [2]string{"https://nano-editor.org/dist/v2.7/nano-2.7.5.tar.xz", ""}),
+			[]error{ErrMissingName, ErrMissingVersion, &SourceError{Source: nano[0], Err: source.ErrMissingValidator}},
+		},
+	}
+	for i, test := range tests {
+		errs := test.pkg.Validate()
+		if len(errs) != len(test.errors) {
+			t.Fatalf("Wrong problems for test %d: %v", i, errs)
+		}
+		for j, err := range errs {
+			if err.Error() != test.errors[j].Error() {
+				t.Fatalf("Wrong problem for test %d: '%v' vs expected '%v'", i, err, test.errors[j])
+			}
+		}
+		err := test.pkg.CheckValid()
+		if invalid, ok := err.(*InvalidPackageError); !ok || len(invalid.Errors) != len(errs) {
+			t.Fatalf("Expected an InvalidPackageError for test %d, got: %v", i, err)
+		}
+	}
+}
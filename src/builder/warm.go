@@ -0,0 +1,144 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// WarmSnapshotDir is the name of the directory within each profile's
+	// cache directory that holds the warm overlay snapshot.
+	WarmSnapshotDir = ".warm"
+)
+
+// WarmOverlays controls whether the prepared state of the build root, after
+// upgrading the base and installing system.devel, is kept and restored for
+// subsequent builds instead of being prepared from scratch.
+var WarmOverlays = false
+
+// A WarmSnapshot is a copy of an overlay's upper layer in its clean state,
+// prior to any package specific changes. Restoring it rolls a build root
+// back to this state, allowing sequential builds to skip preparing the root.
+type WarmSnapshot struct {
+	Key string // Key computed from the backing image
+	Dir string // Where the snapshot is stored
+}
+
+// NewWarmSnapshot will return the warm snapshot for the overlay, or nil if
+// warm overlays are disabled.
+func NewWarmSnapshot(o *Overlay) (*WarmSnapshot, error) {
+	if !WarmOverlays {
+		return nil, nil
+	}
+	key, err := getDependencyLayerKey(o.Back, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WarmSnapshot{
+		Key: key,
+		Dir: filepath.Join(filepath.Dir(o.BaseDir), WarmSnapshotDir, key),
+	}, nil
+}
+
+// IsWarm will determine whether the snapshot is available for use
+func (w *WarmSnapshot) IsWarm() bool {
+	return PathExists(w.Dir)
+}
+
+// getPackagePaths returns the chroot paths containing package specific
+// files, which must never form part of the snapshot.
+func getPackagePaths(p *Package) []string {
+	paths := []string{p.GetWorkDirInternal()}
+	if p.Type == PackageTypeXML {
+		paths = append(paths, filepath.Join(filepath.Dir(p.GetWorkDirInternal()), "component.xml"))
+	}
+	return paths
+}
+
+// Store will snapshot the upper layer of the overlay, excluding any files
+// belonging to the current package.
+func (w *WarmSnapshot) Store(o *Overlay) error {
+	parent := filepath.Dir(w.Dir)
+	if err := os.MkdirAll(parent, 00755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(parent, ".layer")
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"key": w.Key,
+	}).Debug("Storing warm overlay snapshot")
+
+	if err := copyTree(o.UpperDir, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	for _, p := range getPackagePaths(o.Package) {
+		if err := os.RemoveAll(filepath.Join(tmpDir, p[1:])); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	if err := pruneStaleLayers(parent, w.Key); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := os.Rename(tmpDir, w.Dir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+// Restore will roll the upper layer of the overlay back to the snapshot.
+// This must be called before the overlay is mounted.
+func (w *WarmSnapshot) Restore(o *Overlay) error {
+	log.WithFields(log.Fields{
+		"key": w.Key,
+	}).Debug("Restoring warm overlay snapshot")
+
+	for _, dir := range []string{o.UpperDir, o.WorkDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			return err
+		}
+	}
+	return copyTree(w.Dir, o.UpperDir)
+}
+
+// UseWarmSnapshot will configure the overlay to be restored from the warm
+// snapshot, if one exists. Otherwise a snapshot will be stored once the
+// build root has been prepared.
+func (p *Package) UseWarmSnapshot(o *Overlay) error {
+	warm, err := NewWarmSnapshot(o)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to determine warm overlay snapshot")
+		return err
+	}
+	o.Warm = warm
+	return nil
+}
@@ -0,0 +1,105 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmOverlay(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "solbuild-warm")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	back := &BackingImage{Name: "main-x86_64", ImagePath: filepath.Join(tmp, "main-x86_64.img")}
+	if err := ioutil.WriteFile(back.ImagePath, []byte("image"), 00644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	WarmOverlays = true
+	defer func() {
+		WarmOverlays = false
+	}()
+
+	newOverlay := func(name string) *Overlay {
+		pkg := &Package{Name: name, Type: PackageTypeYpkg}
+		basedir := filepath.Join(tmp, "main-x86_64", name)
+		o := &Overlay{
+			Back:     back,
+			Package:  pkg,
+			BaseDir:  basedir,
+			UpperDir: filepath.Join(basedir, "tmp"),
+			WorkDir:  filepath.Join(basedir, "work"),
+		}
+		if err := pkg.UseWarmSnapshot(o); err != nil || o.Warm == nil {
+			t.Fatalf("Failed to get warm snapshot: %v", err)
+		}
+		for _, dir := range []string{o.UpperDir, o.WorkDir} {
+			if err := os.MkdirAll(dir, 00755); err != nil {
+				t.Fatalf("Failed to create overlay directory: %v", err)
+			}
+		}
+		return o
+	}
+
+	// First, cold build prepares the root and stores the snapshot
+	cold := newOverlay("nano")
+	if cold.Warm.IsWarm() {
+		t.Fatalf("Snapshot should not exist yet")
+	}
+	prepared := filepath.Join("usr", "bin", "gcc")
+	workFile := filepath.Join(BuildUserHome[1:], "work", "package.yml")
+	for _, f := range []string{prepared, workFile} {
+		path := filepath.Join(cold.UpperDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f), 00644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := cold.Warm.Store(cold); err != nil {
+		t.Fatalf("Failed to store snapshot: %v", err)
+	}
+
+	// Second build restores the prepared state without package files
+	warm := newOverlay("vim")
+	if !warm.Warm.IsWarm() {
+		t.Fatalf("Sequential build should reuse the warm snapshot")
+	}
+	leftover := filepath.Join(warm.UpperDir, "leftover")
+	if err := ioutil.WriteFile(leftover, []byte("dirty"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := warm.Warm.Restore(warm); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(warm.UpperDir, prepared)); err != nil || string(contents) != prepared {
+		t.Fatalf("Prepared state missing after restore: %v", err)
+	}
+	if PathExists(filepath.Join(warm.UpperDir, workFile)) {
+		t.Fatalf("Package files from the previous build should not be restored")
+	}
+	if PathExists(leftover) {
+		t.Fatalf("Restore should roll back all changes")
+	}
+}
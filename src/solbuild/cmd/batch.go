@@ -0,0 +1,177 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+)
+
+var batchCmd = &cobra.Command{
+	Use:   "batch [package.yml|pspec.xml...]",
+	Short: "build multiple packages",
+	Long: `Build each of the given packages in turn, recording progress in a
+checkpoint file so that an interrupted batch may be resumed later. A running
+batch may be paused with --pause, finishing the current build without
+starting the next, and continued with --resume`,
+	RunE: batchBuild,
+}
+
+var checkpointPath string
+var forceRebuild bool
+var pauseBatch bool
+var resumeBatch bool
+var junitPath string
+
+func init() {
+	batchCmd.Flags().StringVarP(&checkpointPath, "checkpoint", "c", ".solbuild-batch", "Checkpoint file used to resume the batch")
+	batchCmd.Flags().BoolVarP(&forceRebuild, "force", "f", false, "Ignore the checkpoint and rebuild all packages")
+	batchCmd.Flags().BoolVar(&pauseBatch, "pause", false, "Pause the batch using the checkpoint once the current build completes")
+	batchCmd.Flags().BoolVar(&resumeBatch, "resume", false, "Resume a paused batch")
+	batchCmd.Flags().StringVarP(&junitPath, "junit", "j", "", "Write a JUnit XML report of the batch to this file")
+	batchCmd.Flags().BoolVarP(&tmpfs, "tmpfs", "t", false, "Enable building in a tmpfs")
+	batchCmd.Flags().StringVarP(&tmpfsSize, "memory", "m", "", "Set the tmpfs size to use")
+	batchCmd.Flags().StringVarP(&targetArch, "arch", "a", "", "Set the target architecture")
+	RootCmd.AddCommand(batchCmd)
+}
+
+// getBatchBuildArgs will return the arguments of the child solbuild process
+// building the package, passing on the options of the batch.
+func getBatchBuildArgs(pkg *builder.Package) []string {
+	args := []string{"build", pkg.Path}
+	if profile != "" {
+		args = append(args, "-p", profile)
+	}
+	if CLIDebug {
+		args = append(args, "-d")
+	}
+	if builder.DisableColors {
+		args = append(args, "-n")
+	}
+	if builder.QuietMode {
+		args = append(args, "-q")
+	}
+	if tmpfs {
+		args = append(args, "-t")
+	}
+	if tmpfsSize != "" {
+		args = append(args, "-m", tmpfsSize)
+	}
+	if targetArch != "" {
+		args = append(args, "-a", targetArch)
+	}
+	if source.Offline {
+		args = append(args, "--offline")
+	}
+	return args
+}
+
+// batchBuildPackage will build a single package in a child solbuild process,
+// ensuring each build gets a fresh namespace.
+func batchBuildPackage(pkg *builder.Package) error {
+	c := exec.Command("/proc/self/exe", getBatchBuildArgs(pkg)...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	// Keep the end of the output for the report
+	if junitPath != "" {
+		tail := builder.NewOutputTail(builder.JUnitOutputLimit)
+		c.Stdout = io.MultiWriter(os.Stdout, tail)
+		c.Stderr = io.MultiWriter(os.Stderr, tail)
+		if err := c.Run(); err != nil {
+			return &builder.BatchBuildError{Err: err, Output: tail.String()}
+		}
+		return nil
+	}
+	return c.Run()
+}
+
+func batchBuild(cmd *cobra.Command, args []string) error {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	// Control a batch running elsewhere with the same checkpoint
+	if pauseBatch || resumeBatch {
+		checkpoint, err := builder.NewBatchCheckpoint(checkpointPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		if err := checkpoint.SetPaused(pauseBatch); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to update batch state: %v\n", err)
+			os.Exit(1)
+		}
+		state := builder.BatchRunning
+		if checkpoint.IsPaused() {
+			state = builder.BatchPaused
+		}
+		fmt.Printf("Batch %s is now %s\n", checkpointPath, state)
+		return nil
+	}
+
+	if len(args) < 1 {
+		return errors.New("Require at least one filename to build")
+	}
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to run build packages\n")
+		os.Exit(1)
+	}
+
+	var pkgs []*builder.Package
+	for _, pkgPath := range args {
+		pkg, err := builder.NewPackage(pkgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load package %s: %v\n", pkgPath, err)
+			os.Exit(1)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	checkpoint, err := builder.NewBatchCheckpoint(checkpointPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load checkpoint: %v\n", err)
+		os.Exit(1)
+	}
+
+	runner := builder.NewBatchRunner(checkpoint, batchBuildPackage)
+	err = runner.Run(pkgs, forceRebuild)
+	if junitPath != "" {
+		if reportErr := builder.WriteJUnitReport(junitPath, "solbuild batch", runner.Results); reportErr != nil {
+			log.WithFields(log.Fields{
+				"path":  junitPath,
+				"error": reportErr,
+			}).Error("Failed to write JUnit report")
+		}
+	}
+	if err != nil {
+		log.Error("Failed to build packages")
+		os.Exit(builder.ExitCode(err))
+	}
+
+	log.Info("Batch build succeeded")
+	return nil
+}
@@ -18,12 +18,15 @@ package cmd
 
 import (
 	"builder"
+	"builder/source"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
+	"os/exec"
 	"strings"
+	"syscall"
 )
 
 var buildCmd = &cobra.Command{
@@ -36,10 +39,26 @@ store those packages in the current directory`,
 
 var tmpfs bool
 var tmpfsSize string
+var replayLock string
+var prepareOnly bool
+var streamSources bool
+var targetArch string
+var forceBuild bool
+var freshDownload bool
+var fetchJobs int
+var limitRate string
 
 func init() {
 	buildCmd.Flags().BoolVarP(&tmpfs, "tmpfs", "t", false, "Enable building in a tmpfs")
 	buildCmd.Flags().StringVarP(&tmpfsSize, "memory", "m", "", "Set the tmpfs size to use")
+	buildCmd.Flags().BoolVarP(&prepareOnly, "prepare", "P", false, "Prepare the build root for chroot without building")
+	buildCmd.Flags().BoolVar(&streamSources, "stream", false, "Stream uncached archives into the prepared build root")
+	buildCmd.Flags().StringVarP(&targetArch, "arch", "a", "", "Set the target architecture")
+	buildCmd.Flags().StringVarP(&replayLock, "replay", "r", "", "Require the inputs recorded in this input lock")
+	buildCmd.Flags().BoolVarP(&forceBuild, "force", "f", false, "Build even if the package has already been built")
+	buildCmd.Flags().BoolVar(&freshDownload, "fresh", false, "Discard partial downloads instead of resuming them")
+	buildCmd.Flags().IntVarP(&fetchJobs, "jobs", "j", 0, "Set the number of sources to fetch at the same time")
+	buildCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit the rate of source downloads, i.e. 500K")
 	RootCmd.AddCommand(buildCmd)
 }
 
@@ -58,8 +77,30 @@ func buildPackage(cmd *cobra.Command, args []string) error {
 		pkgPath = FindLikelyArg()
 	}
 
+	// A re-executed build is only root once the parent maps its IDs
+	if err := builder.AwaitUserNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	if os.Geteuid() != 0 {
-		fmt.Fprintf(os.Stderr, "You must be root to run build packages\n")
+		// Try to build unprivileged within a user namespace instead
+		err := builder.ReexecInUserNamespace(os.Args[1:])
+		switch err {
+		case nil:
+			os.Exit(0)
+		case builder.ErrNoUserNamespaces:
+			fmt.Fprintf(os.Stderr, "You must be root to run build packages\n")
+		default:
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				// Exit as the shell would for a child killed by a signal
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+					os.Exit(128 + int(status.Signal()))
+				}
+				os.Exit(exitErr.ExitCode())
+			}
+			fmt.Fprintf(os.Stderr, "Cannot build without root: %v\n", err)
+		}
 		os.Exit(1)
 	}
 
@@ -69,10 +110,23 @@ func buildPackage(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if targetArch != "" {
+		if err := builder.SetTargetArch(targetArch); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return nil
+		}
+	}
+
 	// Safety first..
 	if err = manager.SetProfile(profile); err != nil {
 		return nil
 	}
+	if limitRate != "" {
+		if err := source.SetLimitRate(limitRate); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return nil
+		}
+	}
 
 	pkgPath = strings.TrimSpace(pkgPath)
 
@@ -86,6 +140,23 @@ func buildPackage(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	builder.ToolVersion = SolbuildVersion
+	pkg.PrepareOnly = prepareOnly
+	if streamSources {
+		builder.StreamSources = true
+	}
+	pkg.Force = forceBuild
+	source.ForceFreshDownloads = freshDownload
+	if fetchJobs > 0 {
+		builder.FetchJobs = fetchJobs
+	}
+	if replayLock != "" {
+		if pkg.ReplayLock, err = builder.ReadInputLock(replayLock); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load input lock: %v\n", err)
+			return nil
+		}
+	}
+
 	// Set the package
 	if err := manager.SetPackage(pkg); err != nil {
 		if err == builder.ErrProfileNotInstalled {
@@ -97,9 +168,14 @@ func buildPackage(cmd *cobra.Command, args []string) error {
 	manager.SetTmpfs(tmpfs, tmpfsSize)
 	if err := manager.Build(); err != nil {
 		log.Error("Failed to build packages")
-		return nil
+		// Ensure batch builds and scripts can see the failure
+		os.Exit(builder.ExitCode(err))
 	}
 
+	if prepareOnly {
+		log.Info("Preparing succeeded")
+		return nil
+	}
 	log.Info("Building succeeded")
 	return nil
 }
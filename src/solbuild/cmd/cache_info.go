@@ -0,0 +1,76 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var cacheInfoCmd = &cobra.Command{
+	Use:   "cache-info",
+	Short: "show source cache usage",
+	Long: `Report the disk usage of the source cache, its largest entries, and any
+files left in the staging directory by interrupted fetches`,
+	Run: cacheInfo,
+}
+
+// Whether we print the statistics as JSON, i.e. for monitoring
+var cacheInfoJSON bool
+
+func init() {
+	cacheInfoCmd.Flags().BoolVarP(&cacheInfoJSON, "json", "j", false, "Print the statistics as JSON")
+	RootCmd.AddCommand(cacheInfoCmd)
+}
+
+func cacheInfo(cmd *cobra.Command, args []string) {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	// Respect relocated caches
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid cache paths: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stats, err := source.CacheStats()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to read source cache")
+		os.Exit(1)
+	}
+	if !cacheInfoJSON {
+		fmt.Print(stats)
+		return
+	}
+	b, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode statistics: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(b))
+}
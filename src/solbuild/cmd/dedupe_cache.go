@@ -0,0 +1,78 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var dedupeCacheCmd = &cobra.Command{
+	Use:   "dedupe-cache",
+	Short: "hardlink identical cached sources",
+	Long: `Replace the copies of each source cached under several names with
+hardlinks to a single copy, reclaiming the disk space they used`,
+	Run: dedupeCache,
+}
+
+// Whether we only report the copies that would be linked
+var dedupeDryRun bool
+
+func init() {
+	dedupeCacheCmd.Flags().BoolVar(&dedupeDryRun, "dry-run", false, "Only report the copies that would be linked")
+	RootCmd.AddCommand(dedupeCacheCmd)
+}
+
+func dedupeCache(cmd *cobra.Command, args []string) {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to deduplicate caches\n")
+		os.Exit(1)
+	}
+
+	// Respect relocated caches
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid cache paths: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := source.DeduplicateCache(dedupeDryRun)
+	if result != nil {
+		log.WithFields(log.Fields{
+			"linked":     result.Linked,
+			"freed":      result.FreedBytes,
+			"mismatched": result.Mismatched,
+			"dry_run":    dedupeDryRun,
+		}).Info("Deduplicated source cache")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to deduplicate source cache")
+		os.Exit(1)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 	"strings"
+	"time"
 )
 
 var deleteCacheCmd = &cobra.Command{
@@ -36,8 +37,20 @@ var deleteCacheCmd = &cobra.Command{
 // Whether we nuke *all* assets, i.e. sources too
 var purgeAll bool
 
+// Whether we only prune the source cache, and by which policy
+var (
+	pruneSources bool
+	pruneMaxAge  int
+	pruneMaxSize string
+	pruneDryRun  bool
+)
+
 func init() {
 	deleteCacheCmd.Flags().BoolVarP(&purgeAll, "all", "a", false, "Also delete ccache, packages and sources")
+	deleteCacheCmd.Flags().BoolVarP(&pruneSources, "sources", "s", false, "Only prune old sources from the source cache")
+	deleteCacheCmd.Flags().IntVar(&pruneMaxAge, "max-age", 90, "With --sources, remove sources unused for this many days (0 for no limit)")
+	deleteCacheCmd.Flags().StringVar(&pruneMaxSize, "max-size", "", "With --sources, remove the least recently used sources beyond this size, i.e. 20G")
+	deleteCacheCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "With --sources, only list the sources that would be removed")
 	RootCmd.AddCommand(deleteCacheCmd)
 }
 
@@ -56,6 +69,19 @@ func deleteCache(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// Respect relocated caches
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid cache paths: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if pruneSources {
+		deleteSources()
+		return
+	}
+
 	// By default include /var/lib/solbuild
 	nukeDirs := []string{
 		builder.OverlayRootDir,
@@ -77,7 +103,8 @@ func deleteCache(cmd *cobra.Command, args []string) {
 		log.WithFields(log.Fields{
 			"dir": p,
 		}).Info("Removing cache directory")
-		if err := os.RemoveAll(p); err != nil {
+		// Leaked mounts must be removed first, else we'd delete through them
+		if err := builder.ForceUnmount(p); err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
 				"dir":   p,
@@ -86,3 +113,51 @@ func deleteCache(cmd *cobra.Command, args []string) {
 		}
 	}
 }
+
+// deleteSources will prune the source cache by the requested policy
+func deleteSources() {
+	if purgeAll {
+		fmt.Fprintf(os.Stderr, "Cannot use --sources with --all\n")
+		os.Exit(1)
+	}
+	maxSize, err := source.ParseRate(pruneMaxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid maximum size: %s\n", pruneMaxSize)
+		os.Exit(1)
+	}
+	if pruneMaxAge < 0 {
+		fmt.Fprintf(os.Stderr, "Maximum age cannot be negative\n")
+		os.Exit(1)
+	}
+	policy := source.PrunePolicy{
+		MaxAge:  time.Duration(pruneMaxAge) * 24 * time.Hour,
+		MaxSize: maxSize,
+		DryRun:  pruneDryRun,
+	}
+
+	result, err := source.PruneCache(policy)
+	if result != nil {
+		msg := "Removed cached source"
+		if pruneDryRun {
+			msg = "Would remove cached source"
+		}
+		for _, pruned := range result.Removed {
+			log.WithFields(log.Fields{
+				"source":    pruned.File,
+				"hash":      pruned.Hash,
+				"last_used": pruned.LastUsed.Format(time.RFC3339),
+			}).Info(msg)
+		}
+		log.WithFields(log.Fields{
+			"sources":  len(result.Removed),
+			"freed":    result.FreedBytes,
+			"dangling": result.DanglingLinks,
+		}).Info("Pruned source cache")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to prune source cache")
+		os.Exit(1)
+	}
+}
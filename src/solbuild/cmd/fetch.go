@@ -0,0 +1,109 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [package.yml|pspec.xml...]",
+	Short: "fetch the sources of packages",
+	Long: `Download and verify the sources of the given packages into the source
+cache, without building them, i.e. to prefetch while another build runs`,
+	RunE: fetchSources,
+}
+
+func init() {
+	fetchCmd.Flags().IntVarP(&fetchJobs, "jobs", "j", 0, "Set the number of sources to fetch at the same time")
+	fetchCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit the rate of source downloads, i.e. 500K")
+	RootCmd.AddCommand(fetchCmd)
+}
+
+func fetchSources(cmd *cobra.Command, args []string) error {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if len(args) == 0 {
+		// Try to find the logical path..
+		if pkgPath := FindLikelyArg(); pkgPath != "" {
+			args = []string{pkgPath}
+		}
+	}
+	if len(args) == 0 {
+		return errors.New("Require a filename to fetch")
+	}
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to fetch sources\n")
+		os.Exit(1)
+	}
+
+	manager, err := builder.NewManager()
+	if err != nil {
+		return nil
+	}
+	if err := manager.SetProfile(profile); err != nil {
+		return nil
+	}
+	if limitRate != "" {
+		if err := source.SetLimitRate(limitRate); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return nil
+		}
+	}
+	if fetchJobs > 0 {
+		builder.FetchJobs = fetchJobs
+	}
+
+	var fetchErr error
+	for _, pkgPath := range args {
+		pkgPath = strings.TrimSpace(pkgPath)
+		pkg, err := builder.NewPackage(pkgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load package %s: %v\n", pkgPath, err)
+			os.Exit(1)
+		}
+		if err := manager.Fetch(pkg); err != nil {
+			log.WithFields(log.Fields{
+				"package": pkg.Name,
+				"error":   err,
+			}).Error("Failed to fetch sources")
+			fetchErr = err
+			continue
+		}
+		log.WithFields(log.Fields{
+			"package": pkg.Name,
+			"sources": len(pkg.Sources),
+		}).Info("Sources fetched")
+	}
+
+	if fetchErr != nil {
+		// Ensure scripts can tell fetch failures apart
+		os.Exit(builder.ExitCode(fetchErr))
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"builder"
+	"builder/source"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/commands"
@@ -44,7 +45,7 @@ func init() {
 
 func doInit(manager *builder.Manager) {
 	prof := manager.GetProfile()
-	bk := builder.NewBackingImage(prof.Image)
+	bk := builder.NewBackingImage(builder.GetArchImage(prof.Image, builder.TargetArch))
 	if bk.IsInstalled() {
 		fmt.Printf("'%v' has already been initialised\n", profile)
 		return
@@ -68,7 +69,14 @@ func doInit(manager *builder.Manager) {
 
 	// Now ensure we actually have said image
 	if !bk.IsFetched() {
-		com := []string{"-o", bk.ImagePathXZ, "-L", "--progress-bar", bk.ImageURI}
+		if source.Offline {
+			fmt.Fprintf(os.Stderr, "Cannot fetch the '%v' image in offline mode\n", profile)
+			os.Exit(1)
+		}
+		policy := source.GetNetworkPolicy(source.NetworkImage)
+		com := []string{"-o", bk.ImagePathXZ, "-L", "--progress-bar"}
+		com = append(com, policy.CurlArgs()...)
+		com = append(com, bk.ImageURI)
 		log.WithFields(log.Fields{
 			"uri": bk.ImageURI,
 		}).Info("Fetching backing image")
@@ -0,0 +1,70 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var migrateCacheCmd = &cobra.Command{
+	Use:   "migrate-cache",
+	Short: "migrate legacy sources to sha256sum",
+	Long: `Move any legacy sources cached under their sha1sum to the directory
+named after their sha256sum, keeping the sha1sum as a symlink`,
+	Run: migrateCache,
+}
+
+// Whether we remove the sha1sum links once migrated
+var removeLegacyLinks bool
+
+func init() {
+	migrateCacheCmd.Flags().BoolVarP(&removeLegacyLinks, "remove-links", "r", false, "Also remove the legacy sha1sum links")
+	RootCmd.AddCommand(migrateCacheCmd)
+}
+
+func migrateCache(cmd *cobra.Command, args []string) {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to migrate caches\n")
+		os.Exit(1)
+	}
+
+	// Respect relocated caches
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid cache paths: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	source.KeepLegacyLinks = !removeLegacyLinks
+	if err := source.MigrateLegacyCache(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to migrate source cache")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var pruneFailedCmd = &cobra.Command{
+	Use:   "prune-failed",
+	Short: "prune overlays kept from failed builds",
+	Long: `Remove the oldest overlays kept from failed builds, keeping only the
+configured number of most recent ones for each profile`,
+	Run: pruneFailed,
+}
+
+// How many failed overlays to keep, overriding the configuration
+var pruneKeep int
+
+func init() {
+	pruneFailedCmd.Flags().IntVarP(&pruneKeep, "keep", "k", 0, "Number of failed overlays to keep per profile")
+	RootCmd.AddCommand(pruneFailedCmd)
+}
+
+func pruneFailed(cmd *cobra.Command, args []string) {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to prune failed overlays\n")
+		os.Exit(1)
+	}
+
+	keep := 0
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid cache paths: %v\n", err)
+			os.Exit(1)
+		}
+		keep = config.KeepFailedOverlays
+	}
+	if cmd.Flags().Changed("keep") {
+		keep = pruneKeep
+	}
+	if keep < 0 {
+		fmt.Fprintf(os.Stderr, "Cannot keep a negative number of overlays\n")
+		os.Exit(1)
+	}
+
+	pruned, err := builder.PruneFailedOverlays(keep)
+	for _, f := range pruned {
+		log.WithFields(log.Fields{
+			"package": f.Package,
+			"profile": f.Profile,
+			"time":    f.Time,
+		}).Info("Pruned failed overlay")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to prune failed overlays")
+		os.Exit(1)
+	}
+}
@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"builder"
+	"builder/source"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -38,6 +39,8 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "Build profile to use")
 	RootCmd.PersistentFlags().BoolVarP(&CLIDebug, "debug", "d", false, "Enable debug messages")
 	RootCmd.PersistentFlags().BoolVarP(&builder.DisableColors, "no-color", "n", false, "Disable color output")
+	RootCmd.PersistentFlags().BoolVarP(&builder.QuietMode, "quiet", "q", false, "Suppress the build phase log")
+	RootCmd.PersistentFlags().BoolVar(&source.Offline, "offline", false, "Never touch the network, using only local caches")
 }
 
 // FindLikelyArg will look in the current directory to see if common path names exist,
@@ -0,0 +1,70 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// parseFlags will parse the arguments with the flags of the subcommand,
+// merged with the persistent flags of RootCmd, reporting any panic.
+func parseFlags(name string, args []string) (err error) {
+	cmd, _, err := RootCmd.Find([]string{name})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return cmd.ParseFlags(args)
+}
+
+func TestSubcommandFlags(t *testing.T) {
+	for _, cmd := range RootCmd.Commands() {
+		if err := parseFlags(cmd.Name(), nil); err != nil {
+			t.Fatalf("Flags of %s clash with the root flags: %v", cmd.Name(), err)
+		}
+	}
+}
+
+func TestDeleteCacheFlags(t *testing.T) {
+	if err := parseFlags("delete-cache", []string{"--sources", "--dry-run", "--max-age", "30", "-n"}); err != nil {
+		t.Fatalf("Failed to parse delete-cache flags: %v", err)
+	}
+	if !pruneSources || !pruneDryRun || pruneMaxAge != 30 {
+		t.Fatalf("Wrong flags parsed: %v %v %d", pruneSources, pruneDryRun, pruneMaxAge)
+	}
+}
+
+func TestBatchBuildArgs(t *testing.T) {
+	defer func() {
+		targetArch, tmpfs, source.Offline = "", false, false
+	}()
+	if err := parseFlags("batch", []string{"-t", "--arch", "i686", "--offline"}); err != nil {
+		t.Fatalf("Failed to parse batch flags: %v", err)
+	}
+	args := strings.Join(getBatchBuildArgs(&builder.Package{Path: "nano/package.yml"}), " ")
+	if !strings.HasPrefix(args, "build nano/package.yml") || !strings.HasSuffix(args, " -t -a i686 --offline") {
+		t.Fatalf("Batch options should be passed to each build: %v", args)
+	}
+}
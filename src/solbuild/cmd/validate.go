@@ -0,0 +1,114 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [package.yml|pspec.xml...]",
+	Short: "check sources are reachable",
+	Long: `Check that the given packages and their sources are consistent, and
+that all sources are reachable without downloading them, reporting any
+invalid or unreachable sources`,
+	RunE: validateSources,
+}
+
+var validateJobs int
+
+func init() {
+	validateCmd.Flags().IntVarP(&validateJobs, "jobs", "j", 0, "Maximum concurrent requests per host")
+	RootCmd.AddCommand(validateCmd)
+}
+
+func validateSources(cmd *cobra.Command, args []string) error {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if len(args) < 1 {
+		return errors.New("Require at least one filename to validate")
+	}
+
+	// Respect the fetch configuration, i.e. custom headers
+	if config, err := builder.NewConfig(); err == nil {
+		source.MaxRedirects = config.MaxRedirects
+		source.HostHeaders = config.Headers
+		source.StaticHosts = config.Hosts
+		source.DoHURL = config.DoHURL
+		if err := builder.SetNetworkPolicy(config.Network); err != nil {
+			return err
+		}
+	}
+	if validateJobs > 0 {
+		override := source.NetworkOverrides[source.NetworkMetadata]
+		override.HostConcurrency = &validateJobs
+		source.NetworkOverrides[source.NetworkMetadata] = override
+	}
+
+	failed := 0
+	var sources []source.Source
+	for _, pkgPath := range args {
+		pkg, err := builder.NewPackage(pkgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load package %s: %v\n", pkgPath, err)
+			os.Exit(1)
+		}
+		if err := pkg.CheckValid(); err != nil {
+			failed++
+		}
+		sources = append(sources, pkg.Sources...)
+	}
+
+	for _, result := range source.ValidateSources(sources, nil) {
+		fields := log.Fields{
+			"source": result.Source.GetIdentifier(),
+		}
+		if result.Status > 0 {
+			fields["status"] = result.Status
+		}
+		if result.Size >= 0 {
+			fields["size"] = result.Size
+		}
+		switch {
+		case result.Skipped:
+			log.WithFields(fields).Warning("Cannot validate source type")
+		case result.Err != nil:
+			fields["error"] = result.Err
+			log.WithFields(fields).Error("Source is unreachable")
+			failed++
+		default:
+			log.WithFields(fields).Info("Source is reachable")
+		}
+	}
+
+	if failed > 0 {
+		log.WithFields(log.Fields{
+			"failed": failed,
+		}).Error("Source validation failed")
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//
+// Copyright © 2016-2017 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"builder"
+	"builder/source"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var warmCacheCmd = &cobra.Command{
+	Use:   "warm-cache [manifest]",
+	Short: "fetch sources listed in a manifest",
+	Long: `Fetch every source listed in the manifest that is not already cached,
+without needing the package recipes, i.e. to pre-populate CI caches`,
+	RunE: warmCache,
+}
+
+func init() {
+	RootCmd.AddCommand(warmCacheCmd)
+}
+
+func warmCache(cmd *cobra.Command, args []string) error {
+	if CLIDebug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.StandardLogger().Formatter.(*log.TextFormatter).DisableColors = builder.DisableColors
+
+	if len(args) != 1 {
+		return errors.New("Require a manifest to warm the cache from")
+	}
+
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "You must be root to warm the cache\n")
+		os.Exit(1)
+	}
+
+	// Respect the fetch configuration, i.e. cache paths and mirrors
+	if config, err := builder.NewConfig(); err == nil {
+		if err := builder.SetCachePaths(config); err != nil {
+			return err
+		}
+		source.DeduplicateSources = config.DeduplicateSources
+		source.MaxCachedVersions = config.MaxCachedVersions
+		source.MaxRedirects = config.MaxRedirects
+		source.RangeConnections = config.DownloadConnections
+		source.HostHeaders = config.Headers
+		source.StaticHosts = config.Hosts
+		source.DoHURL = config.DoHURL
+		source.Mirrors = config.Mirrors
+		source.ArchiveURL = config.ArchiveURL
+		if err := source.SetFTPMode(config.FTPMode); err != nil {
+			return err
+		}
+		if err := builder.SetNetworkPolicy(config.Network); err != nil {
+			return err
+		}
+	}
+	if err := source.EnsureSourceDir(); err != nil {
+		return err
+	}
+
+	if err := source.WarmCache(args[0]); err != nil {
+		log.WithFields(log.Fields{
+			"manifest": args[0],
+			"error":    err,
+		}).Error("Failed to warm source cache")
+		os.Exit(1)
+	}
+	return nil
+}